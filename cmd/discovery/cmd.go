@@ -26,6 +26,8 @@ type Config struct {
 	Creds []device.Credentials `json:"creds"`
 	// the urls to attempt to connect to as if they were returned from WS-Discovery service as XAddrs
 	XAddrs []string `json:"xaddrs"`
+	// IPv4 CIDR ranges to probe for ONVIF devices
+	Subnets []string `json:"subnets"`
 }
 
 type options struct {
@@ -56,7 +58,7 @@ func realMain() error {
 
 	xaddrs := map[string]*url.URL{}
 	for _, xaddr := range opts.config.XAddrs {
-		u, err := url.Parse(xaddr)
+		u, err := viamonvif.ParseXAddr(xaddr)
 		if err != nil {
 			logger.Warnf("invalid config xaddr: %s", xaddr)
 			continue
@@ -67,6 +69,13 @@ func realMain() error {
 	timeoutCtx, cancel := context.WithTimeout(context.Background(), opts.timeout)
 	defer cancel()
 	urls := slices.Collect(maps.Values(xaddrs))
+	if len(opts.config.Subnets) > 0 {
+		scanned, err := viamonvif.ScanSubnets(timeoutCtx, opts.config.Subnets, nil, logger)
+		if err != nil {
+			return err
+		}
+		urls = append(scanned, urls...)
+	}
 	list, err := viamonvif.DiscoverCameras(timeoutCtx, opts.config.Creds, urls, logger)
 
 	if timeoutCtx.Err() == context.DeadlineExceeded {
@@ -23,6 +23,7 @@ func main() {
 func realMain() error {
 	debug := flag.Bool("debug", false, "enable debug logging (logs each discovered camera's host/ips)")
 	output := flag.String("output", "", "if set, also write the JSON to this file")
+	resolution := flag.String("resolution", "", "if set, emit a single config per camera for this resolution, e.g. 720p")
 	flag.Parse()
 
 	var logger logging.Logger
@@ -35,7 +36,7 @@ func realMain() error {
 	// Dump the camera resource configs the discovery service would emit
 	// (paste-able into a machine's components). Run with -debug to also see each
 	// discovered camera's host/ips logged, e.g. for direct ffmpeg testing.
-	configs, err := garmin.DiscoverConfigs(context.Background(), &garmin.Config{}, logger)
+	configs, err := garmin.DiscoverConfigs(context.Background(), &garmin.Config{Resolution: *resolution}, logger)
 	if err != nil {
 		return err
 	}
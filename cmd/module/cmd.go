@@ -8,7 +8,10 @@ import (
 
 	"github.com/viam-modules/viamrtsp"
 	"github.com/viam-modules/viamrtsp/garmin"
+	"github.com/viam-modules/viamrtsp/motionsensor"
+	"github.com/viam-modules/viamrtsp/onvifdevice"
 	"github.com/viam-modules/viamrtsp/ptzclient"
+	"github.com/viam-modules/viamrtsp/rtspscan"
 	"github.com/viam-modules/viamrtsp/unifi"
 	"github.com/viam-modules/viamrtsp/upnpdiscovery"
 	"github.com/viam-modules/viamrtsp/viamonvif"
@@ -16,6 +19,7 @@ import (
 	vsutils "github.com/viam-modules/video-store/videostore/utils"
 	"go.viam.com/rdk/components/camera"
 	"go.viam.com/rdk/components/generic"
+	"go.viam.com/rdk/components/sensor"
 	"go.viam.com/rdk/logging"
 	"go.viam.com/rdk/module"
 	"go.viam.com/rdk/services/discovery"
@@ -73,16 +77,34 @@ func mainWithArgs(ctx context.Context, _ []string, logger logging.Logger) error
 	if err != nil {
 		return err
 	}
+	err = myMod.AddModelFromRegistry(ctx, discovery.API, rtspscan.Model)
+	if err != nil {
+		return err
+	}
 	err = myMod.AddModelFromRegistry(ctx, generic.API, ptzclient.Model)
 	if err != nil {
 		return err
 	}
+	err = myMod.AddModelFromRegistry(ctx, generic.API, onvifdevice.Model)
+	if err != nil {
+		return err
+	}
 
 	err = myMod.AddModelFromRegistry(ctx, discovery.API, unifi.Model)
 	if err != nil {
 		return err
 	}
 
+	err = myMod.AddModelFromRegistry(ctx, sensor.API, motionsensor.Model)
+	if err != nil {
+		return err
+	}
+
+	err = myMod.AddModelFromRegistry(ctx, sensor.API, unifi.EventsModel)
+	if err != nil {
+		return err
+	}
+
 	err = myMod.Start(ctx)
 	defer myMod.Close(ctx)
 	if err != nil {
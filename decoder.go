@@ -30,6 +30,8 @@ type decoder struct {
 	// The source yuv420 frame buffer we are decoding from
 	src         *C.AVFrame
 	avFramePool *framePool
+	// deinterlacer is nil unless deinterlacing is configured.
+	deinterlacer *deinterlacer
 }
 
 type videoCodec int
@@ -298,8 +300,14 @@ func newMPEG4Decoder(avFramePool *framePool, logger logging.Logger, extraData []
 	return newDecoder(C.AV_CODEC_ID_MPEG4, avFramePool, logger, extraData)
 }
 
+// newMJPEGDecoder creates a new MJPEG decoder.
+func newMJPEGDecoder(avFramePool *framePool, logger logging.Logger) (*decoder, error) {
+	return newDecoder(C.AV_CODEC_ID_MJPEG, avFramePool, logger, nil)
+}
+
 // close closes the decoder.
 func (d *decoder) close() {
+	d.deinterlacer.close()
 	if d.src != nil {
 		C.av_frame_free(&d.src)
 	}
@@ -309,7 +317,27 @@ func (d *decoder) close() {
 	}
 }
 
+// deinterlace runs decoded frames through a deinterlacing filter, see deinterlacer.filter.
+func (d *decoder) deinterlace(mode string) {
+	d.deinterlacer = newDeinterlacer(mode, d.logger)
+}
+
+// skipNonReferenceFrames makes libav discard frames no other frame references, such as most B-frames,
+// without decoding them.
+func (d *decoder) skipNonReferenceFrames() {
+	d.codecCtx.skip_frame = C.AVDISCARD_NONREF
+}
+
 func (d *decoder) decode(nalu []byte) (*avFrameWrapper, error) {
+	if err := d.feed(nalu); err != nil {
+		return nil, err
+	}
+	return d.copyOut()
+}
+
+// feed decodes nalu into the source frame. On its own it keeps the decoder's reference frames
+// current without the cost of copying the frame out.
+func (d *decoder) feed(nalu []byte) error {
 	if d.codecCtx.codec_id == C.AV_CODEC_ID_H264 || d.codecCtx.codec_id == C.AV_CODEC_ID_H265 {
 		nalu = append(H2645StartCode(), nalu...)
 	}
@@ -321,22 +349,59 @@ func (d *decoder) decode(nalu []byte) (*avFrameWrapper, error) {
 	avPacket.size = C.int(len(nalu))
 	res := C.avcodec_send_packet(d.codecCtx, &avPacket)
 	if res < 0 {
-		return nil, newRecoverableError(newAvError(res, "error sending packet to the decoder"))
+		return newRecoverableError(newAvError(res, "error sending packet to the decoder"))
 	}
 
 	// receive frame if available
 	res = C.avcodec_receive_frame(d.codecCtx, d.src)
 	if res < 0 {
-		return nil, newRecoverableError(newAvError(res, "error receiving decoded frame from the decoder"))
+		return newRecoverableError(newAvError(res, "error receiving decoded frame from the decoder"))
 	}
 
+	if err := d.deinterlacer.filter(d.src); err != nil {
+		if errors.As(err, new(*recoverableError)) {
+			return err
+		}
+		// the decoded frame is left as it was, so carry on without deinterlacing
+		d.logger.Warnf("disabling deinterlacing: %s", err.Error())
+		d.deinterlacer.close()
+		d.deinterlacer = nil
+	}
+	return nil
+}
+
+// copyOut copies the last decoded frame into a frame from the pool.
+func (d *decoder) copyOut() (*avFrameWrapper, error) {
+	dst, err := poolFrameFor(d.avFramePool, d.src, d.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// We need to copy the frame data from the source frame to the destination frame
+	// because the source frame will be overwritten by the next frame that is decoded.
+	if res := C.av_frame_copy(dst.frame, d.src); res < 0 {
+		return nil, newAvError(res, "av_frame_copy() failed")
+	}
+
+	// Copy the frame properties from the source frame to the destination frame.
+	// This will fill fields not explicitly set in the initial dst frame allocation.
+	if res := C.av_frame_copy_props(dst.frame, d.src); res < 0 {
+		// We should never reach this point if av_frame_copy() succeeded.
+		return nil, newAvError(res, "av_frame_copy_props() failed")
+	}
+
+	return dst, nil
+}
+
+// poolFrameFor returns a frame from the pool with the size and format of src, but not its contents.
+func poolFrameFor(pool *framePool, src *C.AVFrame, logger logging.Logger) (*avFrameWrapper, error) {
 	// Get a frame from the pool. This frame will be in one of three states:
 	// - The frame is uninitialized. The width/height will be set to 0 and the frame's byte buffer
 	//   will be empty.
 	// - The frame is initialized with a height/width/buffer, all of the desired values/size.
 	// - The frame is initialized with an old height/width/buffer that no longer matches the
 	//   source yuv frame.
-	dst := d.avFramePool.get()
+	dst := pool.get()
 
 	if dst == nil {
 		return nil, errors.New("failed to obtain AVFrame from pool")
@@ -346,17 +411,17 @@ func (d *decoder) decode(nalu []byte) (*avFrameWrapper, error) {
 	}
 
 	// If the frame from the pool has the wrong size, (re-)initialize it.
-	if dst.frame.width != d.src.width || dst.frame.height != d.src.height || dst.frame.format != d.src.format {
-		d.logger.Debugf("(re)making frame due to AVFrame discrepancy: "+
+	if dst.frame.width != src.width || dst.frame.height != src.height || dst.frame.format != src.format {
+		logger.Debugf("(re)making frame due to AVFrame discrepancy: "+
 			"Dst (width: %d, height: %d, format: %d) vs Src (width: %d, height: %d, format: %d)",
 			dst.frame.width, dst.frame.height, dst.frame.format,
-			d.src.width, d.src.height, d.src.format)
+			src.width, src.height, src.format)
 		// Handle size changes while having previously initialized frames to avoid https://github.com/erh/viamrtsp/pull/41#discussion_r1719998891
 		frameWasPreviouslyInitialized := dst.frame.width > 0 && dst.frame.height > 0
 		if frameWasPreviouslyInitialized {
 			// Release previously initialized frames, and block old prev gen frames from returning to pool
 			dst.free()
-			generation := d.avFramePool.clearAndStartNewGeneration()
+			generation := pool.clearAndStartNewGeneration()
 			// Make new frame to be initialized with new size
 			newDst, err := newAVFrameWrapper(generation)
 			if err != nil {
@@ -365,28 +430,13 @@ func (d *decoder) decode(nalu []byte) (*avFrameWrapper, error) {
 			dst = newDst
 		}
 		// Prepare the fresh frame
-		dst.frame.format = d.src.format
-		dst.frame.width = d.src.width
-		dst.frame.height = d.src.height
+		dst.frame.format = src.format
+		dst.frame.width = src.width
+		dst.frame.height = src.height
 
-		res = C.av_frame_get_buffer(dst.frame, 32)
-		if res < 0 {
+		if res := C.av_frame_get_buffer(dst.frame, 32); res < 0 {
 			return nil, newAvError(res, "av_frame_get_buffer() err")
 		}
 	}
-
-	// We need to copy the frame data from the source frame to the destination frame
-	// because the source frame will be overwritten by the next frame that is decoded.
-	if res := C.av_frame_copy(dst.frame, d.src); res < 0 {
-		return nil, newAvError(res, "av_frame_copy() failed")
-	}
-
-	// Copy the frame properties from the source frame to the destination frame.
-	// This will fill fields not explicitly set in the initial dst frame allocation.
-	if res := C.av_frame_copy_props(dst.frame, d.src); res < 0 {
-		// We should never reach this point if av_frame_copy() succeeded.
-		return nil, newAvError(res, "av_frame_copy_props() failed")
-	}
-
 	return dst, nil
 }
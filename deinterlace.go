@@ -0,0 +1,171 @@
+package viamrtsp
+
+/*
+#include <libavfilter/avfilter.h>
+#include <libavfilter/buffersink.h>
+#include <libavfilter/buffersrc.h>
+#include <libavutil/frame.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"go.viam.com/rdk/logging"
+)
+
+const (
+	// deinterlaceAuto deinterlaces frames the decoder flags as interlaced and passes others through.
+	deinterlaceAuto = "auto"
+	// deinterlaceAlways deinterlaces every frame, for encoders that don't flag interlaced video.
+	deinterlaceAlways = "always"
+	// the buffer source needs a time base, this is the RTP video clock
+	deinterlaceTimeBase = "1/90000"
+)
+
+func validateDeinterlace(mode string) error {
+	switch mode {
+	case "", deinterlaceAuto, deinterlaceAlways:
+		return nil
+	default:
+		return fmt.Errorf("invalid deinterlace %q, allowed values are: auto, always", mode)
+	}
+}
+
+// deinterlacer runs decoded frames through the libavfilter yadif filter. yadif looks at the
+// neighbouring frames, so each output frame is the one before the last input.
+type deinterlacer struct {
+	mode   string
+	logger logging.Logger
+
+	graph  *C.AVFilterGraph
+	src    *C.AVFilterContext
+	sink   *C.AVFilterContext
+	width  C.int
+	height C.int
+	format C.int
+}
+
+func newDeinterlacer(mode string, logger logging.Logger) *deinterlacer {
+	if mode == "" {
+		return nil
+	}
+	return &deinterlacer{mode: mode, logger: logger}
+}
+
+// yadifArgs returns the yadif options. Frames keep their rate and field order is taken from the frame.
+func (d *deinterlacer) yadifArgs() string {
+	deint := "interlaced"
+	if d.mode == deinterlaceAlways {
+		deint = "all"
+	}
+	return "mode=send_frame:parity=auto:deint=" + deint
+}
+
+func bufferSourceArgs(frame *C.AVFrame) string {
+	aspectNum, aspectDen := frame.sample_aspect_ratio.num, frame.sample_aspect_ratio.den
+	if aspectNum <= 0 || aspectDen <= 0 {
+		aspectNum, aspectDen = 1, 1
+	}
+	return fmt.Sprintf("video_size=%dx%d:pix_fmt=%d:time_base=%s:pixel_aspect=%d/%d",
+		frame.width, frame.height, frame.format, deinterlaceTimeBase, aspectNum, aspectDen)
+}
+
+func createFilter(graph *C.AVFilterGraph, filterName, name, args string) (*C.AVFilterContext, error) {
+	cFilterName := C.CString(filterName)
+	defer C.free(unsafe.Pointer(cFilterName))
+	filter := C.avfilter_get_by_name(cFilterName)
+	if filter == nil {
+		return nil, fmt.Errorf("filter %s is not available", filterName)
+	}
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	var cArgs *C.char
+	if args != "" {
+		cArgs = C.CString(args)
+		defer C.free(unsafe.Pointer(cArgs))
+	}
+	var ctx *C.AVFilterContext
+	if res := C.avfilter_graph_create_filter(&ctx, filter, cName, cArgs, nil, graph); res < 0 {
+		return nil, newAvError(res, "avfilter_graph_create_filter() failed for "+filterName)
+	}
+	return ctx, nil
+}
+
+// init builds the filter graph for frames of the size and format of frame.
+func (d *deinterlacer) init(frame *C.AVFrame) error {
+	d.close()
+	graph := C.avfilter_graph_alloc()
+	if graph == nil {
+		return errors.New("avfilter_graph_alloc() failed")
+	}
+	d.graph = graph
+	src, err := createFilter(graph, "buffer", "in", bufferSourceArgs(frame))
+	if err != nil {
+		d.close()
+		return err
+	}
+	yadif, err := createFilter(graph, "yadif", "deinterlace", d.yadifArgs())
+	if err != nil {
+		d.close()
+		return err
+	}
+	sink, err := createFilter(graph, "buffersink", "out", "")
+	if err != nil {
+		d.close()
+		return err
+	}
+	if res := C.avfilter_link(src, 0, yadif, 0); res < 0 {
+		d.close()
+		return newAvError(res, "avfilter_link() failed")
+	}
+	if res := C.avfilter_link(yadif, 0, sink, 0); res < 0 {
+		d.close()
+		return newAvError(res, "avfilter_link() failed")
+	}
+	if res := C.avfilter_graph_config(graph, nil); res < 0 {
+		d.close()
+		return newAvError(res, "avfilter_graph_config() failed")
+	}
+	d.src, d.sink = src, sink
+	d.width, d.height, d.format = frame.width, frame.height, frame.format
+	d.logger.Infof("deinterlacing %dx%d frames with yadif (%s)", frame.width, frame.height, d.mode)
+	return nil
+}
+
+// filter replaces frame with the deinterlaced frame. In auto mode no filter is set up until the
+// first interlaced frame, so progressive streams are left untouched. A recoverableError means
+// the filter is waiting for more frames.
+func (d *deinterlacer) filter(frame *C.AVFrame) error {
+	if d == nil {
+		return nil
+	}
+	interlaced := frame.flags&C.AV_FRAME_FLAG_INTERLACED != 0
+	if d.graph == nil && d.mode == deinterlaceAuto && !interlaced {
+		return nil
+	}
+	if d.graph == nil || frame.width != d.width || frame.height != d.height || frame.format != d.format {
+		if err := d.init(frame); err != nil {
+			return err
+		}
+	}
+	if res := C.av_buffersrc_add_frame_flags(d.src, frame, C.AV_BUFFERSRC_FLAG_KEEP_REF); res < 0 {
+		return newAvError(res, "av_buffersrc_add_frame_flags() failed")
+	}
+	C.av_frame_unref(frame)
+	if res := C.av_buffersink_get_frame(d.sink, frame); res < 0 {
+		return newRecoverableError(newAvError(res, "no deinterlaced frame yet"))
+	}
+	return nil
+}
+
+func (d *deinterlacer) close() {
+	if d == nil || d.graph == nil {
+		return
+	}
+	C.avfilter_graph_free(&d.graph)
+	d.src, d.sink = nil, nil
+}
@@ -0,0 +1,70 @@
+package viamrtsp
+
+import (
+	"errors"
+	"testing"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestDeinterlacer(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+
+	t.Run("validate", func(t *testing.T) {
+		test.That(t, validateDeinterlace(""), test.ShouldBeNil)
+		test.That(t, validateDeinterlace(deinterlaceAuto), test.ShouldBeNil)
+		test.That(t, validateDeinterlace(deinterlaceAlways), test.ShouldBeNil)
+		test.That(t, validateDeinterlace("bob"), test.ShouldNotBeNil)
+	})
+
+	t.Run("off is nil", func(t *testing.T) {
+		d := newDeinterlacer("", logger)
+		test.That(t, d, test.ShouldBeNil)
+		test.That(t, d.filter(nil), test.ShouldBeNil)
+		d.close()
+	})
+
+	t.Run("filter args", func(t *testing.T) {
+		test.That(t, newDeinterlacer(deinterlaceAuto, logger).yadifArgs(), test.ShouldEqual, "mode=send_frame:parity=auto:deint=interlaced")
+		test.That(t, newDeinterlacer(deinterlaceAlways, logger).yadifArgs(), test.ShouldEqual, "mode=send_frame:parity=auto:deint=all")
+
+		frame := createTestYUV420PFrame(320, 240)
+		test.That(t, frame, test.ShouldNotBeNil)
+		defer freeFrame(frame)
+		test.That(t, bufferSourceArgs(frame), test.ShouldEqual, "video_size=320x240:pix_fmt=0:time_base=1/90000:pixel_aspect=1/1")
+	})
+
+	t.Run("auto leaves progressive frames alone", func(t *testing.T) {
+		frame := createTestYUV420PFrame(320, 240)
+		test.That(t, frame, test.ShouldNotBeNil)
+		defer freeFrame(frame)
+		fillDummyYUV420PData(frame)
+		before := framePixel(frame, 0, 10, 11)
+
+		d := newDeinterlacer(deinterlaceAuto, logger)
+		defer d.close()
+		test.That(t, d.filter(frame), test.ShouldBeNil)
+		test.That(t, d.graph, test.ShouldBeNil)
+		test.That(t, framePixel(frame, 0, 10, 11), test.ShouldEqual, before)
+	})
+
+	t.Run("always deinterlaces with a frame of delay", func(t *testing.T) {
+		d := newDeinterlacer(deinterlaceAlways, logger)
+		defer d.close()
+		for i := range 3 {
+			frame := createTestYUV420PFrame(320, 240)
+			test.That(t, frame, test.ShouldNotBeNil)
+			fillDummyYUV420PData(frame)
+			err := d.filter(frame)
+			if i == 0 {
+				test.That(t, errors.As(err, new(*recoverableError)), test.ShouldBeTrue)
+			} else {
+				test.That(t, err, test.ShouldBeNil)
+				test.That(t, int(frame.width), test.ShouldEqual, 320)
+				test.That(t, int(frame.height), test.ShouldEqual, 240)
+			}
+			freeFrame(frame)
+		}
+	})
+}
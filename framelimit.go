@@ -0,0 +1,49 @@
+package viamrtsp
+
+import (
+	"sync"
+	"time"
+)
+
+// frameRateLimiter spaces out decoded frames to at most a target rate. Frames are allowed on a
+// fixed schedule rather than by time since the last one, so jitter in arrival times doesn't drop
+// the rate below the target.
+type frameRateLimiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// newFrameRateLimiter returns nil when maxFPS is 0, which allows every frame.
+func newFrameRateLimiter(maxFPS float64) *frameRateLimiter {
+	if maxFPS <= 0 {
+		return nil
+	}
+	return &frameRateLimiter{interval: time.Duration(float64(time.Second) / maxFPS)}
+}
+
+// due reports whether a frame arriving now should be used.
+func (l *frameRateLimiter) due(now time.Time) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// allow a frame up to a quarter interval early to absorb jitter
+	return !now.Before(l.next.Add(-l.interval / 4)) //nolint:mnd
+}
+
+// used schedules the next frame after one arriving now was used.
+func (l *frameRateLimiter) used(now time.Time) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// after a gap in the stream start a new schedule instead of letting a burst through
+	if now.Sub(l.next) > l.interval {
+		l.next = now
+	}
+	l.next = l.next.Add(l.interval)
+}
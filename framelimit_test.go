@@ -0,0 +1,56 @@
+package viamrtsp
+
+import (
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+)
+
+func TestFrameRateLimiter(t *testing.T) {
+	start := time.Now()
+	// count returns how many frames of a stream at fps with the given jitter pattern are used in a second
+	count := func(l *frameRateLimiter, fps int, jitter []time.Duration) int {
+		used := 0
+		for i := range fps {
+			now := start.Add(time.Duration(i)*time.Second/time.Duration(fps) + jitter[i%len(jitter)])
+			if l.due(now) {
+				l.used(now)
+				used++
+			}
+		}
+		return used
+	}
+
+	t.Run("nil allows every frame", func(t *testing.T) {
+		var l *frameRateLimiter
+		test.That(t, newFrameRateLimiter(0), test.ShouldBeNil)
+		test.That(t, count(l, 30, []time.Duration{0}), test.ShouldEqual, 30)
+	})
+
+	t.Run("halves a stream at twice the target", func(t *testing.T) {
+		test.That(t, count(newFrameRateLimiter(15), 30, []time.Duration{0}), test.ShouldEqual, 15)
+	})
+
+	t.Run("jitter doesn't lower the rate", func(t *testing.T) {
+		jitter := []time.Duration{0, -3 * time.Millisecond, 4 * time.Millisecond, -2 * time.Millisecond}
+		test.That(t, count(newFrameRateLimiter(15), 30, jitter), test.ShouldEqual, 15)
+	})
+
+	t.Run("slower streams are unaffected", func(t *testing.T) {
+		test.That(t, count(newFrameRateLimiter(15), 10, []time.Duration{0}), test.ShouldEqual, 10)
+	})
+
+	t.Run("fractional rates", func(t *testing.T) {
+		test.That(t, count(newFrameRateLimiter(2.5), 30, []time.Duration{0}), test.ShouldEqual, 3)
+	})
+
+	t.Run("a gap doesn't cause a burst", func(t *testing.T) {
+		l := newFrameRateLimiter(5)
+		l.used(start)
+		later := start.Add(10 * time.Second)
+		test.That(t, l.due(later), test.ShouldBeTrue)
+		l.used(later)
+		test.That(t, l.due(later.Add(40*time.Millisecond)), test.ShouldBeFalse)
+	})
+}
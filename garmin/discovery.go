@@ -3,6 +3,9 @@ package garmin
 import (
 	"context"
 	"net"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -30,6 +33,43 @@ type Camera struct {
 	Host string `json:"host"`
 	// IPs are the resolved IPv4 addresses for the host.
 	IPs []net.IP `json:"ips"`
+	// Model, Firmware and Resolutions are read from the TXT records, and are empty if the camera
+	// doesn't advertise them.
+	Model    string `json:"model,omitempty"`
+	Firmware string `json:"firmware,omitempty"`
+	// Resolutions are the resolutions the camera streams, e.g. "720p", lowest first.
+	Resolutions []string `json:"resolutions,omitempty"`
+	// TXT are all the key=value TXT records the camera advertises.
+	TXT map[string]string `json:"txt,omitempty"`
+}
+
+// TXT record keys read for each Camera field, in order of preference.
+var (
+	modelKeys      = []string{"model", "md", "mdl"}
+	firmwareKeys   = []string{"fw", "firmware", "swver", "version"}
+	resolutionKeys = []string{"res", "resolutions"}
+)
+
+// resolutionPattern matches resolutions named by their height, e.g. "1080p".
+var resolutionPattern = regexp.MustCompile(`^(\d+)p$`)
+
+// resolutionHeight returns the height of a resolution like "1080p", or 0 if res isn't one.
+func resolutionHeight(res string) int {
+	m := resolutionPattern.FindStringSubmatch(strings.ToLower(res))
+	if m == nil {
+		return 0
+	}
+	height, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0
+	}
+	return height
+}
+
+// pathResolution returns the resolution a stream path serves, its last element, e.g. "720p" for
+// "/Independent/720p".
+func pathResolution(path string) string {
+	return strings.ToLower(path[strings.LastIndex(path, "/")+1:])
 }
 
 // addressHost returns the host to use when building an RTSP URL. We prefer the
@@ -121,11 +161,77 @@ func collectCameras(ctx context.Context, entries <-chan *zeroconf.ServiceEntry,
 	}
 }
 
+// lookupGarmin resolves the current address of the Garmin camera advertised as instance over
+// mDNS. It reports false if the camera doesn't answer within browseTimeout.
+func lookupGarmin(ctx context.Context, instance string, logger logging.Logger) (Camera, bool, error) {
+	resolver, err := zeroconf.NewResolver(logger.Desugar().Sugar())
+	if err != nil {
+		return Camera{}, false, err
+	}
+	defer resolver.Shutdown()
+
+	entries := make(chan *zeroconf.ServiceEntry, entriesBufferSize)
+	lookupCtx, cancel := context.WithTimeout(ctx, browseTimeout)
+	defer cancel()
+
+	if err := resolver.Lookup(lookupCtx, instance, mdnsService, mdnsDomain, entries); err != nil {
+		return Camera{}, false, err
+	}
+
+	for {
+		select {
+		case <-lookupCtx.Done():
+			return Camera{}, false, ctx.Err()
+		case entry, ok := <-entries:
+			if !ok {
+				return Camera{}, false, ctx.Err()
+			}
+			if entry == nil {
+				continue
+			}
+			// entries without an address are followed by complete ones once the camera answers
+			if cam := serviceEntryToCamera(entry); cam.Instance == instance && cam.addressHost() != "" {
+				return cam, true, nil
+			}
+		}
+	}
+}
+
 // serviceEntryToCamera converts a zeroconf ServiceEntry into a Camera.
 func serviceEntryToCamera(entry *zeroconf.ServiceEntry) Camera {
-	return Camera{
+	cam := Camera{
 		Instance: entry.Instance,
 		Host:     strings.TrimSuffix(entry.HostName, "."),
 		IPs:      entry.AddrIPv4,
 	}
+	if len(entry.Text) == 0 {
+		return cam
+	}
+
+	cam.TXT = make(map[string]string, len(entry.Text))
+	for _, record := range entry.Text {
+		key, value, _ := strings.Cut(record, "=")
+		if key = strings.ToLower(strings.TrimSpace(key)); key != "" {
+			cam.TXT[key] = strings.TrimSpace(value)
+		}
+	}
+	cam.Model = txtValue(cam.TXT, modelKeys)
+	cam.Firmware = txtValue(cam.TXT, firmwareKeys)
+	for _, res := range strings.Split(txtValue(cam.TXT, resolutionKeys), ",") {
+		if res = strings.ToLower(strings.TrimSpace(res)); res != "" && !slices.Contains(cam.Resolutions, res) {
+			cam.Resolutions = append(cam.Resolutions, res)
+		}
+	}
+	slices.SortStableFunc(cam.Resolutions, func(a, b string) int { return resolutionHeight(a) - resolutionHeight(b) })
+	return cam
+}
+
+// txtValue returns the value of the first of keys present in txt.
+func txtValue(txt map[string]string, keys []string) string {
+	for _, key := range keys {
+		if v, ok := txt[key]; ok {
+			return v
+		}
+	}
+	return ""
 }
@@ -13,8 +13,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/viam-modules/viamrtsp"
 	"github.com/viam-modules/viamrtsp/rtsppreview"
@@ -22,13 +26,18 @@ import (
 	"go.viam.com/rdk/logging"
 	"go.viam.com/rdk/resource"
 	"go.viam.com/rdk/services/discovery"
+	"go.viam.com/utils"
 )
 
 // Model is the model for a Garmin discovery service for rtsp cameras.
 var Model = viamrtsp.Family.WithModel("garmin")
 
-// defaultRTSPPort is the port Garmin's GStreamer RTSP server listens on.
-const defaultRTSPPort = 8554
+const (
+	// defaultRTSPPort is the port Garmin's GStreamer RTSP server listens on.
+	defaultRTSPPort = 8554
+	// dialTimeout bounds how long get-info waits to connect to a camera's RTSP port.
+	dialTimeout = 2 * time.Second
+)
 
 // defaultStreamPaths are the RTSP routes Garmin cameras serve, confirmed by
 // probing live GC-series cameras in the field. One camera config is emitted per
@@ -55,6 +64,9 @@ type Config struct {
 	// StreamPaths overrides the RTSP path(s) to emit a camera config for, one
 	// config per path. Defaults to defaultStreamPaths if unset.
 	StreamPaths []string `json:"stream_paths,omitempty"`
+	// Resolution is the preferred resolution, e.g. "720p". When set, a single config is emitted per
+	// camera, for the stream path of this resolution or the closest lower one the camera serves.
+	Resolution string `json:"resolution,omitempty"`
 }
 
 // Validate validates the discovery service config.
@@ -67,6 +79,9 @@ func (cfg *Config) Validate(_ string) ([]string, []string, error) {
 			return nil, nil, errors.New("stream_paths entries cannot be empty")
 		}
 	}
+	if cfg.Resolution != "" && resolutionHeight(cfg.Resolution) == 0 {
+		return nil, nil, fmt.Errorf("resolution %q must be a height like 720p", cfg.Resolution)
+	}
 	return []string{}, nil, nil
 }
 
@@ -86,6 +101,56 @@ func (cfg *Config) paths() []string {
 	return defaultStreamPaths
 }
 
+// cameraPaths returns the stream paths to emit configs for for cam: the configured paths of the
+// resolutions cam advertises, narrowed to the one closest to the preferred resolution if set.
+func (cfg *Config) cameraPaths(cam Camera) []string {
+	paths := cfg.paths()
+	if len(cam.Resolutions) > 0 {
+		advertised := []string{}
+		for _, path := range paths {
+			res := pathResolution(path)
+			if resolutionHeight(res) == 0 || slices.Contains(cam.Resolutions, res) {
+				advertised = append(advertised, path)
+			}
+		}
+		// paths that match none of the advertised resolutions are more likely to be named
+		// differently than to be missing
+		if len(advertised) > 0 {
+			paths = advertised
+		}
+	}
+	if cfg.Resolution == "" {
+		return paths
+	}
+	return []string{preferredPath(paths, resolutionHeight(cfg.Resolution))}
+}
+
+// preferredPath returns the path of the highest resolution no higher than height, or of the lowest
+// resolution if all are higher. Paths that don't name a resolution are only picked when none does.
+func preferredPath(paths []string, height int) string {
+	best, lowest := "", ""
+	for _, path := range paths {
+		h := resolutionHeight(pathResolution(path))
+		if h == 0 {
+			continue
+		}
+		if h <= height && (best == "" || h > resolutionHeight(pathResolution(best))) {
+			best = path
+		}
+		if lowest == "" || h < resolutionHeight(pathResolution(lowest)) {
+			lowest = path
+		}
+	}
+	switch {
+	case best != "":
+		return best
+	case lowest != "":
+		return lowest
+	default:
+		return paths[0]
+	}
+}
+
 type garminDiscovery struct {
 	resource.Named
 	resource.AlwaysRebuild
@@ -93,6 +158,8 @@ type garminDiscovery struct {
 
 	cfg    *Config
 	logger logging.Logger
+	// lookup resolves a camera's current address, replaced in tests.
+	lookup func(ctx context.Context, instance string, logger logging.Logger) (Camera, bool, error)
 }
 
 func newDiscovery(_ context.Context, _ resource.Dependencies,
@@ -108,6 +175,7 @@ func newDiscovery(_ context.Context, _ resource.Dependencies,
 		Named:  conf.ResourceName().AsNamed(),
 		cfg:    cfg,
 		logger: logger,
+		lookup: lookupGarmin,
 	}, nil
 }
 
@@ -119,7 +187,9 @@ func (dis *garminDiscovery) DiscoverResources(ctx context.Context, _ map[string]
 
 // DoCommand handles the "preview" command, returning a JPEG data URL for the
 // given rtsp_address grabbed over RTSP. Garmin cameras expose no HTTP snapshot
-// endpoint, so preview always goes through RTSP.
+// endpoint, so preview always goes through RTSP. The "get-info" command
+// re-resolves a camera's current address and reports its metadata and whether
+// its RTSP port is reachable.
 func (dis *garminDiscovery) DoCommand(ctx context.Context, command map[string]interface{}) (map[string]interface{}, error) {
 	cmd, ok := command["command"].(string)
 	if !ok {
@@ -137,11 +207,71 @@ func (dis *garminDiscovery) DoCommand(ctx context.Context, command map[string]in
 			return nil, err
 		}
 		return map[string]interface{}{"preview": dataURL}, nil
+	case "get-info":
+		instance, ok := command["instance"].(string)
+		if !ok || instance == "" {
+			return nil, errors.New("instance cannot be empty")
+		}
+		return dis.getInfo(ctx, instance)
 	default:
 		return nil, fmt.Errorf("unknown command: %s", cmd)
 	}
 }
 
+// getInfo looks the camera advertised as instance up over mDNS and returns its current address,
+// metadata and whether its RTSP port accepts connections.
+func (dis *garminDiscovery) getInfo(ctx context.Context, instance string) (map[string]interface{}, error) {
+	cam, found, err := dis.lookup(ctx, instance, dis.logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up garmin camera %q: %w", instance, err)
+	}
+	if !found {
+		return map[string]interface{}{"instance": instance, "found": false, "reachable": false}, nil
+	}
+
+	ips := []interface{}{}
+	for _, ip := range cam.IPs {
+		ips = append(ips, ip.String())
+	}
+	resolutions := []interface{}{}
+	for _, res := range cam.Resolutions {
+		resolutions = append(resolutions, res)
+	}
+	txt := map[string]interface{}{}
+	for key, value := range cam.TXT {
+		txt[key] = value
+	}
+	addresses := []interface{}{}
+	for _, path := range dis.cfg.cameraPaths(cam) {
+		addresses = append(addresses, rtspAddress(cam.addressHost(), dis.cfg.port(), path))
+	}
+
+	return map[string]interface{}{
+		"instance":       instance,
+		"found":          true,
+		"host":           cam.Host,
+		"ips":            ips,
+		"model":          cam.Model,
+		"firmware":       cam.Firmware,
+		"resolutions":    resolutions,
+		"txt":            txt,
+		"rtsp_addresses": addresses,
+		"reachable":      portOpen(ctx, net.JoinHostPort(cam.addressHost(), strconv.Itoa(dis.cfg.port()))),
+	}, nil
+}
+
+// portOpen reports whether a TCP connection to hostPort can be made.
+func portOpen(ctx context.Context, hostPort string) bool {
+	dialCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(dialCtx, "tcp", hostPort)
+	if err != nil {
+		return false
+	}
+	utils.UncheckedError(conn.Close())
+	return true
+}
+
 // DiscoverConfigs browses for Garmin cameras and returns the camera resource
 // configs the discovery service would emit for them. Exported for use by the
 // standalone cmd/garmin debug binary.
@@ -158,7 +288,9 @@ func DiscoverConfigs(ctx context.Context, cfg *Config, logger logging.Logger) ([
 // (no I/O) so it can be unit-tested over ServiceEntry-derived fixtures.
 func camerasToConfigs(cameras []Camera, cfg *Config, logger logging.Logger) ([]resource.Config, error) {
 	configs := []resource.Config{}
-	paths := cfg.paths()
+	// names only carry the path when a camera can get more than one config, so they stay the same
+	// whichever resolutions a camera advertises
+	multiPath := len(cfg.paths()) > 1 && cfg.Resolution == ""
 	port := cfg.port()
 
 	for _, cam := range cameras {
@@ -168,9 +300,12 @@ func camerasToConfigs(cameras []Camera, cfg *Config, logger logging.Logger) ([]r
 			continue
 		}
 
-		for _, path := range paths {
-			address := fmt.Sprintf("rtsp://%s:%d%s", host, port, path)
-			name := cameraName(cam.Instance, path, len(paths) > 1)
+		if cam.Model != "" || cam.Firmware != "" {
+			logger.Debugf("garmin camera %q is a %s running firmware %s", cam.Instance, cam.Model, cam.Firmware)
+		}
+		for _, path := range cfg.cameraPaths(cam) {
+			address := rtspAddress(host, port, path)
+			name := cameraName(cam.Instance, path, multiPath)
 			camCfg, err := createCameraConfig(name, address)
 			if err != nil {
 				return nil, err
@@ -182,6 +317,11 @@ func camerasToConfigs(cameras []Camera, cfg *Config, logger logging.Logger) ([]r
 	return configs, nil
 }
 
+// rtspAddress returns the address of the stream at path.
+func rtspAddress(host string, port int, path string) string {
+	return fmt.Sprintf("rtsp://%s:%d%s", host, port, path)
+}
+
 // nonNameChars matches runs of characters not allowed in a Viam resource name.
 var nonNameChars = regexp.MustCompile("[^a-zA-Z0-9_-]+")
 
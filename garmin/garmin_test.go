@@ -53,6 +53,12 @@ func TestConfigValidate(t *testing.T) {
 		_, _, err := (&Config{StreamPaths: []string{""}}).Validate("")
 		test.That(t, err, test.ShouldNotBeNil)
 	})
+	t.Run("resolution", func(t *testing.T) {
+		_, _, err := (&Config{Resolution: "720p"}).Validate("")
+		test.That(t, err, test.ShouldBeNil)
+		_, _, err = (&Config{Resolution: "hd"}).Validate("")
+		test.That(t, err, test.ShouldNotBeNil)
+	})
 }
 
 func TestAddressHost(t *testing.T) {
@@ -77,6 +83,41 @@ func TestServiceEntryToCamera(t *testing.T) {
 	test.That(t, cam.IPs[0].String(), test.ShouldEqual, "172.16.0.5")
 }
 
+func TestServiceEntryTXT(t *testing.T) {
+	entry := newServiceEntry("garmin-cv28-x", "garmin-cv28-x.local.", "172.16.0.5")
+	entry.Text = []string{"MD=GC 200", "fw=5.20", "res=1080p, 480p,720p", "flag"}
+	cam := serviceEntryToCamera(entry)
+	test.That(t, cam.Model, test.ShouldEqual, "GC 200")
+	test.That(t, cam.Firmware, test.ShouldEqual, "5.20")
+	test.That(t, cam.Resolutions, test.ShouldResemble, []string{"480p", "720p", "1080p"})
+	test.That(t, cam.TXT, test.ShouldResemble, map[string]string{"md": "GC 200", "fw": "5.20", "res": "1080p, 480p,720p", "flag": ""})
+
+	// cameras without TXT records have no metadata
+	cam = serviceEntryToCamera(newServiceEntry("garmin-cv28-x", "garmin-cv28-x.local.", ""))
+	test.That(t, cam.TXT, test.ShouldBeNil)
+	test.That(t, cam.Resolutions, test.ShouldBeNil)
+}
+
+func TestCameraPaths(t *testing.T) {
+	cam := Camera{Instance: "garmin-cv28-x", Resolutions: []string{"480p", "720p"}}
+
+	t.Run("advertised resolutions narrow the default paths", func(t *testing.T) {
+		test.That(t, (&Config{}).cameraPaths(cam), test.ShouldResemble, []string{"/Independent/480p", "/Independent/720p"})
+		test.That(t, (&Config{}).cameraPaths(Camera{}), test.ShouldResemble, defaultStreamPaths)
+	})
+	t.Run("preferred resolution", func(t *testing.T) {
+		test.That(t, (&Config{Resolution: "720p"}).cameraPaths(Camera{}), test.ShouldResemble, []string{"/Independent/720p"})
+		// 1080p isn't advertised, the closest lower resolution is picked
+		test.That(t, (&Config{Resolution: "1080p"}).cameraPaths(cam), test.ShouldResemble, []string{"/Independent/720p"})
+		// nothing is as low, the lowest resolution is picked
+		test.That(t, (&Config{Resolution: "360p"}).cameraPaths(cam), test.ShouldResemble, []string{"/Independent/480p"})
+	})
+	t.Run("paths without a resolution", func(t *testing.T) {
+		cfg := &Config{StreamPaths: []string{"/main", "/sub"}, Resolution: "720p"}
+		test.That(t, cfg.cameraPaths(cam), test.ShouldResemble, []string{"/main"})
+	})
+}
+
 func TestCollectCamerasReturnsOnClosedChannel(t *testing.T) {
 	logger := logging.NewTestLogger(t)
 
@@ -183,6 +224,22 @@ func TestCamerasToConfigs(t *testing.T) {
 		test.That(t, rtsp.Address, test.ShouldEqual, "rtsp://172.16.0.5:8554/Independent/720p")
 	})
 
+	t.Run("preferred resolution emits one config per camera", func(t *testing.T) {
+		cams := []Camera{
+			{Instance: "garmin-a", Host: "garmin-a.local"},
+			{Instance: "garmin-b", Host: "garmin-b.local", Resolutions: []string{"480p"}},
+		}
+		configs, err := camerasToConfigs(cams, &Config{Resolution: "720p"}, logger)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(configs), test.ShouldEqual, 2)
+		test.That(t, configs[0].Name, test.ShouldEqual, "garmin-a")
+		test.That(t, configs[0].ConvertedAttributes.(*viamrtsp.Config).Address, test.ShouldEqual,
+			"rtsp://garmin-a.local:8554/Independent/720p")
+		test.That(t, configs[1].Name, test.ShouldEqual, "garmin-b")
+		test.That(t, configs[1].ConvertedAttributes.(*viamrtsp.Config).Address, test.ShouldEqual,
+			"rtsp://garmin-b.local:8554/Independent/480p")
+	})
+
 	t.Run("skips cameras with neither hostname nor IP", func(t *testing.T) {
 		cams := []Camera{{Instance: "garmin-cv28-x"}}
 		configs, err := camerasToConfigs(cams, &Config{}, logger)
@@ -223,3 +280,54 @@ func TestCreateCameraConfig(t *testing.T) {
 
 	var _ resource.Config = cfg
 }
+
+func TestGetInfo(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	test.That(t, err, test.ShouldBeNil)
+	port := l.Addr().(*net.TCPAddr).Port
+
+	dis := &garminDiscovery{
+		cfg:    &Config{RTSPPort: port, Resolution: "1080p"},
+		logger: logger,
+		lookup: func(_ context.Context, instance string, _ logging.Logger) (Camera, bool, error) {
+			if instance != "garmin-a" {
+				return Camera{}, false, nil
+			}
+			return Camera{
+				Instance: instance, IPs: []net.IP{net.ParseIP("127.0.0.1")},
+				Model: "GC 200", Firmware: "5.20", Resolutions: []string{"480p", "720p"},
+				TXT: map[string]string{"md": "GC 200", "fw": "5.20", "res": "480p,720p"},
+			}, true, nil
+		},
+	}
+
+	resp, err := dis.DoCommand(ctx, map[string]interface{}{"command": "get-info", "instance": "garmin-a"})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, resp, test.ShouldResemble, map[string]interface{}{
+		"instance":       "garmin-a",
+		"found":          true,
+		"host":           "",
+		"ips":            []interface{}{"127.0.0.1"},
+		"model":          "GC 200",
+		"firmware":       "5.20",
+		"resolutions":    []interface{}{"480p", "720p"},
+		"txt":            map[string]interface{}{"md": "GC 200", "fw": "5.20", "res": "480p,720p"},
+		"rtsp_addresses": []interface{}{fmt.Sprintf("rtsp://127.0.0.1:%d/Independent/720p", port)},
+		"reachable":      true,
+	})
+
+	test.That(t, l.Close(), test.ShouldBeNil)
+	resp, err = dis.DoCommand(ctx, map[string]interface{}{"command": "get-info", "instance": "garmin-a"})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, resp["reachable"], test.ShouldBeFalse)
+
+	resp, err = dis.DoCommand(ctx, map[string]interface{}{"command": "get-info", "instance": "garmin-b"})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, resp["found"], test.ShouldBeFalse)
+
+	_, err = dis.DoCommand(ctx, map[string]interface{}{"command": "get-info"})
+	test.That(t, err, test.ShouldNotBeNil)
+}
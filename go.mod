@@ -15,13 +15,18 @@ require (
 	github.com/pion/rtcp v1.2.16
 	github.com/pion/rtp v1.8.26
 	github.com/rhysd/actionlint v1.7.8
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/stretchr/testify v1.11.1
 	github.com/viam-modules/video-store v0.0.12
 	github.com/viamrobotics/zeroconf v1.0.14
 	go.viam.com/rdk v1.0.0
 	go.viam.com/test v1.2.4
 	go.viam.com/utils v0.6.6
+	golang.org/x/image v0.25.0
 	golang.org/x/net v0.49.0
+	golang.org/x/sync v0.19.0
+	golang.org/x/sys v0.42.0
+	nhooyr.io/websocket v1.8.7
 )
 
 require (
@@ -196,7 +201,6 @@ require (
 	github.com/pmezard/go-difflib v1.0.1-0.20181226105442-5d4384ee4fb2 // indirect
 	github.com/power-devops/perfstat v0.0.0-20210106213030-5aafc221ea8c // indirect
 	github.com/prometheus/procfs v0.15.1 // indirect
-	github.com/robfig/cron/v3 v3.0.1 // indirect
 	github.com/rs/cors v1.11.1 // indirect
 	github.com/samber/lo v1.51.0 // indirect
 	github.com/sergi/go-diff v1.4.0 // indirect
@@ -248,11 +252,8 @@ require (
 	goji.io v2.0.2+incompatible // indirect
 	golang.org/x/crypto v0.47.0 // indirect
 	golang.org/x/exp v0.0.0-20251113190631-e25ba8c21ef6 // indirect
-	golang.org/x/image v0.25.0 // indirect
 	golang.org/x/mod v0.31.0 // indirect
 	golang.org/x/oauth2 v0.34.0 // indirect
-	golang.org/x/sync v0.19.0 // indirect
-	golang.org/x/sys v0.42.0 // indirect
 	golang.org/x/text v0.33.0 // indirect
 	golang.org/x/time v0.12.0 // indirect
 	golang.org/x/tools v0.40.0 // indirect
@@ -270,5 +271,4 @@ require (
 	gorgonia.org/tensor v0.9.24 // indirect
 	gorgonia.org/vecf32 v0.9.0 // indirect
 	gorgonia.org/vecf64 v0.9.0 // indirect
-	nhooyr.io/websocket v1.8.7 // indirect
 )
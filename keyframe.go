@@ -0,0 +1,102 @@
+package viamrtsp
+
+/*
+#include <libavcodec/avcodec.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"image"
+
+	"github.com/viam-modules/viamrtsp/registry"
+	"github.com/viam-modules/video-store/videostore"
+	"go.viam.com/rdk/logging"
+)
+
+func init() {
+	registry.NewKeyframeDecoder = func(logger logging.Logger) registry.KeyframeDecoder {
+		return &keyframeDecoder{logger: logger}
+	}
+}
+
+// keyframeDecoder decodes keyframes one at a time, keeping the decoder open between them.
+type keyframeDecoder struct {
+	logger  logging.Logger
+	codec   videostore.CodecType
+	decoder *decoder
+}
+
+func (kd *keyframeDecoder) Decode(codec videostore.CodecType, nalus [][]byte) (image.Image, error) {
+	if kd.decoder == nil || kd.codec != codec {
+		kd.Close()
+		var err error
+		switch codec {
+		case videostore.CodecTypeH264:
+			kd.decoder, err = newH264Decoder(nil, kd.logger)
+		case videostore.CodecTypeH265:
+			kd.decoder, err = newH265Decoder(nil, kd.logger)
+		case videostore.CodecTypeUnknown:
+			fallthrough
+		default:
+			return nil, errors.New("keyframes can only be decoded from H264 or H265 video")
+		}
+		if err != nil {
+			return nil, err
+		}
+		kd.codec = codec
+	}
+	d := kd.decoder
+	// the next keyframe doesn't depend on anything decoded before it
+	defer C.avcodec_flush_buffers(d.codecCtx)
+
+	decoded := false
+	for _, nalu := range nalus {
+		err := d.feed(nalu)
+		if err == nil {
+			decoded = true
+			continue
+		}
+		if !errors.As(err, new(*recoverableError)) {
+			return nil, err
+		}
+	}
+	if !decoded {
+		// nothing follows the keyframe to push it out of the decoder, so drain it
+		if res := C.avcodec_send_packet(d.codecCtx, nil); res < 0 {
+			return nil, newAvError(res, "error draining the decoder")
+		}
+		if res := C.avcodec_receive_frame(d.codecCtx, d.src); res < 0 {
+			return nil, newAvError(res, "no frame decoded from keyframe")
+		}
+	}
+	img, ok := (&avFrameWrapper{frame: d.src}).toImage().(*image.YCbCr)
+	if !ok {
+		return nil, errors.New("keyframe did not decode to YUV420P")
+	}
+	// the frame is reused by the next decode
+	return copyYCbCr(img), nil
+}
+
+func (kd *keyframeDecoder) Close() {
+	if kd.decoder != nil {
+		kd.decoder.close()
+		kd.decoder = nil
+	}
+}
+
+// copyYCbCr copies an image that points into libav owned memory into Go memory.
+func copyYCbCr(src *image.YCbCr) *image.YCbCr {
+	dst := image.NewYCbCr(src.Rect, src.SubsampleRatio)
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	for y := range height {
+		copy(dst.Y[y*dst.YStride:][:width], src.Y[y*src.YStride:])
+	}
+	// chroma planes are half size, toImage only maps the rows of even heights
+	chromaWidth := (width + 1) / yuv420SubsampleRatio
+	for y := range min(len(dst.Cb)/dst.CStride, len(src.Cb)/src.CStride) {
+		copy(dst.Cb[y*dst.CStride:][:chromaWidth], src.Cb[y*src.CStride:])
+		copy(dst.Cr[y*dst.CStride:][:chromaWidth], src.Cr[y*src.CStride:])
+	}
+	return dst
+}
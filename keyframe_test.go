@@ -0,0 +1,45 @@
+package viamrtsp
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/viam-modules/viamrtsp/registry"
+	"github.com/viam-modules/video-store/videostore"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestKeyframeDecoder(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	b, err := base64.StdEncoding.DecodeString(testH264Base64)
+	test.That(t, err, test.ShouldBeNil)
+	nalus, err := h264.AnnexBUnmarshal(b)
+	test.That(t, err, test.ShouldBeNil)
+
+	// everything up to and including the first IDR, as a thumbnail would be fed from a stored segment
+	var keyframe [][]byte
+	for _, nalu := range nalus {
+		keyframe = append(keyframe, nalu)
+		if h264.NALUType(nalu[0]&0x1f) == h264.NALUTypeIDR {
+			break
+		}
+	}
+
+	kd := registry.NewKeyframeDecoder(logger)
+	defer kd.Close()
+
+	// the decoder is reused, so a second keyframe must decode the same as the first
+	for range 2 {
+		img, err := kd.Decode(videostore.CodecTypeH264, keyframe)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, img.Bounds().Dx(), test.ShouldBeGreaterThan, 0)
+		r, g, bl, _ := img.At(img.Bounds().Dx()/2, img.Bounds().Dy()/2).RGBA()
+		test.That(t, r, test.ShouldBeGreaterThan, g)
+		test.That(t, r, test.ShouldBeGreaterThan, bl)
+	}
+
+	_, err = kd.Decode(videostore.CodecTypeUnknown, keyframe)
+	test.That(t, err, test.ShouldNotBeNil)
+}
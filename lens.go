@@ -0,0 +1,362 @@
+package viamrtsp
+
+/*
+#include <libavutil/frame.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/rimage/transform"
+)
+
+const (
+	lensModeUndistort = "undistort"
+	lensModePanorama  = "panorama"
+	lensModeQuad      = "quad"
+
+	defaultQuadFovDeg  = 90
+	defaultQuadTiltDeg = 55
+	// the panorama spans from the horizon of a ceiling mounted fisheye down to this angle from straight down
+	panoramaMinThetaDeg = 15
+	// bilinear weights are stored in 1/lensWeightScale steps
+	lensWeightScale        = 256
+	maxKannalaBrandtParams = 4
+)
+
+// LensCorrection configures undistortion and fisheye dewarping of decoded frames.
+type LensCorrection struct {
+	// IntrinsicParams are the calibrated intrinsics, in the same format as camera properties.
+	IntrinsicParams *transform.PinholeCameraIntrinsics `json:"intrinsic_parameters"`
+	// DistortionModel is brown_conrady (default) or kannala_brandt for fisheye lenses.
+	DistortionModel string `json:"distortion_model,omitempty"`
+	// DistortionParams are rk1, rk2, rk3, tp1, tp2 for brown_conrady and k1 to k4 for kannala_brandt.
+	DistortionParams []float64 `json:"distortion_parameters,omitempty"`
+	// Mode is undistort (default), panorama or quad.
+	Mode string `json:"mode,omitempty"`
+	// FovDeg is the horizontal field of view of undistorted and quad views.
+	FovDeg float64 `json:"fov_deg,omitempty"`
+	// TiltDeg is how far quad views are tilted from the optical axis.
+	TiltDeg float64 `json:"tilt_deg,omitempty"`
+}
+
+func (c *LensCorrection) distortionModel() transform.DistortionType {
+	if c.DistortionModel == "" {
+		return transform.BrownConradyDistortionType
+	}
+	return transform.DistortionType(c.DistortionModel)
+}
+
+func (c *LensCorrection) mode() string {
+	if c.Mode == "" {
+		return lensModeUndistort
+	}
+	return c.Mode
+}
+
+func (c *LensCorrection) validate() error {
+	if c.IntrinsicParams == nil {
+		return errors.New("lens_correction requires intrinsic_parameters")
+	}
+	if err := c.IntrinsicParams.CheckValid(); err != nil {
+		return fmt.Errorf("lens_correction: %w", err)
+	}
+	switch c.distortionModel() { //nolint:exhaustive
+	case transform.BrownConradyDistortionType:
+		if _, err := transform.NewBrownConrady(c.DistortionParams); err != nil {
+			return fmt.Errorf("lens_correction: %w", err)
+		}
+	case transform.KannalaBrandtDistortionType:
+		if len(c.DistortionParams) > maxKannalaBrandtParams {
+			return fmt.Errorf("lens_correction: kannala_brandt takes at most %d distortion_parameters, got %d",
+				maxKannalaBrandtParams, len(c.DistortionParams))
+		}
+	default:
+		return fmt.Errorf("invalid lens_correction distortion_model %q, allowed values are: brown_conrady, kannala_brandt", c.DistortionModel)
+	}
+	switch c.mode() {
+	case lensModeUndistort:
+	case lensModePanorama, lensModeQuad:
+		if c.distortionModel() != transform.KannalaBrandtDistortionType {
+			return fmt.Errorf("lens_correction mode %s requires the kannala_brandt distortion_model", c.Mode)
+		}
+	default:
+		return fmt.Errorf("invalid lens_correction mode %q, allowed values are: undistort, panorama, quad", c.Mode)
+	}
+	if c.FovDeg < 0 || c.FovDeg >= 180 {
+		return errors.New("lens_correction fov_deg must be between 0 and 180")
+	}
+	if c.TiltDeg < 0 || c.TiltDeg > 90 {
+		return errors.New("lens_correction tilt_deg must be between 0 and 90")
+	}
+	return nil
+}
+
+// vec3 is a direction in camera coordinates, x right, y down and z forward.
+type vec3 struct {
+	x, y, z float64
+}
+
+func (a vec3) add(b vec3) vec3 {
+	return vec3{a.x + b.x, a.y + b.y, a.z + b.z}
+}
+
+func (a vec3) scale(s float64) vec3 {
+	return vec3{a.x * s, a.y * s, a.z * s}
+}
+
+func (a vec3) cross(b vec3) vec3 {
+	return vec3{a.y*b.z - a.z*b.y, a.z*b.x - a.x*b.z, a.x*b.y - a.y*b.x}
+}
+
+// lensModel projects rays to source pixels.
+type lensModel struct {
+	fx, fy, ppx, ppy float64
+	brownConrady     *transform.BrownConrady
+	kannalaBrandt    [maxKannalaBrandtParams]float64
+	fisheye          bool
+}
+
+// newLensModel returns the model for frames of width x height, scaling the calibrated intrinsics if needed.
+func newLensModel(c *LensCorrection, width, height int) lensModel {
+	in := c.IntrinsicParams
+	sx, sy := float64(width)/float64(in.Width), float64(height)/float64(in.Height)
+	m := lensModel{fx: in.Fx * sx, fy: in.Fy * sy, ppx: in.Ppx * sx, ppy: in.Ppy * sy}
+	if c.distortionModel() == transform.KannalaBrandtDistortionType {
+		m.fisheye = true
+		copy(m.kannalaBrandt[:], c.DistortionParams)
+	} else {
+		// validated in LensCorrection.validate
+		m.brownConrady, _ = transform.NewBrownConrady(c.DistortionParams)
+	}
+	return m
+}
+
+// project returns the source pixel the ray lands on, and false if the lens can't see it.
+func (m lensModel) project(ray vec3) (float64, float64, bool) {
+	var xd, yd float64
+	if m.fisheye {
+		r := math.Hypot(ray.x, ray.y)
+		theta := math.Atan2(r, ray.z)
+		t2 := theta * theta
+		k := m.kannalaBrandt
+		thetaD := theta * (1 + t2*(k[0]+t2*(k[1]+t2*(k[2]+t2*k[3]))))
+		if r == 0 {
+			xd, yd = 0, 0
+		} else {
+			xd, yd = thetaD*ray.x/r, thetaD*ray.y/r
+		}
+	} else {
+		if ray.z <= 0 {
+			return 0, 0, false
+		}
+		xd, yd = m.brownConrady.Transform(ray.x/ray.z, ray.y/ray.z)
+	}
+	return m.fx*xd + m.ppx, m.fy*yd + m.ppy, true
+}
+
+// outputIntrinsics returns the pinhole intrinsics of undistorted frames of width x height.
+func (c *LensCorrection) outputIntrinsics(width, height int) *transform.PinholeCameraIntrinsics {
+	m := newLensModel(c, width, height)
+	fx, fy := m.fx, m.fy
+	if c.FovDeg > 0 {
+		fx = float64(width) / 2 / math.Tan(c.FovDeg*math.Pi/360)
+		fy = fx * m.fy / m.fx
+	}
+	return &transform.PinholeCameraIntrinsics{Width: width, Height: height, Fx: fx, Fy: fy, Ppx: m.ppx, Ppy: m.ppy}
+}
+
+// rayFunc returns the direction seen by output pixel (u, v) of a width x height frame.
+type rayFunc func(u, v float64) vec3
+
+func (c *LensCorrection) rays(width, height int) rayFunc {
+	w, h := float64(width), float64(height)
+	switch c.mode() {
+	case lensModePanorama:
+		// each column is an azimuth and rows run from the horizon down to panoramaMinThetaDeg
+		maxTheta, minTheta := math.Pi/2, panoramaMinThetaDeg*math.Pi/180
+		return func(u, v float64) vec3 {
+			phi := 2 * math.Pi * (u + 0.5) / w
+			theta := maxTheta - (v+0.5)/h*(maxTheta-minTheta)
+			return vec3{math.Sin(theta) * math.Cos(phi), math.Sin(theta) * math.Sin(phi), math.Cos(theta)}
+		}
+	case lensModeQuad:
+		fov, tilt := c.FovDeg, c.TiltDeg
+		if fov == 0 {
+			fov = defaultQuadFovDeg
+		}
+		if tilt == 0 {
+			tilt = defaultQuadTiltDeg
+		}
+		tilt *= math.Pi / 180
+		qw, qh := w/2, h/2
+		f := qw / 2 / math.Tan(fov*math.Pi/360)
+		// quadrants look out at 0, 90, 180 and 270 degrees, clockwise from the top left
+		quadrants := [4]int{0, 1, 3, 2}
+		return func(u, v float64) vec3 {
+			col, row := min(max(int(u/qw), 0), 1), min(max(int(v/qh), 0), 1)
+			quadrant := quadrants[row*2+col]
+			phi := float64(quadrant) * math.Pi / 2
+			forward := vec3{math.Sin(tilt) * math.Cos(phi), math.Sin(tilt) * math.Sin(phi), math.Cos(tilt)}
+			right := vec3{-math.Sin(phi), math.Cos(phi), 0}
+			// down points back towards the optical axis, so the horizon is at the top of each view
+			down := forward.cross(right)
+			x := (u - float64(col)*qw + 0.5 - qw/2) / f
+			y := (v - float64(row)*qh + 0.5 - qh/2) / f
+			return forward.add(right.scale(x)).add(down.scale(y))
+		}
+	default:
+		out := c.outputIntrinsics(width, height)
+		return func(u, v float64) vec3 {
+			return vec3{(u - out.Ppx) / out.Fx, (v - out.Ppy) / out.Fy, 1}
+		}
+	}
+}
+
+// lensSample is where one output pixel is read from: a pixel and the bilinear weights of its
+// right and lower neighbours. x is -1 for pixels the lens can't see.
+type lensSample struct {
+	x, y   int32
+	wx, wy uint16
+}
+
+// lensMap holds the samples for each plane of a frame size.
+type lensMap struct {
+	width, height int
+	format        C.int
+	planes        [][]lensSample
+}
+
+// lensCorrector remaps decoded frames. The map is rebuilt when the frame size changes.
+type lensCorrector struct {
+	cfg    *LensCorrection
+	logger logging.Logger
+
+	mu    sync.Mutex
+	remap *lensMap
+}
+
+// newLensCorrector returns nil when cfg is nil.
+func newLensCorrector(cfg *LensCorrection, logger logging.Logger) *lensCorrector {
+	if cfg == nil {
+		return nil
+	}
+	return &lensCorrector{cfg: cfg, logger: logger}
+}
+
+// buildLensMap computes the samples for every plane of a frame.
+func buildLensMap(c *LensCorrection, planes []plane, width, height int, format C.int) *lensMap {
+	m := newLensModel(c, width, height)
+	rays := c.rays(width, height)
+	lm := &lensMap{width: width, height: height, format: format}
+	for _, p := range planes {
+		sx, sy := float64(int(1)<<p.shiftX), float64(int(1)<<p.shiftY)
+		samples := make([]lensSample, 0, p.width*p.height)
+		for y := range p.height {
+			for x := range p.width {
+				// the luma position of this plane pixel's center
+				u, v := (float64(x)+0.5)*sx-0.5, (float64(y)+0.5)*sy-0.5
+				srcU, srcV, ok := m.project(rays(u, v))
+				// back to plane coordinates
+				px, py := (srcU+0.5)/sx-0.5, (srcV+0.5)/sy-0.5
+				if !ok || px < 0 || py < 0 || px > float64(p.width-1) || py > float64(p.height-1) {
+					samples = append(samples, lensSample{x: -1})
+					continue
+				}
+				x0, y0 := math.Floor(px), math.Floor(py)
+				samples = append(samples, lensSample{
+					x:  int32(x0),
+					y:  int32(y0),
+					wx: uint16((px - x0) * lensWeightScale),
+					wy: uint16((py - y0) * lensWeightScale),
+				})
+			}
+		}
+		lm.planes = append(lm.planes, samples)
+	}
+	return lm
+}
+
+// apply writes the corrected src into dst, which must have the same size and format.
+func (lc *lensCorrector) apply(src, dst *C.AVFrame) error {
+	srcPlanes, err := framePlanes(src)
+	if err != nil {
+		return fmt.Errorf("lens correction: %w", err)
+	}
+	dstPlanes, err := framePlanes(dst)
+	if err != nil {
+		return fmt.Errorf("lens correction: %w", err)
+	}
+	width, height := int(src.width), int(src.height)
+	lc.mu.Lock()
+	if lc.remap == nil || lc.remap.width != width || lc.remap.height != height || lc.remap.format != src.format {
+		lc.logger.Debugf("building %s lens correction map for %dx%d frames", lc.cfg.mode(), width, height)
+		lc.remap = buildLensMap(lc.cfg, srcPlanes, width, height, src.format)
+	}
+	remap := lc.remap
+	lc.mu.Unlock()
+
+	for i, sp := range srcPlanes {
+		dp := dstPlanes[i]
+		samples := remap.planes[i]
+		for y := range dp.height {
+			row := dp.data[y*dp.stride : y*dp.stride+dp.width]
+			for x := range row {
+				s := samples[y*dp.width+x]
+				if s.x < 0 {
+					row[x] = sp.black
+					continue
+				}
+				x1, y1 := min(int(s.x)+1, sp.width-1), min(int(s.y)+1, sp.height-1)
+				top := sp.data[int(s.y)*sp.stride:]
+				bottom := sp.data[y1*sp.stride:]
+				wx, wy := int(s.wx), int(s.wy)
+				t := int(top[s.x])*(lensWeightScale-wx) + int(top[x1])*wx
+				b := int(bottom[s.x])*(lensWeightScale-wx) + int(bottom[x1])*wx
+				row[x] = byte((t*(lensWeightScale-wy) + b*wy) / (lensWeightScale * lensWeightScale))
+			}
+		}
+	}
+	return nil
+}
+
+// intrinsics returns the intrinsics of corrected frames, which are only a single pinhole view in undistort mode.
+func (lc *lensCorrector) intrinsics(width, height int) *transform.PinholeCameraIntrinsics {
+	if lc == nil || lc.cfg.mode() != lensModeUndistort {
+		return nil
+	}
+	if width <= 0 || height <= 0 {
+		width, height = lc.cfg.IntrinsicParams.Width, lc.cfg.IntrinsicParams.Height
+	}
+	return lc.cfg.outputIntrinsics(width, height)
+}
+
+// correct returns a corrected copy of frame from the pool and puts frame back, or frame itself if
+// correction fails. frame must not be shared yet.
+func (lc *lensCorrector) correct(pool *framePool, frame *avFrameWrapper) *avFrameWrapper {
+	if lc == nil {
+		return frame
+	}
+	dst, err := poolFrameFor(pool, frame.frame, lc.logger)
+	if err != nil {
+		lc.logger.Debugw("error getting frame for lens correction", "err", err.Error())
+		return frame
+	}
+	if err := lc.apply(frame.frame, dst.frame); err != nil {
+		lc.logger.Debugw("error correcting lens distortion", "err", err.Error())
+		pool.put(dst)
+		return frame
+	}
+	if res := C.av_frame_copy_props(dst.frame, frame.frame); res < 0 {
+		lc.logger.Debugw("error correcting lens distortion", "err", newAvError(res, "av_frame_copy_props() failed").Error())
+		pool.put(dst)
+		return frame
+	}
+	pool.put(frame)
+	return dst
+}
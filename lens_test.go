@@ -0,0 +1,158 @@
+package viamrtsp
+
+import (
+	"math"
+	"testing"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/rimage/transform"
+	"go.viam.com/test"
+)
+
+func testIntrinsics(width, height int) *transform.PinholeCameraIntrinsics {
+	return &transform.PinholeCameraIntrinsics{
+		Width:  width,
+		Height: height,
+		Fx:     float64(width) / 2,
+		Fy:     float64(width) / 2,
+		Ppx:    float64(width) / 2,
+		Ppy:    float64(height) / 2,
+	}
+}
+
+func TestLensCorrectionValidate(t *testing.T) {
+	valid := func() *LensCorrection {
+		return &LensCorrection{IntrinsicParams: testIntrinsics(640, 480), DistortionParams: []float64{0.1, 0, 0, 0, 0}}
+	}
+	test.That(t, valid().validate(), test.ShouldBeNil)
+
+	c := valid()
+	c.IntrinsicParams = nil
+	test.That(t, c.validate(), test.ShouldBeError, "lens_correction requires intrinsic_parameters")
+
+	c = valid()
+	c.DistortionModel = "mystery"
+	test.That(t, c.validate().Error(), test.ShouldContainSubstring, "invalid lens_correction distortion_model")
+
+	c = valid()
+	c.Mode = lensModePanorama
+	test.That(t, c.validate().Error(), test.ShouldContainSubstring, "requires the kannala_brandt distortion_model")
+
+	c = valid()
+	c.DistortionModel = string(transform.KannalaBrandtDistortionType)
+	c.DistortionParams = []float64{0, 0, 0, 0, 0}
+	test.That(t, c.validate().Error(), test.ShouldContainSubstring, "at most 4 distortion_parameters")
+
+	c.DistortionParams = []float64{0.01}
+	c.Mode = lensModeQuad
+	test.That(t, c.validate(), test.ShouldBeNil)
+
+	c.Mode = "sideways"
+	test.That(t, c.validate().Error(), test.ShouldContainSubstring, "invalid lens_correction mode")
+
+	c = valid()
+	c.FovDeg = 180
+	test.That(t, c.validate(), test.ShouldBeError, "lens_correction fov_deg must be between 0 and 180")
+}
+
+func TestLensModel(t *testing.T) {
+	t.Run("intrinsics scale with the frame size", func(t *testing.T) {
+		c := &LensCorrection{IntrinsicParams: testIntrinsics(640, 480)}
+		out := c.outputIntrinsics(320, 240)
+		test.That(t, out.Width, test.ShouldEqual, 320)
+		test.That(t, out.Fx, test.ShouldEqual, 160)
+		test.That(t, out.Ppy, test.ShouldEqual, 120)
+
+		c.FovDeg = 90
+		out = c.outputIntrinsics(320, 240)
+		test.That(t, out.Fx, test.ShouldAlmostEqual, 160)
+		test.That(t, out.Fy, test.ShouldAlmostEqual, 160)
+	})
+
+	t.Run("fisheye projects by angle", func(t *testing.T) {
+		c := &LensCorrection{
+			IntrinsicParams: testIntrinsics(640, 480),
+			DistortionModel: string(transform.KannalaBrandtDistortionType),
+		}
+		m := newLensModel(c, 640, 480)
+		x, y, ok := m.project(vec3{0, 0, 1})
+		test.That(t, ok, test.ShouldBeTrue)
+		test.That(t, x, test.ShouldEqual, 320)
+		test.That(t, y, test.ShouldEqual, 240)
+		// a ray at 90 degrees lands pi/2 focal lengths from the center
+		x, _, ok = m.project(vec3{1, 0, 0})
+		test.That(t, ok, test.ShouldBeTrue)
+		test.That(t, x, test.ShouldAlmostEqual, 320+320*math.Pi/2)
+	})
+
+	t.Run("pinhole can't see behind the camera", func(t *testing.T) {
+		m := newLensModel(&LensCorrection{IntrinsicParams: testIntrinsics(640, 480)}, 640, 480)
+		_, _, ok := m.project(vec3{0, 0, -1})
+		test.That(t, ok, test.ShouldBeFalse)
+	})
+}
+
+func TestLensCorrectorApply(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+
+	t.Run("no distortion leaves the frame unchanged", func(t *testing.T) {
+		src := createTestYUV420PFrame(64, 48)
+		test.That(t, src, test.ShouldNotBeNil)
+		defer freeFrame(src)
+		fillDummyYUV420PData(src)
+		for x := range 64 {
+			setFramePixel(src, 0, x, 10, byte(x*3))
+		}
+		dst := createTestYUV420PFrame(64, 48)
+		test.That(t, dst, test.ShouldNotBeNil)
+		defer freeFrame(dst)
+
+		lc := newLensCorrector(&LensCorrection{IntrinsicParams: testIntrinsics(64, 48)}, logger)
+		test.That(t, lc.apply(src, dst), test.ShouldBeNil)
+		for x := range 64 {
+			test.That(t, framePixel(dst, 0, x, 10), test.ShouldEqual, x*3)
+		}
+		test.That(t, framePixel(dst, 1, 5, 5), test.ShouldEqual, framePixel(src, 1, 5, 5))
+	})
+
+	for _, mode := range []string{lensModePanorama, lensModeQuad} {
+		t.Run(mode, func(t *testing.T) {
+			src := createTestYUV420PFrame(320, 240)
+			test.That(t, src, test.ShouldNotBeNil)
+			defer freeFrame(src)
+			fillDummyYUV420PData(src)
+			dst := createTestYUV420PFrame(320, 240)
+			test.That(t, dst, test.ShouldNotBeNil)
+			defer freeFrame(dst)
+
+			lc := newLensCorrector(&LensCorrection{
+				IntrinsicParams:  &transform.PinholeCameraIntrinsics{Width: 320, Height: 240, Fx: 80, Fy: 80, Ppx: 160, Ppy: 120},
+				DistortionModel:  string(transform.KannalaBrandtDistortionType),
+				DistortionParams: []float64{0.01},
+				Mode:             mode,
+			}, logger)
+			test.That(t, lc.apply(src, dst), test.ShouldBeNil)
+			test.That(t, lc.intrinsics(320, 240), test.ShouldBeNil)
+		})
+	}
+
+	t.Run("unsupported pixel format", func(t *testing.T) {
+		src := createTestRGBAFrame(16, 16)
+		test.That(t, src, test.ShouldNotBeNil)
+		defer freeFrame(src)
+		lc := newLensCorrector(&LensCorrection{IntrinsicParams: testIntrinsics(16, 16)}, logger)
+		test.That(t, lc.apply(src, src), test.ShouldNotBeNil)
+	})
+}
+
+func TestLensCorrectorIntrinsics(t *testing.T) {
+	var lc *lensCorrector
+	test.That(t, lc.intrinsics(640, 480), test.ShouldBeNil)
+
+	lc = newLensCorrector(&LensCorrection{IntrinsicParams: testIntrinsics(640, 480)}, logging.NewTestLogger(t))
+	// before the first frame the calibrated size is used
+	in := lc.intrinsics(0, 0)
+	test.That(t, in.Width, test.ShouldEqual, 640)
+	test.That(t, in.Fx, test.ShouldEqual, 320)
+	test.That(t, lc.intrinsics(1280, 960).Fx, test.ShouldEqual, 640)
+}
@@ -89,3 +89,13 @@ func freeFrame(frame *C.AVFrame) {
 func createTestRGBAFrame(width, height int) *C.AVFrame {
 	return createTestFrame(width, height, C.AV_PIX_FMT_RGBA)
 }
+
+// framePixel returns the value of a pixel in one plane of a frame.
+func framePixel(frame *C.AVFrame, plane, x, y int) byte {
+	return *(*byte)(unsafe.Add(unsafe.Pointer(frame.data[plane]), y*int(frame.linesize[plane])+x))
+}
+
+// setFramePixel sets the value of a pixel in one plane of a frame.
+func setFramePixel(frame *C.AVFrame, plane, x, y int, v byte) {
+	*(*byte)(unsafe.Add(unsafe.Pointer(frame.data[plane]), y*int(frame.linesize[plane])+x)) = v
+}
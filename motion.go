@@ -0,0 +1,272 @@
+package viamrtsp
+
+/*
+#include <libavutil/frame.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMotionSampleInterval = 200 * time.Millisecond
+	defaultMotionSensitivity    = 50
+	defaultMotionMinArea        = 0.01
+	defaultMotionHold           = 5 * time.Second
+	// frames are compared at this width, which is plenty to see motion and cheap to diff
+	motionSampleWidth = 160
+	// per pixel luma difference needed to count as changed at sensitivity 100 and 0
+	minMotionPixelThreshold = 5
+	maxMotionPixelThreshold = 55
+	maxMotionSensitivity    = 100
+)
+
+// MotionZone is a polygon that motion is detected in.
+type MotionZone struct {
+	Points []FramePoint `json:"points"`
+}
+
+// Motion configures software motion detection on the decoded stream.
+type Motion struct {
+	// SampleIntervalMs is how often the latest frame is compared with the previous sample.
+	SampleIntervalMs int `json:"sample_interval_ms,omitempty"`
+	// Sensitivity from 0 to 100, higher detects smaller changes in brightness.
+	Sensitivity *int `json:"sensitivity,omitempty"`
+	// MinArea is the fraction of the zones, or of the frame without zones, that must change.
+	MinArea float64      `json:"min_area,omitempty"`
+	Zones   []MotionZone `json:"zones,omitempty"`
+	// HoldSec is how long motion is reported after the last changed sample.
+	HoldSec *float64 `json:"hold_sec,omitempty"`
+	// DecodeOnMotion decodes only keyframes until motion is detected, then every frame.
+	DecodeOnMotion bool `json:"decode_on_motion,omitempty"`
+}
+
+func (c *Motion) sampleInterval() time.Duration {
+	if c.SampleIntervalMs == 0 {
+		return defaultMotionSampleInterval
+	}
+	return time.Duration(c.SampleIntervalMs) * time.Millisecond
+}
+
+func (c *Motion) sensitivity() int {
+	if c.Sensitivity == nil {
+		return defaultMotionSensitivity
+	}
+	return *c.Sensitivity
+}
+
+func (c *Motion) minArea() float64 {
+	if c.MinArea == 0 {
+		return defaultMotionMinArea
+	}
+	return c.MinArea
+}
+
+func (c *Motion) hold() time.Duration {
+	if c.HoldSec == nil {
+		return defaultMotionHold
+	}
+	return time.Duration(*c.HoldSec * float64(time.Second))
+}
+
+// pixelThreshold maps sensitivity to the luma difference a sampled pixel needs to count as changed.
+func (c *Motion) pixelThreshold() int {
+	span := maxMotionPixelThreshold - minMotionPixelThreshold
+	return minMotionPixelThreshold + span*(maxMotionSensitivity-c.sensitivity())/maxMotionSensitivity
+}
+
+func (c *Motion) validate(conf *Config) error {
+	if c.SampleIntervalMs < 0 {
+		return errors.New("motion sample_interval_ms can't be negative")
+	}
+	if s := c.sensitivity(); s < 0 || s > maxMotionSensitivity {
+		return fmt.Errorf("motion sensitivity must be between 0 and %d", maxMotionSensitivity)
+	}
+	if c.MinArea < 0 || c.MinArea > 1 {
+		return errors.New("motion min_area must be between 0 and 1")
+	}
+	if c.HoldSec != nil && *c.HoldSec < 0 {
+		return errors.New("motion hold_sec can't be negative")
+	}
+	for i, z := range c.Zones {
+		if err := (PrivacyMask{Points: z.Points}).validate(); err != nil {
+			return fmt.Errorf("motion zones[%d]: %w", i, err)
+		}
+	}
+	if c.DecodeOnMotion && (conf.LazyDecode || conf.IframeOnlyDecode) {
+		return errors.New("motion decode_on_motion can't be combined with lazy_decode or i_frame_only_decode")
+	}
+	return nil
+}
+
+// motionDetector compares downscaled luma planes of the latest frame.
+type motionDetector struct {
+	cfg *Motion
+
+	mu         sync.Mutex
+	prev       []byte
+	width      int
+	height     int
+	zone       []bool
+	zonePixels int
+	score      float64
+	motion     bool
+	lastMotion time.Time
+	lastSample time.Time
+	events     int
+
+	// decodingAll is whether every frame since the last keyframe is being decoded, see allowDecode.
+	decodingAll atomic.Bool
+}
+
+// newMotionDetector returns nil when cfg is nil.
+func newMotionDetector(cfg *Motion) *motionDetector {
+	if cfg == nil {
+		return nil
+	}
+	return &motionDetector{cfg: cfg}
+}
+
+// update compares a downscaled luma plane with the previous one.
+func (d *motionDetector) update(luma []byte, width, height int, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lastSample = now
+	if width != d.width || height != d.height {
+		d.width, d.height = width, height
+		d.zone, d.zonePixels = motionZoneMask(d.cfg.Zones, width, height)
+		d.prev = append(d.prev[:0], luma...)
+		return
+	}
+	threshold := d.cfg.pixelThreshold()
+	changed := 0
+	for i, v := range luma {
+		if d.zone != nil && !d.zone[i] {
+			continue
+		}
+		diff := int(v) - int(d.prev[i])
+		if diff > threshold || -diff > threshold {
+			changed++
+		}
+	}
+	copy(d.prev, luma)
+	d.score = float64(changed) / float64(max(1, d.zonePixels))
+	if d.score >= d.cfg.minArea() {
+		if !d.motion {
+			d.events++
+		}
+		d.motion = true
+		d.lastMotion = now
+	} else if d.motion && now.Sub(d.lastMotion) >= d.cfg.hold() {
+		d.motion = false
+	}
+}
+
+// motionZoneMask returns which pixels are in a zone, or nil when the whole frame is.
+func motionZoneMask(zones []MotionZone, width, height int) ([]bool, int) {
+	if len(zones) == 0 {
+		return nil, width * height
+	}
+	mask := make([]bool, width*height)
+	count := 0
+	for _, z := range zones {
+		for y, row := range rasterizeMask(PrivacyMask{Points: z.Points}, width, height).rows {
+			for _, s := range row {
+				for x := s.x0; x < s.x1; x++ {
+					if !mask[y*width+x] {
+						mask[y*width+x] = true
+						count++
+					}
+				}
+			}
+		}
+	}
+	return mask, count
+}
+
+// due reports whether enough time has passed since the last sample to take another.
+func (d *motionDetector) due(now time.Time) bool {
+	if d == nil {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return now.Sub(d.lastSample) >= d.cfg.sampleInterval()
+}
+
+// sample runs detection on frame if a sample is due. It must be called before the overlay
+// is drawn, or a changing timestamp would count as motion.
+func (d *motionDetector) sample(frame *C.AVFrame) error {
+	now := time.Now()
+	if frame == nil || !d.due(now) {
+		return nil
+	}
+	luma, width, height, err := downscaleLuma(frame, motionSampleWidth)
+	if err != nil {
+		return fmt.Errorf("motion detection: %w", err)
+	}
+	d.update(luma, width, height, now)
+	return nil
+}
+
+func (d *motionDetector) active() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.motion
+}
+
+// allowDecode reports whether an access unit should be decoded when decode_on_motion is set.
+// Keyframes are always decoded so motion can be detected, and decoding every frame only starts
+// at a keyframe so the decoder never sees frames that reference ones it skipped.
+func (d *motionDetector) allowDecode(keyframe bool) bool {
+	if d == nil || !d.cfg.DecodeOnMotion {
+		return true
+	}
+	if keyframe {
+		d.decodingAll.Store(d.active())
+		return true
+	}
+	return d.decodingAll.Load()
+}
+
+// doCommandState is returned by the get-motion DoCommand and the motion-sensor readings.
+func (d *motionDetector) doCommandState() map[string]interface{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	state := map[string]interface{}{
+		"motion":       d.motion,
+		"score":        d.score,
+		"motion_count": d.events,
+	}
+	if !d.lastMotion.IsZero() {
+		state["last_motion"] = d.lastMotion.UTC().Format(time.RFC3339)
+	}
+	if !d.lastSample.IsZero() {
+		state["last_sample"] = d.lastSample.UTC().Format(time.RFC3339)
+	}
+	return state
+}
+
+// downscaleLuma samples the luma plane of frame down to about width pixels across.
+func downscaleLuma(frame *C.AVFrame, width int) ([]byte, int, int, error) {
+	planes, err := framePlanes(frame)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	y := planes[0]
+	step := max(1, y.width/width)
+	w, h := y.width/step, y.height/step
+	out := make([]byte, 0, w*h)
+	for row := range h {
+		line := y.data[row*step*y.stride:]
+		for col := range w {
+			out = append(out, line[col*step])
+		}
+	}
+	return out, w, h, nil
+}
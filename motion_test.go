@@ -0,0 +1,187 @@
+package viamrtsp
+
+import (
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+)
+
+func TestMotionDetector(t *testing.T) {
+	const width, height = 10, 10
+	still := func() []byte {
+		luma := make([]byte, width*height)
+		for i := range luma {
+			luma[i] = 100
+		}
+		return luma
+	}
+	// moved brightens a block of pixels in the top left corner
+	moved := func(size int) []byte {
+		luma := still()
+		for y := range size {
+			for x := range size {
+				luma[y*width+x] = 200
+			}
+		}
+		return luma
+	}
+	start := time.Now()
+
+	t.Run("first sample sets the baseline", func(t *testing.T) {
+		d := newMotionDetector(&Motion{})
+		d.update(moved(5), width, height, start)
+		test.That(t, d.active(), test.ShouldBeFalse)
+		test.That(t, d.doCommandState()["score"], test.ShouldEqual, 0.0)
+	})
+
+	t.Run("changes above min_area are motion and held for hold_sec", func(t *testing.T) {
+		hold := 2.0
+		d := newMotionDetector(&Motion{MinArea: 0.2, HoldSec: &hold})
+		d.update(still(), width, height, start)
+		d.update(moved(5), width, height, start.Add(time.Second))
+		test.That(t, d.active(), test.ShouldBeTrue)
+		state := d.doCommandState()
+		test.That(t, state["score"], test.ShouldAlmostEqual, 0.25)
+		test.That(t, state["motion_count"], test.ShouldEqual, 1)
+		test.That(t, state["last_motion"], test.ShouldNotBeEmpty)
+
+		// the scene is still again, but within the hold time
+		d.update(moved(5), width, height, start.Add(2*time.Second))
+		test.That(t, d.active(), test.ShouldBeTrue)
+		d.update(moved(5), width, height, start.Add(3*time.Second))
+		test.That(t, d.active(), test.ShouldBeFalse)
+		test.That(t, d.doCommandState()["motion_count"], test.ShouldEqual, 1)
+	})
+
+	t.Run("small changes are ignored", func(t *testing.T) {
+		d := newMotionDetector(&Motion{MinArea: 0.2})
+		d.update(still(), width, height, start)
+		d.update(moved(3), width, height, start.Add(time.Second))
+		test.That(t, d.active(), test.ShouldBeFalse)
+	})
+
+	t.Run("sensitivity sets the brightness change needed", func(t *testing.T) {
+		low := 0
+		d := newMotionDetector(&Motion{Sensitivity: &low})
+		faint := still()
+		for i := range faint {
+			faint[i] += 40
+		}
+		d.update(still(), width, height, start)
+		d.update(faint, width, height, start.Add(time.Second))
+		test.That(t, d.active(), test.ShouldBeFalse)
+
+		high := 100
+		d = newMotionDetector(&Motion{Sensitivity: &high})
+		d.update(still(), width, height, start)
+		d.update(faint, width, height, start.Add(time.Second))
+		test.That(t, d.active(), test.ShouldBeTrue)
+	})
+
+	t.Run("only changes inside zones count", func(t *testing.T) {
+		zone := MotionZone{Points: square(0.5, 0.5, 1, 1, "").Points}
+		d := newMotionDetector(&Motion{MinArea: 0.1, Zones: []MotionZone{zone}})
+		d.update(still(), width, height, start)
+		d.update(moved(5), width, height, start.Add(time.Second))
+		test.That(t, d.active(), test.ShouldBeFalse)
+
+		inZone := still()
+		inZone[9*width+9] = 200
+		inZone[8*width+9] = 200
+		inZone[9*width+8] = 200
+		d.update(inZone, width, height, start.Add(2*time.Second))
+		test.That(t, d.active(), test.ShouldBeTrue)
+		// 3 of the 25 zone pixels changed
+		test.That(t, d.doCommandState()["score"], test.ShouldAlmostEqual, 0.12)
+	})
+
+	t.Run("resolution changes reset the baseline", func(t *testing.T) {
+		d := newMotionDetector(&Motion{})
+		d.update(still(), width, height, start)
+		d.update(make([]byte, 20), 5, 4, start.Add(time.Second))
+		test.That(t, d.active(), test.ShouldBeFalse)
+	})
+}
+
+func TestMotionAllowDecode(t *testing.T) {
+	var nilDetector *motionDetector
+	test.That(t, nilDetector.allowDecode(false), test.ShouldBeTrue)
+	test.That(t, newMotionDetector(&Motion{}).allowDecode(false), test.ShouldBeTrue)
+
+	d := newMotionDetector(&Motion{DecodeOnMotion: true, MinArea: 0.5})
+	test.That(t, d.allowDecode(true), test.ShouldBeTrue)
+	test.That(t, d.allowDecode(false), test.ShouldBeFalse)
+
+	now := time.Now()
+	d.update(make([]byte, 4), 2, 2, now)
+	d.update([]byte{255, 255, 255, 255}, 2, 2, now.Add(time.Second))
+	test.That(t, d.active(), test.ShouldBeTrue)
+	// motion only turns on full decoding at the next keyframe
+	test.That(t, d.allowDecode(false), test.ShouldBeFalse)
+	test.That(t, d.allowDecode(true), test.ShouldBeTrue)
+	test.That(t, d.allowDecode(false), test.ShouldBeTrue)
+}
+
+func TestMotionSample(t *testing.T) {
+	frame := createTestYUV420PFrame(320, 240)
+	test.That(t, frame, test.ShouldNotBeNil)
+	defer freeFrame(frame)
+	fillDummyYUV420PData(frame)
+
+	luma, w, h, err := downscaleLuma(frame, motionSampleWidth)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, w, test.ShouldEqual, 160)
+	test.That(t, h, test.ShouldEqual, 120)
+	test.That(t, len(luma), test.ShouldEqual, w*h)
+	test.That(t, luma[1], test.ShouldEqual, framePixel(frame, 0, 2, 0))
+
+	d := newMotionDetector(&Motion{})
+	test.That(t, d.sample(frame), test.ShouldBeNil)
+	test.That(t, d.doCommandState()["last_sample"], test.ShouldNotBeEmpty)
+	// the next sample isn't due until the interval passes
+	test.That(t, d.due(time.Now()), test.ShouldBeFalse)
+	test.That(t, d.due(time.Now().Add(defaultMotionSampleInterval)), test.ShouldBeTrue)
+
+	invalid := createInvalidFrame()
+	defer freeFrame(invalid)
+	_, _, _, err = downscaleLuma(invalid, motionSampleWidth)
+	test.That(t, err, test.ShouldNotBeNil)
+}
+
+func TestMotionValidate(t *testing.T) {
+	base := &Config{Address: "rtsp://127.0.0.1:8554/live"}
+	negative := -1
+	tooHigh := 101
+	negativeHold := -1.0
+
+	for _, tc := range []struct {
+		name   string
+		motion Motion
+		conf   Config
+		err    string
+	}{
+		{name: "defaults", motion: Motion{}},
+		{name: "negative interval", motion: Motion{SampleIntervalMs: -1}, err: "sample_interval_ms"},
+		{name: "negative sensitivity", motion: Motion{Sensitivity: &negative}, err: "sensitivity"},
+		{name: "sensitivity above 100", motion: Motion{Sensitivity: &tooHigh}, err: "sensitivity"},
+		{name: "min_area above 1", motion: Motion{MinArea: 1.5}, err: "min_area"},
+		{name: "negative hold", motion: Motion{HoldSec: &negativeHold}, err: "hold_sec"},
+		{name: "zone with two points", motion: Motion{Zones: []MotionZone{{Points: []FramePoint{{}, {X: 1}}}}}, err: "zones[0]"},
+		{name: "decode_on_motion with lazy_decode", motion: Motion{DecodeOnMotion: true}, conf: Config{LazyDecode: true}, err: "decode_on_motion"},
+		{name: "decode_on_motion with i_frame_only_decode", motion: Motion{DecodeOnMotion: true}, conf: Config{IframeOnlyDecode: true}, err: "decode_on_motion"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := tc.conf
+			conf.Address = base.Address
+			conf.Motion = &tc.motion
+			_, _, err := conf.Validate("path")
+			if tc.err == "" {
+				test.That(t, err, test.ShouldBeNil)
+				return
+			}
+			test.That(t, err, test.ShouldNotBeNil)
+			test.That(t, err.Error(), test.ShouldContainSubstring, tc.err)
+		})
+	}
+}
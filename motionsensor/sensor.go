@@ -0,0 +1,80 @@
+// Package motionsensor implements a sensor that reports motion detected by a viamrtsp camera.
+package motionsensor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/viam-modules/viamrtsp"
+	"go.viam.com/rdk/components/camera"
+	"go.viam.com/rdk/components/sensor"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/resource"
+)
+
+// Model is the model for the motion sensor.
+var Model = viamrtsp.Family.WithModel("motion-sensor")
+
+func init() {
+	resource.RegisterComponent(
+		sensor.API,
+		Model,
+		resource.Registration[sensor.Sensor, *Config]{
+			Constructor: newMotionSensor,
+		},
+	)
+}
+
+// Config is the config for the motion sensor.
+type Config struct {
+	// Camera is a viamrtsp camera with the motion attribute configured.
+	Camera string `json:"camera"`
+}
+
+// Validate validates the config and returns the camera as a dependency.
+func (cfg *Config) Validate(path string) ([]string, []string, error) {
+	if cfg.Camera == "" {
+		return nil, nil, fmt.Errorf(`expected "camera" attribute for %s %q`, Model.String(), path)
+	}
+	return []string{cfg.Camera}, nil, nil
+}
+
+type motionSensor struct {
+	resource.Named
+	resource.AlwaysRebuild
+	resource.TriviallyCloseable
+
+	cam camera.Camera
+}
+
+func newMotionSensor(
+	_ context.Context,
+	deps resource.Dependencies,
+	rawConf resource.Config,
+	_ logging.Logger,
+) (sensor.Sensor, error) {
+	conf, err := resource.NativeConfig[*Config](rawConf)
+	if err != nil {
+		return nil, err
+	}
+	cam, err := camera.FromProvider(deps, conf.Camera)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get camera %q: %w", conf.Camera, err)
+	}
+	return &motionSensor{Named: rawConf.ResourceName().AsNamed(), cam: cam}, nil
+}
+
+// Readings returns the motion state of the camera: motion, score, motion_count and,
+// once they have happened, last_motion and last_sample.
+func (s *motionSensor) Readings(ctx context.Context, _ map[string]interface{}) (map[string]interface{}, error) {
+	readings, err := s.cam.DoCommand(ctx, map[string]interface{}{"command": "get-motion"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get motion from camera: %w", err)
+	}
+	return readings, nil
+}
+
+func (s *motionSensor) DoCommand(_ context.Context, _ map[string]interface{}) (map[string]interface{}, error) {
+	return nil, errors.New("not implemented")
+}
@@ -0,0 +1,67 @@
+package motionsensor
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.viam.com/rdk/components/camera"
+	"go.viam.com/rdk/components/sensor"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/resource"
+	"go.viam.com/test"
+)
+
+// fakeCamera only implements DoCommand, which is all the sensor uses.
+type fakeCamera struct {
+	camera.Camera
+}
+
+func (c *fakeCamera) Name() resource.Name {
+	return camera.Named("cam")
+}
+
+func (c *fakeCamera) DoCommand(_ context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+	if cmd["command"] != "get-motion" {
+		return nil, errors.New("unknown command")
+	}
+	return map[string]interface{}{"motion": true, "score": 0.5}, nil
+}
+
+func TestMotionSensor(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	deps := resource.Dependencies{camera.Named("cam"): &fakeCamera{}}
+
+	t.Run("validate requires a camera", func(t *testing.T) {
+		_, _, err := (&Config{}).Validate("path")
+		test.That(t, err, test.ShouldNotBeNil)
+		deps, _, err := (&Config{Camera: "cam"}).Validate("path")
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, deps, test.ShouldResemble, []string{"cam"})
+	})
+
+	t.Run("readings come from the camera", func(t *testing.T) {
+		conf := resource.Config{
+			Name:                "motion",
+			API:                 sensor.API,
+			Model:               Model,
+			ConvertedAttributes: &Config{Camera: "cam"},
+		}
+		s, err := newMotionSensor(context.Background(), deps, conf, logger)
+		test.That(t, err, test.ShouldBeNil)
+		readings, err := s.Readings(context.Background(), nil)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, readings, test.ShouldResemble, map[string]interface{}{"motion": true, "score": 0.5})
+	})
+
+	t.Run("missing camera fails", func(t *testing.T) {
+		conf := resource.Config{
+			Name:                "motion",
+			API:                 sensor.API,
+			Model:               Model,
+			ConvertedAttributes: &Config{Camera: "other"},
+		}
+		_, err := newMotionSensor(context.Background(), deps, conf, logger)
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+}
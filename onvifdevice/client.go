@@ -0,0 +1,461 @@
+// Package onvifdevice implements a model for maintaining ONVIF devices with the ONVIF device management
+// and media services.
+package onvifdevice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/viam-modules/viamrtsp"
+	"github.com/viam-modules/viamrtsp/viamonvif"
+	"github.com/viam-modules/viamrtsp/viamonvif/device"
+	"go.viam.com/rdk/components/generic"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/resource"
+)
+
+// defaultMaxClockDriftSec is how far a device clock may drift from the machine's before a warning is logged.
+const defaultMaxClockDriftSec = 5
+
+// Model is the model for the ONVIF device management client.
+var Model = viamrtsp.Family.WithModel("onvif-device")
+
+func init() {
+	resource.RegisterComponent(
+		generic.API,
+		Model,
+		resource.Registration[resource.Resource, *Config]{
+			Constructor: newOnvifDevice,
+		},
+	)
+}
+
+// Config represents the configuration for the ONVIF device management client.
+type Config struct {
+	Address  string `json:"address"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// ProfileToken is the media profile whose video encoder the encoder commands change by default.
+	ProfileToken string `json:"profile_token,omitempty"`
+	// MaxClockDriftSec is how many seconds the device clock may differ from the machine's before a warning is logged.
+	MaxClockDriftSec *float64 `json:"max_clock_drift_sec,omitempty"`
+}
+
+// Validate validates the configuration for the ONVIF device management client.
+func (cfg *Config) Validate(path string) ([]string, []string, error) {
+	if cfg.Address == "" {
+		return nil, nil, fmt.Errorf(`expected "address" attribute for %s %q`, Model.String(), path)
+	}
+	if _, err := viamonvif.ParseXAddr(cfg.Address); err != nil {
+		return nil, nil, fmt.Errorf("invalid address for %s %q: %w", Model.String(), path, err)
+	}
+	if cfg.MaxClockDriftSec != nil && *cfg.MaxClockDriftSec <= 0 {
+		return nil, nil, fmt.Errorf(`"max_clock_drift_sec" must be positive for %s %q`, Model.String(), path)
+	}
+	return nil, nil, nil
+}
+
+type onvifDevice struct {
+	resource.Named
+	resource.AlwaysRebuild
+
+	logger logging.Logger
+	cfg    *Config
+	params device.Params
+	// dev calls the device service, which the management commands use. Creating it makes no
+	// requests, so they work even when the device rejects GetCapabilities.
+	dev *device.Device
+	// clock reads the device's time without credentials, which ONVIF devices must allow so
+	// clients can fix a clock drifted too far for WS-Security.
+	clock         *device.Device
+	maxClockDrift time.Duration
+
+	// mediaDev is created the first time an encoder command needs the media service.
+	mediaMu  sync.Mutex
+	mediaDev *device.Device
+
+	cancelCtx  context.Context
+	cancelFunc func()
+}
+
+func newOnvifDevice(
+	ctx context.Context,
+	deps resource.Dependencies,
+	rawConf resource.Config,
+	logger logging.Logger,
+) (resource.Resource, error) {
+	conf, err := resource.NativeConfig[*Config](rawConf)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewClient(ctx, deps, rawConf.ResourceName(), conf, logger)
+}
+
+// NewClient creates a new ONVIF device management client.
+func NewClient(
+	ctx context.Context,
+	_ resource.Dependencies,
+	name resource.Name,
+	conf *Config,
+	logger logging.Logger,
+) (resource.Resource, error) {
+	xaddr, err := viamonvif.ParseXAddr(conf.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ONVIF address %s: %w", conf.Address, err)
+	}
+	params := device.Params{
+		Xaddr:                    xaddr,
+		Username:                 conf.Username,
+		Password:                 conf.Password,
+		SkipLocalTLSVerification: true,
+	}
+	clockParams := params
+	clockParams.Username, clockParams.Password = "", ""
+
+	maxClockDrift := float64(defaultMaxClockDriftSec)
+	if conf.MaxClockDriftSec != nil {
+		maxClockDrift = *conf.MaxClockDriftSec
+	}
+	cancelCtx, cancelFunc := context.WithCancel(context.Background())
+	s := &onvifDevice{
+		Named:         name.AsNamed(),
+		logger:        logger,
+		cfg:           conf,
+		params:        params,
+		dev:           device.NewDeviceServiceClient(params, logger),
+		clock:         device.NewDeviceServiceClient(clockParams, logger),
+		maxClockDrift: time.Duration(maxClockDrift * float64(time.Second)),
+		cancelCtx:     cancelCtx,
+		cancelFunc:    cancelFunc,
+	}
+	// a drifted clock breaks WS-Security authentication and makes recordings hard to line up,
+	// so it is checked as soon as the device is configured
+	if _, err := s.handleGetDateTime(ctx); err != nil {
+		logger.Warnf("failed to check the clock of ONVIF device %s: %v", conf.Address, err)
+	}
+	return s, nil
+}
+
+// media returns the device with the services listed by GetCapabilities, creating it on first use.
+// Creating it is retried on every call until it succeeds, since it fails while the device's clock
+// has drifted too far for authentication.
+func (s *onvifDevice) media(ctx context.Context) (*device.Device, error) {
+	s.mediaMu.Lock()
+	defer s.mediaMu.Unlock()
+	if s.mediaDev != nil {
+		return s.mediaDev, nil
+	}
+	dev, err := device.NewDevice(ctx, s.params, s.logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ONVIF device for %s: %w", s.cfg.Address, err)
+	}
+	s.mediaDev = dev
+	return dev, nil
+}
+
+// clockStatus is a device's clock compared to the machine's.
+type clockStatus struct {
+	device.SystemDateAndTime
+	drift time.Duration
+	// known is false when the device doesn't report its UTC time, so its drift can't be measured.
+	known bool
+}
+
+// getClockStatus returns the device clock and how far it is from the machine's. Devices report their
+// time to the second, so drift under a second isn't meaningful.
+func (s *onvifDevice) getClockStatus(ctx context.Context) (clockStatus, error) {
+	start := time.Now()
+	sdt, err := s.clock.GetSystemDateAndTime(ctx)
+	if err != nil {
+		return clockStatus{}, err
+	}
+	// compare against the middle of the request to leave out the network round trip
+	mid := start.Add(time.Since(start) / 2) //nolint:mnd
+	status := clockStatus{SystemDateAndTime: sdt, known: !sdt.UTC.IsZero()}
+	if status.known {
+		status.drift = sdt.UTC.Sub(mid.UTC().Truncate(time.Second))
+	}
+	return status, nil
+}
+
+func (s *onvifDevice) handleGetDateTime(ctx context.Context) (map[string]interface{}, error) {
+	status, err := s.getClockStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ret := map[string]interface{}{
+		"date_time_type":   status.DateTimeType,
+		"daylight_savings": status.DaylightSavings,
+		"timezone":         status.TimeZone,
+		"machine_utc":      time.Now().UTC().Format(time.RFC3339),
+		"max_drift_sec":    s.maxClockDrift.Seconds(),
+	}
+	if !status.Local.IsZero() {
+		ret["device_local"] = status.Local.Format("2006-01-02T15:04:05")
+	}
+	if !status.known {
+		s.logger.Warnf("ONVIF device %s doesn't report its UTC time, so its clock drift can't be checked", s.cfg.Address)
+		return ret, nil
+	}
+	drifted := status.drift.Abs() > s.maxClockDrift
+	ret["device_utc"] = status.UTC.Format(time.RFC3339)
+	ret["drift_sec"] = status.drift.Seconds()
+	ret["drifted"] = drifted
+	if drifted {
+		s.logger.Warnf("ONVIF device %s clock is %.0fs off from this machine's, more than the %.0fs allowed. "+
+			"Run the sync-time or set-ntp command to fix it", s.cfg.Address, status.drift.Seconds(), s.maxClockDrift.Seconds())
+	}
+	return ret, nil
+}
+
+func (s *onvifDevice) handleGetSystemInfo(ctx context.Context) (map[string]interface{}, error) {
+	info, err := s.dev.GetDeviceInformation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dateTime, err := s.handleGetDateTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"manufacturer":     info.Manufacturer,
+		"model":            info.Model,
+		"firmware_version": info.FirmwareVersion,
+		"serial_number":    info.SerialNumber,
+		"hardware_id":      info.HardwareID,
+		"date_time":        dateTime,
+	}, nil
+}
+
+// handleSyncTime sets the device clock to the machine's. The time zone and daylight savings setting
+// are kept unless they are given.
+func (s *onvifDevice) handleSyncTime(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+	status, err := s.getClockStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	current := status.SystemDateAndTime
+	tz, err := optionalString(cmd, "timezone", current.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := optionalBool(cmd, "daylight_savings", current.DaylightSavings)
+	if err != nil {
+		return nil, err
+	}
+	// sign the request with the device's time, since a drifted device may reject the machine's. The
+	// offset is set on a client of its own so concurrent commands keep signing with the machine's time.
+	syncDev := device.NewDeviceServiceClient(s.params, s.logger)
+	syncDev.SetClockOffset(status.drift)
+	if err := syncDev.SetSystemDateAndTime(ctx, time.Now(), tz, dst); err != nil {
+		return nil, err
+	}
+	s.logger.Infof("set the clock of ONVIF device %s to this machine's time", s.cfg.Address)
+	return s.handleGetDateTime(ctx)
+}
+
+func (s *onvifDevice) handleGetNTP(ctx context.Context) (map[string]interface{}, error) {
+	info, err := s.dev.GetNTP(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sdt, err := s.clock.GetSystemDateAndTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"from_dhcp":      info.FromDHCP,
+		"servers":        info.Servers,
+		"date_time_type": sdt.DateTimeType,
+	}, nil
+}
+
+// handleSetNTP sets the device's NTP servers and has it set its clock from them.
+func (s *onvifDevice) handleSetNTP(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+	fromDHCP, err := optionalBool(cmd, "from_dhcp", false)
+	if err != nil {
+		return nil, err
+	}
+	servers, err := optionalStrings(cmd, "servers")
+	if err != nil {
+		return nil, err
+	}
+	if !fromDHCP && len(servers) == 0 {
+		return nil, errors.New(`"servers" is required unless "from_dhcp" is true`)
+	}
+	current, err := s.clock.GetSystemDateAndTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tz, err := optionalString(cmd, "timezone", current.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.dev.SetNTP(ctx, servers, fromDHCP); err != nil {
+		return nil, err
+	}
+	if err := s.dev.SetSystemDateAndTimeNTP(ctx, tz, current.DaylightSavings); err != nil {
+		return nil, err
+	}
+	return s.handleGetNTP(ctx)
+}
+
+// handleReboot reboots the device. It must be confirmed, since the device stops streaming until it is back up.
+func (s *onvifDevice) handleReboot(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+	confirm, err := optionalBool(cmd, "confirm", false)
+	if err != nil {
+		return nil, err
+	}
+	if !confirm {
+		return nil, errors.New(`reboot requires "confirm": true`)
+	}
+	msg, err := s.dev.SystemReboot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Infof("rebooting ONVIF device %s: %s", s.cfg.Address, msg)
+	return map[string]interface{}{"message": msg}, nil
+}
+
+func (s *onvifDevice) handleGetSystemLog(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+	logType, err := optionalString(cmd, "log_type", device.SystemLogTypeSystem)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(logType) {
+	case "system":
+		logType = device.SystemLogTypeSystem
+	case "access":
+		logType = device.SystemLogTypeAccess
+	default:
+		return nil, fmt.Errorf(`"log_type" must be %q or %q, got %q`, device.SystemLogTypeSystem, device.SystemLogTypeAccess, logType)
+	}
+	log, err := s.dev.GetSystemLog(ctx, logType)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"log_type": logType, "log": log}, nil
+}
+
+func (s *onvifDevice) handleGetUsers(ctx context.Context) (map[string]interface{}, error) {
+	users, err := s.dev.GetUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]interface{}, 0, len(users))
+	for _, u := range users {
+		ret = append(ret, map[string]interface{}{"username": u.Username, "user_level": u.UserLevel})
+	}
+	return map[string]interface{}{"users": ret}, nil
+}
+
+// DoCommand maps incoming commands to the appropriate ONVIF device management action.
+func (s *onvifDevice) DoCommand(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+	command, ok := cmd["command"].(string)
+	if !ok {
+		return nil, errors.New("invalid command request: 'command' key missing or not a string")
+	}
+	s.logger.Debugf("Received command: %s", command)
+
+	// commands stop when the request is cancelled or the component is closed
+	ctx, cancel := mergeCancel(ctx, s.cancelCtx)
+	defer cancel()
+
+	switch strings.ToLower(command) {
+	case "get-system-info":
+		return s.handleGetSystemInfo(ctx)
+	case "get-date-time":
+		return s.handleGetDateTime(ctx)
+	case "sync-time":
+		return s.handleSyncTime(ctx, cmd)
+	case "get-ntp":
+		return s.handleGetNTP(ctx)
+	case "set-ntp":
+		return s.handleSetNTP(ctx, cmd)
+	case "reboot":
+		return s.handleReboot(ctx, cmd)
+	case "get-system-log":
+		return s.handleGetSystemLog(ctx, cmd)
+	case "get-users":
+		return s.handleGetUsers(ctx)
+	case "get-video-encoder-configurations":
+		return s.handleGetVideoEncoderConfigurations(ctx)
+	case "get-video-encoder-configuration":
+		return s.handleGetVideoEncoderConfiguration(ctx, cmd)
+	case "get-video-encoder-configuration-options":
+		return s.handleGetVideoEncoderConfigurationOptions(ctx, cmd)
+	case "set-video-encoder-configuration":
+		return s.handleSetVideoEncoderConfiguration(ctx, cmd)
+	default:
+		return nil, fmt.Errorf("unrecognized DoCommand command: %s", command)
+	}
+}
+
+func (s *onvifDevice) Close(context.Context) error {
+	s.cancelFunc()
+	return nil
+}
+
+// mergeCancel returns a context with the values and deadline of ctx that is also cancelled when other is.
+func mergeCancel(ctx, other context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(other, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+func optionalString(cmd map[string]interface{}, key, defaultVal string) (string, error) {
+	val, ok := cmd[key]
+	if !ok {
+		return defaultVal, nil
+	}
+	s, ok := val.(string)
+	if !ok {
+		return "", fmt.Errorf("argument '%s' must be a string, got %T", key, val)
+	}
+	return s, nil
+}
+
+func optionalBool(cmd map[string]interface{}, key string, defaultVal bool) (bool, error) {
+	val, ok := cmd[key]
+	if !ok {
+		return defaultVal, nil
+	}
+	b, ok := val.(bool)
+	if !ok {
+		return false, fmt.Errorf("argument '%s' must be a bool, got %T", key, val)
+	}
+	return b, nil
+}
+
+func optionalStrings(cmd map[string]interface{}, key string) ([]string, error) {
+	val, ok := cmd[key]
+	if !ok {
+		return nil, nil
+	}
+	switch v := val.(type) {
+	case string:
+		return []string{v}, nil
+	case []string:
+		return v, nil
+	case []interface{}:
+		ret := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("argument '%s' must be a list of strings, got an item of type %T", key, item)
+			}
+			ret = append(ret, s)
+		}
+		return ret, nil
+	default:
+		return nil, fmt.Errorf("argument '%s' must be a list of strings, got %T", key, val)
+	}
+}
@@ -0,0 +1,251 @@
+package onvifdevice
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/components/generic"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/resource"
+	"go.viam.com/test"
+)
+
+// createdPattern matches the time a WS-Security header was created at.
+var createdPattern = regexp.MustCompile(`<Created[^>]*>([^<]+)</Created>`)
+
+// fakeDevice is an ONVIF device service whose clock is offset from the machine's.
+type fakeDevice struct {
+	t      *testing.T
+	url    string
+	offset time.Duration
+	// maxSkew, when set, rejects requests other than GetSystemDateAndTime that aren't signed
+	// within maxSkew of the device's clock.
+	maxSkew time.Duration
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func (d *fakeDevice) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	test.That(d.t, err, test.ShouldBeNil)
+	body := string(b)
+	envelope := func(content string) {
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Envelope><Body>` + content + `</Body></Envelope>`))
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.requests = append(d.requests, body)
+	if d.maxSkew > 0 && !strings.Contains(body, "tds:GetSystemDateAndTime") {
+		m := createdPattern.FindStringSubmatch(body)
+		if m == nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		created, err := time.Parse(time.RFC3339Nano, m[1])
+		test.That(d.t, err, test.ShouldBeNil)
+		if created.Sub(time.Now().Add(d.offset)).Abs() > d.maxSkew {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	switch {
+	case strings.Contains(body, "GetCapabilities"):
+		envelope(`<GetCapabilitiesResponse><Capabilities><Media><XAddr>` + d.url +
+			`/media</XAddr></Media></Capabilities></GetCapabilitiesResponse>`)
+	case strings.Contains(body, "tds:GetSystemDateAndTime"):
+		now := time.Now().UTC().Add(d.offset)
+		envelope(fmt.Sprintf(`<GetSystemDateAndTimeResponse><SystemDateAndTime>
+			<DateTimeType>Manual</DateTimeType><DaylightSavings>false</DaylightSavings>
+			<TimeZone><TZ>GMT0</TZ></TimeZone>
+			<UTCDateTime>
+				<Time><Hour>%d</Hour><Minute>%d</Minute><Second>%d</Second></Time>
+				<Date><Year>%d</Year><Month>%d</Month><Day>%d</Day></Date>
+			</UTCDateTime>
+		</SystemDateAndTime></GetSystemDateAndTimeResponse>`,
+			now.Hour(), now.Minute(), now.Second(), now.Year(), now.Month(), now.Day()))
+	case strings.Contains(body, "tds:SetSystemDateAndTime"):
+		d.offset = 0
+		envelope(`<SetSystemDateAndTimeResponse/>`)
+	case strings.Contains(body, "tds:SystemReboot"):
+		envelope(`<SystemRebootResponse><Message>Rebooting</Message></SystemRebootResponse>`)
+	case strings.Contains(body, "tds:GetUsers"):
+		envelope(`<GetUsersResponse><User><Username>admin</Username><UserLevel>Administrator</UserLevel></User></GetUsersResponse>`)
+	case strings.Contains(body, "trt:GetProfiles"):
+		envelope(`<GetProfilesResponse><Profiles token="Profile_1"><Name>main</Name>
+			<VideoEncoderConfiguration token="VideoEncoderToken_1"></VideoEncoderConfiguration>
+		</Profiles></GetProfilesResponse>`)
+	case strings.Contains(body, "trt:GetVideoEncoderConfigurationOptions"):
+		envelope(`<GetVideoEncoderConfigurationOptionsResponse><Options>
+			<QualityRange><Min>1</Min><Max>6</Max></QualityRange>
+			<H264>
+				<ResolutionsAvailable><Width>1920</Width><Height>1080</Height></ResolutionsAvailable>
+				<GovLengthRange><Min>1</Min><Max>100</Max></GovLengthRange>
+				<FrameRateRange><Min>1</Min><Max>30</Max></FrameRateRange>
+			</H264>
+		</Options></GetVideoEncoderConfigurationOptionsResponse>`)
+	case strings.Contains(body, "trt:GetVideoEncoderConfiguration"):
+		envelope(`<GetVideoEncoderConfigurationResponse><Configuration token="VideoEncoderToken_1">
+			<Name>VideoEncoder_1</Name><Encoding>H264</Encoding>
+			<Resolution><Width>1920</Width><Height>1080</Height></Resolution>
+			<RateControl><FrameRateLimit>25</FrameRateLimit><BitrateLimit>4096</BitrateLimit></RateControl>
+			<H264><GovLength>50</GovLength><H264Profile>Main</H264Profile></H264>
+		</Configuration></GetVideoEncoderConfigurationResponse>`)
+	case strings.Contains(body, "trt:SetVideoEncoderConfiguration"):
+		envelope(`<SetVideoEncoderConfigurationResponse/>`)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (d *fakeDevice) requestsContaining(s string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var ret []string
+	for _, r := range d.requests {
+		if strings.Contains(r, s) {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
+
+func TestConfigValidate(t *testing.T) {
+	_, _, err := (&Config{}).Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+
+	_, _, err = (&Config{Address: "rtsp://10.0.0.5"}).Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+
+	drift := 0.0
+	_, _, err = (&Config{Address: "10.0.0.5", MaxClockDriftSec: &drift}).Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+
+	drift = 2
+	_, _, err = (&Config{Address: "10.0.0.5:8080", MaxClockDriftSec: &drift}).Validate("path")
+	test.That(t, err, test.ShouldBeNil)
+}
+
+func TestDoCommand(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	ctx := context.Background()
+	fake := &fakeDevice{t: t, offset: -time.Minute}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	fake.url = server.URL
+
+	res, err := NewClient(ctx, nil, resource.NewName(generic.API, "onvif-device"), &Config{Address: server.URL}, logger)
+	test.That(t, err, test.ShouldBeNil)
+	defer res.Close(ctx)
+
+	t.Run("drift is reported", func(t *testing.T) {
+		resp, err := res.DoCommand(ctx, map[string]interface{}{"command": "get-date-time"})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["drifted"], test.ShouldBeTrue)
+		test.That(t, resp["drift_sec"], test.ShouldAlmostEqual, -60, 2)
+		test.That(t, resp["timezone"], test.ShouldEqual, "GMT0")
+	})
+
+	t.Run("sync-time", func(t *testing.T) {
+		resp, err := res.DoCommand(ctx, map[string]interface{}{"command": "sync-time"})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["drifted"], test.ShouldBeFalse)
+		sets := fake.requestsContaining("tds:SetSystemDateAndTime")
+		test.That(t, len(sets), test.ShouldEqual, 1)
+		test.That(t, sets[0], test.ShouldContainSubstring, "<tds:DateTimeType>Manual</tds:DateTimeType>")
+		// the device's time zone is kept
+		test.That(t, sets[0], test.ShouldContainSubstring, "<onvif:TZ>GMT0</onvif:TZ>")
+	})
+
+	t.Run("reboot must be confirmed", func(t *testing.T) {
+		_, err := res.DoCommand(ctx, map[string]interface{}{"command": "reboot"})
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, len(fake.requestsContaining("tds:SystemReboot")), test.ShouldEqual, 0)
+
+		resp, err := res.DoCommand(ctx, map[string]interface{}{"command": "reboot", "confirm": true})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["message"], test.ShouldEqual, "Rebooting")
+	})
+
+	t.Run("get-users", func(t *testing.T) {
+		resp, err := res.DoCommand(ctx, map[string]interface{}{"command": "get-users"})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["users"], test.ShouldResemble, []interface{}{
+			map[string]interface{}{"username": "admin", "user_level": "Administrator"},
+		})
+	})
+
+	t.Run("set-video-encoder-configuration", func(t *testing.T) {
+		// there is no configured profile_token, so the encoder must be named
+		_, err := res.DoCommand(ctx, map[string]interface{}{"command": "set-video-encoder-configuration", "gov_length": 25.0})
+		test.That(t, err, test.ShouldNotBeNil)
+
+		_, err = res.DoCommand(ctx, map[string]interface{}{
+			"command": "set-video-encoder-configuration", "profile_token": "Profile_1", "gov_length": 250.0,
+		})
+		test.That(t, err.Error(), test.ShouldContainSubstring, "gov_length 250 is out of the supported range")
+		test.That(t, len(fake.requestsContaining("trt:SetVideoEncoderConfiguration")), test.ShouldEqual, 0)
+
+		resp, err := res.DoCommand(ctx, map[string]interface{}{
+			"command": "set-video-encoder-configuration", "profile_token": "Profile_1", "gov_length": 25.0, "bitrate_kbps": 2048.0,
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["token"], test.ShouldEqual, "VideoEncoderToken_1")
+		sets := fake.requestsContaining("trt:SetVideoEncoderConfiguration")
+		test.That(t, len(sets), test.ShouldEqual, 1)
+		test.That(t, sets[0], test.ShouldContainSubstring, "<onvif:GovLength>25</onvif:GovLength>")
+		test.That(t, sets[0], test.ShouldContainSubstring, "<onvif:BitrateLimit>2048</onvif:BitrateLimit>")
+		test.That(t, sets[0], test.ShouldContainSubstring, "<onvif:Width>1920</onvif:Width>")
+	})
+
+	t.Run("invalid arguments", func(t *testing.T) {
+		_, err := res.DoCommand(ctx, map[string]interface{}{"command": "get-system-log", "log_type": "kernel"})
+		test.That(t, err, test.ShouldNotBeNil)
+		_, err = res.DoCommand(ctx, map[string]interface{}{"command": "set-ntp"})
+		test.That(t, err, test.ShouldNotBeNil)
+		_, err = res.DoCommand(ctx, map[string]interface{}{"command": "unknown"})
+		test.That(t, err.Error(), test.ShouldContainSubstring, "unrecognized DoCommand command")
+	})
+}
+
+func TestDriftedDevice(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	ctx := context.Background()
+	// the device rejects requests signed with the machine's time until its clock is fixed
+	fake := &fakeDevice{t: t, offset: -10 * time.Minute, maxSkew: 5 * time.Second}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	fake.url = server.URL
+
+	res, err := NewClient(ctx, nil, resource.NewName(generic.API, "onvif-device"),
+		&Config{Address: server.URL, Username: "admin", Password: "secret"}, logger)
+	test.That(t, err, test.ShouldBeNil)
+	defer res.Close(ctx)
+
+	_, err = res.DoCommand(ctx, map[string]interface{}{"command": "get-video-encoder-configurations"})
+	test.That(t, err, test.ShouldNotBeNil)
+
+	resp, err := res.DoCommand(ctx, map[string]interface{}{"command": "get-date-time"})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, resp["drifted"], test.ShouldBeTrue)
+	for _, r := range fake.requestsContaining("tds:GetSystemDateAndTime") {
+		test.That(t, r, test.ShouldNotContainSubstring, "UsernameToken")
+	}
+
+	resp, err = res.DoCommand(ctx, map[string]interface{}{"command": "sync-time"})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, resp["drifted"], test.ShouldBeFalse)
+
+	// once the clock is fixed the media service can be reached
+	resp, err = res.DoCommand(ctx, map[string]interface{}{"command": "get-video-encoder-configurations"})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, resp["configurations"], test.ShouldNotBeNil)
+}
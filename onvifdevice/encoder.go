@@ -0,0 +1,364 @@
+package onvifdevice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/viam-modules/viamrtsp/viamonvif/device"
+	"github.com/viam-modules/viamrtsp/viamonvif/xsd/onvif"
+)
+
+// encoderSettings are the settings set-video-encoder-configuration can change, nil when left as they are.
+type encoderSettings struct {
+	encoding  *string
+	width     *int
+	height    *int
+	frameRate *float64
+	bitrate   *int
+	govLength *int
+	quality   *float64
+	profile   *string
+}
+
+func parseEncoderSettings(cmd map[string]interface{}) (encoderSettings, error) {
+	var s encoderSettings
+	var err error
+	if s.encoding, err = optionalStringPtr(cmd, "encoding"); err != nil {
+		return s, err
+	}
+	if s.encoding != nil {
+		*s.encoding = strings.ToUpper(*s.encoding)
+	}
+	if s.profile, err = optionalStringPtr(cmd, "profile"); err != nil {
+		return s, err
+	}
+	if s.width, err = optionalIntPtr(cmd, "width"); err != nil {
+		return s, err
+	}
+	if s.height, err = optionalIntPtr(cmd, "height"); err != nil {
+		return s, err
+	}
+	if (s.width == nil) != (s.height == nil) {
+		return s, errors.New(`"width" and "height" must be set together`)
+	}
+	if s.bitrate, err = optionalIntPtr(cmd, "bitrate_kbps"); err != nil {
+		return s, err
+	}
+	if s.govLength, err = optionalIntPtr(cmd, "gov_length"); err != nil {
+		return s, err
+	}
+	if s.frameRate, err = optionalFloatPtr(cmd, "frame_rate"); err != nil {
+		return s, err
+	}
+	if s.quality, err = optionalFloatPtr(cmd, "quality"); err != nil {
+		return s, err
+	}
+	if s == (encoderSettings{}) {
+		return s, errors.New("no encoder settings to change, expected any of encoding, width and height, " +
+			"frame_rate, bitrate_kbps, gov_length, quality or profile")
+	}
+	return s, nil
+}
+
+// apply returns cfg with the settings changed.
+func (s encoderSettings) apply(cfg device.VideoEncoderConfig) device.VideoEncoderConfig {
+	if s.encoding != nil {
+		cfg.Encoding = *s.encoding
+	}
+	if s.width != nil {
+		cfg.Width, cfg.Height = *s.width, *s.height
+	}
+	if s.frameRate != nil {
+		cfg.FrameRateLimit = *s.frameRate
+	}
+	if s.bitrate != nil {
+		cfg.BitrateLimit = *s.bitrate
+	}
+	if s.govLength != nil {
+		cfg.GovLength = *s.govLength
+	}
+	if s.quality != nil {
+		cfg.Quality = *s.quality
+	}
+	if s.profile != nil {
+		cfg.Profile = *s.profile
+	}
+	return cfg
+}
+
+// checkEncoderOptions checks the changed settings of cfg against what the encoder reports it supports,
+// since many devices accept settings they can't use and then stop streaming.
+func (s encoderSettings) checkEncoderOptions(cfg device.VideoEncoderConfig, options []device.VideoEncoderOptions) error {
+	idx := slices.IndexFunc(options, func(o device.VideoEncoderOptions) bool { return o.Encoding == cfg.Encoding })
+	if idx < 0 {
+		if s.encoding == nil || len(options) == 0 {
+			return nil
+		}
+		var encodings []string
+		for _, o := range options {
+			encodings = append(encodings, o.Encoding)
+		}
+		return fmt.Errorf("encoding %s is not supported, supported encodings are %v", cfg.Encoding, encodings)
+	}
+	opts := options[idx]
+	if (s.width != nil || s.encoding != nil) && len(opts.Resolutions) > 0 &&
+		!slices.ContainsFunc(opts.Resolutions, func(r onvif.VideoResolution) bool {
+			return int(r.Width) == cfg.Width && int(r.Height) == cfg.Height
+		}) {
+		return fmt.Errorf("resolution %dx%d is not supported for %s, run get-video-encoder-configuration-options for the supported resolutions",
+			cfg.Width, cfg.Height, cfg.Encoding)
+	}
+	if s.frameRate != nil {
+		if len(opts.FrameRates) > 0 && !slices.Contains(opts.FrameRates, cfg.FrameRateLimit) {
+			return fmt.Errorf("frame_rate %v is not supported for %s, supported frame rates are %v",
+				cfg.FrameRateLimit, cfg.Encoding, opts.FrameRates)
+		}
+		if err := checkRange("frame_rate", cfg.FrameRateLimit, opts.FrameRateMin, opts.FrameRateMax); err != nil {
+			return err
+		}
+	}
+	if s.bitrate != nil {
+		if err := checkRange("bitrate_kbps", float64(cfg.BitrateLimit), float64(opts.BitrateMin), float64(opts.BitrateMax)); err != nil {
+			return err
+		}
+	}
+	if s.govLength != nil {
+		if err := checkRange("gov_length", float64(cfg.GovLength), float64(opts.GovLengthMin), float64(opts.GovLengthMax)); err != nil {
+			return err
+		}
+	}
+	if s.quality != nil {
+		if err := checkRange("quality", cfg.Quality, opts.QualityMin, opts.QualityMax); err != nil {
+			return err
+		}
+	}
+	if s.profile != nil && len(opts.Profiles) > 0 && !slices.Contains(opts.Profiles, cfg.Profile) {
+		return fmt.Errorf("profile %s is not supported for %s, supported profiles are %v", cfg.Profile, cfg.Encoding, opts.Profiles)
+	}
+	return nil
+}
+
+// checkRange checks that v is in [lo, hi], if the device reported a range.
+func checkRange(name string, v, lo, hi float64) error {
+	if hi <= 0 {
+		return nil
+	}
+	if v < lo || v > hi {
+		return fmt.Errorf("%s %v is out of the supported range %v to %v", name, v, lo, hi)
+	}
+	return nil
+}
+
+// encoderToken returns the token of the video encoder configuration a command is for: the token
+// argument, or the encoder of the profile_token argument or of the configured profile.
+func (s *onvifDevice) encoderToken(ctx context.Context, cmd map[string]interface{}) (string, error) {
+	token, err := optionalString(cmd, "token", "")
+	if err != nil || token != "" {
+		return token, err
+	}
+	profileToken, err := optionalString(cmd, "profile_token", s.cfg.ProfileToken)
+	if err != nil {
+		return "", err
+	}
+	if profileToken == "" {
+		return "", errors.New(`"token" or "profile_token" is required when no "profile_token" is configured`)
+	}
+	dev, err := s.media(ctx)
+	if err != nil {
+		return "", err
+	}
+	profiles, err := dev.GetProfiles(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range profiles.Profiles {
+		if string(p.Token) != profileToken {
+			continue
+		}
+		if p.VideoEncoderConfiguration.Token == "" {
+			return "", fmt.Errorf("profile %s has no video encoder configuration", profileToken)
+		}
+		return string(p.VideoEncoderConfiguration.Token), nil
+	}
+	return "", fmt.Errorf("no profile with token %s", profileToken)
+}
+
+func encoderConfigMap(cfg device.VideoEncoderConfig) map[string]interface{} {
+	return map[string]interface{}{
+		"token":        cfg.Token,
+		"name":         cfg.Name,
+		"use_count":    cfg.UseCount,
+		"encoding":     cfg.Encoding,
+		"width":        cfg.Width,
+		"height":       cfg.Height,
+		"frame_rate":   cfg.FrameRateLimit,
+		"bitrate_kbps": cfg.BitrateLimit,
+		"gov_length":   cfg.GovLength,
+		"quality":      cfg.Quality,
+		"profile":      cfg.Profile,
+	}
+}
+
+func encoderOptionsMap(opts device.VideoEncoderOptions) map[string]interface{} {
+	resolutions := make([]interface{}, 0, len(opts.Resolutions))
+	for _, r := range opts.Resolutions {
+		resolutions = append(resolutions, map[string]interface{}{"width": int(r.Width), "height": int(r.Height)})
+	}
+	ret := map[string]interface{}{
+		"encoding":    opts.Encoding,
+		"resolutions": resolutions,
+		"quality":     map[string]interface{}{"min": opts.QualityMin, "max": opts.QualityMax},
+		"frame_rate":  map[string]interface{}{"min": opts.FrameRateMin, "max": opts.FrameRateMax},
+		"gov_length":  map[string]interface{}{"min": opts.GovLengthMin, "max": opts.GovLengthMax},
+		"bitrate_kbps": map[string]interface{}{
+			"min": opts.BitrateMin,
+			"max": opts.BitrateMax,
+		},
+		"profiles": opts.Profiles,
+	}
+	if len(opts.FrameRates) > 0 {
+		ret["frame_rates"] = opts.FrameRates
+	}
+	return ret
+}
+
+func (s *onvifDevice) handleGetVideoEncoderConfigurations(ctx context.Context) (map[string]interface{}, error) {
+	dev, err := s.media(ctx)
+	if err != nil {
+		return nil, err
+	}
+	configs, err := dev.GetVideoEncoderConfigurations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]interface{}, 0, len(configs))
+	for _, cfg := range configs {
+		ret = append(ret, encoderConfigMap(cfg))
+	}
+	return map[string]interface{}{"configurations": ret}, nil
+}
+
+func (s *onvifDevice) handleGetVideoEncoderConfiguration(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+	dev, err := s.media(ctx)
+	if err != nil {
+		return nil, err
+	}
+	token, err := s.encoderToken(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := dev.GetVideoEncoderConfiguration(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return encoderConfigMap(cfg), nil
+}
+
+func (s *onvifDevice) handleGetVideoEncoderConfigurationOptions(
+	ctx context.Context,
+	cmd map[string]interface{},
+) (map[string]interface{}, error) {
+	dev, err := s.media(ctx)
+	if err != nil {
+		return nil, err
+	}
+	token, err := s.encoderToken(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	options, err := dev.GetVideoEncoderConfigurationOptions(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]interface{}, 0, len(options))
+	for _, opts := range options {
+		ret = append(ret, encoderOptionsMap(opts))
+	}
+	return map[string]interface{}{"token": token, "options": ret}, nil
+}
+
+// handleSetVideoEncoderConfiguration changes the given settings of a video encoder configuration,
+// leaving the rest as they are.
+func (s *onvifDevice) handleSetVideoEncoderConfiguration(
+	ctx context.Context,
+	cmd map[string]interface{},
+) (map[string]interface{}, error) {
+	dev, err := s.media(ctx)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := parseEncoderSettings(cmd)
+	if err != nil {
+		return nil, err
+	}
+	token, err := s.encoderToken(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	current, err := dev.GetVideoEncoderConfiguration(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	updated := settings.apply(current)
+	if settings.encoding != nil && *settings.encoding != current.Encoding && settings.profile == nil {
+		// the old codec's profile doesn't apply to the new one, so the device picks
+		updated.Profile = ""
+	}
+	options, err := dev.GetVideoEncoderConfigurationOptions(ctx, token)
+	if err != nil {
+		s.logger.Warnf("failed to get video encoder configuration options, setting %s without checking them: %v", token, err)
+	} else if err := settings.checkEncoderOptions(updated, options); err != nil {
+		return nil, err
+	}
+	if err := dev.SetVideoEncoderConfiguration(ctx, updated); err != nil {
+		return nil, err
+	}
+	s.logger.Infof("changed video encoder configuration %s of ONVIF device %s", token, s.cfg.Address)
+	cfg, err := dev.GetVideoEncoderConfiguration(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return encoderConfigMap(cfg), nil
+}
+
+func optionalStringPtr(cmd map[string]interface{}, key string) (*string, error) {
+	if _, ok := cmd[key]; !ok {
+		return nil, nil
+	}
+	v, err := optionalString(cmd, key, "")
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func optionalFloatPtr(cmd map[string]interface{}, key string) (*float64, error) {
+	val, ok := cmd[key]
+	if !ok {
+		return nil, nil
+	}
+	switch v := val.(type) {
+	case float64:
+		return &v, nil
+	case int:
+		f := float64(v)
+		return &f, nil
+	default:
+		return nil, fmt.Errorf("argument '%s' must be a number, got %T", key, val)
+	}
+}
+
+func optionalIntPtr(cmd map[string]interface{}, key string) (*int, error) {
+	f, err := optionalFloatPtr(cmd, key)
+	if f == nil || err != nil {
+		return nil, err
+	}
+	if *f != float64(int(*f)) {
+		return nil, fmt.Errorf("argument '%s' must be a whole number, got %v", key, *f)
+	}
+	i := int(*f)
+	return &i, nil
+}
@@ -0,0 +1,191 @@
+package viamrtsp
+
+/*
+#include <libavutil/frame.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	overlayTopLeft     = "top-left"
+	overlayTopRight    = "top-right"
+	overlayBottomLeft  = "bottom-left"
+	overlayBottomRight = "bottom-right"
+
+	defaultOverlayTimestampFormat = "2006-01-02 15:04:05 MST"
+	defaultOverlayFontSize        = 26
+	maxOverlayFontSize            = 200
+	// glyphs are drawn with a 7x13 bitmap font scaled up by whole pixels
+	overlayGlyphHeight = 13
+	// padding around the text inside its background box, in unscaled font pixels
+	overlayPadding = 2
+)
+
+// Overlay is text drawn into every decoded frame, such as a timestamp for evidence exports.
+type Overlay struct {
+	Timestamp bool `json:"timestamp,omitempty"`
+	// Timezone is an IANA timezone for the timestamp, the system timezone is used if empty.
+	Timezone string `json:"timezone,omitempty"`
+	// TimestampFormat is a Go time layout.
+	TimestampFormat string `json:"timestamp_format,omitempty"`
+	CameraName      bool   `json:"camera_name,omitempty"`
+	Text            string `json:"text,omitempty"`
+	// Position is one of top-left (default), top-right, bottom-left or bottom-right.
+	Position string `json:"position,omitempty"`
+	// FontSize is the text height in pixels.
+	FontSize int `json:"font_size,omitempty"`
+}
+
+func (o *Overlay) validate() error {
+	if !o.Timestamp && !o.CameraName && o.Text == "" {
+		return errors.New("overlay must enable timestamp or camera_name, or set text")
+	}
+	if _, err := time.LoadLocation(o.Timezone); err != nil {
+		return fmt.Errorf("invalid overlay timezone %q: %w", o.Timezone, err)
+	}
+	switch o.Position {
+	case "", overlayTopLeft, overlayTopRight, overlayBottomLeft, overlayBottomRight:
+	default:
+		return fmt.Errorf("invalid overlay position %q, allowed values are: top-left, top-right, bottom-left, bottom-right", o.Position)
+	}
+	if o.FontSize < 0 || o.FontSize > maxOverlayFontSize {
+		return fmt.Errorf("overlay font_size must be between 0 and %d", maxOverlayFontSize)
+	}
+	return nil
+}
+
+func (o *Overlay) timestampFormat() string {
+	if o.TimestampFormat == "" {
+		return defaultOverlayTimestampFormat
+	}
+	return o.TimestampFormat
+}
+
+func (o *Overlay) scale() int {
+	size := o.FontSize
+	if size == 0 {
+		size = defaultOverlayFontSize
+	}
+	return max(1, (size+overlayGlyphHeight/2)/overlayGlyphHeight)
+}
+
+// overlay draws an Overlay into frames. The rendered text is cached until it changes,
+// which for a timestamp is once a second.
+type overlay struct {
+	cfg        *Overlay
+	loc        *time.Location
+	cameraName string
+	now        func() time.Time
+
+	mu       sync.Mutex
+	rendered string
+	glyphs   *image.Alpha
+}
+
+// newOverlay returns nil when cfg is nil.
+func newOverlay(cfg *Overlay, cameraName string) (*overlay, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid overlay timezone %q: %w", cfg.Timezone, err)
+	}
+	return &overlay{cfg: cfg, loc: loc, cameraName: cameraName, now: time.Now}, nil
+}
+
+func (o *overlay) lines() []string {
+	var lines []string
+	if o.cfg.Timestamp {
+		lines = append(lines, o.now().In(o.loc).Format(o.cfg.timestampFormat()))
+	}
+	if o.cfg.CameraName {
+		lines = append(lines, o.cameraName)
+	}
+	if o.cfg.Text != "" {
+		lines = append(lines, strings.Split(o.cfg.Text, "\n")...)
+	}
+	return lines
+}
+
+// render returns the text as an unscaled mask, including the padding around it.
+func (o *overlay) render() *image.Alpha {
+	lines := o.lines()
+	text := strings.Join(lines, "\n")
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.glyphs != nil && text == o.rendered {
+		return o.glyphs
+	}
+	face := basicfont.Face7x13
+	width := 0
+	for _, l := range lines {
+		width = max(width, font.MeasureString(face, l).Ceil())
+	}
+	img := image.NewAlpha(image.Rect(0, 0, width+2*overlayPadding, len(lines)*face.Height+2*overlayPadding))
+	d := &font.Drawer{Dst: img, Src: image.Opaque, Face: face}
+	for i, l := range lines {
+		d.Dot = fixed.P(overlayPadding, overlayPadding+i*face.Height+face.Ascent)
+		d.DrawString(l)
+	}
+	o.rendered, o.glyphs = text, img
+	return img
+}
+
+// origin returns where the scaled overlay of size w x h goes in a frame, in luma pixels.
+func (o *overlay) origin(frameWidth, frameHeight, w, h int) (int, int) {
+	margin := o.cfg.scale() * overlayGlyphHeight / 2
+	x, y := margin, margin
+	if o.cfg.Position == overlayTopRight || o.cfg.Position == overlayBottomRight {
+		x = frameWidth - w - margin
+	}
+	if o.cfg.Position == overlayBottomLeft || o.cfg.Position == overlayBottomRight {
+		y = frameHeight - h - margin
+	}
+	return max(0, x), max(0, y)
+}
+
+// apply draws white text on a black box into the frame in place. It must be called before the frame is shared.
+func (o *overlay) apply(frame *C.AVFrame) error {
+	if o == nil || frame == nil {
+		return nil
+	}
+	planes, err := framePlanes(frame)
+	if err != nil {
+		return fmt.Errorf("overlay: %w", err)
+	}
+	glyphs := o.render()
+	scale := o.cfg.scale()
+	w, h := glyphs.Rect.Dx()*scale, glyphs.Rect.Dy()*scale
+	ox, oy := o.origin(int(frame.width), int(frame.height), w, h)
+	for _, p := range planes {
+		// the box in plane coordinates, clipped to the frame
+		x0, y0 := ox>>p.shiftX, oy>>p.shiftY
+		x1, y1 := min(p.width, (ox+w)>>p.shiftX), min(p.height, (oy+h)>>p.shiftY)
+		for y := y0; y < y1; y++ {
+			row := p.data[y*p.stride:]
+			gy := ((y << p.shiftY) - oy) / scale
+			for x := x0; x < x1; x++ {
+				gx := ((x << p.shiftX) - ox) / scale
+				if glyphs.AlphaAt(gx, gy).A >= 0x80 { //nolint:mnd
+					row[x] = p.white
+				} else {
+					row[x] = p.black
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package viamrtsp
+
+import (
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+)
+
+func TestOverlayValidate(t *testing.T) {
+	test.That(t, (&Overlay{Timestamp: true}).validate(), test.ShouldBeNil)
+	test.That(t, (&Overlay{Text: "dock", Position: overlayBottomRight, FontSize: 40}).validate(), test.ShouldBeNil)
+
+	for _, tc := range []struct {
+		name string
+		cfg  *Overlay
+	}{
+		{"nothing to draw", &Overlay{}},
+		{"invalid timezone", &Overlay{Timestamp: true, Timezone: "Mars/Olympus_Mons"}},
+		{"invalid position", &Overlay{Timestamp: true, Position: "center"}},
+		{"font too large", &Overlay{Timestamp: true, FontSize: maxOverlayFontSize + 1}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			test.That(t, tc.cfg.validate(), test.ShouldNotBeNil)
+			_, _, err := (&Config{Address: "rtsp://example.com:5000", Overlay: tc.cfg}).Validate("path")
+			test.That(t, err, test.ShouldNotBeNil)
+		})
+	}
+}
+
+func TestOverlayRender(t *testing.T) {
+	o, err := newOverlay(&Overlay{Timestamp: true, Timezone: "America/New_York", CameraName: true, Text: "line one\nline two"}, "front-door")
+	test.That(t, err, test.ShouldBeNil)
+	o.now = func() time.Time { return time.Date(2024, 7, 1, 16, 30, 5, 0, time.UTC) }
+	test.That(t, o.lines(), test.ShouldResemble, []string{"2024-07-01 12:30:05 EDT", "front-door", "line one", "line two"})
+
+	glyphs := o.render()
+	// 23 characters at 7 pixels each, 4 lines at 13 pixels each, plus padding
+	test.That(t, glyphs.Rect.Dx(), test.ShouldEqual, 23*7+2*overlayPadding)
+	test.That(t, glyphs.Rect.Dy(), test.ShouldEqual, 4*overlayGlyphHeight+2*overlayPadding)
+	test.That(t, o.render(), test.ShouldEqual, glyphs)
+
+	o.now = func() time.Time { return time.Date(2024, 7, 1, 16, 30, 6, 0, time.UTC) }
+	test.That(t, o.render(), test.ShouldNotEqual, glyphs)
+
+	none, err := newOverlay(nil, "front-door")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, none, test.ShouldBeNil)
+}
+
+func TestOverlayOrigin(t *testing.T) {
+	for _, tc := range []struct {
+		position string
+		x, y     int
+	}{
+		{"", 13, 13},
+		{overlayTopRight, 1920 - 100 - 13, 13},
+		{overlayBottomLeft, 13, 1080 - 50 - 13},
+		{overlayBottomRight, 1920 - 100 - 13, 1080 - 50 - 13},
+	} {
+		o := &overlay{cfg: &Overlay{Text: "x", Position: tc.position}}
+		x, y := o.origin(1920, 1080, 100, 50)
+		test.That(t, x, test.ShouldEqual, tc.x)
+		test.That(t, y, test.ShouldEqual, tc.y)
+	}
+	// overlays larger than the frame are clipped on the right and bottom
+	o := &overlay{cfg: &Overlay{Text: "x", Position: overlayBottomRight}}
+	x, y := o.origin(64, 64, 100, 100)
+	test.That(t, x, test.ShouldEqual, 0)
+	test.That(t, y, test.ShouldEqual, 0)
+}
+
+func TestOverlayApply(t *testing.T) {
+	frame := createTestYUV420PFrame(320, 240)
+	test.That(t, frame, test.ShouldNotBeNil)
+	defer freeFrame(frame)
+	fillDummyYUV420PData(frame)
+
+	o, err := newOverlay(&Overlay{Text: "I", FontSize: overlayGlyphHeight}, "cam")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, o.apply(frame), test.ShouldBeNil)
+
+	glyphs := o.render()
+	margin := overlayGlyphHeight / 2
+	var white, black int
+	for y := range glyphs.Rect.Dy() {
+		for x := range glyphs.Rect.Dx() {
+			switch framePixel(frame, 0, margin+x, margin+y) {
+			case 235:
+				white++
+			case 16:
+				black++
+			}
+		}
+	}
+	test.That(t, white, test.ShouldBeGreaterThan, 0)
+	test.That(t, white+black, test.ShouldEqual, glyphs.Rect.Dx()*glyphs.Rect.Dy())
+	test.That(t, framePixel(frame, 1, margin/2+1, margin/2+1), test.ShouldEqual, 128)
+	// outside the box
+	test.That(t, framePixel(frame, 0, 200, 200), test.ShouldEqual, 128)
+	test.That(t, framePixel(frame, 1, 100, 100), test.ShouldEqual, 64)
+
+	var nilOverlay *overlay
+	test.That(t, nilOverlay.apply(frame), test.ShouldBeNil)
+}
@@ -0,0 +1,350 @@
+package viamrtsp
+
+/*
+#include <libavutil/frame.h>
+#include <libavutil/imgutils.h>
+#include <libavutil/pixfmt.h>
+
+// fill_black blacks out a frame of any pixel format libavutil knows about.
+static int fill_black(AVFrame *frame) {
+	ptrdiff_t linesize[4];
+	for (int i = 0; i < 4; i++) {
+		linesize[i] = frame->linesize[i];
+	}
+	return av_image_fill_black(frame->data, linesize, frame->format, frame->color_range, frame->width, frame->height);
+}
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"unsafe"
+)
+
+const (
+	privacyMaskModeBlack = "black"
+	privacyMaskModeBlur  = "blur"
+	// blurred regions are averaged in square blocks of 1/blurBlocksPerWidth of the frame width.
+	blurBlocksPerWidth = 40
+	minBlurBlockSize   = 8
+	minMaskPoints      = 3
+)
+
+// FramePoint is a polygon vertex. Coordinates are fractions of the frame width and height,
+// so masks and zones keep covering the same area when the camera resolution changes.
+type FramePoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// PrivacyMask is a polygon that is blacked out or blurred in every decoded frame.
+type PrivacyMask struct {
+	Points []FramePoint `json:"points"`
+	// Mode is "black" (default) or "blur".
+	Mode string `json:"mode,omitempty"`
+}
+
+func (m PrivacyMask) mode() string {
+	if m.Mode == "" {
+		return privacyMaskModeBlack
+	}
+	return m.Mode
+}
+
+func (m PrivacyMask) validate() error {
+	if len(m.Points) < minMaskPoints {
+		return fmt.Errorf("privacy mask needs at least %d points, got %d", minMaskPoints, len(m.Points))
+	}
+	for _, p := range m.Points {
+		if p.X < 0 || p.X > 1 || p.Y < 0 || p.Y > 1 {
+			return fmt.Errorf("privacy mask point (%g, %g) must be between 0 and 1", p.X, p.Y)
+		}
+	}
+	if mode := m.mode(); mode != privacyMaskModeBlack && mode != privacyMaskModeBlur {
+		return fmt.Errorf("invalid privacy mask mode %q, allowed values are: black, blur", mode)
+	}
+	return nil
+}
+
+func validatePrivacyMasks(masks []PrivacyMask) error {
+	for i, m := range masks {
+		if err := m.validate(); err != nil {
+			return fmt.Errorf("privacy_masks[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// parsePrivacyMasks converts the privacy_masks DoCommand argument, which has the same shape as the config attribute.
+func parsePrivacyMasks(v interface{}) ([]PrivacyMask, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var masks []PrivacyMask
+	if err := json.Unmarshal(b, &masks); err != nil {
+		return nil, fmt.Errorf("invalid privacy_masks: %w", err)
+	}
+	if err := validatePrivacyMasks(masks); err != nil {
+		return nil, err
+	}
+	return masks, nil
+}
+
+// privacyMasksToDoCommand converts masks to plain values for a DoCommand response.
+func privacyMasksToDoCommand(masks []PrivacyMask) []interface{} {
+	ret := make([]interface{}, 0, len(masks))
+	for _, m := range masks {
+		points := make([]interface{}, 0, len(m.Points))
+		for _, p := range m.Points {
+			points = append(points, map[string]interface{}{"x": p.X, "y": p.Y})
+		}
+		ret = append(ret, map[string]interface{}{"points": points, "mode": m.mode()})
+	}
+	return ret
+}
+
+// span is a half open run of masked pixels [x0, x1) on a row.
+type span struct {
+	x0, x1 int
+}
+
+// rasterizedMask is a mask polygon converted to spans for a given frame size.
+type rasterizedMask struct {
+	mode string
+	rows [][]span
+}
+
+// rasterizeMask fills the polygon with the even-odd rule, sampling at pixel centers.
+func rasterizeMask(m PrivacyMask, width, height int) rasterizedMask {
+	r := rasterizedMask{mode: m.mode(), rows: make([][]span, height)}
+	n := len(m.Points)
+	xs := make([]float64, 0, n)
+	for y := range height {
+		cy := float64(y) + 0.5
+		xs = xs[:0]
+		for i := range n {
+			a, b := m.Points[i], m.Points[(i+1)%n]
+			ay, by := a.Y*float64(height), b.Y*float64(height)
+			if (ay <= cy) == (by <= cy) {
+				continue
+			}
+			ax, bx := a.X*float64(width), b.X*float64(width)
+			xs = append(xs, ax+(cy-ay)/(by-ay)*(bx-ax))
+		}
+		sort.Float64s(xs)
+		for i := 0; i+1 < len(xs); i += 2 {
+			// pixel x is covered when its center x+0.5 lies inside [xs[i], xs[i+1])
+			x0 := max(0, int(math.Ceil(xs[i]-0.5)))
+			x1 := min(width, int(math.Ceil(xs[i+1]-0.5)))
+			if x1 > x0 {
+				r.rows[y] = append(r.rows[y], span{x0, x1})
+			}
+		}
+	}
+	return r
+}
+
+// privacyMasks holds the masks for a camera. Masks are rasterized once per frame size.
+type privacyMasks struct {
+	mu     sync.Mutex
+	masks  []PrivacyMask
+	width  int
+	height int
+	raster []rasterizedMask
+}
+
+func newPrivacyMasks(masks []PrivacyMask) *privacyMasks {
+	return &privacyMasks{masks: masks}
+}
+
+func (pm *privacyMasks) enabled() bool {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return len(pm.masks) > 0
+}
+
+func (pm *privacyMasks) get() []PrivacyMask {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return pm.masks
+}
+
+func (pm *privacyMasks) set(masks []PrivacyMask) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.masks = masks
+	pm.raster = nil
+}
+
+func (pm *privacyMasks) rasterized(width, height int) []rasterizedMask {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if pm.raster == nil || pm.width != width || pm.height != height {
+		pm.raster = make([]rasterizedMask, 0, len(pm.masks))
+		for _, m := range pm.masks {
+			pm.raster = append(pm.raster, rasterizeMask(m, width, height))
+		}
+		pm.width, pm.height = width, height
+	}
+	return pm.raster
+}
+
+// chromaShift returns the log2 chroma subsampling of the planar yuv formats decoders produce.
+func chromaShift(format C.int) (int, int, bool) {
+	switch format {
+	case C.AV_PIX_FMT_YUV420P, C.AV_PIX_FMT_YUVJ420P:
+		return 1, 1, true
+	case C.AV_PIX_FMT_YUV422P, C.AV_PIX_FMT_YUVJ422P:
+		return 1, 0, true
+	case C.AV_PIX_FMT_YUV444P, C.AV_PIX_FMT_YUVJ444P:
+		return 0, 0, true
+	default:
+		return 0, 0, false
+	}
+}
+
+// plane is one plane of an AVFrame.
+type plane struct {
+	data          []byte
+	stride        int
+	width, height int
+	// shiftX and shiftY are the log2 subsampling of the plane relative to the luma plane.
+	shiftX, shiftY int
+	black          byte
+	white          byte
+}
+
+// framePlanes returns the Y, U and V planes of a planar yuv frame.
+func framePlanes(frame *C.AVFrame) ([]plane, error) {
+	shiftX, shiftY, ok := chromaShift(frame.format)
+	if !ok {
+		return nil, fmt.Errorf("unsupported pixel format %d", frame.format)
+	}
+	width, height := int(frame.width), int(frame.height)
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("invalid frame size")
+	}
+	// limited range luma unless the frame uses the jpeg full range formats
+	lumaBlack, lumaWhite := byte(16), byte(235) //nolint:mnd
+	if frame.format == C.AV_PIX_FMT_YUVJ420P || frame.format == C.AV_PIX_FMT_YUVJ422P || frame.format == C.AV_PIX_FMT_YUVJ444P {
+		lumaBlack, lumaWhite = 0, 255
+	}
+	planes := make([]plane, 0, 3) //nolint:mnd
+	for i := range 3 {
+		sx, sy, black, white := 0, 0, lumaBlack, lumaWhite
+		if i > 0 {
+			sx, sy, black, white = shiftX, shiftY, 128, 128 //nolint:mnd
+		}
+		stride := int(frame.linesize[i])
+		rows := (height + (1 << sy) - 1) >> sy
+		planes = append(planes, plane{
+			data:   unsafe.Slice((*byte)(unsafe.Pointer(frame.data[i])), stride*rows),
+			stride: stride,
+			width:  (width + (1 << sx) - 1) >> sx,
+			height: rows,
+			shiftX: sx,
+			shiftY: sy,
+			black:  black,
+			white:  white,
+		})
+	}
+	return planes, nil
+}
+
+// privacyMaskError is returned by apply when a frame couldn't be masked.
+type privacyMaskError struct {
+	err error
+	// blackedOut is set when the whole frame was blacked out instead. Otherwise the frame
+	// is unmasked and must not be shared.
+	blackedOut bool
+}
+
+func (e *privacyMaskError) Error() string {
+	if e.blackedOut {
+		return fmt.Sprintf("privacy masks: %s, blacked out the whole frame", e.err.Error())
+	}
+	return fmt.Sprintf("privacy masks: %s, unable to black out the frame", e.err.Error())
+}
+
+func (e *privacyMaskError) Unwrap() error {
+	return e.err
+}
+
+// apply masks the frame in place. It must be called before the frame is shared. Masks fail
+// closed, frames in pixel formats masks can't be drawn into are blacked out entirely.
+func (pm *privacyMasks) apply(frame *C.AVFrame) error {
+	if frame == nil || !pm.enabled() {
+		return nil
+	}
+	planes, err := framePlanes(frame)
+	if err != nil {
+		return &privacyMaskError{err: err, blackedOut: C.fill_black(frame) >= 0}
+	}
+	width, height := int(frame.width), int(frame.height)
+	block := max(minBlurBlockSize, width/blurBlocksPerWidth)
+	for _, r := range pm.rasterized(width, height) {
+		for _, p := range planes {
+			switch r.mode {
+			case privacyMaskModeBlur:
+				p.blur(r, block)
+			default:
+				p.fill(r)
+			}
+		}
+	}
+	return nil
+}
+
+// covered calls fn for every masked span of the plane, in plane coordinates.
+func (p plane) covered(r rasterizedMask, fn func(y, x0, x1 int)) {
+	for y := range p.height {
+		for _, s := range r.rows[y<<p.shiftY] {
+			x0 := s.x0 >> p.shiftX
+			x1 := (s.x1 + (1 << p.shiftX) - 1) >> p.shiftX
+			fn(y, x0, x1)
+		}
+	}
+}
+
+func (p plane) fill(r rasterizedMask) {
+	p.covered(r, func(y, x0, x1 int) {
+		row := p.data[y*p.stride : y*p.stride+x1]
+		for x := x0; x < x1; x++ {
+			row[x] = p.black
+		}
+	})
+}
+
+// blur replaces masked pixels with the average of their block, which hides detail even in small masks.
+func (p plane) blur(r rasterizedMask, lumaBlock int) {
+	block := max(1, lumaBlock>>p.shiftX)
+	averages := map[[2]int]byte{}
+	// a block's average is taken the first time one of its pixels is masked, before any of them are written
+	average := func(bx, by int) byte {
+		key := [2]int{bx, by}
+		if v, ok := averages[key]; ok {
+			return v
+		}
+		var sum, count int
+		for y := by * block; y < min((by+1)*block, p.height); y++ {
+			for x := bx * block; x < min((bx+1)*block, p.width); x++ {
+				sum += int(p.data[y*p.stride+x])
+				count++
+			}
+		}
+		v := byte(sum / max(1, count))
+		averages[key] = v
+		return v
+	}
+	p.covered(r, func(y, x0, x1 int) {
+		for x := x0; x < x1; x++ {
+			p.data[y*p.stride+x] = average(x/block, y/block)
+		}
+	})
+}
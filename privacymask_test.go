@@ -0,0 +1,228 @@
+package viamrtsp
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func square(x0, y0, x1, y1 float64, mode string) PrivacyMask {
+	return PrivacyMask{
+		Points: []FramePoint{{X: x0, Y: y0}, {X: x1, Y: y0}, {X: x1, Y: y1}, {X: x0, Y: y1}},
+		Mode:   mode,
+	}
+}
+
+func TestRasterizeMask(t *testing.T) {
+	t.Run("square", func(t *testing.T) {
+		r := rasterizeMask(square(0.25, 0.25, 0.75, 0.75, ""), 8, 8)
+		test.That(t, r.mode, test.ShouldEqual, privacyMaskModeBlack)
+		for y, row := range r.rows {
+			if y < 2 || y >= 6 {
+				test.That(t, row, test.ShouldBeEmpty)
+				continue
+			}
+			test.That(t, row, test.ShouldResemble, []span{{2, 6}})
+		}
+	})
+
+	t.Run("triangle", func(t *testing.T) {
+		tri := PrivacyMask{Points: []FramePoint{{X: 0, Y: 0}, {X: 1, Y: 0}, {X: 0, Y: 1}}}
+		r := rasterizeMask(tri, 4, 4)
+		// pixels whose centers lie on the hypotenuse are not covered
+		test.That(t, r.rows, test.ShouldResemble, [][]span{{{0, 3}}, {{0, 2}}, {{0, 1}}, nil})
+	})
+
+	t.Run("concave polygons leave holes", func(t *testing.T) {
+		// a U shape open at the top
+		u := PrivacyMask{Points: []FramePoint{
+			{X: 0, Y: 0}, {X: 0.25, Y: 0}, {X: 0.25, Y: 0.5}, {X: 0.75, Y: 0.5},
+			{X: 0.75, Y: 0}, {X: 1, Y: 0}, {X: 1, Y: 1}, {X: 0, Y: 1},
+		}}
+		r := rasterizeMask(u, 8, 8)
+		test.That(t, r.rows[0], test.ShouldResemble, []span{{0, 2}, {6, 8}})
+		test.That(t, r.rows[7], test.ShouldResemble, []span{{0, 8}})
+	})
+}
+
+func TestParsePrivacyMasks(t *testing.T) {
+	masks, err := parsePrivacyMasks([]interface{}{
+		map[string]interface{}{
+			"points": []interface{}{
+				map[string]interface{}{"x": 0.0, "y": 0.0},
+				map[string]interface{}{"x": 0.5, "y": 0.0},
+				map[string]interface{}{"x": 0.5, "y": 0.5},
+			},
+			"mode": "blur",
+		},
+	})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, masks, test.ShouldResemble, []PrivacyMask{{
+		Points: []FramePoint{{X: 0, Y: 0}, {X: 0.5, Y: 0}, {X: 0.5, Y: 0.5}},
+		Mode:   privacyMaskModeBlur,
+	}})
+
+	masks, err = parsePrivacyMasks([]interface{}{})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, masks, test.ShouldBeEmpty)
+
+	for _, tc := range []struct {
+		name  string
+		masks []PrivacyMask
+	}{
+		{"too few points", []PrivacyMask{{Points: []FramePoint{{X: 0, Y: 0}, {X: 1, Y: 1}}}}},
+		{"point out of range", []PrivacyMask{square(0, 0, 1.5, 1, "")}},
+		{"unknown mode", []PrivacyMask{square(0, 0, 1, 1, "pixelate")}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			test.That(t, validatePrivacyMasks(tc.masks), test.ShouldNotBeNil)
+			_, _, err := (&Config{Address: "rtsp://example.com:5000", PrivacyMasks: tc.masks}).Validate("path")
+			test.That(t, err, test.ShouldNotBeNil)
+		})
+	}
+}
+
+func TestApplyPrivacyMasks(t *testing.T) {
+	t.Run("black", func(t *testing.T) {
+		frame := createTestYUV420PFrame(64, 64)
+		test.That(t, frame, test.ShouldNotBeNil)
+		defer freeFrame(frame)
+		fillDummyYUV420PData(frame)
+
+		pm := newPrivacyMasks([]PrivacyMask{square(0, 0, 0.5, 0.5, privacyMaskModeBlack)})
+		test.That(t, pm.apply(frame), test.ShouldBeNil)
+		test.That(t, framePixel(frame, 0, 0, 0), test.ShouldEqual, 16)
+		test.That(t, framePixel(frame, 0, 31, 31), test.ShouldEqual, 16)
+		test.That(t, framePixel(frame, 1, 15, 15), test.ShouldEqual, 128)
+		test.That(t, framePixel(frame, 2, 15, 15), test.ShouldEqual, 128)
+		// outside the mask
+		test.That(t, framePixel(frame, 0, 32, 32), test.ShouldEqual, 128)
+		test.That(t, framePixel(frame, 1, 16, 16), test.ShouldEqual, 64)
+	})
+
+	t.Run("black is full range for jpeg frames", func(t *testing.T) {
+		frame := createTestYUVJ420PFrame(64, 64)
+		test.That(t, frame, test.ShouldNotBeNil)
+		defer freeFrame(frame)
+		fillDummyYUV420PData(frame)
+
+		pm := newPrivacyMasks([]PrivacyMask{square(0, 0, 1, 1, "")})
+		test.That(t, pm.apply(frame), test.ShouldBeNil)
+		test.That(t, framePixel(frame, 0, 63, 63), test.ShouldEqual, 0)
+	})
+
+	t.Run("blur averages blocks", func(t *testing.T) {
+		frame := createTestYUV420PFrame(64, 64)
+		test.That(t, frame, test.ShouldNotBeNil)
+		defer freeFrame(frame)
+		fillDummyYUV420PData(frame)
+		// a checkerboard in the first luma block averages to its mid value
+		for y := range minBlurBlockSize {
+			for x := range minBlurBlockSize {
+				v := byte(0)
+				if (x+y)%2 == 0 {
+					v = 200
+				}
+				setFramePixel(frame, 0, x, y, v)
+			}
+		}
+
+		pm := newPrivacyMasks([]PrivacyMask{square(0, 0, 0.5, 0.5, privacyMaskModeBlur)})
+		test.That(t, pm.apply(frame), test.ShouldBeNil)
+		test.That(t, framePixel(frame, 0, 0, 0), test.ShouldEqual, 100)
+		test.That(t, framePixel(frame, 0, 1, 0), test.ShouldEqual, 100)
+		test.That(t, framePixel(frame, 0, 20, 20), test.ShouldEqual, 128)
+		test.That(t, framePixel(frame, 1, 4, 4), test.ShouldEqual, 64)
+	})
+
+	t.Run("unsupported pixel formats are blacked out", func(t *testing.T) {
+		frame := createTestRGBAFrame(16, 16)
+		test.That(t, frame, test.ShouldNotBeNil)
+		defer freeFrame(frame)
+		for x := range 16 * 4 {
+			setFramePixel(frame, 0, x, 15, 0xff)
+		}
+		pm := newPrivacyMasks([]PrivacyMask{square(0, 0, 0.1, 0.1, "")})
+		err := pm.apply(frame)
+		var maskErr *privacyMaskError
+		test.That(t, errors.As(err, &maskErr), test.ShouldBeTrue)
+		test.That(t, maskErr.blackedOut, test.ShouldBeTrue)
+		// the whole frame, not just the masked area
+		test.That(t, framePixel(frame, 0, 15*4, 15), test.ShouldEqual, 0)
+	})
+
+	t.Run("frames that can't be blacked out are reported", func(t *testing.T) {
+		frame := createInvalidFrame()
+		test.That(t, frame, test.ShouldNotBeNil)
+		defer freeFrame(frame)
+		err := newPrivacyMasks([]PrivacyMask{square(0, 0, 1, 1, "")}).apply(frame)
+		var maskErr *privacyMaskError
+		test.That(t, errors.As(err, &maskErr), test.ShouldBeTrue)
+		test.That(t, maskErr.blackedOut, test.ShouldBeFalse)
+	})
+
+	t.Run("no masks leaves the frame alone", func(t *testing.T) {
+		frame := createTestRGBAFrame(16, 16)
+		test.That(t, frame, test.ShouldNotBeNil)
+		defer freeFrame(frame)
+		test.That(t, newPrivacyMasks(nil).apply(frame), test.ShouldBeNil)
+	})
+}
+
+func TestHandleLatestFrameMasksFailClosed(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	pool := newFramePool(2, logger)
+	defer pool.close()
+	rc := &rtspCamera{
+		logger:       logger,
+		avFramePool:  pool,
+		privacyMasks: newPrivacyMasks([]PrivacyMask{square(0, 0, 0.5, 0.5, "")}),
+	}
+
+	// unmasked frames are never shared
+	test.That(t, rc.handleLatestFrame(&avFrameWrapper{frame: createInvalidFrame()}), test.ShouldBeNil)
+	test.That(t, rc.latestFrame, test.ShouldBeNil)
+	test.That(t, rc.maskFailing.Load(), test.ShouldBeTrue)
+
+	blackedOut := rc.handleLatestFrame(&avFrameWrapper{frame: createTestRGBAFrame(16, 16)})
+	test.That(t, blackedOut, test.ShouldNotBeNil)
+	test.That(t, rc.latestFrame, test.ShouldEqual, blackedOut)
+
+	frame := &avFrameWrapper{frame: createTestYUV420PFrame(16, 16)}
+	test.That(t, rc.handleLatestFrame(frame), test.ShouldEqual, frame)
+	test.That(t, rc.maskFailing.Load(), test.ShouldBeFalse)
+	// the earlier frames went back to the pool, which frees them on close
+	frame.free()
+}
+
+func TestPrivacyMaskDoCommand(t *testing.T) {
+	rc := &rtspCamera{
+		logger:       logging.NewTestLogger(t),
+		privacyMasks: newPrivacyMasks([]PrivacyMask{square(0, 0, 0.5, 0.5, "")}),
+	}
+
+	res, err := rc.DoCommand(context.Background(), map[string]interface{}{"command": "get-privacy-masks"})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, res["privacy_masks"], test.ShouldHaveLength, 1)
+
+	_, err = rc.DoCommand(context.Background(), map[string]interface{}{
+		"command":       "set-privacy-masks",
+		"privacy_masks": []interface{}{map[string]interface{}{"points": []interface{}{}}},
+	})
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, rc.privacyMasks.get(), test.ShouldHaveLength, 1)
+
+	res, err = rc.DoCommand(context.Background(), map[string]interface{}{
+		"command":       "set-privacy-masks",
+		"privacy_masks": []interface{}{},
+	})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, res["privacy_masks"], test.ShouldBeEmpty)
+	test.That(t, rc.privacyMasks.enabled(), test.ShouldBeFalse)
+
+	_, err = rc.DoCommand(context.Background(), map[string]interface{}{"command": "bogus"})
+	test.That(t, err, test.ShouldNotBeNil)
+}
@@ -4,9 +4,11 @@ package registry
 import (
 	"context"
 	"errors"
+	"image"
 	"sync"
 
 	"github.com/viam-modules/video-store/videostore"
+	"go.viam.com/rdk/logging"
 )
 
 // Mux is how viamrtsp writes it's video data to a video-store that has requested it.
@@ -78,3 +80,16 @@ func (mr *ModuleRegistry) Remove(name string) error {
 	delete(mr.cams, name)
 	return nil
 }
+
+// KeyframeDecoder decodes single H264 or H265 keyframes, so videostores can take thumbnails from
+// stored video.
+type KeyframeDecoder interface {
+	// Decode decodes a keyframe given as its parameter sets followed by its NALUs, without start codes.
+	Decode(codec videostore.CodecType, nalus [][]byte) (image.Image, error)
+	// Close releases the decoder.
+	Close()
+}
+
+// NewKeyframeDecoder returns a KeyframeDecoder. It is set by viamrtsp, which owns the decoders,
+// and is nil when viamrtsp isn't part of the process.
+var NewKeyframeDecoder func(logger logging.Logger) KeyframeDecoder
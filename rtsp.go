@@ -3,6 +3,7 @@ package viamrtsp
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -125,6 +126,13 @@ type Config struct {
 	RTPPassthrough   *bool  `json:"rtp_passthrough"`
 	LazyDecode       bool   `json:"lazy_decode,omitempty"`
 	IframeOnlyDecode bool   `json:"i_frame_only_decode,omitempty"`
+	// MaxDecodeFPS limits how often decoded frames are converted and made available to Image.
+	MaxDecodeFPS float64 `json:"max_decode_fps,omitempty"`
+	// SkipNonReferenceFrames discards frames no other frame references before they are decoded.
+	// It is independent of MaxDecodeFPS and is never enabled automatically.
+	SkipNonReferenceFrames bool `json:"skip_non_reference_frames,omitempty"`
+	// Deinterlace is "auto" to deinterlace frames flagged as interlaced, or "always".
+	Deinterlace string `json:"deinterlace,omitempty"`
 
 	FrameRate    int                  `json:"frame_rate,omitempty"`
 	Resolution   *Resolution          `json:"resolution,omitempty"` // Use a pointer here
@@ -135,6 +143,14 @@ type Config struct {
 	VideoStore *videoStoreConfig `json:"video_store,omitempty"`
 	// New attribute to specify allowed transports: "tcp", "udp", "udp-multicast"
 	Transports []string `json:"transports,omitempty"`
+	// InsecureSkipVerify accepts any certificate from rtsps servers, which commonly use self signed ones.
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+
+	LensCorrection *LensCorrection `json:"lens_correction,omitempty"`
+	PrivacyMasks   []PrivacyMask   `json:"privacy_masks,omitempty"`
+	Overlay        *Overlay        `json:"overlay,omitempty"`
+	Motion         *Motion         `json:"motion,omitempty"`
+	Tamper         *Tamper         `json:"tamper,omitempty"`
 }
 
 // CodecFormat contains a pointer to a format and the corresponding FFmpeg codec.
@@ -160,6 +176,45 @@ func (conf *Config) Validate(path string) ([]string, []string, error) {
 		}
 	}
 
+	if conf.MaxDecodeFPS < 0 {
+		return nil, nil, fmt.Errorf("invalid max_decode_fps %g for component at path '%s', must not be negative", conf.MaxDecodeFPS, path)
+	}
+	if conf.MaxDecodeFPS > 0 && conf.LazyDecode {
+		return nil, nil, fmt.Errorf("max_decode_fps can't be combined with lazy_decode for component at path '%s'", path)
+	}
+
+	if err := validateDeinterlace(conf.Deinterlace); err != nil {
+		return nil, nil, fmt.Errorf("%w for component at path '%s'", err, path)
+	}
+
+	if conf.LensCorrection != nil {
+		if err := conf.LensCorrection.validate(); err != nil {
+			return nil, nil, fmt.Errorf("invalid lens_correction for component at path '%s': %w", path, err)
+		}
+	}
+
+	if err := validatePrivacyMasks(conf.PrivacyMasks); err != nil {
+		return nil, nil, fmt.Errorf("invalid privacy_masks for component at path '%s': %w", path, err)
+	}
+
+	if conf.Overlay != nil {
+		if err := conf.Overlay.validate(); err != nil {
+			return nil, nil, fmt.Errorf("invalid overlay for component at path '%s': %w", path, err)
+		}
+	}
+
+	if conf.Motion != nil {
+		if err := conf.Motion.validate(conf); err != nil {
+			return nil, nil, fmt.Errorf("invalid motion for component at path '%s': %w", path, err)
+		}
+	}
+
+	if conf.Tamper != nil {
+		if err := conf.Tamper.validate(); err != nil {
+			return nil, nil, fmt.Errorf("invalid tamper for component at path '%s': %w", path, err)
+		}
+	}
+
 	var deps []string
 	if conf.DiscoveryDep != "" {
 		deps = []string{conf.DiscoveryDep}
@@ -215,6 +270,10 @@ type rtspCamera struct {
 	u                *base.URL
 	lazyDecode       bool
 	iframeOnlyDecode bool
+	// decodeLimiter throttles frames passed to handleLatestFrame to max_decode_fps, it is nil when not set.
+	decodeLimiter          *frameRateLimiter
+	skipNonReferenceFrames bool
+	deinterlace            string
 
 	closeMu      sync.RWMutex
 	videoRequest *videoRequest
@@ -222,6 +281,8 @@ type rtspCamera struct {
 	au           [][]byte
 	client       *gortsplib.Client
 	rawDecoder   *decoder
+	// transcoder encodes MJPEG and MPEG4 frames to H264 while video-store is requesting video.
+	transcoder *h264Transcoder
 	// h264Media is the RTSP media track for H264.
 	h264Media *description.Media
 	// firSeqNum holds the last FIR sequence number (0–255), wraps per RFC 5104.
@@ -245,6 +306,18 @@ type rtspCamera struct {
 	avFramePool *framePool
 
 	mimeHandler *mimeHandler
+	// lens undistorts or dewarps decoded frames before anything else sees them, it is nil when not configured.
+	lens *lensCorrector
+	// privacyMasks are applied to decoded frames before they are converted or transcoded.
+	privacyMasks *privacyMasks
+	// maskFailing is set while frames can't be masked, so the failure is logged once.
+	maskFailing atomic.Bool
+	// overlay is drawn into decoded frames after the privacy masks, it is nil when not configured.
+	overlay *overlay
+	// motion detects motion in decoded frames, it is nil when not configured.
+	motion *motionDetector
+	// tamper detects covered, defocused or moved cameras, it is nil when not configured.
+	tamper *tamperDetector
 
 	logger logging.Logger
 
@@ -262,6 +335,7 @@ type rtspCamera struct {
 	bufAndCBByID map[rtppassthrough.SubscriptionID]bufAndCB
 
 	preferredTransports []*gortsplib.Transport
+	insecureSkipVerify  bool
 }
 
 // Close closes the camera. It always returns nil, but because of Close() interface, it needs to return an error.
@@ -347,6 +421,10 @@ func (rc *rtspCamera) closeConnection() {
 		rc.rawDecoder.close()
 		rc.rawDecoder = nil
 	}
+	if rc.transcoder != nil {
+		rc.transcoder.close()
+		rc.transcoder = nil
+	}
 	rc.videoRequest.stop()
 }
 
@@ -382,6 +460,9 @@ func (rc *rtspCamera) reconnectClient(codecInfo videoCodec, transport *gortsplib
 	rc.client = &gortsplib.Client{
 		Transport: transport,
 	}
+	if rc.insecureSkipVerify {
+		rc.client.TLSConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec
+	}
 	rc.client.OnPacketLost = func(err error) {
 		rc.logger.Debugf("OnPacketLost: err: %s", err)
 	}
@@ -531,6 +612,10 @@ func (rc *rtspCamera) initH264(session *description.Session) (err error) {
 	if err != nil {
 		return fmt.Errorf("creating H264 raw decoder: %w", err)
 	}
+	if rc.skipNonReferenceFrames {
+		rc.rawDecoder.skipNonReferenceFrames()
+	}
+	rc.rawDecoder.deinterlace(rc.deinterlace)
 
 	// if SPS and PPS are present into the SDP, send them to the decoder
 	initialSPSAndPPS := [][]byte{}
@@ -566,6 +651,9 @@ func (rc *rtspCamera) initH264(session *description.Session) (err error) {
 		if rc.iframeOnlyDecode && !h264.IDRPresent(au) {
 			return
 		}
+		if !rc.motion.allowDecode(h264.IDRPresent(au)) {
+			return
+		}
 
 		if rc.lazyDecode {
 			if h264.IDRPresent(au) {
@@ -641,7 +729,10 @@ func (rc *rtspCamera) initH264(session *description.Session) (err error) {
 
 var codecToCodecType = map[videoCodec]videostore.CodecType{
 	H264: videostore.CodecTypeH264,
-	H265: videostore.CodecTypeH264,
+	H265: videostore.CodecTypeH265,
+	// MJPEG and MPEG4 are transcoded to H264, see writeTranscoded
+	MJPEG: videostore.CodecTypeH264,
+	MPEG4: videostore.CodecTypeH264,
 }
 
 func (rc *rtspCamera) RequestVideo(mux registry.Mux, codecCandiates []videostore.CodecType) (context.Context, error) {
@@ -681,6 +772,10 @@ func (rc *rtspCamera) initH265(session *description.Session) (err error) {
 	if err != nil {
 		return fmt.Errorf("creating H265 raw decoder: %w", err)
 	}
+	if rc.skipNonReferenceFrames {
+		rc.rawDecoder.skipNonReferenceFrames()
+	}
+	rc.rawDecoder.deinterlace(rc.deinterlace)
 
 	// For H.265, handle VPS, SPS, and PPS
 	if f.VPS != nil {
@@ -713,6 +808,9 @@ func (rc *rtspCamera) initH265(session *description.Session) (err error) {
 		if rc.iframeOnlyDecode && !h265.IsRandomAccess(au) {
 			return
 		}
+		if !rc.motion.allowDecode(h265.IsRandomAccess(au)) {
+			return
+		}
 		packedAU := packH265AUIntoNALU(au, rc.logger)
 		if rc.lazyDecode {
 			if h265.IsRandomAccess(au) {
@@ -755,6 +853,21 @@ func (rc *rtspCamera) initH265(session *description.Session) (err error) {
 	return nil
 }
 
+// writeTranscoded encodes a decoded frame to H264 and writes it to video-store. Frames are
+// encoded once, as they arrive, so the stored video keeps the camera's frame rate and timestamps.
+func (rc *rtspCamera) writeTranscoded(frame *avFrameWrapper, pts int64) {
+	// a new request needs a keyframe to start its first segment on
+	au, err := rc.transcoder.encode(frame.frame, pts, rc.videoRequest.pending())
+	if err != nil {
+		rc.logger.Debugw("error transcoding frame to h264", "err", err.Error())
+		return
+	}
+	if len(au) == 0 {
+		return
+	}
+	rc.videoRequest.write(videostore.CodecTypeH264, rc.transcoder.parameters(), au, pts)
+}
+
 func packH265AUIntoNALU(au [][]byte, logger logging.Logger) []byte {
 	// If the AU has more than one NALU, compact them into a single payload with NALUs separated
 	// in AnnexB format. This is necessary because the H.265 decoder expects all NALUs for a frame
@@ -782,6 +895,14 @@ func (rc *rtspCamera) storeH265Frame(nalu []byte) {
 		return
 	}
 
+	now := time.Now()
+	if !rc.decodeLimiter.due(now) {
+		if err := rc.rawDecoder.feed(nalu); err != nil {
+			rc.logger.Debugw("error decoding(2) h265 rtsp stream", "err", err.Error())
+		}
+		return
+	}
+
 	frame, err := rc.rawDecoder.decode(nalu)
 	if err != nil {
 		rc.logger.Debugw("error decoding(2) h265 rtsp stream", "err", err.Error())
@@ -789,6 +910,7 @@ func (rc *rtspCamera) storeH265Frame(nalu []byte) {
 	}
 
 	if frame != nil {
+		rc.decodeLimiter.used(now)
 		rc.handleLatestFrame(frame)
 	}
 }
@@ -807,11 +929,6 @@ func (rc *rtspCamera) initMJPEG(session *description.Session) error {
 		rc.logger.Warn("i_frame_only_decode is currently only supported for H264 and H265 codecs. " +
 			"lazy_decode features disabled due to MJPEG RTSP track")
 	}
-	if rc.videoRequest.active() {
-		rc.logger.Warn("video-store is currently only supported for H264 and H265 codecs. " +
-			"unable to store video due to MJPEG RTSP track")
-	}
-
 	var f *format.MJPEG
 	media := session.FindFormat(&f)
 	if media == nil {
@@ -827,6 +944,14 @@ func (rc *rtspCamera) initMJPEG(session *description.Session) error {
 		return fmt.Errorf("creating MJPEG RTP decoder: %w", err)
 	}
 
+	// frames are only decoded for video-store or when they are masked or drawn on, otherwise
+	// Image returns the jpeg as is
+	rc.rawDecoder, err = newMJPEGDecoder(rc.avFramePool, rc.logger)
+	if err != nil {
+		return fmt.Errorf("creating MJPEG raw decoder: %w", err)
+	}
+	rc.transcoder = newH264Transcoder(rc.logger)
+
 	_, err = rc.client.Setup(session.BaseURL, media, 0, 0)
 	if err != nil {
 		return fmt.Errorf("when calling RTSP Setup on %s for MJPEG: %w", session.BaseURL, err)
@@ -840,6 +965,37 @@ func (rc *rtspCamera) initMJPEG(session *description.Session) error {
 
 		rc.latestMJPEGBytes.Store(&frame)
 		rc.markFrameReceived()
+
+		// frames are also decoded when motion or tamper detection is due to sample one
+		now := time.Now()
+		processed := rc.processesFrames() || rc.motion.due(now) || rc.tamper.due(now)
+		recording := rc.videoRequest.active()
+		// recordings are transcoded from every frame, which must all be masked
+		if !recording {
+			processed = processed && rc.decodeLimiter.due(now)
+		}
+		if !processed && !recording {
+			return
+		}
+		decodedFrame, err := rc.rawDecoder.decode(frame)
+		if err != nil || decodedFrame == nil {
+			return
+		}
+		if processed {
+			rc.decodeLimiter.used(now)
+			decodedFrame = rc.handleLatestFrame(decodedFrame)
+			if decodedFrame == nil {
+				return
+			}
+		}
+		if recording {
+			if pts, ok := rc.client.PacketPTS2(media, pkt); ok {
+				rc.writeTranscoded(decodedFrame, pts)
+			}
+		}
+		if !processed {
+			rc.avFramePool.put(decodedFrame)
+		}
 	})
 
 	return nil
@@ -906,11 +1062,6 @@ func (rc *rtspCamera) initMPEG4(session *description.Session) error {
 			"lazy_decode features disabled due to MPEG4 RTSP track")
 	}
 
-	if rc.videoRequest.active() {
-		rc.logger.Warn("video-store is currently only supported for H264 and H265 codecs. " +
-			"unable to store video due to MPEG4 RTSP track")
-	}
-
 	var f *format.MPEG4Video
 	media := session.FindFormat(&f)
 	var err error
@@ -951,12 +1102,15 @@ func (rc *rtspCamera) initMPEG4(session *description.Session) error {
 			return fmt.Errorf("creating MPEG4 raw decoder: %w", err)
 		}
 	}
+	rc.rawDecoder.deinterlace(rc.deinterlace)
 
 	_, err = rc.client.Setup(session.BaseURL, media, 0, 0)
 	if err != nil {
 		return fmt.Errorf("when calling RTSP Setup on %s for MPEG4: %w", session.BaseURL, err)
 	}
 
+	rc.transcoder = newH264Transcoder(rc.logger)
+
 	rc.client.OnPacketRTP(media, f, func(pkt *rtp.Packet) {
 		frame, err := mpeg4Decoder.Decode(pkt)
 		if err != nil {
@@ -967,8 +1121,24 @@ func (rc *rtspCamera) initMPEG4(session *description.Session) error {
 			// A frame arrived, so the stream is alive. Stamp liveness on the receive path (see
 			// H264 storeImage).
 			rc.markFrameReceived()
+			now := time.Now()
+			recording := rc.videoRequest.active()
+			if !recording && !rc.decodeLimiter.due(now) {
+				// MPEG4 frames reference earlier ones, so they are still decoded
+				if err := rc.rawDecoder.feed(frame); err != nil {
+					rc.logger.Debugw("error decoding mpeg4 rtsp stream", "err", err.Error())
+				}
+				return
+			}
 			if decodedFrame, err := rc.rawDecoder.decode(frame); err == nil && decodedFrame != nil {
-				rc.handleLatestFrame(decodedFrame)
+				rc.decodeLimiter.used(now)
+				// handleLatestFrame applies the privacy masks, so transcode after it
+				decodedFrame = rc.handleLatestFrame(decodedFrame)
+				if decodedFrame != nil && recording {
+					if pts, ok := rc.client.PacketPTS2(media, pkt); ok {
+						rc.writeTranscoded(decodedFrame, pts)
+					}
+				}
 			}
 		}
 	})
@@ -1167,6 +1337,11 @@ func NewRTSPCamera(ctx context.Context, deps resource.Dependencies, conf resourc
 		}
 	}
 
+	osd, err := newOverlay(newConf.Overlay, conf.ResourceName().Name)
+	if err != nil {
+		return nil, err
+	}
+
 	framePool := newFramePool(initialFramePoolSize, logger)
 
 	mimeHandler := newMimeHandler(logger)
@@ -1182,9 +1357,13 @@ func NewRTSPCamera(ctx context.Context, deps resource.Dependencies, conf resourc
 		model:                       conf.Model,
 		lazyDecode:                  newConf.LazyDecode,
 		iframeOnlyDecode:            newConf.IframeOnlyDecode,
+		decodeLimiter:               newFrameRateLimiter(newConf.MaxDecodeFPS),
+		skipNonReferenceFrames:      newConf.SkipNonReferenceFrames,
+		deinterlace:                 newConf.Deinterlace,
 		u:                           u,
 		Named:                       conf.ResourceName().AsNamed(),
 		preferredTransports:         preferredTransports,
+		insecureSkipVerify:          newConf.InsecureSkipVerify,
 		rtpPassthrough:              rtpPassthrough,
 		videoRequest:                &videoRequest{logger: logger},
 		bufAndCBByID:                make(map[rtppassthrough.SubscriptionID]bufAndCB),
@@ -1192,6 +1371,11 @@ func NewRTSPCamera(ctx context.Context, deps resource.Dependencies, conf resourc
 		rtpPassthroughCancelCauseFn: rtpPassthroughCancelCauseFn,
 		avFramePool:                 framePool,
 		mimeHandler:                 mimeHandler,
+		lens:                        newLensCorrector(newConf.LensCorrection, logger),
+		privacyMasks:                newPrivacyMasks(newConf.PrivacyMasks),
+		overlay:                     osd,
+		motion:                      newMotionDetector(newConf.Motion),
+		tamper:                      newTamperDetector(newConf.Tamper, logger),
 		cancelCtx:                   cancelCtx,
 		cancelFunc:                  cancel,
 		logger:                      logger,
@@ -1222,6 +1406,7 @@ func NewRTSPCamera(ctx context.Context, deps resource.Dependencies, conf resourc
 	rc.markFrameReceived()
 
 	rc.clientReconnectBackgroundWorker(codecInfo)
+	rc.analysisBackgroundWorker()
 	guard.Success()
 	return rc, nil
 }
@@ -1249,6 +1434,10 @@ func (rc *rtspCamera) validateSupportsPassthrough() error {
 		return fmt.Errorf("rtp_passthrough only supported for H264 codec, current codec is: %s", currentCodec)
 	}
 
+	if rc.privacyMasks.enabled() {
+		return errors.New("rtp_passthrough is disabled while privacy masks are set")
+	}
+
 	if err := context.Cause(rc.rtpPassthroughCtx); err != nil {
 		return fmt.Errorf("rtp_passthrough was determined to not be supported at runtime due to %w", err)
 	}
@@ -1365,6 +1554,16 @@ func H2645StartCode() []byte {
 }
 
 func (rc *rtspCamera) decodeAndStore(nalu []byte) error {
+	now := time.Now()
+	if !rc.decodeLimiter.due(now) {
+		// keep the decoder's reference frames current, but skip copying out a frame nobody will see
+		err := rc.rawDecoder.feed(nalu)
+		recoverableErr := &recoverableError{}
+		if errors.As(err, &recoverableErr) {
+			return nil
+		}
+		return err
+	}
 	frame, err := rc.rawDecoder.decode(nalu)
 	recoverableErr := &recoverableError{}
 	if errors.As(err, &recoverableErr) {
@@ -1374,6 +1573,7 @@ func (rc *rtspCamera) decodeAndStore(nalu []byte) error {
 		return err
 	}
 	if frame != nil {
+		rc.decodeLimiter.used(now)
 		rc.handleLatestFrame(frame)
 	}
 	return nil
@@ -1382,7 +1582,25 @@ func (rc *rtspCamera) decodeAndStore(nalu []byte) error {
 // handleLatestFrame sets the new latest frame, and cleans up
 // the previous frame by trying to put it back in the pool. It might not make
 // it back into the pool immediately or at all depending on its state.
-func (rc *rtspCamera) handleLatestFrame(newFrame *avFrameWrapper) {
+// It returns the frame that was stored, which is a new one when lens correction is configured,
+// or nil when the frame was dropped because it couldn't be masked.
+func (rc *rtspCamera) handleLatestFrame(newFrame *avFrameWrapper) *avFrameWrapper {
+	// correct the lens first, so masks, zones and the overlay apply to the frame users see
+	newFrame = rc.lens.correct(rc.avFramePool, newFrame)
+	// mask before the frame is shared with Image
+	if !rc.applyPrivacyMasks(newFrame) {
+		rc.avFramePool.put(newFrame)
+		return nil
+	}
+	if err := rc.motion.sample(newFrame.frame); err != nil {
+		rc.logger.Debugw("error detecting motion", "err", err.Error())
+	}
+	if err := rc.tamper.sample(newFrame.frame); err != nil {
+		rc.logger.Debugw("error detecting tamper", "err", err.Error())
+	}
+	if err := rc.overlay.apply(newFrame.frame); err != nil {
+		rc.logger.Debugw("error drawing overlay", "err", err.Error())
+	}
 	rc.latestFrameMu.Lock()
 	defer rc.latestFrameMu.Unlock()
 
@@ -1395,6 +1613,51 @@ func (rc *rtspCamera) handleLatestFrame(newFrame *avFrameWrapper) {
 	newFrame.incrementRefs()
 	rc.latestFrame = newFrame
 	rc.latestFrameCache = cache{}
+	return newFrame
+}
+
+// applyPrivacyMasks masks frame and reports whether it can be shared. Frames that can't be
+// masked are blacked out, or must be dropped if even that fails.
+func (rc *rtspCamera) applyPrivacyMasks(frame *avFrameWrapper) bool {
+	err := rc.privacyMasks.apply(frame.frame)
+	if err == nil {
+		if rc.maskFailing.CompareAndSwap(true, false) {
+			rc.logger.Info("privacy masks are being applied again")
+		}
+		return true
+	}
+	if rc.maskFailing.CompareAndSwap(false, true) {
+		rc.logger.Errorw("unable to apply privacy masks, frames are blacked out or dropped until they can be", "err", err.Error())
+	}
+	var maskErr *privacyMaskError
+	return errors.As(err, &maskErr) && maskErr.blackedOut
+}
+
+// processesFrames returns whether decoded frames are changed before they are shared,
+// in which case Image can't return MJPEG frames as sent by the camera.
+func (rc *rtspCamera) processesFrames() bool {
+	return rc.lens != nil || rc.privacyMasks.enabled() || rc.overlay != nil
+}
+
+// analysisBackgroundWorker decodes the buffered frames of a lazy_decode camera at the motion and
+// tamper sample interval, since otherwise frames are only decoded when Image is called.
+func (rc *rtspCamera) analysisBackgroundWorker() {
+	if !rc.lazyDecode || (rc.motion == nil && rc.tamper == nil) {
+		return
+	}
+	interval := defaultTamperSampleInterval
+	if rc.tamper != nil {
+		interval = rc.tamper.cfg.sampleInterval()
+	}
+	if rc.motion != nil {
+		interval = min(interval, rc.motion.cfg.sampleInterval())
+	}
+	rc.activeBackgroundWorkers.Add(1)
+	utils.ManagedGo(func() {
+		for utils.SelectContextOrWait(rc.cancelCtx, interval) {
+			rc.consumeLazyAU()
+		}
+	}, rc.activeBackgroundWorkers.Done)
 }
 
 // markFrameReceived stamps the liveness timestamp used by the reconnect worker and Image().
@@ -1433,7 +1696,8 @@ func (rc *rtspCamera) Image(_ context.Context, mimeType string, _ map[string]int
 		rc.logger.Error(err.Error())
 		return nil, "", err
 	}
-	if videoCodec(rc.currentCodec.Load()) == MJPEG {
+	// MJPEG frames are only decoded when they need to be masked or drawn on
+	if videoCodec(rc.currentCodec.Load()) == MJPEG && !rc.processesFrames() {
 		mjpegBytes := rc.latestMJPEGBytes.Load()
 		if mjpegBytes == nil {
 			return nil, "", errors.New("no frame yet")
@@ -1488,10 +1752,21 @@ func (rc *rtspCamera) getAndConvertFrame(mimeType string) ([]byte, string, error
 }
 
 func (rc *rtspCamera) Properties(_ context.Context) (camera.Properties, error) {
-	return camera.Properties{
+	props := camera.Properties{
 		SupportsPCD: false,
 		MimeTypes:   []string{rutils.MimeTypeJPEG},
-	}, nil
+	}
+	if rc.lens != nil {
+		// frames are already corrected, so there are intrinsics but no distortion to report
+		width, height := 0, 0
+		rc.latestFrameMu.Lock()
+		if rc.latestFrame != nil {
+			width, height = int(rc.latestFrame.frame.width), int(rc.latestFrame.frame.height)
+		}
+		rc.latestFrameMu.Unlock()
+		props.IntrinsicParams = rc.lens.intrinsics(width, height)
+	}
+	return props, nil
 }
 
 // Images returns the latest frame as a named image as jpeg bytes.
@@ -1523,8 +1798,70 @@ func (rc *rtspCamera) NextPointCloud(_ context.Context, _ map[string]interface{}
 	return nil, errors.New("not implemented")
 }
 
-func (rc *rtspCamera) DoCommand(_ context.Context, _ map[string]interface{}) (map[string]interface{}, error) {
-	return nil, errors.New("not implemented")
+func (rc *rtspCamera) DoCommand(_ context.Context, command map[string]interface{}) (map[string]interface{}, error) {
+	cmd, ok := command["command"].(string)
+	if !ok {
+		return nil, errors.New("invalid command type")
+	}
+	switch cmd {
+	case "get-privacy-masks":
+		return map[string]interface{}{"privacy_masks": privacyMasksToDoCommand(rc.privacyMasks.get())}, nil
+	case "set-privacy-masks":
+		masks, err := parsePrivacyMasks(command["privacy_masks"])
+		if err != nil {
+			return nil, err
+		}
+		rc.setPrivacyMasks(masks)
+		return map[string]interface{}{"privacy_masks": privacyMasksToDoCommand(masks)}, nil
+	case "get-motion":
+		if rc.motion == nil {
+			return nil, errors.New("motion detection is not configured")
+		}
+		return rc.motion.doCommandState(), nil
+	case "get-health":
+		return rc.health(), nil
+	case "reset-tamper":
+		if rc.tamper == nil {
+			return nil, errors.New("tamper detection is not configured")
+		}
+		rc.tamper.reset()
+		return map[string]interface{}{}, nil
+	default:
+		return nil, fmt.Errorf("unknown command: %s", cmd)
+	}
+}
+
+// health reports whether the stream is delivering frames and, when configured, camera tamper.
+func (rc *rtspCamera) health() map[string]interface{} {
+	since := rc.timeSinceLastFrame()
+	ret := map[string]interface{}{
+		"streaming":                since <= noFrameTimeout,
+		"seconds_since_last_frame": since.Seconds(),
+		"codec":                    videoCodec(rc.currentCodec.Load()).String(),
+	}
+	if rc.tamper != nil {
+		ret["tamper"] = rc.tamper.doCommandState()
+	}
+	return ret
+}
+
+// setPrivacyMasks replaces the privacy masks until the camera is reconfigured.
+func (rc *rtspCamera) setPrivacyMasks(masks []PrivacyMask) {
+	rc.privacyMasks.set(masks)
+	if len(masks) > 0 {
+		// passthrough streams can't be masked, so end them and let clients fall back to Image
+		rc.unsubscribeAll()
+	}
+	// mask the frame Image would return next, rather than waiting for a new one
+	rc.latestFrameMu.Lock()
+	defer rc.latestFrameMu.Unlock()
+	if rc.latestFrame != nil && !rc.applyPrivacyMasks(rc.latestFrame) {
+		if refCount := rc.latestFrame.decrementRefs(); refCount == 0 {
+			rc.avFramePool.put(rc.latestFrame)
+		}
+		rc.latestFrame = nil
+	}
+	rc.latestFrameCache = cache{}
 }
 
 // wrapWithMarkerFromTimestamp wraps an RTP packet handler to force the marker bit
@@ -1,8 +1,11 @@
 package viamrtsp
 
 import (
+	"bytes"
 	"context"
 	"image"
+	"slices"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -13,6 +16,8 @@ import (
 	"github.com/erh/viamupnp"
 	"github.com/koron/go-ssdp"
 	"github.com/pion/rtp"
+	"github.com/viam-modules/viamrtsp/registry"
+	"github.com/viam-modules/video-store/videostore"
 	"go.viam.com/rdk/components/camera"
 	"go.viam.com/rdk/components/camera/rtppassthrough"
 	"go.viam.com/rdk/logging"
@@ -327,6 +332,62 @@ func TestRTSPCamera(t *testing.T) {
 			})
 		})
 	})
+
+	t.Run("H265", func(t *testing.T) {
+		forma := &format.H265{
+			PayloadTyp: 96,
+			VPS:        []byte{0x40, 0x01, 0x0c, 0x01, 0xff, 0xff, 0x01, 0x60, 0x00, 0x00, 0x03, 0x00, 0x90},
+			SPS: []byte{
+				0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03,
+				0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03,
+				0x00, 0x78, 0xa0, 0x03, 0xc0, 0x80, 0x10, 0xe5,
+				0x96, 0x66, 0x69, 0x24, 0xca, 0xe0, 0x10, 0x00,
+				0x00, 0x03, 0x00, 0x10, 0x00, 0x00, 0x03, 0x01,
+				0xe0, 0x80,
+			},
+			PPS: []byte{0x44, 0x01, 0xc1, 0x72, 0xb4, 0x62, 0x40},
+		}
+		// the camera changes its PPS in band after the first keyframe
+		newPPS := []byte{0x44, 0x01, 0xc1, 0x73, 0xd0, 0x89}
+		aus := [][][]byte{
+			{forma.VPS, forma.SPS, forma.PPS, {0x26, 0x01, 0xaf, 0x08, 0x40}},
+			{{0x02, 0x01, 0xd0, 0x10, 0x80}},
+			{forma.VPS, forma.SPS, newPPS, {0x26, 0x01, 0xaf, 0x08, 0x40}},
+			{{0x02, 0x01, 0xd0, 0x10, 0x80}},
+		}
+
+		t.Run("RequestVideo", func(t *testing.T) {
+			h, closeFunc := NewMockH265ServerHandler(t, forma, bURL, aus, logger)
+			defer closeFunc()
+			test.That(t, h.S.Start(), test.ShouldBeNil)
+			timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), time.Second*10)
+			defer timeoutCancel()
+			config := resource.NewEmptyConfig(camera.Named("foo"), ModelAgnostic)
+			config.ConvertedAttributes = &Config{Address: "rtsp://" + h.S.RTSPAddress + "/stream1"}
+			cam, err := NewRTSPCamera(timeoutCtx, nil, config, logger)
+			test.That(t, err, test.ShouldBeNil)
+			defer func() { test.That(t, cam.Close(context.Background()), test.ShouldBeNil) }()
+			rtspCam, ok := cam.(*rtspCamera)
+			test.That(t, ok, test.ShouldBeTrue)
+
+			_, err = rtspCam.RequestVideo(&fakeMux{}, []videostore.CodecType{videostore.CodecTypeH264})
+			test.That(t, err, test.ShouldBeError, registry.ErrUnsupported)
+
+			mux := &fakeMux{}
+			_, err = rtspCam.RequestVideo(mux, []videostore.CodecType{videostore.CodecTypeH264, videostore.CodecTypeH265})
+			test.That(t, err, test.ShouldBeNil)
+			defer func() { test.That(t, rtspCam.CancelRequest(mux), test.ShouldBeNil) }()
+
+			for timeoutCtx.Err() == nil && !mux.sawNALU(newPPS) {
+				time.Sleep(50 * time.Millisecond)
+			}
+			test.That(t, timeoutCtx.Err(), test.ShouldBeNil)
+			codec, params := mux.started()
+			test.That(t, codec, test.ShouldEqual, videostore.CodecTypeH265)
+			test.That(t, params, test.ShouldResemble, [][]byte{forma.VPS, forma.SPS, forma.PPS})
+			test.That(t, mux.codecs(), test.ShouldResemble, []videostore.CodecType{videostore.CodecTypeH265})
+		})
+	})
 }
 
 func TestRTSPConfig(t *testing.T) {
@@ -359,6 +420,18 @@ func TestRTSPConfig(t *testing.T) {
 	_, _, err = rtspConf.Validate("path")
 	test.That(t, err, test.ShouldNotBeNil)
 	test.That(t, err.Error(), test.ShouldContainSubstring, "invalid transport")
+	// max_decode_fps
+	rtspConf = &Config{Address: "rtsp://example.com:5000", MaxDecodeFPS: 2.5, SkipNonReferenceFrames: true}
+	_, _, err = rtspConf.Validate("path")
+	test.That(t, err, test.ShouldBeNil)
+	rtspConf = &Config{Address: "rtsp://example.com:5000", MaxDecodeFPS: -1}
+	_, _, err = rtspConf.Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "max_decode_fps")
+	rtspConf = &Config{Address: "rtsp://example.com:5000", MaxDecodeFPS: 5, LazyDecode: true}
+	_, _, err = rtspConf.Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "lazy_decode")
 }
 
 // Dedicated test for performance benchmarking.
@@ -472,3 +545,52 @@ func TestUPNPStuff(t *testing.T) {
 	test.That(t, err, test.ShouldBeNil)
 	test.That(t, u.Host, test.ShouldEqual, "eliot:1234")
 }
+
+// fakeMux records what a camera sends to video-store.
+type fakeMux struct {
+	mu           sync.Mutex
+	startCodec   videostore.CodecType
+	startParams  [][]byte
+	packetCodecs []videostore.CodecType
+	nalus        [][]byte
+}
+
+func (m *fakeMux) Start(codec videostore.CodecType, initialParameters [][]byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.startCodec = codec
+	m.startParams = initialParameters
+	return nil
+}
+
+func (m *fakeMux) WritePacket(codec videostore.CodecType, au [][]byte, _ int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !slices.Contains(m.packetCodecs, codec) {
+		m.packetCodecs = append(m.packetCodecs, codec)
+	}
+	m.nalus = append(m.nalus, au...)
+	return nil
+}
+
+func (m *fakeMux) Stop() error {
+	return nil
+}
+
+func (m *fakeMux) started() (videostore.CodecType, [][]byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.startCodec, m.startParams
+}
+
+func (m *fakeMux) codecs() []videostore.CodecType {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.packetCodecs
+}
+
+func (m *fakeMux) sawNALU(nalu []byte) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.ContainsFunc(m.nalus, func(n []byte) bool { return bytes.Equal(n, nalu) })
+}
@@ -0,0 +1,93 @@
+package rtspscan
+
+import "strings"
+
+// vendor is a camera maker whose RTSP servers serve streams at well known paths.
+type vendor struct {
+	name string
+	// serverHints are lowercase substrings of the Server header the vendor's RTSP servers answer
+	// OPTIONS with.
+	serverHints []string
+	// paths are the vendor's stream paths, main stream first.
+	paths []string
+}
+
+// genericVendor names the paths used by many unbranded cameras and firmwares.
+const genericVendor = "generic"
+
+// vendors is the database of known stream paths. Each vendor's paths are only tried until one of
+// them answers, after which only the rest of that vendor's paths are tried. Generic paths come last
+// since they can match cameras of any vendor.
+var vendors = []vendor{
+	{
+		name:        "hikvision",
+		serverHints: []string{"hikvision"},
+		paths:       []string{"/Streaming/Channels/101", "/Streaming/Channels/102"},
+	},
+	{
+		name:        "dahua",
+		serverHints: []string{"dahua", "amcrest", "lorex"},
+		paths:       []string{"/cam/realmonitor?channel=1&subtype=0", "/cam/realmonitor?channel=1&subtype=1"},
+	},
+	{
+		name:        "reolink",
+		serverHints: []string{"reolink"},
+		paths:       []string{"/h264Preview_01_main", "/h264Preview_01_sub", "/h265Preview_01_main"},
+	},
+	{
+		name:        "axis",
+		serverHints: []string{"axis"},
+		paths:       []string{"/axis-media/media.amp"},
+	},
+	{
+		name:        "uniview",
+		serverHints: []string{"uniview", "unv"},
+		paths:       []string{"/unicast/c1/s0/live", "/unicast/c1/s1/live"},
+	},
+	{
+		name:        "hanwha",
+		serverHints: []string{"hanwha", "samsung", "wisenet"},
+		paths:       []string{"/profile2/media.smp", "/profile3/media.smp"},
+	},
+	{
+		name:        "vivotek",
+		serverHints: []string{"vivotek"},
+		paths:       []string{"/live.sdp", "/live2.sdp"},
+	},
+	{
+		name:        "foscam",
+		serverHints: []string{"foscam"},
+		paths:       []string{"/videoMain", "/videoSub"},
+	},
+	{
+		name:  "tapo",
+		paths: []string{"/stream1", "/stream2"},
+	},
+	{
+		name:  genericVendor,
+		paths: []string{"/live", "/11", "/12", "/h264", "/live/ch00_0", "/"},
+	},
+}
+
+// vendorsByHint returns vendors ordered so that the ones whose hints match server come first,
+// keeping database order otherwise.
+func vendorsByHint(server string) []vendor {
+	server = strings.ToLower(server)
+	hinted := []vendor{}
+	rest := []vendor{}
+	for _, v := range vendors {
+		matched := false
+		for _, hint := range v.serverHints {
+			if server != "" && strings.Contains(server, hint) {
+				matched = true
+				break
+			}
+		}
+		if matched {
+			hinted = append(hinted, v)
+		} else {
+			rest = append(rest, v)
+		}
+	}
+	return append(hinted, rest...)
+}
@@ -0,0 +1,273 @@
+package rtspscan
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/liberrors"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h265"
+	"go.viam.com/rdk/logging"
+)
+
+const (
+	// how long a single RTSP request may take to be answered
+	rtspTimeout = 5 * time.Second
+	// wildcardPath is a path no camera serves. Cameras that describe it answer every path with the
+	// same stream, so their paths tell nothing about what they serve.
+	wildcardPath = "/viam-rtsp-scan-nonexistent"
+)
+
+// errUnauthorized is returned when a camera requires credentials and none of the configured ones
+// are accepted.
+var errUnauthorized = errors.New("camera requires authentication and none of the configured credentials were accepted")
+
+// Credentials are a username and password tried on cameras that require authentication.
+type Credentials struct {
+	User string `json:"user"`
+	Pass string `json:"pass"`
+}
+
+// Stream is an RTSP stream found on a camera.
+type Stream struct {
+	// Vendor is the name of the vendor whose paths matched, or empty for configured stream paths.
+	Vendor string
+	// Host is the camera's host and port.
+	Host string
+	Path string
+	// URL is the stream's address, including the credentials the camera accepted.
+	URL       *url.URL
+	Codec     string
+	Width     int
+	Height    int
+	FrameRate int
+}
+
+// prober finds the streams served by the RTSP server at host.
+type prober struct {
+	host   string
+	creds  []Credentials
+	logger logging.Logger
+	// cred is the credential the camera accepted, nil while the camera hasn't asked for one.
+	cred *Credentials
+}
+
+// newClient returns an RTSP client whose connection is dialed with ctx.
+func newClient(ctx context.Context) *gortsplib.Client {
+	return &gortsplib.Client{
+		ReadTimeout:  rtspTimeout,
+		WriteTimeout: rtspTimeout,
+		DialContext: func(_ context.Context, network, address string) (net.Conn, error) {
+			return (&net.Dialer{Timeout: rtspTimeout}).DialContext(ctx, network, address)
+		},
+	}
+}
+
+// describe sends a DESCRIBE for path using cred, which may be nil. A new client is used for every
+// request, since a client keeps answering challenges with the first credentials it was given.
+func (p *prober) describe(ctx context.Context, path string, cred *Credentials) (*description.Session, error) {
+	u, err := base.ParseURL("rtsp://" + p.host + path)
+	if err != nil {
+		return nil, err
+	}
+	if cred != nil {
+		u.User = url.UserPassword(cred.User, cred.Pass)
+	}
+	client := newClient(ctx)
+	if err := client.Start(u.Scheme, u.Host); err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	desc, _, err := client.Describe(u)
+	return desc, err
+}
+
+// serverHeader returns the Server header the camera answers OPTIONS with, or an empty string.
+func (p *prober) serverHeader(ctx context.Context) string {
+	u, err := base.ParseURL("rtsp://" + p.host + "/")
+	if err != nil {
+		return ""
+	}
+	client := newClient(ctx)
+	if err := client.Start(u.Scheme, u.Host); err != nil {
+		return ""
+	}
+	defer client.Close()
+	// some cameras want credentials even for OPTIONS, the header is still sent with the error
+	res, err := client.Options(u)
+	if res == nil || len(res.Header["Server"]) == 0 {
+		if err != nil {
+			p.logger.Debugf("OPTIONS to %s failed: %v", p.host, err)
+		}
+		return ""
+	}
+	return res.Header["Server"][0]
+}
+
+// describePath describes path, finding which of the configured credentials the camera accepts the
+// first time it asks for them. Each credential is tried at most once per camera so a scan can't
+// lock out an account on cameras that block repeated failed logins.
+func (p *prober) describePath(ctx context.Context, path string) (*description.Session, error) {
+	desc, err := p.describe(ctx, path, p.cred)
+	if p.cred != nil || !isStatus(err, base.StatusUnauthorized) {
+		return desc, err
+	}
+	for i := range p.creds {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		desc, err = p.describe(ctx, path, &p.creds[i])
+		var statusErr liberrors.ErrClientBadStatusCode
+		if err == nil || (errors.As(err, &statusErr) && statusErr.Code != base.StatusUnauthorized) {
+			p.cred = &p.creds[i]
+			return desc, err
+		}
+	}
+	return nil, errUnauthorized
+}
+
+// isStatus reports whether err is a response with status code.
+func isStatus(err error, code base.StatusCode) bool {
+	var statusErr liberrors.ErrClientBadStatusCode
+	return errors.As(err, &statusErr) && statusErr.Code == code
+}
+
+// probe returns the streams the camera serves at customPaths, or, when none of them answer, at the
+// known paths of the vendors database, trying the vendors hinted at by the camera's Server header
+// first.
+func (p *prober) probe(ctx context.Context, customPaths []string) ([]Stream, error) {
+	server := p.serverHeader(ctx)
+	if server != "" {
+		p.logger.Debugf("RTSP server at %s is %q", p.host, server)
+	}
+
+	wildcard := false
+	if _, err := p.describePath(ctx, wildcardPath); err == nil {
+		p.logger.Debugf("RTSP server at %s answers every path, only a single stream is reported", p.host)
+		wildcard = true
+	} else if errors.Is(err, errUnauthorized) || ctx.Err() != nil {
+		return nil, err
+	}
+
+	streams := []Stream{}
+	// try describes path, reporting whether the camera serves it and the stream is new.
+	try := func(vendorName, path string) (bool, error) {
+		desc, err := p.describePath(ctx, path)
+		if err != nil {
+			if errors.Is(err, errUnauthorized) || ctx.Err() != nil {
+				return false, err
+			}
+			p.logger.Debugf("%s%s: %v", p.host, path, err)
+			return false, nil
+		}
+		stream := p.stream(vendorName, path, desc)
+		for _, s := range streams {
+			if sameStream(s, stream) {
+				p.logger.Debugf("%s%s serves the same stream as %s", p.host, path, s.Path)
+				return false, nil
+			}
+		}
+		streams = append(streams, stream)
+		return true, nil
+	}
+
+	for _, path := range customPaths {
+		found, err := try("", path)
+		if err != nil {
+			return nil, err
+		}
+		if found && wildcard {
+			return streams, nil
+		}
+	}
+	if len(streams) > 0 {
+		return streams, nil
+	}
+	if wildcard {
+		if _, err := try(genericVendor, "/"); err != nil {
+			return nil, err
+		}
+		return streams, nil
+	}
+
+	for _, v := range vendorsByHint(server) {
+		for _, path := range v.paths {
+			if _, err := try(v.name, path); err != nil {
+				return nil, err
+			}
+		}
+		if len(streams) > 0 {
+			return streams, nil
+		}
+	}
+	return streams, nil
+}
+
+// sameStream reports whether a and b look like the same stream served at two paths. Streams without
+// a known resolution, like MJPEG or H264 without an SPS in the SDP, can't be told apart so are never
+// treated as the same.
+func sameStream(a, b Stream) bool {
+	if a.Width == 0 || a.Height == 0 {
+		return false
+	}
+	return a.Codec == b.Codec && a.Width == b.Width && a.Height == b.Height && a.FrameRate == b.FrameRate
+}
+
+// stream returns the stream described by desc.
+func (p *prober) stream(vendorName, path string, desc *description.Session) Stream {
+	rawPath, query, _ := strings.Cut(path, "?")
+	u := &url.URL{Scheme: "rtsp", Host: p.host, Path: rawPath, RawQuery: query}
+	if p.cred != nil {
+		u.User = url.UserPassword(p.cred.User, p.cred.Pass)
+	}
+	stream := Stream{Vendor: vendorName, Host: p.host, Path: path, URL: u}
+	streamFormat(desc, &stream)
+	return stream
+}
+
+// streamFormat fills in the codec, resolution and frame rate of the first video format in desc.
+// Resolution and frame rate are only known for H264 and H265 streams whose SDP carries an SPS.
+func streamFormat(desc *description.Session, stream *Stream) {
+	for _, media := range desc.Medias {
+		if media.Type != description.MediaTypeVideo {
+			continue
+		}
+		for _, forma := range media.Formats {
+			switch f := forma.(type) {
+			case *format.H264:
+				stream.Codec = "H264"
+				sps, _ := f.SafeParams()
+				var parsed h264.SPS
+				if len(sps) > 0 && parsed.Unmarshal(sps) == nil {
+					stream.Width, stream.Height = parsed.Width(), parsed.Height()
+					stream.FrameRate = int(math.Round(parsed.FPS()))
+				}
+				return
+			case *format.H265:
+				stream.Codec = "H265"
+				_, sps, _ := f.SafeParams()
+				var parsed h265.SPS
+				if len(sps) > 0 && parsed.Unmarshal(sps) == nil {
+					stream.Width, stream.Height = parsed.Width(), parsed.Height()
+					stream.FrameRate = int(math.Round(parsed.FPS()))
+				}
+				return
+			case *format.MJPEG:
+				stream.Codec = "JPEG"
+				return
+			case *format.MPEG4Video:
+				stream.Codec = "MPEG4"
+				return
+			}
+		}
+	}
+}
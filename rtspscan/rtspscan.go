@@ -0,0 +1,281 @@
+// Package rtspscan provides a discovery service that finds RTSP cameras which support neither
+// ONVIF nor UPnP by scanning subnets for RTSP servers and fingerprinting the stream paths they serve.
+//
+// Many inexpensive cameras only speak RTSP. This service probes every host of the configured
+// subnets for an open RTSP port, asks the RTSP server which of a database of known vendor stream
+// paths it serves, and turns each stream it finds into a camera resource config with the codec and
+// resolution read from the stream's SDP.
+package rtspscan
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/viam-modules/viamrtsp"
+	"github.com/viam-modules/viamrtsp/rtsppreview"
+	"github.com/viam-modules/viamrtsp/viamonvif"
+	"go.viam.com/rdk/components/camera"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/resource"
+	"go.viam.com/rdk/services/discovery"
+	"go.viam.com/utils"
+)
+
+// Model is the model for a discovery service that scans subnets for rtsp cameras.
+var Model = viamrtsp.Family.WithModel("rtsp-scan")
+
+const (
+	// how long connecting to a host's RTSP port may take
+	dialTimeout = 2 * time.Second
+	// how many hosts are probed at the same time
+	scanConcurrency = 64
+)
+
+// defaultPorts are the ports RTSP servers commonly listen on.
+var defaultPorts = []int{554, 8554}
+
+func init() {
+	resource.RegisterService(
+		discovery.API,
+		Model,
+		resource.Registration[discovery.Service, *Config]{
+			Constructor: newDiscovery,
+		})
+}
+
+// Config is the config for the RTSP scan discovery service.
+type Config struct {
+	// Subnets are the IPv4 CIDR ranges whose hosts are scanned.
+	Subnets []string `json:"subnets"`
+	// Ports are the RTSP ports probed on each host. Defaults to defaultPorts if unset.
+	Ports []int `json:"ports,omitempty"`
+	// Credentials are tried in order on cameras that require authentication.
+	Credentials []Credentials `json:"credentials,omitempty"`
+	// StreamPaths are tried before the known vendor paths. When any of them is served, the vendor
+	// paths aren't tried.
+	StreamPaths []string `json:"stream_paths,omitempty"`
+}
+
+// Validate validates the discovery service config.
+func (cfg *Config) Validate(_ string) ([]string, []string, error) {
+	if len(cfg.Subnets) == 0 {
+		return nil, nil, errors.New("at least one subnet must be set")
+	}
+	if _, err := viamonvif.SubnetHosts(cfg.Subnets); err != nil {
+		return nil, nil, err
+	}
+	for _, p := range cfg.Ports {
+		if p <= 0 || p > 65535 {
+			return nil, nil, fmt.Errorf("port %d out of range", p)
+		}
+	}
+	for _, cred := range cfg.Credentials {
+		if cred.Pass != "" && cred.User == "" {
+			return nil, nil, errors.New("credential missing username")
+		}
+	}
+	for _, p := range cfg.StreamPaths {
+		if !strings.HasPrefix(p, "/") {
+			return nil, nil, fmt.Errorf("stream path %q must start with /", p)
+		}
+	}
+	return []string{}, nil, nil
+}
+
+// ports returns the configured RTSP ports or the default.
+func (cfg *Config) ports() []int {
+	if len(cfg.Ports) > 0 {
+		return cfg.Ports
+	}
+	return defaultPorts
+}
+
+type scanDiscovery struct {
+	resource.Named
+	resource.AlwaysRebuild
+	resource.TriviallyCloseable
+
+	cfg    *Config
+	logger logging.Logger
+}
+
+func newDiscovery(_ context.Context, _ resource.Dependencies,
+	conf resource.Config,
+	logger logging.Logger,
+) (discovery.Service, error) {
+	cfg, err := resource.NativeConfig[*Config](conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &scanDiscovery{
+		Named:  conf.ResourceName().AsNamed(),
+		cfg:    cfg,
+		logger: logger,
+	}, nil
+}
+
+// DiscoverResources scans the configured subnets and returns a camera config for every stream found.
+func (dis *scanDiscovery) DiscoverResources(ctx context.Context, _ map[string]any) ([]resource.Config, error) {
+	hosts, err := viamonvif.SubnetHosts(dis.cfg.Subnets)
+	if err != nil {
+		return nil, err
+	}
+	streams := scan(ctx, hosts, dis.cfg, dis.logger)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return streamsToConfigs(streams)
+}
+
+// DoCommand handles the "preview" command, returning a JPEG data URL for the given rtsp_address
+// grabbed over RTSP.
+func (dis *scanDiscovery) DoCommand(ctx context.Context, command map[string]interface{}) (map[string]interface{}, error) {
+	cmd, ok := command["command"].(string)
+	if !ok {
+		return nil, errors.New("invalid command type")
+	}
+
+	switch cmd {
+	case "preview":
+		rtspURL, err := rtsppreview.ParsePreviewCommand(command)
+		if err != nil {
+			return nil, err
+		}
+		dataURL, err := rtsppreview.FetchImageFromRTSPURL(ctx, dis.logger, rtspURL)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"preview": dataURL}, nil
+	default:
+		return nil, fmt.Errorf("unknown command: %s", cmd)
+	}
+}
+
+// scan probes the configured ports of every host for an RTSP server and returns the streams the
+// servers it finds serve. Hosts whose cameras reject every configured credential are logged and
+// skipped.
+func scan(ctx context.Context, hosts []netip.Addr, cfg *Config, logger logging.Logger) []Stream {
+	ports := cfg.ports()
+	logger.Debugf("scanning %d hosts on ports %v for RTSP servers", len(hosts), ports)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		streams []Stream
+		tokens  = make(chan struct{}, scanConcurrency)
+	)
+	for _, host := range hosts {
+		select {
+		case tokens <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return streams
+		}
+		wg.Add(1)
+		utils.ManagedGo(func() {
+			defer func() { <-tokens }()
+			for _, port := range ports {
+				hostPort := net.JoinHostPort(host.String(), strconv.Itoa(port))
+				if ctx.Err() != nil || !portOpen(ctx, hostPort) {
+					continue
+				}
+				p := &prober{host: hostPort, creds: cfg.Credentials, logger: logger}
+				found, err := p.probe(ctx, cfg.StreamPaths)
+				if err != nil {
+					if ctx.Err() == nil {
+						logger.Warnf("skipping RTSP server at %s: %v", hostPort, err)
+					}
+					continue
+				}
+				logger.Debugf("found %d streams at %s", len(found), hostPort)
+				mu.Lock()
+				streams = append(streams, found...)
+				mu.Unlock()
+			}
+		}, wg.Done)
+	}
+	wg.Wait()
+	// hosts finish in any order, keep the results stable across scans
+	slices.SortStableFunc(streams, func(a, b Stream) int { return strings.Compare(a.Host, b.Host) })
+	logger.Debugf("RTSP scan found %d streams", len(streams))
+	return streams
+}
+
+// portOpen reports whether a TCP connection to hostPort can be made.
+func portOpen(ctx context.Context, hostPort string) bool {
+	dialCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(dialCtx, "tcp", hostPort)
+	if err != nil {
+		return false
+	}
+	utils.UncheckedError(conn.Close())
+	return true
+}
+
+// streamsToConfigs turns streams into camera resource configs.
+func streamsToConfigs(streams []Stream) ([]resource.Config, error) {
+	configs := []resource.Config{}
+	for _, stream := range streams {
+		camCfg, err := createCameraConfig(cameraName(stream), stream)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, camCfg)
+	}
+	return configs, nil
+}
+
+// nonNameChars matches runs of characters not allowed in a Viam resource name.
+var nonNameChars = regexp.MustCompile("[^a-zA-Z0-9_-]+")
+
+// cameraName builds a deterministic component name from the stream's vendor, host and path.
+func cameraName(stream Stream) string {
+	parts := []string{}
+	for _, part := range []string{stream.Vendor, stream.Host, stream.Path} {
+		if part = strings.Trim(nonNameChars.ReplaceAllString(part, "-"), "-"); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// createCameraConfig builds a camera resource.Config for the given stream.
+func createCameraConfig(name string, stream Stream) (resource.Config, error) {
+	// Use viamrtsp's Config struct so a breaking change surfaces at compile time.
+	rtpPassthrough := true
+	attributes := viamrtsp.Config{
+		Address:        stream.URL.String(),
+		RTPPassthrough: &rtpPassthrough,
+		Codec:          stream.Codec,
+		FrameRate:      stream.FrameRate,
+	}
+	if stream.Width > 0 && stream.Height > 0 {
+		attributes.Resolution = &viamrtsp.Resolution{Width: stream.Width, Height: stream.Height}
+	}
+
+	jsonBytes, err := json.Marshal(attributes)
+	if err != nil {
+		return resource.Config{}, err
+	}
+	var result map[string]interface{}
+	if err = json.Unmarshal(jsonBytes, &result); err != nil {
+		return resource.Config{}, err
+	}
+
+	return resource.Config{
+		Name: name, API: camera.API, Model: viamrtsp.ModelAgnostic,
+		Attributes: result, ConvertedAttributes: &attributes,
+	}, nil
+}
@@ -0,0 +1,326 @@
+package viamrtsp
+
+/*
+#include <libavutil/frame.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"go.viam.com/rdk/logging"
+)
+
+const (
+	tamperSceneChange = "scene_change"
+	tamperCovered     = "covered"
+	tamperDefocused   = "defocused"
+
+	defaultTamperSampleInterval = time.Second
+	defaultTamperDuration       = 5 * time.Second
+	defaultTamperHold           = time.Minute
+	defaultSceneChangeThreshold = 0.6
+	defaultUniformThreshold     = 8
+	defaultSharpnessRatio       = 0.4
+	// sharpness is lost at the motion sample width, so tamper samples are larger
+	tamperSampleWidth = 320
+	// luma difference for a pixel to count as changed from the reference scene
+	sceneChangePixelThreshold = 25
+	// weight of a new sample in the reference sharpness
+	sharpnessSmoothing = 0.1
+)
+
+// Tamper configures detection of cameras that are moved, covered or out of focus.
+type Tamper struct {
+	// SampleIntervalMs is how often a frame is checked.
+	SampleIntervalMs int `json:"sample_interval_ms,omitempty"`
+	// DurationSec is how long a condition must last before it is reported.
+	DurationSec *float64 `json:"duration_sec,omitempty"`
+	// SceneChangeThreshold is the fraction of the frame that must differ from the reference scene.
+	SceneChangeThreshold float64 `json:"scene_change_threshold,omitempty"`
+	// UniformThreshold is the luma standard deviation below which a frame counts as covered.
+	UniformThreshold float64 `json:"uniform_threshold,omitempty"`
+	// SharpnessRatio is the fraction of the reference sharpness below which a frame counts as defocused.
+	SharpnessRatio float64 `json:"sharpness_ratio,omitempty"`
+	// HoldSec is how long a scene change stays reported, since the new scene becomes the reference.
+	HoldSec *float64 `json:"hold_sec,omitempty"`
+}
+
+func (c *Tamper) sampleInterval() time.Duration {
+	if c.SampleIntervalMs == 0 {
+		return defaultTamperSampleInterval
+	}
+	return time.Duration(c.SampleIntervalMs) * time.Millisecond
+}
+
+func (c *Tamper) duration() time.Duration {
+	if c.DurationSec == nil {
+		return defaultTamperDuration
+	}
+	return time.Duration(*c.DurationSec * float64(time.Second))
+}
+
+func (c *Tamper) hold() time.Duration {
+	if c.HoldSec == nil {
+		return defaultTamperHold
+	}
+	return time.Duration(*c.HoldSec * float64(time.Second))
+}
+
+func (c *Tamper) sceneChangeThreshold() float64 {
+	if c.SceneChangeThreshold == 0 {
+		return defaultSceneChangeThreshold
+	}
+	return c.SceneChangeThreshold
+}
+
+func (c *Tamper) uniformThreshold() float64 {
+	if c.UniformThreshold == 0 {
+		return defaultUniformThreshold
+	}
+	return c.UniformThreshold
+}
+
+func (c *Tamper) sharpnessRatio() float64 {
+	if c.SharpnessRatio == 0 {
+		return defaultSharpnessRatio
+	}
+	return c.SharpnessRatio
+}
+
+func (c *Tamper) validate() error {
+	if c.SampleIntervalMs < 0 {
+		return errors.New("tamper sample_interval_ms can't be negative")
+	}
+	if c.DurationSec != nil && *c.DurationSec < 0 {
+		return errors.New("tamper duration_sec can't be negative")
+	}
+	if c.HoldSec != nil && *c.HoldSec < 0 {
+		return errors.New("tamper hold_sec can't be negative")
+	}
+	if c.SceneChangeThreshold < 0 || c.SceneChangeThreshold > 1 {
+		return errors.New("tamper scene_change_threshold must be between 0 and 1")
+	}
+	if c.UniformThreshold < 0 {
+		return errors.New("tamper uniform_threshold can't be negative")
+	}
+	if c.SharpnessRatio < 0 || c.SharpnessRatio > 1 {
+		return errors.New("tamper sharpness_ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// tamperStats are the measurements of one sample.
+type tamperStats struct {
+	// changed is the fraction of pixels that differ from the reference scene.
+	changed float64
+	// stddev is the standard deviation of luma, near zero for a covered lens.
+	stddev float64
+	// sharpness is the mean absolute luma gradient, which drops when the image is blurry.
+	sharpness float64
+}
+
+// tamperCondition tracks one kind of tamper. It is only reported once it has lasted the configured duration.
+type tamperCondition struct {
+	pendingSince time.Time
+	activeSince  time.Time
+	active       bool
+}
+
+// tamperDetector checks sampled frames for tamper conditions.
+type tamperDetector struct {
+	cfg    *Tamper
+	logger logging.Logger
+
+	mu         sync.Mutex
+	reference  []byte
+	width      int
+	height     int
+	sharpness  float64
+	lastSample time.Time
+	stats      tamperStats
+	conditions map[string]*tamperCondition
+}
+
+// newTamperDetector returns nil when cfg is nil.
+func newTamperDetector(cfg *Tamper, logger logging.Logger) *tamperDetector {
+	if cfg == nil {
+		return nil
+	}
+	return &tamperDetector{
+		cfg:    cfg,
+		logger: logger,
+		conditions: map[string]*tamperCondition{
+			tamperSceneChange: {},
+			tamperCovered:     {},
+			tamperDefocused:   {},
+		},
+	}
+}
+
+func (d *tamperDetector) due(now time.Time) bool {
+	if d == nil {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return now.Sub(d.lastSample) >= d.cfg.sampleInterval()
+}
+
+// sample checks frame if a sample is due. It must be called before the overlay is drawn.
+func (d *tamperDetector) sample(frame *C.AVFrame) error {
+	now := time.Now()
+	if frame == nil || !d.due(now) {
+		return nil
+	}
+	luma, width, height, err := downscaleLuma(frame, tamperSampleWidth)
+	if err != nil {
+		return fmt.Errorf("tamper detection: %w", err)
+	}
+	d.update(luma, width, height, now)
+	return nil
+}
+
+// measure computes the stats of luma against the reference scene.
+func (d *tamperDetector) measure(luma []byte, width, height int) tamperStats {
+	var stats tamperStats
+	var sum, sumSq float64
+	changed, gradients := 0, 0
+	for i, v := range luma {
+		sum += float64(v)
+		sumSq += float64(v) * float64(v)
+		if d.reference != nil {
+			diff := int(v) - int(d.reference[i])
+			if diff > sceneChangePixelThreshold || -diff > sceneChangePixelThreshold {
+				changed++
+			}
+		}
+		x, y := i%width, i/width
+		if x+1 < width && y+1 < height {
+			dx := int(luma[i+1]) - int(v)
+			dy := int(luma[i+width]) - int(v)
+			stats.sharpness += math.Abs(float64(dx)) + math.Abs(float64(dy))
+			gradients++
+		}
+	}
+	n := float64(len(luma))
+	mean := sum / n
+	stats.stddev = math.Sqrt(max(0, sumSq/n-mean*mean))
+	stats.changed = float64(changed) / n
+	stats.sharpness /= float64(max(1, gradients))
+	return stats
+}
+
+// update checks a downscaled luma plane for tamper. The reference scene and sharpness follow
+// gradual changes such as daylight, so only sudden changes are reported.
+func (d *tamperDetector) update(luma []byte, width, height int, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lastSample = now
+	if width != d.width || height != d.height {
+		d.width, d.height = width, height
+		d.reference = nil
+		d.sharpness = 0
+	}
+	stats := d.measure(luma, width, height)
+	d.stats = stats
+
+	covered := stats.stddev < d.cfg.uniformThreshold()
+	defocused := !covered && d.sharpness > 0 && stats.sharpness < d.cfg.sharpnessRatio()*d.sharpness
+	// covering or defocusing the lens also changes most pixels, which is reported as that instead
+	sceneChanged := !covered && !defocused && d.reference != nil && stats.changed >= d.cfg.sceneChangeThreshold()
+
+	d.observe(tamperCovered, covered, now)
+	d.observe(tamperDefocused, defocused, now)
+	if d.observe(tamperSceneChange, sceneChanged, now) {
+		// the camera now points somewhere else, which becomes the scene to compare against
+		d.reference = append(d.reference[:0], luma...)
+		d.sharpness = stats.sharpness
+	}
+	if scene := d.conditions[tamperSceneChange]; scene.active && !sceneChanged && now.Sub(scene.activeSince) >= d.cfg.hold() {
+		scene.active = false
+		d.logger.Infof("camera tamper cleared: %s", tamperSceneChange)
+	}
+
+	if !sceneChanged && !covered && !defocused {
+		d.reference = append(d.reference[:0], luma...)
+	}
+	if !covered && !defocused {
+		if d.sharpness == 0 {
+			d.sharpness = stats.sharpness
+		} else {
+			d.sharpness += sharpnessSmoothing * (stats.sharpness - d.sharpness)
+		}
+	}
+}
+
+// observe updates a condition and reports whether it just became active.
+// Scene changes are cleared by update once they have been reported for hold_sec.
+func (d *tamperDetector) observe(name string, observed bool, now time.Time) bool {
+	c := d.conditions[name]
+	if !observed {
+		c.pendingSince = time.Time{}
+		if c.active && name != tamperSceneChange {
+			c.active = false
+			d.logger.Infof("camera tamper cleared: %s", name)
+		}
+		return false
+	}
+	if c.pendingSince.IsZero() {
+		c.pendingSince = now
+	}
+	if c.active || now.Sub(c.pendingSince) < d.cfg.duration() {
+		return false
+	}
+	c.active = true
+	c.activeSince = now
+	d.logger.Warnw("camera tamper detected", "condition", name,
+		"changed", d.stats.changed, "stddev", d.stats.stddev, "sharpness", d.stats.sharpness, "reference_sharpness", d.sharpness)
+	return true
+}
+
+// reset forgets the reference scene and clears all conditions, for after a camera was deliberately moved.
+func (d *tamperDetector) reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.reference = nil
+	d.width, d.height = 0, 0
+	d.sharpness = 0
+	for _, c := range d.conditions {
+		*c = tamperCondition{}
+	}
+}
+
+// doCommandState is the tamper part of the get-health DoCommand.
+func (d *tamperDetector) doCommandState() map[string]interface{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	active := []interface{}{}
+	conditions := map[string]interface{}{}
+	for _, name := range []string{tamperSceneChange, tamperCovered, tamperDefocused} {
+		c := d.conditions[name]
+		state := map[string]interface{}{"active": c.active}
+		if c.active {
+			active = append(active, name)
+			state["since"] = c.activeSince.UTC().Format(time.RFC3339)
+		}
+		conditions[name] = state
+	}
+	state := map[string]interface{}{
+		"tampered":            len(active) > 0,
+		"active":              active,
+		"conditions":          conditions,
+		"scene_changed":       d.stats.changed,
+		"stddev":              d.stats.stddev,
+		"sharpness":           d.stats.sharpness,
+		"reference_sharpness": d.sharpness,
+	}
+	if !d.lastSample.IsZero() {
+		state["last_sample"] = d.lastSample.UTC().Format(time.RFC3339)
+	}
+	return state
+}
@@ -0,0 +1,170 @@
+package viamrtsp
+
+import (
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestTamperDetector(t *testing.T) {
+	const width, height = 16, 16
+	logger := logging.NewTestLogger(t)
+	// checkerboard is a sharp, high contrast scene
+	checkerboard := func(light, dark byte) []byte {
+		luma := make([]byte, width*height)
+		for i := range luma {
+			if (i%width/2+i/width/2)%2 == 0 {
+				luma[i] = light
+			} else {
+				luma[i] = dark
+			}
+		}
+		return luma
+	}
+	// soft is the checkerboard out of focus, with the same mean but much weaker edges
+	soft := func() []byte {
+		return checkerboard(140, 110)
+	}
+	uniform := func(v byte) []byte {
+		luma := make([]byte, width*height)
+		for i := range luma {
+			luma[i] = v
+		}
+		return luma
+	}
+	zero := 0.0
+	start := time.Now()
+	at := func(sec int) time.Time { return start.Add(time.Duration(sec) * time.Second) }
+	active := func(d *tamperDetector) []interface{} {
+		return d.doCommandState()["active"].([]interface{})
+	}
+
+	t.Run("a steady scene is not tampered", func(t *testing.T) {
+		d := newTamperDetector(&Tamper{}, logger)
+		for i := range 10 {
+			d.update(checkerboard(200, 50), width, height, at(i))
+		}
+		test.That(t, active(d), test.ShouldBeEmpty)
+		test.That(t, d.doCommandState()["tampered"], test.ShouldBeFalse)
+	})
+
+	t.Run("covered lens is reported after duration and clears when uncovered", func(t *testing.T) {
+		d := newTamperDetector(&Tamper{}, logger)
+		d.update(checkerboard(200, 50), width, height, at(0))
+		d.update(uniform(20), width, height, at(1))
+		test.That(t, active(d), test.ShouldBeEmpty)
+		d.update(uniform(20), width, height, at(6))
+		test.That(t, active(d), test.ShouldResemble, []interface{}{tamperCovered})
+		// a covered lens is not also a scene change or defocus
+		d.update(uniform(20), width, height, at(20))
+		test.That(t, active(d), test.ShouldResemble, []interface{}{tamperCovered})
+
+		d.update(checkerboard(200, 50), width, height, at(21))
+		test.That(t, active(d), test.ShouldBeEmpty)
+	})
+
+	t.Run("brief changes are not reported", func(t *testing.T) {
+		d := newTamperDetector(&Tamper{}, logger)
+		d.update(checkerboard(200, 50), width, height, at(0))
+		d.update(checkerboard(50, 200), width, height, at(1))
+		d.update(checkerboard(200, 50), width, height, at(7))
+		test.That(t, active(d), test.ShouldBeEmpty)
+	})
+
+	t.Run("scene change is held and the new scene becomes the reference", func(t *testing.T) {
+		hold := 30.0
+		d := newTamperDetector(&Tamper{DurationSec: &zero, HoldSec: &hold}, logger)
+		d.update(checkerboard(200, 50), width, height, at(0))
+		d.update(checkerboard(50, 200), width, height, at(1))
+		test.That(t, active(d), test.ShouldResemble, []interface{}{tamperSceneChange})
+		test.That(t, d.doCommandState()["scene_changed"], test.ShouldEqual, 1.0)
+
+		d.update(checkerboard(50, 200), width, height, at(10))
+		test.That(t, active(d), test.ShouldResemble, []interface{}{tamperSceneChange})
+		test.That(t, d.doCommandState()["scene_changed"], test.ShouldEqual, 0.0)
+		d.update(checkerboard(50, 200), width, height, at(31))
+		test.That(t, active(d), test.ShouldBeEmpty)
+	})
+
+	t.Run("gradual changes follow the reference", func(t *testing.T) {
+		d := newTamperDetector(&Tamper{DurationSec: &zero}, logger)
+		for i := range 10 {
+			// darken by less than the pixel threshold each sample
+			d.update(checkerboard(byte(200-i*10), byte(60-i*5)), width, height, at(i))
+		}
+		test.That(t, active(d), test.ShouldBeEmpty)
+	})
+
+	t.Run("loss of focus", func(t *testing.T) {
+		d := newTamperDetector(&Tamper{DurationSec: &zero}, logger)
+		for i := range 3 {
+			d.update(checkerboard(200, 50), width, height, at(i))
+		}
+		d.update(soft(), width, height, at(3))
+		test.That(t, active(d), test.ShouldResemble, []interface{}{tamperDefocused})
+		// the reference sharpness doesn't drift down while defocused
+		for i := 4; i < 20; i++ {
+			d.update(soft(), width, height, at(i))
+		}
+		test.That(t, active(d), test.ShouldResemble, []interface{}{tamperDefocused})
+		d.update(checkerboard(200, 50), width, height, at(20))
+		test.That(t, active(d), test.ShouldBeEmpty)
+	})
+
+	t.Run("reset clears conditions", func(t *testing.T) {
+		d := newTamperDetector(&Tamper{DurationSec: &zero}, logger)
+		d.update(checkerboard(200, 50), width, height, at(0))
+		d.update(soft(), width, height, at(1))
+		test.That(t, active(d), test.ShouldNotBeEmpty)
+		d.reset()
+		test.That(t, active(d), test.ShouldBeEmpty)
+		d.update(soft(), width, height, at(2))
+		test.That(t, active(d), test.ShouldBeEmpty)
+	})
+}
+
+func TestTamperSample(t *testing.T) {
+	frame := createTestYUV420PFrame(640, 480)
+	test.That(t, frame, test.ShouldNotBeNil)
+	defer freeFrame(frame)
+	fillDummyYUV420PData(frame)
+
+	d := newTamperDetector(&Tamper{}, logging.NewTestLogger(t))
+	test.That(t, d.sample(frame), test.ShouldBeNil)
+	test.That(t, d.width, test.ShouldEqual, tamperSampleWidth)
+	test.That(t, d.doCommandState()["last_sample"], test.ShouldNotBeEmpty)
+	test.That(t, d.due(time.Now()), test.ShouldBeFalse)
+
+	var nilDetector *tamperDetector
+	test.That(t, nilDetector.due(time.Now()), test.ShouldBeFalse)
+}
+
+func TestTamperValidate(t *testing.T) {
+	negative := -1.0
+	for _, tc := range []struct {
+		name   string
+		tamper Tamper
+		err    string
+	}{
+		{name: "defaults", tamper: Tamper{}},
+		{name: "negative interval", tamper: Tamper{SampleIntervalMs: -1}, err: "sample_interval_ms"},
+		{name: "negative duration", tamper: Tamper{DurationSec: &negative}, err: "duration_sec"},
+		{name: "negative hold", tamper: Tamper{HoldSec: &negative}, err: "hold_sec"},
+		{name: "scene change above 1", tamper: Tamper{SceneChangeThreshold: 2}, err: "scene_change_threshold"},
+		{name: "negative uniform threshold", tamper: Tamper{UniformThreshold: -1}, err: "uniform_threshold"},
+		{name: "sharpness ratio above 1", tamper: Tamper{SharpnessRatio: 1.5}, err: "sharpness_ratio"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := Config{Address: "rtsp://127.0.0.1:8554/live", Tamper: &tc.tamper}
+			_, _, err := conf.Validate("path")
+			if tc.err == "" {
+				test.That(t, err, test.ShouldBeNil)
+				return
+			}
+			test.That(t, err, test.ShouldNotBeNil)
+			test.That(t, err.Error(), test.ShouldContainSubstring, tc.err)
+		})
+	}
+}
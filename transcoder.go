@@ -0,0 +1,244 @@
+package viamrtsp
+
+/*
+#include <libavcodec/avcodec.h>
+#include <libavutil/error.h>
+#include <libavutil/opt.h>
+#include <libavutil/frame.h>
+#include <libavutil/pixfmt.h>
+#include <libswscale/swscale.h>
+#include <stdlib.h>
+
+// AVERROR is a function-like macro so cgo can't use it directly.
+static int encoder_needs_more(int res) {
+	return res == AVERROR(EAGAIN) || res == AVERROR_EOF;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"unsafe"
+
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"go.viam.com/rdk/logging"
+)
+
+const (
+	// transcoded video uses the RTP video clock so camera timestamps can be passed through.
+	transcodeTimeBase = 90000
+	// frames between keyframes, this bounds how long video-store waits for a segment to start.
+	transcodeGOPSize = 30
+)
+
+// h264Transcoder encodes decoded frames to H264 so that cameras sending codecs video-store
+// can't store directly (MJPEG, MPEG4) can still be recorded without re-requesting images.
+type h264Transcoder struct {
+	logger logging.Logger
+	encCtx *C.AVCodecContext
+	// yuvFrame and swsCtx convert frames that are not yuv420p or have odd dimensions.
+	yuvFrame *C.AVFrame
+	swsCtx   *C.struct_SwsContext
+	// refFrame references frames that are encoded without conversion. The decoded frame is
+	// shared with Image, so the encoder's pts and picture type are set on refFrame instead.
+	refFrame *C.AVFrame
+	pkt      *C.AVPacket
+	// srcFormat is the pixel format of the frames the encoder was created for.
+	srcFormat C.int
+	sps       []byte
+	pps       []byte
+}
+
+func newH264Transcoder(logger logging.Logger) *h264Transcoder {
+	return &h264Transcoder{logger: logger}
+}
+
+// parameters returns the most recent SPS and PPS produced by the encoder.
+func (t *h264Transcoder) parameters() [][]byte {
+	return [][]byte{t.sps, t.pps}
+}
+
+// encode encodes frame with the given pts and returns the resulting access unit, which is nil
+// while the encoder is buffering. When forceKeyframe is set the frame is encoded as an IDR.
+func (t *h264Transcoder) encode(frame *C.AVFrame, pts int64, forceKeyframe bool) ([][]byte, error) {
+	if frame == nil {
+		return nil, errors.New("frame input is nil, cannot transcode to H264")
+	}
+	// x264 needs even dimensions for yuv420p
+	width, height := frame.width&^1, frame.height&^1
+	if t.encCtx == nil || t.encCtx.width != width || t.encCtx.height != height || t.srcFormat != frame.format {
+		if err := t.initEncoder(frame, width, height); err != nil {
+			return nil, err
+		}
+	}
+
+	var src *C.AVFrame
+	if t.swsCtx == nil {
+		if res := C.av_frame_ref(t.refFrame, frame); res < 0 {
+			return nil, newAvError(res, "failed to reference frame")
+		}
+		defer C.av_frame_unref(t.refFrame)
+		src = t.refFrame
+	} else {
+		res := C.sws_scale(
+			t.swsCtx,
+			frameData(frame),
+			frameLineSize(frame),
+			0,
+			frame.height,
+			frameData(t.yuvFrame),
+			frameLineSize(t.yuvFrame),
+		)
+		if res < 0 {
+			return nil, newAvError(res, "failed to convert frame to yuv420p")
+		}
+		src = t.yuvFrame
+	}
+
+	src.pts = C.int64_t(pts)
+	if forceKeyframe {
+		src.pict_type = C.AV_PICTURE_TYPE_I
+	} else {
+		src.pict_type = C.AV_PICTURE_TYPE_NONE
+	}
+	res := C.avcodec_send_frame(t.encCtx, src)
+	if res < 0 {
+		return nil, newAvError(res, "failed to send frame to H264 encoder")
+	}
+
+	var au [][]byte
+	for {
+		res = C.avcodec_receive_packet(t.encCtx, t.pkt)
+		if C.encoder_needs_more(res) != 0 {
+			break
+		}
+		if res < 0 {
+			return nil, newAvError(res, "failed to receive packet from H264 encoder")
+		}
+		nalus, err := h264.AnnexBUnmarshal(C.GoBytes(unsafe.Pointer(t.pkt.data), t.pkt.size))
+		C.av_packet_unref(t.pkt)
+		if err != nil {
+			return nil, err
+		}
+		au = append(au, nalus...)
+	}
+	for _, nalu := range au {
+		switch naluType(nalu) {
+		case h264.NALUTypeSPS:
+			t.sps = nalu
+		case h264.NALUTypePPS:
+			t.pps = nalu
+		default:
+		}
+	}
+	return au, nil
+}
+
+func (t *h264Transcoder) initEncoder(frame *C.AVFrame, width, height C.int) error {
+	t.logger.Infof("creating H264 transcoder with frame size: %dx%d", width, height)
+	t.close()
+	if width <= 0 || height <= 0 {
+		return errors.New("invalid frame size for H264 transcoder")
+	}
+
+	name := C.CString("libx264")
+	defer C.free(unsafe.Pointer(name))
+	codec := C.avcodec_find_encoder_by_name(name)
+	if codec == nil {
+		codec = C.avcodec_find_encoder(C.AV_CODEC_ID_H264)
+	}
+	if codec == nil {
+		return errors.New("failed to find H264 encoder")
+	}
+	encCtx := C.avcodec_alloc_context3(codec)
+	if encCtx == nil {
+		return errors.New("avcodec_alloc_context3() failed")
+	}
+	encCtx.width = width
+	encCtx.height = height
+	encCtx.pix_fmt = C.AV_PIX_FMT_YUV420P
+	encCtx.time_base = C.AVRational{num: 1, den: transcodeTimeBase}
+	encCtx.gop_size = transcodeGOPSize
+	// no b-frames so packets come out in the order frames go in
+	encCtx.max_b_frames = 0
+
+	var opts *C.AVDictionary
+	defer C.av_dict_free(&opts)
+	for k, v := range map[string]string{"preset": "ultrafast", "tune": "zerolatency", "forced-idr": "1"} {
+		key, value := C.CString(k), C.CString(v)
+		res := C.av_dict_set(&opts, key, value, 0)
+		C.free(unsafe.Pointer(key))
+		C.free(unsafe.Pointer(value))
+		if res < 0 {
+			C.avcodec_free_context(&encCtx)
+			return newAvError(res, "failed to set H264 encoder option "+k)
+		}
+	}
+	if res := C.avcodec_open2(encCtx, codec, &opts); res < 0 {
+		C.avcodec_free_context(&encCtx)
+		return newAvError(res, "failed to open H264 encoder")
+	}
+	t.encCtx = encCtx
+	t.srcFormat = frame.format
+
+	t.pkt = C.av_packet_alloc()
+	if t.pkt == nil {
+		t.close()
+		return errors.New("failed to allocate packet")
+	}
+
+	if frame.format == C.AV_PIX_FMT_YUV420P && frame.width == width && frame.height == height {
+		t.refFrame = C.av_frame_alloc()
+		if t.refFrame == nil {
+			t.close()
+			return errors.New("failed to allocate frame")
+		}
+		return nil
+	}
+	t.yuvFrame = C.av_frame_alloc()
+	if t.yuvFrame == nil {
+		t.close()
+		return errors.New("failed to allocate frame")
+	}
+	t.yuvFrame.width = width
+	t.yuvFrame.height = height
+	t.yuvFrame.format = C.AV_PIX_FMT_YUV420P
+	if res := C.av_frame_get_buffer(t.yuvFrame, 32); res < 0 {
+		t.close()
+		return newAvError(res, "failed to allocate buffer for frame")
+	}
+	t.swsCtx = C.sws_getContext(
+		frame.width, frame.height, C.enum_AVPixelFormat(frame.format),
+		width, height, C.AV_PIX_FMT_YUV420P,
+		C.SWS_FAST_BILINEAR, nil, nil, nil,
+	)
+	if t.swsCtx == nil {
+		t.close()
+		return errors.New("failed to create converter")
+	}
+	return nil
+}
+
+func (t *h264Transcoder) close() {
+	if t.encCtx != nil {
+		C.avcodec_free_context(&t.encCtx)
+		t.encCtx = nil
+	}
+	if t.pkt != nil {
+		C.av_packet_free(&t.pkt)
+		t.pkt = nil
+	}
+	if t.swsCtx != nil {
+		C.sws_freeContext(t.swsCtx)
+		t.swsCtx = nil
+	}
+	if t.yuvFrame != nil {
+		C.av_frame_free(&t.yuvFrame)
+		t.yuvFrame = nil
+	}
+	if t.refFrame != nil {
+		C.av_frame_free(&t.refFrame)
+		t.refFrame = nil
+	}
+	t.sps, t.pps = nil, nil
+}
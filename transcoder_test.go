@@ -0,0 +1,92 @@
+package viamrtsp
+
+import (
+	"testing"
+
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestH264Transcoder(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+
+	t.Run("yuv420p frames produce h264 access units", func(t *testing.T) {
+		frame := createTestYUV420PFrame(640, 480)
+		test.That(t, frame, test.ShouldNotBeNil)
+		defer freeFrame(frame)
+		fillDummyYUV420PData(frame)
+
+		tc := newH264Transcoder(logger)
+		defer tc.close()
+		au, err := tc.encode(frame, 0, true)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, h264.IDRPresent(au), test.ShouldBeTrue)
+		params := tc.parameters()
+		test.That(t, params[0], test.ShouldNotBeEmpty)
+		test.That(t, params[1], test.ShouldNotBeEmpty)
+
+		var sps h264.SPS
+		test.That(t, sps.Unmarshal(params[0]), test.ShouldBeNil)
+		test.That(t, sps.Width(), test.ShouldEqual, 640)
+		test.That(t, sps.Height(), test.ShouldEqual, 480)
+
+		au, err = tc.encode(frame, 3000, false)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, au, test.ShouldNotBeEmpty)
+		test.That(t, h264.IDRPresent(au), test.ShouldBeFalse)
+
+		au, err = tc.encode(frame, 6000, true)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, h264.IDRPresent(au), test.ShouldBeTrue)
+	})
+
+	t.Run("yuvj420p frames with an odd height are converted", func(t *testing.T) {
+		frame := createTestYUVJ420PFrame(320, 241)
+		test.That(t, frame, test.ShouldNotBeNil)
+		defer freeFrame(frame)
+		fillDummyYUV420PData(frame)
+
+		tc := newH264Transcoder(logger)
+		defer tc.close()
+		au, err := tc.encode(frame, 0, true)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, h264.IDRPresent(au), test.ShouldBeTrue)
+
+		var sps h264.SPS
+		test.That(t, sps.Unmarshal(tc.parameters()[0]), test.ShouldBeNil)
+		test.That(t, sps.Width(), test.ShouldEqual, 320)
+		test.That(t, sps.Height(), test.ShouldEqual, 240)
+	})
+
+	t.Run("resolution change recreates the encoder", func(t *testing.T) {
+		small := createTestYUV420PFrame(320, 240)
+		test.That(t, small, test.ShouldNotBeNil)
+		defer freeFrame(small)
+		fillDummyYUV420PData(small)
+		large := createTestYUV420PFrame(640, 480)
+		test.That(t, large, test.ShouldNotBeNil)
+		defer freeFrame(large)
+		fillDummyYUV420PData(large)
+
+		tc := newH264Transcoder(logger)
+		defer tc.close()
+		_, err := tc.encode(small, 0, true)
+		test.That(t, err, test.ShouldBeNil)
+		au, err := tc.encode(large, 3000, false)
+		test.That(t, err, test.ShouldBeNil)
+		// a new encoder always starts with a keyframe
+		test.That(t, h264.IDRPresent(au), test.ShouldBeTrue)
+
+		var sps h264.SPS
+		test.That(t, sps.Unmarshal(tc.parameters()[0]), test.ShouldBeNil)
+		test.That(t, sps.Width(), test.ShouldEqual, 640)
+	})
+
+	t.Run("nil frame fails", func(t *testing.T) {
+		tc := newH264Transcoder(logger)
+		defer tc.close()
+		_, err := tc.encode(nil, 0, true)
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+}
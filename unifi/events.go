@@ -0,0 +1,524 @@
+package unifi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/viam-modules/viamrtsp"
+	"go.viam.com/rdk/components/sensor"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/resource"
+	"go.viam.com/utils"
+	"nhooyr.io/websocket"
+)
+
+const (
+	// defaultHoldSec is how long an event keeps being reported as active after it ends.
+	defaultHoldSec = 10
+	// maxEvents is how many of the most recent events are kept for get-events.
+	maxEvents = 500
+	// minReconnectDelay and maxReconnectDelay bound the backoff between attempts to resubscribe.
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+	// maxEventMessageBytes is the largest event message read from the subscription.
+	maxEventMessageBytes = 1 << 20
+	// defaultPingInterval is how often the subscription is pinged, and defaultPingTimeout how long a
+	// pong may take before the subscription is considered dead.
+	defaultPingInterval = 30 * time.Second
+	defaultPingTimeout  = 10 * time.Second
+)
+
+// Protect event types and subscription message types.
+const (
+	eventMotion      = "motion"
+	eventRing        = "ring"
+	eventMessageAdd  = "add"
+	eventMessageUpd  = "update"
+	eventModelKey    = "event"
+	readingConnected = "connected"
+)
+
+// smartDetectTypes are the smart detections reported for every camera, whether or not they have
+// happened. Other types are reported once seen.
+var smartDetectTypes = []string{"person", "vehicle", "package", "animal"}
+
+// EventsModel is the model for the UniFi Protect event sensor.
+var EventsModel = viamrtsp.Family.WithModel("unifi-events")
+
+func init() {
+	resource.RegisterComponent(
+		sensor.API,
+		EventsModel,
+		resource.Registration[sensor.Sensor, *EventsConfig]{
+			Constructor: newEventSensor,
+		})
+}
+
+// EventsConfig is the configuration for the UniFi Protect event sensor.
+type EventsConfig struct {
+	NVRAddress string `json:"nvr_address"`
+	UnifiToken string `json:"unifi_token"`
+	// Cameras limits the reported cameras to these camera names or IDs. Every camera is reported when
+	// empty.
+	Cameras []string `json:"cameras,omitempty"`
+	// HoldSec is how long an event keeps being reported as active after it ends. Defaults to
+	// defaultHoldSec.
+	HoldSec *float64 `json:"hold_sec,omitempty"`
+}
+
+// Validate validates the UniFi Protect event sensor configuration.
+func (cfg *EventsConfig) Validate(_ string) ([]string, []string, error) {
+	if cfg.NVRAddress == "" {
+		return nil, nil, errors.New("nvr_address is required")
+	}
+	if cfg.UnifiToken == "" {
+		return nil, nil, errors.New("unifi_token is required")
+	}
+	if cfg.HoldSec != nil && *cfg.HoldSec < 0 {
+		return nil, nil, fmt.Errorf("hold_sec %g must not be negative", *cfg.HoldSec)
+	}
+	return nil, nil, nil
+}
+
+func (cfg *EventsConfig) hold() time.Duration {
+	if cfg.HoldSec == nil {
+		return defaultHoldSec * time.Second
+	}
+	return time.Duration(*cfg.HoldSec * float64(time.Second))
+}
+
+// eventMessage is a message of the Protect event subscription. Update messages only carry the
+// fields of the event that changed.
+type eventMessage struct {
+	Type string       `json:"type"`
+	Item protectEvent `json:"item"`
+}
+
+// protectEvent is an event as sent by the Protect integration API. Times are in Unix milliseconds.
+type protectEvent struct {
+	ID               string   `json:"id"`
+	ModelKey         string   `json:"modelKey"`
+	Type             string   `json:"type"`
+	Start            int64    `json:"start"`
+	End              int64    `json:"end"`
+	Device           string   `json:"device"`
+	SmartDetectTypes []string `json:"smartDetectTypes"`
+}
+
+// cameraEvent is an event of a camera.
+type cameraEvent struct {
+	id         string
+	cameraID   string
+	eventType  string
+	smartTypes []string
+	start      time.Time
+	// end is zero while the event is ongoing.
+	end time.Time
+}
+
+// active reports whether the event is ongoing or ended less than hold before now.
+func (e *cameraEvent) active(now time.Time, hold time.Duration) bool {
+	if e.end.IsZero() {
+		return true
+	}
+	return now.Sub(e.end) < hold
+}
+
+// kinds returns what the event detected: its smart detection types for smart detections, otherwise
+// its type.
+func (e *cameraEvent) kinds() []string {
+	if len(e.smartTypes) > 0 {
+		return e.smartTypes
+	}
+	return []string{e.eventType}
+}
+
+type eventSensor struct {
+	resource.Named
+	resource.AlwaysRebuild
+
+	logger     logging.Logger
+	unifiToken string
+	nvrAddr    string
+	httpClient *http.Client
+	cameras    []string
+	hold       time.Duration
+	workers    *utils.StoppableWorkers
+
+	// reconnectDelay is the first delay before resubscribing, doubled after each failed attempt.
+	reconnectDelay time.Duration
+	pingInterval   time.Duration
+	pingTimeout    time.Duration
+
+	mu          sync.Mutex
+	connected   bool
+	lastError   string
+	reconnects  int
+	cameraNames map[string]string
+	// events are the most recent events, oldest first.
+	events []*cameraEvent
+	byID   map[string]*cameraEvent
+}
+
+func newEventSensor(_ context.Context, _ resource.Dependencies,
+	conf resource.Config,
+	logger logging.Logger,
+) (sensor.Sensor, error) {
+	cfg, err := resource.NativeConfig[*EventsConfig](conf)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &eventSensor{
+		Named:          conf.ResourceName().AsNamed(),
+		logger:         logger,
+		unifiToken:     cfg.UnifiToken,
+		nvrAddr:        cfg.NVRAddress,
+		httpClient:     newHTTPClient(),
+		cameras:        cfg.Cameras,
+		hold:           cfg.hold(),
+		reconnectDelay: minReconnectDelay,
+		pingInterval:   defaultPingInterval,
+		pingTimeout:    defaultPingTimeout,
+		cameraNames:    map[string]string{},
+		byID:           map[string]*cameraEvent{},
+	}
+	s.workers = utils.NewBackgroundStoppableWorkers(s.run)
+	return s, nil
+}
+
+// Close stops the event subscription.
+func (s *eventSensor) Close(_ context.Context) error {
+	s.workers.Stop()
+	return nil
+}
+
+// run keeps the event subscription open until ctx is done, resubscribing with a backoff whenever
+// it drops.
+func (s *eventSensor) run(ctx context.Context) {
+	delay := s.reconnectDelay
+	for {
+		subscribed, err := s.subscribe(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		s.disconnected(err)
+		if subscribed {
+			delay = s.reconnectDelay
+		}
+		s.logger.Warnf("UniFi Protect event subscription to %s lost, retrying in %s: %v", s.nvrAddr, delay, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// subscribe refreshes the camera names, then reads events from the subscription until it fails.
+// It reports whether the subscription was opened.
+func (s *eventSensor) subscribe(ctx context.Context) (bool, error) {
+	var cameras []unifiCamera
+	if err := protectRequest(ctx, s.httpClient, s.nvrAddr, s.unifiToken, http.MethodGet, "/cameras", nil, &cameras); err != nil {
+		return false, fmt.Errorf("failed to get cameras: %w", err)
+	}
+
+	url := fmt.Sprintf("wss://%s/proxy/protect/integration/v1/subscribe/events", s.nvrAddr)
+	conn, resp, err := websocket.Dial(ctx, url, &websocket.DialOptions{
+		HTTPClient: s.httpClient,
+		HTTPHeader: http.Header{"X-Api-Key": []string{s.unifiToken}},
+	})
+	if resp != nil && resp.Body != nil {
+		utils.UncheckedError(resp.Body.Close())
+	}
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+			return false, errors.New("authentication failed: invalid or expired API token")
+		}
+		return false, err
+	}
+	defer func() {
+		utils.UncheckedError(conn.Close(websocket.StatusNormalClosure, ""))
+	}()
+	conn.SetReadLimit(maxEventMessageBytes)
+
+	s.mu.Lock()
+	for _, cam := range cameras {
+		s.cameraNames[cam.ID] = cam.Name
+	}
+	s.connected = true
+	s.lastError = ""
+	s.mu.Unlock()
+	s.logger.Infof("Subscribed to UniFi Protect events of %d cameras on NVR %s", len(cameras), s.nvrAddr)
+
+	// a half-open connection never fails Read, so only missing pongs show that the NVR is gone
+	pingErrs := make(chan error, 1)
+	pings := utils.NewBackgroundStoppableWorkers(func(ctx context.Context) {
+		pingErrs <- s.keepAlive(ctx, conn)
+	})
+	defer pings.Stop()
+
+	for {
+		_, b, err := conn.Read(ctx)
+		if err != nil {
+			pings.Stop()
+			if pingErr := <-pingErrs; pingErr != nil {
+				return true, pingErr
+			}
+			return true, err
+		}
+		var msg eventMessage
+		if err := json.Unmarshal(b, &msg); err != nil {
+			s.logger.Debugf("ignoring undecodable UniFi Protect event message: %v", err)
+			continue
+		}
+		s.handle(msg)
+	}
+}
+
+// keepAlive pings the subscription every s.pingInterval until ctx is done. If a pong doesn't arrive
+// within s.pingTimeout the subscription is closed, which ends the Read waiting on it.
+func (s *eventSensor) keepAlive(ctx context.Context, conn *websocket.Conn) error {
+	ticker := time.NewTicker(s.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, s.pingTimeout)
+		err := conn.Ping(pingCtx)
+		cancel()
+		// other errors mean the subscription was closed or stopped, which Read reports
+		if errors.Is(err, context.DeadlineExceeded) {
+			utils.UncheckedError(conn.Close(websocket.StatusGoingAway, "ping timed out"))
+			return fmt.Errorf("no pong from NVR within %s: %w", s.pingTimeout, err)
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
+
+// disconnected records that the subscription dropped. Ongoing events are ended, since their end
+// would be missed.
+func (s *eventSensor) disconnected(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.connected {
+		s.reconnects++
+	}
+	s.connected = false
+	if err != nil {
+		s.lastError = err.Error()
+	}
+	now := time.Now()
+	for _, e := range s.events {
+		if e.end.IsZero() {
+			e.end = now
+		}
+	}
+}
+
+// handle applies an event message.
+func (s *eventSensor) handle(msg eventMessage) {
+	item := msg.Item
+	if item.ModelKey != "" && item.ModelKey != eventModelKey {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.byID[item.ID]
+	switch {
+	case msg.Type == eventMessageAdd && !ok:
+		if item.Device == "" || !s.reportsCamera(item.Device) {
+			return
+		}
+		e = &cameraEvent{id: item.ID, cameraID: item.Device, eventType: item.Type}
+		s.byID[e.id] = e
+		s.events = append(s.events, e)
+		if len(s.events) > maxEvents {
+			delete(s.byID, s.events[0].id)
+			s.events = s.events[1:]
+		}
+	case msg.Type == eventMessageUpd && ok:
+	default:
+		return
+	}
+
+	if item.Start != 0 {
+		e.start = time.UnixMilli(item.Start)
+	}
+	if item.End != 0 {
+		e.end = time.UnixMilli(item.End)
+	}
+	if item.SmartDetectTypes != nil {
+		e.smartTypes = item.SmartDetectTypes
+	}
+	// rings have no duration
+	if e.eventType == eventRing && e.end.IsZero() {
+		e.end = e.start
+	}
+}
+
+// reportsCamera reports whether the events of the camera with id are reported. s.mu must be held.
+func (s *eventSensor) reportsCamera(id string) bool {
+	if len(s.cameras) == 0 {
+		return true
+	}
+	return slices.Contains(s.cameras, id) || slices.Contains(s.cameras, s.cameraNames[id])
+}
+
+// Readings returns whether the subscription is connected and, for every camera, whether each kind
+// of event is active and when it last started.
+func (s *eventSensor) Readings(_ context.Context, _ map[string]interface{}) (map[string]interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+
+	byCamera := map[string]map[string]interface{}{}
+	reading := func(cameraID string) map[string]interface{} {
+		r, ok := byCamera[cameraID]
+		if !ok {
+			r = map[string]interface{}{
+				"camera_id":   cameraID,
+				"camera_name": s.cameraNames[cameraID],
+				eventMotion:   false,
+				eventRing:     false,
+			}
+			for _, kind := range smartDetectTypes {
+				r[kind] = false
+			}
+			byCamera[cameraID] = r
+		}
+		return r
+	}
+	for id := range s.cameraNames {
+		if s.reportsCamera(id) {
+			reading(id)
+		}
+	}
+	for _, e := range s.events {
+		r := reading(e.cameraID)
+		for _, kind := range e.kinds() {
+			if e.active(now, s.hold) {
+				r[kind] = true
+			} else if _, ok := r[kind]; !ok {
+				r[kind] = false
+			}
+			if !e.start.IsZero() {
+				r["last_"+kind] = e.start.UTC().Format(time.RFC3339)
+			}
+		}
+		r["last_event"] = e.eventType
+	}
+
+	readings := map[string]interface{}{readingConnected: s.connected}
+	for id, r := range byCamera {
+		readings[sanitizeName(s.cameraName(id), id)] = r
+	}
+	return readings, nil
+}
+
+// cameraName returns the name of the camera with id, or its id if the camera is unknown. s.mu must
+// be held.
+func (s *eventSensor) cameraName(id string) string {
+	if name := s.cameraNames[id]; name != "" {
+		return name
+	}
+	return id
+}
+
+// DoCommand handles get-events, which returns the most recent events, and get-status.
+func (s *eventSensor) DoCommand(_ context.Context, command map[string]interface{}) (map[string]interface{}, error) {
+	cmd, ok := command["command"].(string)
+	if !ok {
+		return nil, errors.New("invalid command type")
+	}
+
+	switch cmd {
+	case "get-events":
+		return s.getEvents(command)
+	case "get-status":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return map[string]interface{}{
+			readingConnected: s.connected,
+			"reconnects":     s.reconnects,
+			"last_error":     s.lastError,
+			"event_count":    len(s.events),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown command: %s", cmd)
+	}
+}
+
+// getEvents returns the most recent events, newest first, optionally filtered by camera, kind of
+// event and start time.
+func (s *eventSensor) getEvents(command map[string]interface{}) (map[string]interface{}, error) {
+	camera, _ := command["camera"].(string)
+	kind, _ := command["type"].(string)
+	var since time.Time
+	if raw, ok := command["since"].(string); ok && raw != "" {
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return nil, fmt.Errorf("since must be an RFC3339 time: %w", err)
+		}
+		since = t
+	}
+	limit := maxEvents
+	if raw, ok := command["limit"].(float64); ok {
+		if raw < 1 {
+			return nil, errors.New("limit must be at least 1")
+		}
+		limit = int(raw)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+
+	events := []interface{}{}
+	for i := len(s.events) - 1; i >= 0 && len(events) < limit; i-- {
+		e := s.events[i]
+		name := s.cameraName(e.cameraID)
+		if camera != "" && camera != e.cameraID && camera != name && camera != sanitizeName(name, e.cameraID) {
+			continue
+		}
+		if kind != "" && kind != e.eventType && !slices.Contains(e.smartTypes, kind) {
+			continue
+		}
+		if e.start.Before(since) {
+			continue
+		}
+		event := map[string]interface{}{
+			"id":          e.id,
+			"camera_id":   e.cameraID,
+			"camera_name": s.cameraNames[e.cameraID],
+			"type":        e.eventType,
+			"active":      e.active(now, s.hold),
+			"start":       e.start.UTC().Format(time.RFC3339Nano),
+		}
+		if len(e.smartTypes) > 0 {
+			smartTypes := make([]interface{}, 0, len(e.smartTypes))
+			for _, t := range e.smartTypes {
+				smartTypes = append(smartTypes, t)
+			}
+			event["smart_detect_types"] = smartTypes
+		}
+		if !e.end.IsZero() {
+			event["end"] = e.end.UTC().Format(time.RFC3339Nano)
+		}
+		events = append(events, event)
+	}
+	return map[string]interface{}{"events": events}, nil
+}
@@ -0,0 +1,314 @@
+package unifi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/components/sensor"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/resource"
+	"go.viam.com/test"
+	"go.viam.com/utils"
+	"go.viam.com/utils/testutils"
+	"nhooyr.io/websocket"
+)
+
+// fakeEvents is a Protect integration API that lists cameras and pushes the messages sent on msgs
+// to event subscribers. Sending on drop closes the current subscription. Subscriptions opened while
+// unresponsive is set never answer pings, like an NVR that went away without closing the connection.
+type fakeEvents struct {
+	t            *testing.T
+	cameras      []unifiCamera
+	msgs         chan eventMessage
+	drop         chan struct{}
+	unresponsive atomic.Bool
+	closed       chan struct{}
+}
+
+func (p *fakeEvents) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Api-Key") != "test-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch r.URL.Path {
+	case "/proxy/protect/integration/v1/cameras":
+		w.Header().Set("Content-Type", "application/json")
+		test.That(p.t, json.NewEncoder(w).Encode(p.cameras), test.ShouldBeNil)
+	case "/proxy/protect/integration/v1/subscribe/events":
+		conn, err := websocket.Accept(w, r, nil)
+		test.That(p.t, err, test.ShouldBeNil)
+		if p.unresponsive.Load() {
+			// pongs are only sent while reading, so never read
+			<-p.closed
+			return
+		}
+		defer func() {
+			utils.UncheckedError(conn.Close(websocket.StatusGoingAway, ""))
+		}()
+		ctx := conn.CloseRead(r.Context())
+		for {
+			select {
+			case msg := <-p.msgs:
+				b, err := json.Marshal(msg)
+				test.That(p.t, err, test.ShouldBeNil)
+				if err := conn.Write(ctx, websocket.MessageText, b); err != nil {
+					return
+				}
+			case <-p.drop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestEventSensor(t *testing.T, cameras []string) (*fakeEvents, *eventSensor) {
+	t.Helper()
+	protect := &fakeEvents{
+		t: t,
+		cameras: []unifiCamera{
+			{ID: "cam1", Name: "Front Door", State: "CONNECTED"},
+			{ID: "cam2", Name: "Backyard", State: "CONNECTED"},
+		},
+		msgs:   make(chan eventMessage),
+		drop:   make(chan struct{}),
+		closed: make(chan struct{}),
+	}
+	server := httptest.NewTLSServer(protect)
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(protect.closed) })
+
+	s := &eventSensor{
+		Named:          resource.NewName(sensor.API, "test").AsNamed(),
+		logger:         logging.NewTestLogger(t),
+		unifiToken:     "test-token",
+		nvrAddr:        server.URL[8:],
+		httpClient:     server.Client(),
+		cameras:        cameras,
+		hold:           time.Hour,
+		reconnectDelay: 10 * time.Millisecond,
+		pingInterval:   10 * time.Millisecond,
+		pingTimeout:    50 * time.Millisecond,
+		cameraNames:    map[string]string{},
+		byID:           map[string]*cameraEvent{},
+	}
+	s.workers = utils.NewBackgroundStoppableWorkers(s.run)
+	t.Cleanup(func() { test.That(t, s.Close(context.Background()), test.ShouldBeNil) })
+	return protect, s
+}
+
+// cameraReading returns the readings of the camera named name, or nil if there are none.
+func cameraReading(readings map[string]interface{}, name string) map[string]interface{} {
+	r, _ := readings[name].(map[string]interface{})
+	return r
+}
+
+// eventsOf returns the events of a get-events response.
+func eventsOf(resp map[string]interface{}) []map[string]interface{} {
+	raw, _ := resp["events"].([]interface{})
+	events := make([]map[string]interface{}, 0, len(raw))
+	for _, e := range raw {
+		events = append(events, e.(map[string]interface{}))
+	}
+	return events
+}
+
+func TestEventsConfig(t *testing.T) {
+	_, _, err := (&EventsConfig{}).Validate("")
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "nvr_address is required")
+
+	_, _, err = (&EventsConfig{NVRAddress: "10.0.0.1"}).Validate("")
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "unifi_token is required")
+
+	hold := -1.0
+	_, _, err = (&EventsConfig{NVRAddress: "10.0.0.1", UnifiToken: "test-token", HoldSec: &hold}).Validate("")
+	test.That(t, err, test.ShouldNotBeNil)
+
+	cfg := &EventsConfig{NVRAddress: "10.0.0.1", UnifiToken: "test-token"}
+	_, _, err = cfg.Validate("")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, cfg.hold(), test.ShouldEqual, defaultHoldSec*time.Second)
+}
+
+func TestEventSensor(t *testing.T) {
+	ctx := context.Background()
+	start := time.Now().UTC().Add(-time.Minute).Truncate(time.Second)
+
+	t.Run("Test events as readings", func(t *testing.T) {
+		protect, s := newTestEventSensor(t, nil)
+
+		protect.msgs <- eventMessage{Type: eventMessageAdd, Item: protectEvent{
+			ID: "e1", ModelKey: eventModelKey, Type: "smartDetectZone", Device: "cam1",
+			Start: start.UnixMilli(), SmartDetectTypes: []string{"person"},
+		}}
+		protect.msgs <- eventMessage{Type: eventMessageAdd, Item: protectEvent{
+			ID: "e2", ModelKey: eventModelKey, Type: eventRing, Device: "cam2", Start: start.UnixMilli(),
+		}}
+		// events of other models are ignored
+		protect.msgs <- eventMessage{Type: eventMessageAdd, Item: protectEvent{
+			ID: "e3", ModelKey: "camera", Type: eventMotion, Device: "cam2", Start: start.UnixMilli(),
+		}}
+
+		var readings map[string]interface{}
+		testutils.WaitForAssertion(t, func(tb testing.TB) {
+			var err error
+			readings, err = s.Readings(ctx, nil)
+			test.That(tb, err, test.ShouldBeNil)
+			test.That(tb, readings[readingConnected], test.ShouldBeTrue)
+			test.That(tb, cameraReading(readings, "backyard_cam2")[eventRing], test.ShouldBeTrue)
+		})
+		frontDoor := cameraReading(readings, "front_door_cam1")
+		test.That(t, frontDoor["camera_id"], test.ShouldEqual, "cam1")
+		test.That(t, frontDoor["camera_name"], test.ShouldEqual, "Front Door")
+		test.That(t, frontDoor["person"], test.ShouldBeTrue)
+		test.That(t, frontDoor["vehicle"], test.ShouldBeFalse)
+		test.That(t, frontDoor[eventMotion], test.ShouldBeFalse)
+		test.That(t, frontDoor["last_person"], test.ShouldEqual, start.Format(time.RFC3339))
+		backyard := cameraReading(readings, "backyard_cam2")
+		test.That(t, backyard[eventMotion], test.ShouldBeFalse)
+		test.That(t, backyard["last_ring"], test.ShouldEqual, start.Format(time.RFC3339))
+
+		// the update ends the event, which is no longer active once the hold has passed
+		protect.msgs <- eventMessage{Type: eventMessageUpd, Item: protectEvent{ID: "e1", End: start.Add(time.Second).UnixMilli()}}
+		testutils.WaitForAssertion(t, func(tb testing.TB) {
+			resp, err := s.DoCommand(ctx, map[string]interface{}{"command": "get-events", "type": "person"})
+			test.That(tb, err, test.ShouldBeNil)
+			events := eventsOf(resp)
+			test.That(tb, len(events), test.ShouldEqual, 1)
+			if len(events) == 1 {
+				test.That(tb, events[0]["end"], test.ShouldNotBeNil)
+			}
+		})
+		s.mu.Lock()
+		s.hold = 0
+		s.mu.Unlock()
+		readings, err := s.Readings(ctx, nil)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, cameraReading(readings, "front_door_cam1")["person"], test.ShouldBeFalse)
+	})
+
+	t.Run("Test get-events", func(t *testing.T) {
+		protect, s := newTestEventSensor(t, nil)
+		for i, cam := range []string{"cam1", "cam2", "cam1"} {
+			protect.msgs <- eventMessage{Type: eventMessageAdd, Item: protectEvent{
+				ID: cam + string(rune('a'+i)), Type: eventMotion, Device: cam,
+				Start: start.Add(time.Duration(i) * time.Minute).UnixMilli(),
+			}}
+		}
+		testutils.WaitForAssertion(t, func(tb testing.TB) {
+			resp, err := s.DoCommand(ctx, map[string]interface{}{"command": "get-status"})
+			test.That(tb, err, test.ShouldBeNil)
+			test.That(tb, resp["event_count"], test.ShouldEqual, 3)
+		})
+
+		resp, err := s.DoCommand(ctx, map[string]interface{}{"command": "get-events", "camera": "Front Door"})
+		test.That(t, err, test.ShouldBeNil)
+		events := eventsOf(resp)
+		test.That(t, len(events), test.ShouldEqual, 2)
+		// newest first
+		test.That(t, events[0]["id"], test.ShouldEqual, "cam1c")
+
+		resp, err = s.DoCommand(ctx, map[string]interface{}{
+			"command": "get-events", "since": start.Add(30 * time.Second).Format(time.RFC3339), "limit": 1.0,
+		})
+		test.That(t, err, test.ShouldBeNil)
+		events = eventsOf(resp)
+		test.That(t, len(events), test.ShouldEqual, 1)
+		test.That(t, events[0]["id"], test.ShouldEqual, "cam1c")
+
+		_, err = s.DoCommand(ctx, map[string]interface{}{"command": "get-events", "since": "yesterday"})
+		test.That(t, err, test.ShouldNotBeNil)
+		_, err = s.DoCommand(ctx, map[string]interface{}{"command": "get-events", "limit": 0.0})
+		test.That(t, err, test.ShouldNotBeNil)
+		_, err = s.DoCommand(ctx, map[string]interface{}{"command": "unknown"})
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+
+	t.Run("Test camera filter", func(t *testing.T) {
+		protect, s := newTestEventSensor(t, []string{"Backyard"})
+		protect.msgs <- eventMessage{Type: eventMessageAdd, Item: protectEvent{
+			ID: "e1", Type: eventMotion, Device: "cam1", Start: start.UnixMilli(),
+		}}
+		protect.msgs <- eventMessage{Type: eventMessageAdd, Item: protectEvent{
+			ID: "e2", Type: eventMotion, Device: "cam2", Start: start.UnixMilli(),
+		}}
+		testutils.WaitForAssertion(t, func(tb testing.TB) {
+			readings, err := s.Readings(ctx, nil)
+			test.That(tb, err, test.ShouldBeNil)
+			test.That(tb, cameraReading(readings, "backyard_cam2")[eventMotion], test.ShouldBeTrue)
+			test.That(tb, readings["front_door_cam1"], test.ShouldBeNil)
+		})
+	})
+
+	t.Run("Test reconnect", func(t *testing.T) {
+		protect, s := newTestEventSensor(t, nil)
+		protect.msgs <- eventMessage{Type: eventMessageAdd, Item: protectEvent{
+			ID: "e1", Type: eventMotion, Device: "cam1", Start: start.UnixMilli(),
+		}}
+		protect.drop <- struct{}{}
+
+		// the ongoing event is ended since its end can't be seen anymore
+		testutils.WaitForAssertion(t, func(tb testing.TB) {
+			resp, err := s.DoCommand(ctx, map[string]interface{}{"command": "get-status"})
+			test.That(tb, err, test.ShouldBeNil)
+			test.That(tb, resp["reconnects"], test.ShouldEqual, 1)
+		})
+		resp, err := s.DoCommand(ctx, map[string]interface{}{"command": "get-events"})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, eventsOf(resp)[0]["end"], test.ShouldNotBeNil)
+
+		// events keep arriving on the new subscription
+		protect.msgs <- eventMessage{Type: eventMessageAdd, Item: protectEvent{
+			ID: "e2", Type: eventMotion, Device: "cam2", Start: start.UnixMilli(),
+		}}
+		testutils.WaitForAssertion(t, func(tb testing.TB) {
+			readings, err := s.Readings(ctx, nil)
+			test.That(tb, err, test.ShouldBeNil)
+			test.That(tb, readings[readingConnected], test.ShouldBeTrue)
+			test.That(tb, cameraReading(readings, "backyard_cam2")[eventMotion], test.ShouldBeTrue)
+		})
+	})
+
+	t.Run("Test unresponsive NVR", func(t *testing.T) {
+		protect, s := newTestEventSensor(t, nil)
+		testutils.WaitForAssertion(t, func(tb testing.TB) {
+			readings, err := s.Readings(ctx, nil)
+			test.That(tb, err, test.ShouldBeNil)
+			test.That(tb, readings[readingConnected], test.ShouldBeTrue)
+		})
+		// a responsive NVR answers the pings and keeps the subscription
+		time.Sleep(10 * s.pingInterval)
+		resp, err := s.DoCommand(ctx, map[string]interface{}{"command": "get-status"})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["reconnects"], test.ShouldEqual, 0)
+
+		// later subscriptions stop answering, so they are closed once a ping times out
+		protect.unresponsive.Store(true)
+		protect.drop <- struct{}{}
+		testutils.WaitForAssertion(t, func(tb testing.TB) {
+			resp, err := s.DoCommand(ctx, map[string]interface{}{"command": "get-status"})
+			test.That(tb, err, test.ShouldBeNil)
+			test.That(tb, resp["reconnects"], test.ShouldBeGreaterThanOrEqualTo, 2)
+			test.That(tb, resp["last_error"], test.ShouldContainSubstring, "no pong from NVR")
+		})
+	})
+
+	t.Run("Test invalid token", func(t *testing.T) {
+		_, s := newTestEventSensor(t, nil)
+		s.workers.Stop()
+		s.unifiToken = "wrong-token"
+		_, err := s.subscribe(ctx)
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "authentication failed")
+	})
+}
@@ -2,16 +2,21 @@
 package unifi
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/viam-modules/viamrtsp"
+	"github.com/viam-modules/viamrtsp/rtsppreview"
 	"go.viam.com/rdk/components/camera"
 	"go.viam.com/rdk/logging"
 	"go.viam.com/rdk/resource"
@@ -21,15 +26,48 @@ import (
 const (
 	httpClientTimeout = 30 * time.Second
 	idSuffixLength    = 6
+	// stateConnected is the state of cameras that are online.
+	stateConnected = "CONNECTED"
+	// maxSnapshotBytes is the largest snapshot read for a preview.
+	maxSnapshotBytes = 10 << 20
 )
 
 // Model is the model for the Unifi discovery service.
 var Model = viamrtsp.Family.WithModel("unifi")
 
+// Stream qualities a camera config can be emitted for.
+const (
+	// qualityHighest is the highest quality stream the camera serves.
+	qualityHighest = "highest"
+	// qualityAll emits a config for every quality stream the camera serves.
+	qualityAll    = "all"
+	qualityHigh   = "high"
+	qualityMedium = "medium"
+	qualityLow    = "low"
+)
+
+// streamQualities are the qualities of the streams Protect serves, highest first.
+var streamQualities = []string{qualityHigh, qualityMedium, qualityLow}
+
 // Config is the configuration for the Unifi discovery service.
 type Config struct {
 	NVRAddress string `json:"nvr_address"`
 	UnifiToken string `json:"unifi_token"`
+	// Quality is the stream quality configs are emitted for, one of streamQualities, qualityAll or
+	// qualityHighest. Defaults to qualityHighest.
+	Quality string `json:"quality,omitempty"`
+	// CameraQualities overrides Quality for cameras, keyed by camera name or ID.
+	CameraQualities map[string]string `json:"camera_qualities,omitempty"`
+	// KeepRTSPS keeps the encrypted rtsps stream addresses rather than converting them to plain rtsp.
+	KeepRTSPS bool `json:"keep_rtsps,omitempty"`
+	// InsecureSkipVerify has the rtsps cameras kept with KeepRTSPS skip verifying the NVR's
+	// certificate, which is self-signed unless one has been installed.
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+	// CreateStreams has Protect create the RTSPS streams of the selected quality that a camera doesn't
+	// share yet.
+	CreateStreams bool `json:"create_streams,omitempty"`
+	// IncludeOffline emits configs for cameras that aren't connected to the NVR too.
+	IncludeOffline bool `json:"include_offline,omitempty"`
 }
 
 // unifiCamera represents a camera from the UniFi Protect API.
@@ -37,6 +75,44 @@ type unifiCamera struct {
 	ID    string `json:"id"`
 	Name  string `json:"name"`
 	State string `json:"state"`
+	// The fields below are only sent by some Protect versions.
+	Type            string          `json:"type,omitempty"`
+	MarketName      string          `json:"marketName,omitempty"`
+	FirmwareVersion string          `json:"firmwareVersion,omitempty"`
+	Channels        []cameraChannel `json:"channels,omitempty"`
+}
+
+// cameraChannel is one of the video channels a camera encodes, one per stream quality.
+type cameraChannel struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	FPS     int    `json:"fps"`
+}
+
+// online reports whether the camera is connected to the NVR. Cameras whose state is unknown are
+// assumed to be.
+func (cam unifiCamera) online() bool {
+	return cam.State == "" || cam.State == stateConnected
+}
+
+// model returns the camera's model name, empty if Protect doesn't send it.
+func (cam unifiCamera) model() string {
+	if cam.MarketName != "" {
+		return cam.MarketName
+	}
+	return cam.Type
+}
+
+// channel returns the channel of the stream of quality, nil if Protect doesn't send it.
+func (cam unifiCamera) channel(quality string) *cameraChannel {
+	for i := range cam.Channels {
+		if strings.EqualFold(cam.Channels[i].Name, quality) {
+			return &cam.Channels[i]
+		}
+	}
+	return nil
 }
 
 // rtspStreamResponse represents the RTSPS stream response from the API.
@@ -47,6 +123,31 @@ type rtspStreamResponse struct {
 	Package string `json:"package"`
 }
 
+// url returns the address of the stream of quality, empty if the camera doesn't share it.
+func (r rtspStreamResponse) url(quality string) string {
+	switch quality {
+	case qualityHigh:
+		return r.High
+	case qualityMedium:
+		return r.Medium
+	case qualityLow:
+		return r.Low
+	default:
+		return ""
+	}
+}
+
+// createStreamsRequest is the body of a request to create RTSPS streams.
+type createStreamsRequest struct {
+	Qualities []string `json:"qualities"`
+}
+
+// rtspStream is a camera's stream of one quality.
+type rtspStream struct {
+	quality string
+	url     string
+}
+
 type unifiDiscovery struct {
 	resource.Named
 	resource.AlwaysRebuild
@@ -55,6 +156,18 @@ type unifiDiscovery struct {
 	unifToken  string
 	nvrAddr    string
 	httpClient *http.Client
+
+	quality            string
+	cameraQualities    map[string]string
+	keepRTSPS          bool
+	insecureSkipVerify bool
+	createStreams      bool
+	includeOffline     bool
+
+	// rtspToCameraID maps the addresses of the last discovered configs to their camera's ID, so
+	// previews can use the camera's snapshot.
+	rtspToCameraIDMu sync.Mutex
+	rtspToCameraID   map[string]string
 }
 
 // Validate validates the Unifi discovery service configuration.
@@ -65,9 +178,25 @@ func (cfg *Config) Validate(_ string) ([]string, []string, error) {
 	if cfg.UnifiToken == "" {
 		return nil, nil, errors.New("unifi_token is required")
 	}
+	if err := validateQuality(cfg.Quality); err != nil {
+		return nil, nil, err
+	}
+	for cam, quality := range cfg.CameraQualities {
+		if err := validateQuality(quality); err != nil {
+			return nil, nil, fmt.Errorf("camera_qualities %q: %w", cam, err)
+		}
+	}
 	return nil, nil, nil
 }
 
+// validateQuality checks that quality is a quality configs can be emitted for.
+func validateQuality(quality string) error {
+	if quality == "" || quality == qualityHighest || quality == qualityAll || slices.Contains(streamQualities, quality) {
+		return nil
+	}
+	return fmt.Errorf("invalid quality %q, must be one of highest, all, high, medium or low", quality)
+}
+
 func init() {
 	resource.RegisterService(
 		discovery.API,
@@ -100,6 +229,13 @@ func newUnifiDiscovery(_ context.Context, _ resource.Dependencies,
 		nvrAddr:    cfg.NVRAddress,
 		logger:     logger,
 		httpClient: newHTTPClient(),
+
+		quality:            cfg.Quality,
+		cameraQualities:    cfg.CameraQualities,
+		keepRTSPS:          cfg.KeepRTSPS,
+		insecureSkipVerify: cfg.InsecureSkipVerify,
+		createStreams:      cfg.CreateStreams,
+		includeOffline:     cfg.IncludeOffline,
 	}
 
 	return dis, nil
@@ -115,102 +251,348 @@ func (dis *unifiDiscovery) DiscoverResources(ctx context.Context, _ map[string]a
 	dis.logger.Infof("Found %d cameras on NVR %s", len(cameras), dis.nvrAddr)
 
 	var configs []resource.Config
+	rtspToCameraID := map[string]string{}
 
 	for _, cam := range cameras {
-		rtspURL, err := dis.getRTSPStream(ctx, cam.ID)
+		if !cam.online() && !dis.includeOffline {
+			dis.logger.Infof("Skipping camera %s (%s) in state %s", cam.Name, cam.ID, cam.State)
+			continue
+		}
+		quality := dis.cameraQuality(cam)
+		streams, err := dis.getRTSPStreams(ctx, cam.ID, quality)
 		if err != nil {
 			dis.logger.Warnf("Failed to get RTSP stream for camera %s (%s): %v", cam.Name, cam.ID, err)
 			continue
 		}
 
-		dis.logger.Infof("Camera %s: %s", cam.Name, rtspURL)
-
-		// Create a camera config for this discovered camera
-		cfg := resource.Config{
-			API:   camera.API,
-			Model: viamrtsp.ModelAgnostic,
-			Name:  sanitizeName(cam.Name, cam.ID),
-			Attributes: map[string]any{
-				"rtsp_address": rtspURL,
-			},
+		for _, stream := range streams {
+			dis.logger.Infof("Camera %s (%s): %s", cam.Name, stream.quality, stream.url)
+
+			name := sanitizeName(cam.Name, cam.ID)
+			// every quality gets its own camera, named so it stays stable as streams are shared or not
+			if quality == qualityAll {
+				name += "_" + stream.quality
+			}
+			// Use viamrtsp's Config struct so a breaking change surfaces at compile time.
+			attributes := viamrtsp.Config{
+				Address:            stream.url,
+				InsecureSkipVerify: dis.keepRTSPS && dis.insecureSkipVerify,
+			}
+			if ch := cam.channel(stream.quality); ch != nil {
+				attributes.FrameRate = ch.FPS
+				if ch.Width > 0 && ch.Height > 0 {
+					attributes.Resolution = &viamrtsp.Resolution{Width: ch.Width, Height: ch.Height}
+				}
+			}
+
+			cfg, err := createCameraConfig(name, attributes)
+			if err != nil {
+				return nil, err
+			}
+			configs = append(configs, cfg)
+			rtspToCameraID[stream.url] = cam.ID
 		}
-		configs = append(configs, cfg)
 	}
 
+	dis.rtspToCameraIDMu.Lock()
+	dis.rtspToCameraID = rtspToCameraID
+	dis.rtspToCameraIDMu.Unlock()
+
 	return configs, nil
 }
 
-func (dis *unifiDiscovery) getCameras(ctx context.Context) ([]unifiCamera, error) {
-	url := fmt.Sprintf("https://%s/proxy/protect/integration/v1/cameras", dis.nvrAddr)
+// DoCommand handles the "preview" command, returning a JPEG data URL for the given rtsp_address,
+// and the "get-cameras" command, returning the model, firmware and state of the NVR's cameras.
+func (dis *unifiDiscovery) DoCommand(ctx context.Context, command map[string]interface{}) (map[string]interface{}, error) {
+	cmd, ok := command["command"].(string)
+	if !ok {
+		return nil, errors.New("invalid command type")
+	}
+
+	switch cmd {
+	case "preview":
+		rtspURL, err := rtsppreview.ParsePreviewCommand(command)
+		if err != nil {
+			return nil, err
+		}
+		dataURL, err := dis.preview(ctx, rtspURL)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"preview": dataURL}, nil
+	case "get-cameras":
+		cameras, err := dis.getCameras(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get cameras: %w", err)
+		}
+		infos := make([]interface{}, 0, len(cameras))
+		for _, cam := range cameras {
+			infos = append(infos, map[string]interface{}{
+				"id":       cam.ID,
+				"name":     cam.Name,
+				"model":    cam.model(),
+				"firmware": cam.FirmwareVersion,
+				"state":    cam.State,
+				"online":   cam.online(),
+			})
+		}
+		return map[string]interface{}{"cameras": infos}, nil
+	default:
+		return nil, fmt.Errorf("unknown command: %s", cmd)
+	}
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+// preview fetches an image of the camera streaming at rtspURL, from the camera's Protect snapshot
+// if it was discovered, falling back to grabbing a frame over RTSP.
+func (dis *unifiDiscovery) preview(ctx context.Context, rtspURL string) (string, error) {
+	dis.rtspToCameraIDMu.Lock()
+	cameraID, ok := dis.rtspToCameraID[rtspURL]
+	dis.rtspToCameraIDMu.Unlock()
+
+	var snapshotErr error
+	if ok {
+		dataURL, err := dis.getSnapshot(ctx, cameraID)
+		if err == nil {
+			return dataURL, nil
+		}
+		dis.logger.Debugf("failed to get snapshot of camera %s: %v", cameraID, err)
+		snapshotErr = fmt.Errorf("snapshot error for camera %s: %w", cameraID, err)
+	} else {
+		snapshotErr = fmt.Errorf("no discovered camera streams at %s", rtspURL)
+	}
+
+	dataURL, err := rtsppreview.FetchImageFromRTSPURL(ctx, dis.logger, rtspURL)
 	if err != nil {
-		return nil, err
+		return "", fmt.Errorf("both snapshot and RTSP fetch failed: %w", errors.Join(snapshotErr, fmt.Errorf("RTSP error: %w", err)))
 	}
+	return dataURL, nil
+}
 
-	req.Header.Set("X-Api-Key", dis.unifToken)
-	req.Header.Set("Accept", "application/json")
+// getSnapshot returns the camera's current snapshot as a data URL.
+func (dis *unifiDiscovery) getSnapshot(ctx context.Context, cameraID string) (string, error) {
+	req, err := newProtectRequest(ctx, dis.nvrAddr, dis.unifToken, http.MethodGet, fmt.Sprintf("/cameras/%s/snapshot", cameraID), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "image/jpeg")
 
 	resp, err := dis.httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 	defer resp.Body.Close()
 
-	if err := checkResponse(resp); err != nil {
-		return nil, err
+	if resp.StatusCode == http.StatusUnauthorized {
+		return "", errors.New("authentication failed: invalid or expired API token")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("API returned status %d", resp.StatusCode)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "image/") {
+		return "", fmt.Errorf("unexpected content type %q (expected an image)", contentType)
 	}
 
-	var cameras []unifiCamera
-	if err := json.NewDecoder(resp.Body).Decode(&cameras); err != nil {
-		return nil, fmt.Errorf("failed to decode cameras response: %w", err)
+	img, err := io.ReadAll(io.LimitReader(resp.Body, maxSnapshotBytes))
+	if err != nil {
+		return "", fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	return rtsppreview.FormatDataURL(contentType, img), nil
+}
+
+// createCameraConfig builds a camera resource.Config with attributes.
+func createCameraConfig(name string, attributes viamrtsp.Config) (resource.Config, error) {
+	jsonBytes, err := json.Marshal(attributes)
+	if err != nil {
+		return resource.Config{}, err
+	}
+	var result map[string]interface{}
+	if err = json.Unmarshal(jsonBytes, &result); err != nil {
+		return resource.Config{}, err
 	}
 
-	return cameras, nil
+	return resource.Config{
+		Name: name, API: camera.API, Model: viamrtsp.ModelAgnostic,
+		Attributes: result, ConvertedAttributes: &attributes,
+	}, nil
+}
+
+// cameraQuality returns the stream quality cam's configs are emitted for, applying camera_qualities.
+func (dis *unifiDiscovery) cameraQuality(cam unifiCamera) string {
+	quality := dis.quality
+	if q, ok := dis.cameraQualities[cam.ID]; ok {
+		quality = q
+	} else if q, ok := dis.cameraQualities[cam.Name]; ok {
+		quality = q
+	}
+	if quality == "" {
+		return qualityHighest
+	}
+	return quality
 }
 
-func (dis *unifiDiscovery) getRTSPStream(ctx context.Context, cameraID string) (string, error) {
-	url := fmt.Sprintf("https://%s/proxy/protect/integration/v1/cameras/%s/rtsps-stream", dis.nvrAddr, cameraID)
+// apiRequest sends a request to the NVR's Protect integration API at path.
+func (dis *unifiDiscovery) apiRequest(ctx context.Context, method, path string, body, out any) error {
+	return protectRequest(ctx, dis.httpClient, dis.nvrAddr, dis.unifToken, method, path, body, out)
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+// protectRequest sends a request to the Protect integration API of the NVR at nvrAddr at path,
+// encoding body as JSON if it isn't nil, and decodes the JSON response into out.
+func protectRequest(ctx context.Context, client *http.Client, nvrAddr, token, method, path string, body, out any) error {
+	req, err := newProtectRequest(ctx, nvrAddr, token, method, path, body)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	req.Header.Set("X-Api-Key", dis.unifToken)
-	req.Header.Set("Accept", "application/json")
-
-	resp, err := dis.httpClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return err
 	}
 	defer resp.Body.Close()
 
 	if err := checkResponse(resp); err != nil {
-		return "", err
+		return err
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", path, err)
+	}
+	return nil
+}
+
+// newProtectRequest builds an authenticated request to the Protect integration API of the NVR at
+// nvrAddr at path, encoding body as JSON if it isn't nil.
+func newProtectRequest(ctx context.Context, nvrAddr, token, method, path string, body any) (*http.Request, error) {
+	url := fmt.Sprintf("https://%s/proxy/protect/integration/v1%s", nvrAddr, path)
+
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("X-Api-Key", token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+func (dis *unifiDiscovery) getCameras(ctx context.Context) ([]unifiCamera, error) {
+	var cameras []unifiCamera
+	if err := dis.apiRequest(ctx, http.MethodGet, "/cameras", nil, &cameras); err != nil {
+		return nil, err
 	}
 
+	return cameras, nil
+}
+
+// getRTSPStreams returns the camera's streams of quality, creating the ones the camera doesn't
+// share yet if createStreams is set.
+func (dis *unifiDiscovery) getRTSPStreams(ctx context.Context, cameraID, quality string) ([]rtspStream, error) {
+	path := fmt.Sprintf("/cameras/%s/rtsps-stream", cameraID)
+
 	var streamResp rtspStreamResponse
-	if err := json.NewDecoder(resp.Body).Decode(&streamResp); err != nil {
-		return "", fmt.Errorf("failed to decode stream response: %w", err)
-	}
-
-	// Use the first available stream: high, medium, then low
-	var rtspsURL string
-	if streamResp.High != "" {
-		rtspsURL = streamResp.High
-	} else if streamResp.Medium != "" {
-		rtspsURL = streamResp.Medium
-	} else if streamResp.Low != "" {
-		rtspsURL = streamResp.Low
-	} else {
-		return "", errors.New("no RTSP stream URL available")
+	if err := dis.apiRequest(ctx, http.MethodGet, path, nil, &streamResp); err != nil {
+		if !dis.createStreams {
+			return nil, err
+		}
+		// cameras that share no stream may answer with an error rather than an empty response
+		dis.logger.Debugf("failed to get RTSPS streams of camera %s, creating them: %v", cameraID, err)
+	}
+
+	if missing := missingQualities(streamResp, quality); dis.createStreams && len(missing) > 0 {
+		dis.logger.Infof("Creating %v RTSPS streams for camera %s", missing, cameraID)
+		var created rtspStreamResponse
+		if err := dis.apiRequest(ctx, http.MethodPost, path, createStreamsRequest{Qualities: missing}, &created); err != nil {
+			return nil, fmt.Errorf("failed to create %v RTSPS streams: %w", missing, err)
+		}
+		for _, q := range missing {
+			if u := created.url(q); u != "" {
+				streamResp = streamResp.withURL(q, u)
+			}
+		}
 	}
 
-	// Convert RTSPS to RTSP: change port 7441 to 7447 and remove ?enableSrtp
-	rtspURL := convertRTSPStoRTSP(rtspsURL)
+	streams := selectStreams(streamResp, quality)
+	if len(streams) == 0 {
+		if quality == qualityHighest || quality == qualityAll {
+			return nil, errors.New("no RTSP stream URL available")
+		}
+		return nil, fmt.Errorf("no RTSP stream URL available for %s quality", quality)
+	}
 
-	return rtspURL, nil
+	for i := range streams {
+		if dis.keepRTSPS {
+			streams[i].url = removeEnableSRTP(streams[i].url)
+		} else {
+			// Convert RTSPS to RTSP: change port 7441 to 7447 and remove ?enableSrtp
+			streams[i].url = convertRTSPStoRTSP(streams[i].url)
+		}
+	}
+
+	return streams, nil
+}
+
+// withURL returns r with the address of the stream of quality set to u.
+func (r rtspStreamResponse) withURL(quality, u string) rtspStreamResponse {
+	switch quality {
+	case qualityHigh:
+		r.High = u
+	case qualityMedium:
+		r.Medium = u
+	case qualityLow:
+		r.Low = u
+	}
+	return r
+}
+
+// selectStreams returns the streams of quality the camera shares.
+func selectStreams(resp rtspStreamResponse, quality string) []rtspStream {
+	var streams []rtspStream
+	for _, q := range streamQualities {
+		u := resp.url(q)
+		if u == "" || (quality != qualityAll && quality != qualityHighest && quality != q) {
+			continue
+		}
+		streams = append(streams, rtspStream{quality: q, url: u})
+		// Use the first available stream: high, medium, then low
+		if quality == qualityHighest {
+			break
+		}
+	}
+	return streams
+}
+
+// missingQualities returns the qualities that must be created for the camera to share a stream of
+// quality. A camera that shares any stream has a highest quality one.
+func missingQualities(resp rtspStreamResponse, quality string) []string {
+	var missing []string
+	switch quality {
+	case qualityHighest:
+		if len(selectStreams(resp, quality)) == 0 {
+			missing = []string{qualityHigh}
+		}
+	case qualityAll:
+		for _, q := range streamQualities {
+			if resp.url(q) == "" {
+				missing = append(missing, q)
+			}
+		}
+	default:
+		if resp.url(quality) == "" {
+			missing = []string{quality}
+		}
+	}
+	return missing
 }
 
 // convertRTSPStoRTSP converts an RTSPS URL to plain RTSP.
@@ -218,6 +600,13 @@ func (dis *unifiDiscovery) getRTSPStream(ctx context.Context, cameraID string) (
 func convertRTSPStoRTSP(rtspsURL string) string {
 	rtspURL := strings.Replace(rtspsURL, "rtsps://", "rtsp://", 1)
 	rtspURL = strings.Replace(rtspURL, ":7441/", ":7447/", 1)
+
+	return removeEnableSRTP(rtspURL)
+}
+
+// removeEnableSRTP removes the ?enableSrtp query Protect adds to RTSPS addresses. RTP isn't
+// encrypted on its own then, but rtsps streams are sent over the TLS connection anyway.
+func removeEnableSRTP(rtspURL string) string {
 	if idx := strings.Index(rtspURL, "?enableSrtp"); idx != -1 {
 		rtspURL = rtspURL[:idx]
 	}
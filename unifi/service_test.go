@@ -6,8 +6,11 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/viam-modules/viamrtsp"
+	"github.com/viam-modules/viamrtsp/rtsppreview"
 	"go.viam.com/rdk/logging"
 	"go.viam.com/rdk/resource"
 	"go.viam.com/rdk/services/discovery"
@@ -355,9 +358,10 @@ func TestGetRTSPStream(t *testing.T) {
 		}
 		dis.httpClient = server.Client()
 
-		rtspURL, err := dis.getRTSPStream(ctx, "cam123")
+		streams, err := dis.getRTSPStreams(ctx, "cam123", qualityHighest)
 		test.That(t, err, test.ShouldBeNil)
-		test.That(t, rtspURL, test.ShouldEqual, "rtsp://10.0.0.1:7447/highstream")
+		test.That(t, len(streams), test.ShouldEqual, 1)
+		test.That(t, streams[0].url, test.ShouldEqual, "rtsp://10.0.0.1:7447/highstream")
 	})
 
 	t.Run("Test fallback to medium stream", func(t *testing.T) {
@@ -394,10 +398,10 @@ func TestGetRTSPStream(t *testing.T) {
 		}
 		dis.httpClient = server.Client()
 
-		rtspURL, err := dis.getRTSPStream(ctx, "cam123")
+		streams, err := dis.getRTSPStreams(ctx, "cam123", qualityHighest)
 		test.That(t, err, test.ShouldNotBeNil)
 		test.That(t, err.Error(), test.ShouldContainSubstring, "no RTSP stream URL available")
-		test.That(t, rtspURL, test.ShouldEqual, "")
+		test.That(t, streams, test.ShouldBeNil)
 	})
 }
 
@@ -420,9 +424,10 @@ func testStreamFallback(ctx context.Context, t *testing.T, logger logging.Logger
 	}
 	dis.httpClient = server.Client()
 
-	rtspURL, err := dis.getRTSPStream(ctx, "cam123")
+	streams, err := dis.getRTSPStreams(ctx, "cam123", qualityHighest)
 	test.That(t, err, test.ShouldBeNil)
-	test.That(t, rtspURL, test.ShouldEqual, expected)
+	test.That(t, len(streams), test.ShouldEqual, 1)
+	test.That(t, streams[0].url, test.ShouldEqual, expected)
 }
 
 func TestCheckResponse(t *testing.T) {
@@ -484,3 +489,248 @@ func TestCheckResponse(t *testing.T) {
 		test.That(t, err.Error(), test.ShouldContainSubstring, "unexpected content type")
 	})
 }
+
+// fakeProtect is a Protect integration API that shares the streams in streams and creates the ones
+// it's asked to.
+type fakeProtect struct {
+	t       *testing.T
+	cameras []unifiCamera
+	streams map[string]rtspStreamResponse
+	created map[string][]string
+}
+
+func (p *fakeProtect) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/proxy/protect/integration/v1/cameras" {
+		w.Header().Set("Content-Type", "application/json")
+		test.That(p.t, json.NewEncoder(w).Encode(p.cameras), test.ShouldBeNil)
+		return
+	}
+	if id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/proxy/protect/integration/v1/cameras/"), "/snapshot"); ok {
+		test.That(p.t, r.Header.Get("Accept"), test.ShouldEqual, "image/jpeg")
+		w.Header().Set("Content-Type", "image/jpeg")
+		_, err := w.Write([]byte("jpeg-" + id))
+		test.That(p.t, err, test.ShouldBeNil)
+		return
+	}
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/proxy/protect/integration/v1/cameras/"), "/rtsps-stream")
+	streams, ok := p.streams[id]
+	switch {
+	case r.Method == http.MethodGet && !ok:
+		// Protect answers cameras that share no stream with an error
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case r.Method == http.MethodPost:
+		test.That(p.t, r.Header.Get("Content-Type"), test.ShouldEqual, "application/json")
+		var req createStreamsRequest
+		test.That(p.t, json.NewDecoder(r.Body).Decode(&req), test.ShouldBeNil)
+		p.created[id] = append(p.created[id], req.Qualities...)
+		for _, q := range req.Qualities {
+			streams = streams.withURL(q, "rtsps://10.0.0.1:7441/"+id+q+"?enableSrtp")
+		}
+		p.streams[id] = streams
+	}
+	w.Header().Set("Content-Type", "application/json")
+	test.That(p.t, json.NewEncoder(w).Encode(streams), test.ShouldBeNil)
+}
+
+func TestStreamOptions(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	newProtect := func(t *testing.T) (*fakeProtect, *unifiDiscovery) {
+		protect := &fakeProtect{
+			t: t,
+			cameras: []unifiCamera{
+				{ID: "cam1", Name: "Front Door", State: "CONNECTED"},
+				{ID: "cam2", Name: "Backyard", State: "CONNECTED"},
+			},
+			streams: map[string]rtspStreamResponse{
+				"cam1": {
+					High: "rtsps://10.0.0.1:7441/cam1high?enableSrtp",
+					Low:  "rtsps://10.0.0.1:7441/cam1low?enableSrtp",
+				},
+			},
+			created: map[string][]string{},
+		}
+		server := httptest.NewTLSServer(protect)
+		t.Cleanup(server.Close)
+		dis := &unifiDiscovery{
+			Named:      resource.NewName(discovery.API, "test").AsNamed(),
+			unifToken:  "test-token",
+			nvrAddr:    server.URL[8:],
+			logger:     logger,
+			httpClient: server.Client(),
+		}
+		return protect, dis
+	}
+
+	t.Run("Test quality per camera", func(t *testing.T) {
+		_, dis := newProtect(t)
+		dis.quality = qualityAll
+		dis.cameraQualities = map[string]string{"Backyard": qualityLow}
+
+		configs, err := dis.DiscoverResources(ctx, nil)
+		test.That(t, err, test.ShouldBeNil)
+		// Backyard shares no stream and streams aren't created
+		test.That(t, len(configs), test.ShouldEqual, 2)
+		test.That(t, configs[0].Name, test.ShouldEqual, "front_door_cam1_high")
+		test.That(t, configs[0].Attributes["rtsp_address"], test.ShouldEqual, "rtsp://10.0.0.1:7447/cam1high")
+		test.That(t, configs[1].Name, test.ShouldEqual, "front_door_cam1_low")
+		test.That(t, configs[1].Attributes["rtsp_address"], test.ShouldEqual, "rtsp://10.0.0.1:7447/cam1low")
+
+		dis.quality = qualityMedium
+		dis.cameraQualities = map[string]string{"cam1": qualityLow}
+		configs, err = dis.DiscoverResources(ctx, nil)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(configs), test.ShouldEqual, 1)
+		test.That(t, configs[0].Name, test.ShouldEqual, "front_door_cam1")
+		test.That(t, configs[0].Attributes["rtsp_address"], test.ShouldEqual, "rtsp://10.0.0.1:7447/cam1low")
+	})
+
+	t.Run("Test keep rtsps", func(t *testing.T) {
+		_, dis := newProtect(t)
+		dis.keepRTSPS = true
+
+		configs, err := dis.DiscoverResources(ctx, nil)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(configs), test.ShouldEqual, 1)
+		test.That(t, configs[0].Attributes["rtsp_address"], test.ShouldEqual, "rtsps://10.0.0.1:7441/cam1high")
+		// certificates are verified unless insecure_skip_verify is set
+		test.That(t, configs[0].Attributes["insecure_skip_verify"], test.ShouldBeNil)
+
+		dis.insecureSkipVerify = true
+		configs, err = dis.DiscoverResources(ctx, nil)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(configs), test.ShouldEqual, 1)
+		test.That(t, configs[0].Attributes["insecure_skip_verify"], test.ShouldBeTrue)
+	})
+
+	t.Run("Test create streams", func(t *testing.T) {
+		protect, dis := newProtect(t)
+		dis.createStreams = true
+		dis.quality = qualityMedium
+
+		configs, err := dis.DiscoverResources(ctx, nil)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(configs), test.ShouldEqual, 2)
+		test.That(t, configs[0].Attributes["rtsp_address"], test.ShouldEqual, "rtsp://10.0.0.1:7447/cam1medium")
+		test.That(t, configs[1].Attributes["rtsp_address"], test.ShouldEqual, "rtsp://10.0.0.1:7447/cam2medium")
+		test.That(t, protect.created, test.ShouldResemble, map[string][]string{"cam1": {qualityMedium}, "cam2": {qualityMedium}})
+
+		// streams that exist aren't created again
+		_, err = dis.DiscoverResources(ctx, nil)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, protect.created, test.ShouldResemble, map[string][]string{"cam1": {qualityMedium}, "cam2": {qualityMedium}})
+
+		dis.quality = qualityAll
+		configs, err = dis.DiscoverResources(ctx, nil)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(configs), test.ShouldEqual, 6)
+		test.That(t, protect.created["cam2"], test.ShouldResemble, []string{qualityMedium, qualityHigh, qualityLow})
+	})
+}
+
+func TestValidateQuality(t *testing.T) {
+	cfg := Config{NVRAddress: "10.0.0.1", UnifiToken: "test-token", Quality: "ultra"}
+	_, _, err := cfg.Validate("")
+	test.That(t, err, test.ShouldNotBeNil)
+
+	cfg = Config{NVRAddress: "10.0.0.1", UnifiToken: "test-token", CameraQualities: map[string]string{"Front Door": "best"}}
+	_, _, err = cfg.Validate("")
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "Front Door")
+
+	cfg = Config{
+		NVRAddress: "10.0.0.1", UnifiToken: "test-token", Quality: qualityAll,
+		CameraQualities: map[string]string{"Front Door": qualityLow, "cam2": qualityHighest},
+	}
+	_, _, err = cfg.Validate("")
+	test.That(t, err, test.ShouldBeNil)
+}
+
+func TestCameraMetadata(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	protect := &fakeProtect{
+		t: t,
+		cameras: []unifiCamera{
+			{
+				ID: "cam1", Name: "Front Door", State: "CONNECTED", MarketName: "G4 Doorbell", FirmwareVersion: "4.69.55",
+				Channels: []cameraChannel{
+					{Name: "High", Enabled: true, Width: 1600, Height: 1200, FPS: 30},
+					{Name: "Low", Enabled: true, Width: 480, Height: 360, FPS: 15},
+				},
+			},
+			{ID: "cam2", Name: "Backyard", State: "DISCONNECTED", Type: "UVC G3"},
+		},
+		streams: map[string]rtspStreamResponse{
+			"cam1": {High: "rtsps://10.0.0.1:7441/cam1high?enableSrtp", Low: "rtsps://10.0.0.1:7441/cam1low?enableSrtp"},
+			"cam2": {High: "rtsps://10.0.0.1:7441/cam2high?enableSrtp"},
+		},
+		created: map[string][]string{},
+	}
+	server := httptest.NewTLSServer(protect)
+	t.Cleanup(server.Close)
+	dis := &unifiDiscovery{
+		Named:      resource.NewName(discovery.API, "test").AsNamed(),
+		unifToken:  "test-token",
+		nvrAddr:    server.URL[8:],
+		logger:     logger,
+		httpClient: server.Client(),
+		quality:    qualityAll,
+	}
+
+	t.Run("Test resolution and frame rate", func(t *testing.T) {
+		configs, err := dis.DiscoverResources(ctx, nil)
+		test.That(t, err, test.ShouldBeNil)
+		// the disconnected camera is skipped
+		test.That(t, len(configs), test.ShouldEqual, 2)
+		attrs := configs[0].ConvertedAttributes.(*viamrtsp.Config)
+		test.That(t, attrs.Address, test.ShouldEqual, "rtsp://10.0.0.1:7447/cam1high")
+		test.That(t, attrs.Resolution, test.ShouldResemble, &viamrtsp.Resolution{Width: 1600, Height: 1200})
+		test.That(t, attrs.FrameRate, test.ShouldEqual, 30)
+		test.That(t, configs[0].Attributes["rtp_passthrough"], test.ShouldBeNil)
+		test.That(t, configs[1].Attributes["frame_rate"], test.ShouldEqual, 15)
+	})
+
+	t.Run("Test include offline", func(t *testing.T) {
+		dis.includeOffline = true
+		defer func() { dis.includeOffline = false }()
+		configs, err := dis.DiscoverResources(ctx, nil)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(configs), test.ShouldEqual, 3)
+		test.That(t, configs[2].Name, test.ShouldEqual, "backyard_cam2_high")
+		// Protect didn't send the camera's channels
+		test.That(t, configs[2].ConvertedAttributes.(*viamrtsp.Config).Resolution, test.ShouldBeNil)
+	})
+
+	t.Run("Test preview from snapshot", func(t *testing.T) {
+		_, err := dis.DiscoverResources(ctx, nil)
+		test.That(t, err, test.ShouldBeNil)
+		resp, err := dis.DoCommand(ctx, map[string]interface{}{
+			"command":    "preview",
+			"attributes": map[string]interface{}{"rtsp_address": "rtsp://10.0.0.1:7447/cam1low"},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["preview"], test.ShouldEqual, rtsppreview.FormatDataURL("image/jpeg", []byte("jpeg-cam1")))
+
+		_, err = dis.DoCommand(ctx, map[string]interface{}{"command": "preview", "attributes": map[string]interface{}{}})
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+
+	t.Run("Test get cameras", func(t *testing.T) {
+		resp, err := dis.DoCommand(ctx, map[string]interface{}{"command": "get-cameras"})
+		test.That(t, err, test.ShouldBeNil)
+		cameras := resp["cameras"].([]interface{})
+		test.That(t, len(cameras), test.ShouldEqual, 2)
+		test.That(t, cameras[0], test.ShouldResemble, map[string]interface{}{
+			"id": "cam1", "name": "Front Door", "model": "G4 Doorbell", "firmware": "4.69.55", "state": "CONNECTED", "online": true,
+		})
+		test.That(t, cameras[1].(map[string]interface{})["model"], test.ShouldEqual, "UVC G3")
+		test.That(t, cameras[1].(map[string]interface{})["online"], test.ShouldBeFalse)
+
+		_, err = dis.DoCommand(ctx, map[string]interface{}{"command": "unknown"})
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+}
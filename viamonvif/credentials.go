@@ -0,0 +1,160 @@
+package viamonvif
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/viam-modules/viamrtsp/viamonvif/device"
+	"go.viam.com/rdk/logging"
+)
+
+// onvifScopePrefix starts the WS-Discovery scopes defined by ONVIF, onvif://www.onvif.org/<type>/<value>.
+const onvifScopePrefix = "onvif://www.onvif.org/"
+
+// manufacturerScopeTypes are the scope types vendors put their name in.
+var manufacturerScopeTypes = []string{"name", "hardware", "manufacturer", "mfgname"}
+
+// deviceIdentity is what is known about a device before authenticating to it, used to pick the
+// credentials that are tried on it.
+type deviceIdentity struct {
+	host         string
+	ip           net.IP
+	scopes       map[string][]string
+	manufacturer string
+	serialNumber string
+}
+
+func newDeviceIdentity(xaddr *url.URL, scopes []string) deviceIdentity {
+	id := deviceIdentity{host: xaddr.Hostname(), ip: parseIPFromHost(xaddr.Host), scopes: map[string][]string{}}
+	for _, scope := range scopes {
+		rest, ok := strings.CutPrefix(strings.ToLower(scope), onvifScopePrefix)
+		if !ok {
+			continue
+		}
+		scopeType, value, ok := strings.Cut(rest, "/")
+		if !ok {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(value); err == nil {
+			value = unescaped
+		}
+		id.scopes[scopeType] = append(id.scopes[scopeType], value)
+	}
+	return id
+}
+
+// validateCredentialScope checks the hosts a credential is scoped to.
+func validateCredentialScope(cred device.Credentials) error {
+	for _, host := range cred.Hosts {
+		if strings.Contains(host, "/") {
+			if _, _, err := net.ParseCIDR(host); err != nil {
+				return fmt.Errorf("credential for user %q has invalid host range %q: %w", cred.User, host, err)
+			}
+		}
+	}
+	return nil
+}
+
+// needsDeviceInformation reports whether cred can only be matched once the manufacturer or serial number is known.
+func needsDeviceInformation(cred device.Credentials) bool {
+	return len(cred.Manufacturers) > 0 || len(cred.SerialNumbers) > 0
+}
+
+// credentialMatches reports whether cred may be tried on the device. Every kind of scope the credential
+// has must match, and a scope that can't be checked because the device hasn't revealed it doesn't match.
+func credentialMatches(cred device.Credentials, id deviceIdentity) bool {
+	if len(cred.Hosts) > 0 && !matchesAny(cred.Hosts, id.matchesHost) {
+		return false
+	}
+	if len(cred.Manufacturers) > 0 && !matchesAny(cred.Manufacturers, id.matchesManufacturer) {
+		return false
+	}
+	if len(cred.SerialNumbers) > 0 && !matchesAny(cred.SerialNumbers, id.matchesSerialNumber) {
+		return false
+	}
+	return true
+}
+
+func matchesAny(values []string, matches func(string) bool) bool {
+	for _, v := range values {
+		if matches(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (id deviceIdentity) matchesHost(host string) bool {
+	if _, ipNet, err := net.ParseCIDR(host); err == nil {
+		return id.ip != nil && ipNet.Contains(id.ip)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.Equal(id.ip)
+	}
+	return strings.EqualFold(host, id.host)
+}
+
+// matchesManufacturer matches case insensitively against part of the reported manufacturer, or of the
+// scopes vendors name themselves in, since those often include the model too.
+func (id deviceIdentity) matchesManufacturer(manufacturer string) bool {
+	manufacturer = strings.ToLower(manufacturer)
+	if id.manufacturer != "" && strings.Contains(strings.ToLower(id.manufacturer), manufacturer) {
+		return true
+	}
+	for _, scopeType := range manufacturerScopeTypes {
+		for _, value := range id.scopes[scopeType] {
+			if strings.Contains(value, manufacturer) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (id deviceIdentity) matchesSerialNumber(serial string) bool {
+	return id.serialNumber != "" && strings.EqualFold(serial, id.serialNumber)
+}
+
+// identifyDevice fills in the manufacturer and serial number of devices that report them without credentials.
+func identifyDevice(ctx context.Context, xaddr *url.URL, id *deviceIdentity, logger logging.Logger) {
+	dev, err := device.NewDevice(ctx, device.Params{Xaddr: xaddr, SkipLocalTLSVerification: true}, logger)
+	if err != nil {
+		logger.Debugf("Failed to connect to %s without credentials to identify it: %v", xaddr, err)
+		return
+	}
+	info, err := dev.GetDeviceInformation(ctx)
+	if err != nil {
+		logger.Debugf("%s doesn't report its device information without credentials: %v", xaddr, err)
+		return
+	}
+	id.manufacturer, id.serialNumber = info.Manufacturer, info.SerialNumber
+}
+
+// credentialsFor returns the credentials in creds that may be tried on the device at xaddr.
+func credentialsFor(
+	ctx context.Context,
+	xaddr *url.URL,
+	scopes []string,
+	creds []device.Credentials,
+	logger logging.Logger,
+) ([]device.Credentials, deviceIdentity) {
+	id := newDeviceIdentity(xaddr, scopes)
+	for _, cred := range creds {
+		if needsDeviceInformation(cred) {
+			identifyDevice(ctx, xaddr, &id, logger)
+			break
+		}
+	}
+	var matched []device.Credentials
+	for _, cred := range creds {
+		if credentialMatches(cred, id) {
+			matched = append(matched, cred)
+		} else {
+			logger.Debugf("Not trying credentials for user %q on %s, which is outside their scope", cred.User, xaddr)
+		}
+	}
+	return matched, id
+}
@@ -0,0 +1,113 @@
+package viamonvif
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/viam-modules/viamrtsp/viamonvif/device"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestCredentialMatches(t *testing.T) {
+	xaddr, err := url.Parse("http://192.168.1.20/onvif/device_service")
+	test.That(t, err, test.ShouldBeNil)
+	id := newDeviceIdentity(xaddr, []string{
+		"onvif://www.onvif.org/type/video_encoder",
+		"onvif://www.onvif.org/name/HIKVISION%20DS-2CD2142FWD-I",
+		"onvif://www.onvif.org/location/city/hangzhou",
+		"not-an-onvif-scope",
+	})
+	test.That(t, id.scopes["name"], test.ShouldResemble, []string{"hikvision ds-2cd2142fwd-i"})
+
+	t.Run("unscoped credentials match every device", func(t *testing.T) {
+		test.That(t, credentialMatches(device.Credentials{User: "admin"}, id), test.ShouldBeTrue)
+	})
+
+	t.Run("hosts", func(t *testing.T) {
+		test.That(t, credentialMatches(device.Credentials{Hosts: []string{"192.168.1.20"}}, id), test.ShouldBeTrue)
+		test.That(t, credentialMatches(device.Credentials{Hosts: []string{"192.168.1.0/24"}}, id), test.ShouldBeTrue)
+		test.That(t, credentialMatches(device.Credentials{Hosts: []string{"10.0.0.0/8", "192.168.1.21"}}, id), test.ShouldBeFalse)
+	})
+
+	t.Run("manufacturers from scopes", func(t *testing.T) {
+		test.That(t, credentialMatches(device.Credentials{Manufacturers: []string{"Hikvision"}}, id), test.ShouldBeTrue)
+		test.That(t, credentialMatches(device.Credentials{Manufacturers: []string{"Axis"}}, id), test.ShouldBeFalse)
+		// location scopes don't name the manufacturer
+		test.That(t, credentialMatches(device.Credentials{Manufacturers: []string{"hangzhou"}}, id), test.ShouldBeFalse)
+	})
+
+	t.Run("serial numbers need device information", func(t *testing.T) {
+		cred := device.Credentials{SerialNumbers: []string{"ABC123"}}
+		test.That(t, credentialMatches(cred, id), test.ShouldBeFalse)
+		withInfo := id
+		withInfo.manufacturer, withInfo.serialNumber = "Hikvision", "abc123"
+		test.That(t, credentialMatches(cred, withInfo), test.ShouldBeTrue)
+	})
+
+	t.Run("every kind of scope must match", func(t *testing.T) {
+		cred := device.Credentials{Hosts: []string{"192.168.1.0/24"}, Manufacturers: []string{"axis"}}
+		test.That(t, credentialMatches(cred, id), test.ShouldBeFalse)
+		cred.Manufacturers = []string{"hikvision"}
+		test.That(t, credentialMatches(cred, id), test.ShouldBeTrue)
+	})
+}
+
+func TestValidateCredentialScope(t *testing.T) {
+	test.That(t, validateCredentialScope(device.Credentials{Hosts: []string{"10.0.0.0/24", "10.0.1.5", "cam.local"}}), test.ShouldBeNil)
+	err := validateCredentialScope(device.Credentials{User: "admin", Hosts: []string{"10.0.0.0/33"}})
+	test.That(t, err.Error(), test.ShouldContainSubstring, `credential for user "admin" has invalid host range`)
+}
+
+func TestDiscoverCameraInfoAuthFailure(t *testing.T) {
+	xaddr, err := url.Parse("http://192.168.1.20/onvif/device_service")
+	test.That(t, err, test.ShouldBeNil)
+	creds := []device.Credentials{{User: "admin", Pass: "secret", Hosts: []string{"10.0.0.0/24"}}}
+	_, err = discoverCameraInfo(context.Background(), xaddr, nil, creds, logging.NewTestLogger(t))
+	var authErr *authError
+	test.That(t, errors.As(err, &authErr), test.ShouldBeTrue)
+	test.That(t, authErr.failure.Host, test.ShouldEqual, "192.168.1.20")
+	test.That(t, authErr.failure.TriedUsers, test.ShouldBeEmpty)
+	test.That(t, authErr.failure.Error, test.ShouldEqual, "no credentials are in scope for the device")
+}
+
+func TestDiscoverCameraInfoErrors(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	creds := []device.Credentials{{}, {User: "admin", Pass: "secret"}, {User: "lab", Pass: "other"}}
+	discover := func(status int) error {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.WriteHeader(status)
+		}))
+		defer server.Close()
+		xaddr, err := url.Parse(server.URL)
+		test.That(t, err, test.ShouldBeNil)
+		_, err = discoverCameraInfo(context.Background(), xaddr, nil, creds, logger)
+		if status != http.StatusUnauthorized {
+			// a failure that isn't about credentials isn't retried with the others
+			test.That(t, requests, test.ShouldEqual, 1)
+		}
+		return err
+	}
+
+	t.Run("rejected credentials are an auth failure", func(t *testing.T) {
+		err := discover(http.StatusUnauthorized)
+		var authErr *authError
+		test.That(t, errors.As(err, &authErr), test.ShouldBeTrue)
+		test.That(t, authErr.failure.TriedUsers, test.ShouldResemble, []string{"admin", "lab"})
+		test.That(t, authErr.failure.Error, test.ShouldContainSubstring, "status code: 401")
+	})
+
+	t.Run("other failures are not", func(t *testing.T) {
+		err := discover(http.StatusInternalServerError)
+		test.That(t, err, test.ShouldNotBeNil)
+		var authErr *authError
+		test.That(t, errors.As(err, &authErr), test.ShouldBeFalse)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "status code: 500")
+	})
+}
@@ -17,6 +17,9 @@ import (
 	"net/netip"
 	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/beevik/etree"
 	"github.com/viam-modules/viamrtsp/viamonvif/gosoap"
@@ -35,6 +38,7 @@ var Xlmns = map[string]string{
 	"onvif":   "http://www.onvif.org/ver10/schema",
 	"tds":     "http://www.onvif.org/ver10/device/wsdl",
 	"trt":     "http://www.onvif.org/ver10/media/wsdl",
+	"tr2":     media2Namespace,
 	"tev":     "http://www.onvif.org/ver10/events/wsdl",
 	"tptz":    "http://www.onvif.org/ver20/ptz/wsdl",
 	"timg":    "http://www.onvif.org/ver20/imaging/wsdl",
@@ -57,6 +61,14 @@ type Device struct {
 	logger    logging.Logger
 	params    Params
 	endpoints map[string]string
+	// clockOffset is added to the machine's time in WS-Security headers.
+	clockOffset atomic.Int64
+
+	// servicesMu guards media2, the Media2 address from GetServices, which is only called once
+	// Media2 is needed, see media2XAddr.
+	servicesMu      sync.Mutex
+	servicesChecked bool
+	media2          string
 }
 
 // Params configures the device connection.
@@ -106,6 +118,40 @@ type GetCapabilities struct {
 
 // NewDevice construct an ONVIF Device entity.
 func NewDevice(ctx context.Context, params Params, logger logging.Logger) (*Device, error) {
+	dev := NewDeviceServiceClient(params, logger)
+	data, err := dev.callDevice(ctx, GetCapabilities{Category: "All"})
+	if err != nil {
+		return nil, err
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil {
+		return nil, err
+	}
+	dev.logger.Debugf("GetCapabilitiesResponse: %s", string(data))
+	services := doc.FindElements("./Envelope/Body/GetCapabilitiesResponse/Capabilities/*/XAddr")
+	for i, s := range services {
+		if i == 0 {
+			dev.logger.Debug("GetCapabilities services:")
+		}
+		dev.logger.Debugf("%s: %s", s.Parent().Tag, s.Text())
+		dev.endpoints[strings.ToLower(s.Parent().Tag)] = s.Text()
+	}
+	extensionServices := doc.FindElements("./Envelope/Body/GetCapabilitiesResponse/Capabilities/Extension/*/XAddr")
+	for i, s := range extensionServices {
+		if i == 0 {
+			dev.logger.Debug("GetCapabilities extension services:")
+		}
+		dev.logger.Debugf("%s: %s", s.Parent().Tag, s.Text())
+		dev.endpoints[strings.ToLower(s.Parent().Tag)] = s.Text()
+	}
+	return dev, nil
+}
+
+// NewDeviceServiceClient returns a Device that only calls the device service at params.Xaddr. Unlike
+// NewDevice it makes no requests, so it works when GetCapabilities fails, such as when the device's
+// clock has drifted too far for WS-Security authentication.
+func NewDeviceServiceClient(params Params, logger logging.Logger) *Device {
 	dev := &Device{
 		xaddr:     params.Xaddr,
 		logger:    logger,
@@ -137,35 +183,13 @@ func NewDevice(ctx context.Context, params Params, logger logging.Logger) (*Devi
 				params.Xaddr.Hostname())
 		}
 	}
+	return dev
+}
 
-	data, err := dev.callDevice(ctx, GetCapabilities{Category: "All"})
-	if err != nil {
-		return nil, err
-	}
-
-	doc := etree.NewDocument()
-	if err := doc.ReadFromBytes(data); err != nil {
-		return nil, err
-	}
-	dev.logger.Debugf("GetCapabilitiesResponse: %s", string(data))
-	services := doc.FindElements("./Envelope/Body/GetCapabilitiesResponse/Capabilities/*/XAddr")
-	for i, s := range services {
-		if i == 0 {
-			dev.logger.Debug("GetCapabilities services:")
-		}
-		dev.logger.Debugf("%s: %s", s.Parent().Tag, s.Text())
-		dev.endpoints[strings.ToLower(s.Parent().Tag)] = s.Text()
-	}
-	extensionServices := doc.FindElements("./Envelope/Body/GetCapabilitiesResponse/Capabilities/Extension/*/XAddr")
-	for i, s := range extensionServices {
-		if i == 0 {
-			dev.logger.Debug("GetCapabilities extension services:")
-		}
-		dev.logger.Debugf("%s: %s", s.Parent().Tag, s.Text())
-		dev.endpoints[strings.ToLower(s.Parent().Tag)] = s.Text()
-	}
-
-	return dev, nil
+// SetClockOffset sets how far the device's clock is ahead of the machine's. Requests are signed
+// with the device's time so they authenticate even when the device rejects the machine's.
+func (dev *Device) SetClockOffset(offset time.Duration) {
+	dev.clockOffset.Store(int64(offset))
 }
 
 // GetDeviceInformationResponse is the response to GetDeviceInformation.
@@ -247,8 +271,21 @@ type GetProfilesResponseEnvelope struct {
 	} `xml:"Body"`
 }
 
-// GetProfiles returns the device's profiles.
+// GetProfiles returns the device's profiles. Media2 is preferred when the device supports it, since
+// the legacy Media service can't describe H265 encoders.
 func (dev *Device) GetProfiles(ctx context.Context) (GetProfilesResponse, error) {
+	if dev.SupportsMedia2(ctx) {
+		resp, err := dev.getProfiles2(ctx)
+		if err == nil {
+			dev.logProfiles(resp)
+			return resp, nil
+		}
+		if dev.endpoints["media"] == "" {
+			return resp, err
+		}
+		dev.logger.Debugf("Media2 GetProfiles failed, falling back to Media: %v", err)
+	}
+
 	var zero GetProfilesResponse
 	getProfiles := GetProfiles{}
 	b, err := dev.callMedia(ctx, getProfiles)
@@ -268,8 +305,14 @@ func (dev *Device) GetProfiles(ctx context.Context) (GetProfilesResponse, error)
 		return zero, errors.New("no media profiles found")
 	}
 
-	dev.logger.Debugf("Found %d media profiles", len(resp.Body.GetProfilesResponse.Profiles))
-	for i, profile := range resp.Body.GetProfilesResponse.Profiles {
+	dev.logProfiles(resp.Body.GetProfilesResponse)
+	dev.logger.Debugf("GetProfiles decoded: %#v", resp.Body.GetProfilesResponse)
+	return resp.Body.GetProfilesResponse, nil
+}
+
+func (dev *Device) logProfiles(resp GetProfilesResponse) {
+	dev.logger.Debugf("Found %d media profiles", len(resp.Profiles))
+	for i, profile := range resp.Profiles {
 		dev.logger.Debugf("Profile %d: Token=%s, Name=%s, FrameRate=%d, Resolution=%dx%d, Codec=%s",
 			i,
 			profile.Token,
@@ -279,9 +322,6 @@ func (dev *Device) GetProfiles(ctx context.Context) (GetProfilesResponse, error)
 			profile.VideoEncoderConfiguration.Resolution.Height,
 			string(profile.VideoEncoderConfiguration.Encoding))
 	}
-
-	dev.logger.Debugf("GetProfiles decoded: %#v", resp.Body.GetProfilesResponse)
-	return resp.Body.GetProfilesResponse, nil
 }
 
 type getStreamURIResponse struct {
@@ -306,11 +346,24 @@ type getSnapshotURIResponse struct {
 type Credentials struct {
 	User string `json:"user"`
 	Pass string `json:"pass"`
+	// Manufacturers, Hosts and SerialNumbers limit discovery to trying the credentials on matching
+	// devices. Credentials without any of them are tried on every device.
+	Manufacturers []string `json:"manufacturers,omitempty"`
+	// Hosts are IP addresses, CIDR ranges or hostnames.
+	Hosts         []string `json:"hosts,omitempty"`
+	SerialNumbers []string `json:"serial_numbers,omitempty"`
 }
 
 // GetStreamURI returns a device's stream URI for a given profile token.
 func (dev *Device) GetStreamURI(ctx context.Context, token onvif.ReferenceToken, creds Credentials) (*url.URL, error) {
 	dev.logger.Debugf("GetStreamUri token: %s", token)
+	if dev.SupportsMedia2(ctx) {
+		uri, err := dev.getStreamURI2(ctx, token, creds)
+		if err == nil || dev.endpoints["media"] == "" {
+			return uri, err
+		}
+		dev.logger.Debugf("Media2 GetStreamUri failed for token %s, falling back to Media: %v", token, err)
+	}
 	body, err := dev.callMedia(ctx, GetStreamURI{
 		StreamSetup: onvif.StreamSetup{
 			Stream:    onvif.StreamType(streamTypeRTPUnicast),
@@ -350,6 +403,13 @@ func (dev *Device) GetStreamURI(ctx context.Context, token onvif.ReferenceToken,
 // GetSnapshotURI returns a device's snapshot URI for a given profile token.
 func (dev *Device) GetSnapshotURI(ctx context.Context, token onvif.ReferenceToken, creds Credentials) (*url.URL, error) {
 	dev.logger.Debugf("GetSnapshotUri token: %s", token)
+	if dev.SupportsMedia2(ctx) {
+		uri, err := dev.getSnapshotURI2(ctx, token, creds)
+		if err == nil || dev.endpoints["media"] == "" {
+			return uri, err
+		}
+		dev.logger.Debugf("Media2 GetSnapshotUri failed for token %s, falling back to Media: %v", token, err)
+	}
 	body, err := dev.callMedia(ctx, GetSnapshotURI{
 		ProfileToken: token,
 	})
@@ -421,7 +481,8 @@ func (dev *Device) callOnvifServiceMethod(ctx context.Context, endpoint string,
 	}
 
 	if dev.params.Username != "" || dev.params.Password != "" {
-		if err := soap.AddWSSecurity(dev.params.Username, dev.params.Password); err != nil {
+		created := time.Now().Add(time.Duration(dev.clockOffset.Load()))
+		if err := soap.AddWSSecurityAt(dev.params.Username, dev.params.Password, created); err != nil {
 			return nil, err
 		}
 	}
@@ -446,12 +507,75 @@ func (dev *Device) sendSoap(ctx context.Context, endpoint, message string) ([]by
 
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("SOAP request to %s failed with status code: %d", endpoint, resp.StatusCode)
+		reqErr := &RequestError{Endpoint: endpoint, StatusCode: resp.StatusCode}
+		if body, err := io.ReadAll(io.LimitReader(resp.Body, maxFaultBytes)); err == nil {
+			reqErr.FaultCodes = faultCodes(body)
+		}
+		return nil, reqErr
 	}
 
 	return io.ReadAll(resp.Body)
 }
 
+// maxFaultBytes caps how much of an error response is read looking for a SOAP fault.
+const maxFaultBytes = 64 << 10
+
+// RequestError is returned when a device answers a SOAP request with a status other than 200 OK.
+type RequestError struct {
+	Endpoint   string
+	StatusCode int
+	// FaultCodes are the code and subcode values of the SOAP fault in the response, outermost first,
+	// such as "env:Sender" and "ter:NotAuthorized". They are empty if the response had no fault.
+	FaultCodes []string
+}
+
+func (e *RequestError) Error() string {
+	msg := fmt.Sprintf("SOAP request to %s failed with status code: %d", e.Endpoint, e.StatusCode)
+	if len(e.FaultCodes) > 0 {
+		msg += fmt.Sprintf(" (fault %s)", e.FaultCodes[len(e.FaultCodes)-1])
+	}
+	return msg
+}
+
+// IsAuthError reports whether err is a device rejecting a request's credentials, either with
+// HTTP 401 or with a ter:NotAuthorized or wsse:FailedAuthentication SOAP fault.
+func IsAuthError(err error) bool {
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) {
+		return false
+	}
+	if reqErr.StatusCode == http.StatusUnauthorized {
+		return true
+	}
+	for _, code := range reqErr.FaultCodes {
+		// the prefix is whatever the device bound the namespace to, so only compare the local name
+		if i := strings.LastIndex(code, ":"); i >= 0 {
+			code = code[i+1:]
+		}
+		if code == "NotAuthorized" || code == "FailedAuthentication" {
+			return true
+		}
+	}
+	return false
+}
+
+// faultCodes returns the code and subcode values of the SOAP fault in body, if it has one.
+func faultCodes(body []byte) []string {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(body); err != nil {
+		return nil
+	}
+	code := doc.FindElement("./Envelope/Body/Fault/Code")
+	var codes []string
+	for code != nil {
+		if value := code.SelectElement("Value"); value != nil {
+			codes = append(codes, strings.TrimSpace(value.Text()))
+		}
+		code = code.SelectElement("Subcode")
+	}
+	return codes
+}
+
 // GetXaddr returns the URL of the Onvif web service.
 func (dev *Device) GetXaddr() *url.URL {
 	if dev.xaddr == nil {
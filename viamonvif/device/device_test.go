@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -93,6 +94,68 @@ func readBody(r *http.Request) (string, error) {
 	return buf.String(), nil
 }
 
+// fakeOperation answers the requests to a fake device whose body contains name.
+type fakeOperation struct {
+	name    string
+	respond func(w http.ResponseWriter)
+}
+
+// soapResponse writes content in a SOAP envelope that declares the ONVIF namespaces.
+func soapResponse(content string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><env:Envelope
+			xmlns:env="http://www.w3.org/2003/05/soap-envelope" xmlns:tds="http://www.onvif.org/ver10/device/wsdl"
+			xmlns:trt="http://www.onvif.org/ver10/media/wsdl" xmlns:tr2="http://www.onvif.org/ver20/media/wsdl"
+			xmlns:tt="http://www.onvif.org/ver10/schema"><env:Body>` + content + `</env:Body></env:Envelope>`))
+	}
+}
+
+// statusResponse fails the request with status.
+func statusResponse(status int) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.WriteHeader(status)
+	}
+}
+
+// newFakeDevice serves an ONVIF device whose Media service is at /media. GetServices lists
+// services, a map of namespace to path, and fails like it does on older cameras when there
+// are none. Every other request is recorded in requests as "<path> <body>" and answered by
+// the first of operations that matches it, or fails when none do.
+func newFakeDevice(t *testing.T, requests *[]string, services map[string]string, operations ...fakeOperation) *httptest.Server {
+	t.Helper()
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := readBody(r)
+		test.That(t, err, test.ShouldBeNil)
+		switch {
+		case strings.Contains(body, "GetCapabilities"):
+			soapResponse(`<GetCapabilitiesResponse><Capabilities><Media><XAddr>` + server.URL +
+				`/media</XAddr></Media></Capabilities></GetCapabilitiesResponse>`)(w)
+			return
+		case strings.Contains(body, "GetServices"):
+			if len(services) == 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			var list strings.Builder
+			for namespace, path := range services {
+				list.WriteString(`<Service><Namespace>` + namespace + `</Namespace><XAddr>` + server.URL + path + `</XAddr></Service>`)
+			}
+			soapResponse(`<GetServicesResponse>` + list.String() + `</GetServicesResponse>`)(w)
+			return
+		}
+		*requests = append(*requests, r.URL.Path+" "+body)
+		for _, op := range operations {
+			if strings.Contains(body, op.name) {
+				op.respond(w)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	return server
+}
+
 func TestTLSVerificationConfig(t *testing.T) {
 	logger := logging.NewTestLogger(t)
 
@@ -332,3 +395,54 @@ func TestGetProfiles(t *testing.T) {
 		test.That(t, string(subStream.VideoEncoderConfiguration.Encoding), test.ShouldEqual, "H264")
 	})
 }
+
+func TestIsAuthError(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	fault := func(subcode string) string {
+		return `<?xml version="1.0" encoding="UTF-8"?>
+			<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" xmlns:ter="http://www.onvif.org/ver10/error">
+				<env:Body>
+					<env:Fault>
+						<env:Code>
+							<env:Value>env:Sender</env:Value>
+							<env:Subcode><env:Value>` + subcode + `</env:Value></env:Subcode>
+						</env:Code>
+						<env:Reason><env:Text xml:lang="en">fault</env:Text></env:Reason>
+					</env:Fault>
+				</env:Body>
+			</env:Envelope>`
+	}
+	for _, tc := range []struct {
+		name   string
+		status int
+		body   string
+		auth   bool
+	}{
+		{"401", http.StatusUnauthorized, "", true},
+		{"not authorized fault", http.StatusBadRequest, fault("ter:NotAuthorized"), true},
+		{"failed authentication fault", http.StatusInternalServerError, fault("wsse:FailedAuthentication"), true},
+		{"other fault", http.StatusBadRequest, fault("ter:InvalidArgVal"), false},
+		{"server error", http.StatusInternalServerError, "oops", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+			serverURL, err := url.Parse(server.URL)
+			test.That(t, err, test.ShouldBeNil)
+
+			_, err = NewDevice(context.Background(), Params{Xaddr: serverURL, Username: "admin", Password: "pass"}, logger)
+			var reqErr *RequestError
+			test.That(t, errors.As(err, &reqErr), test.ShouldBeTrue)
+			test.That(t, reqErr.StatusCode, test.ShouldEqual, tc.status)
+			test.That(t, IsAuthError(err), test.ShouldEqual, tc.auth)
+		})
+	}
+
+	test.That(t, IsAuthError(errors.New("connection refused")), test.ShouldBeFalse)
+	err := fmt.Errorf("wrapped: %w", &RequestError{StatusCode: http.StatusBadRequest, FaultCodes: []string{"env:Sender", "ter:NotAuthorized"}})
+	test.That(t, IsAuthError(err), test.ShouldBeTrue)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "(fault ter:NotAuthorized)")
+}
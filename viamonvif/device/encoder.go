@@ -0,0 +1,632 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/viam-modules/viamrtsp/viamonvif/xsd"
+	"github.com/viam-modules/viamrtsp/viamonvif/xsd/onvif"
+)
+
+// defaultSessionTimeout is sent to legacy Media devices that didn't report a session timeout.
+const defaultSessionTimeout = "PT60S"
+
+// VideoEncoderConfig is a video encoder configuration. It comes from the Media2 service when the
+// device supports it and from the legacy Media service otherwise.
+type VideoEncoderConfig struct {
+	Token    string
+	Name     string
+	UseCount int
+	// Encoding is H264, H265, JPEG or MPEG4.
+	Encoding       string
+	Width          int
+	Height         int
+	Quality        float64
+	FrameRateLimit float64
+	// BitrateLimit is in kbps.
+	BitrateLimit int
+	// GovLength is the number of frames between I-frames.
+	GovLength int
+	// Profile is the codec profile, such as Main or High.
+	Profile string
+
+	// legacy Media configurations are sent back whole, so the fields only they have are kept
+	encodingInterval int
+	multicast        multicastConfiguration
+	sessionTimeout   string
+}
+
+// VideoEncoderOptions are the settings a video encoder supports for one encoding. Ranges the device
+// doesn't report are zero.
+type VideoEncoderOptions struct {
+	Encoding     string
+	Resolutions  []onvif.VideoResolution
+	QualityMin   float64
+	QualityMax   float64
+	FrameRateMin float64
+	FrameRateMax float64
+	// FrameRates are the only frame rates supported, for Media2 devices that list them.
+	FrameRates   []float64
+	GovLengthMin int
+	GovLengthMax int
+	BitrateMin   int
+	BitrateMax   int
+	Profiles     []string
+}
+
+// GetVideoEncoderConfigurations is a request to the Media GetVideoEncoderConfigurations endpoint.
+type GetVideoEncoderConfigurations struct {
+	XMLName string `xml:"trt:GetVideoEncoderConfigurations"`
+}
+
+// GetVideoEncoderConfiguration is a request to the Media GetVideoEncoderConfiguration endpoint.
+type GetVideoEncoderConfiguration struct {
+	XMLName            string               `xml:"trt:GetVideoEncoderConfiguration"`
+	ConfigurationToken onvif.ReferenceToken `xml:"trt:ConfigurationToken"`
+}
+
+// GetVideoEncoderConfigurationOptions is a request to the Media GetVideoEncoderConfigurationOptions endpoint.
+type GetVideoEncoderConfigurationOptions struct {
+	XMLName            string               `xml:"trt:GetVideoEncoderConfigurationOptions"`
+	ConfigurationToken onvif.ReferenceToken `xml:"trt:ConfigurationToken,omitempty"`
+}
+
+// SetVideoEncoderConfiguration is a request to the Media SetVideoEncoderConfiguration endpoint.
+type SetVideoEncoderConfiguration struct {
+	XMLName          string                           `xml:"trt:SetVideoEncoderConfiguration"`
+	Configuration    videoEncoderConfigurationRequest `xml:"trt:Configuration"`
+	ForcePersistence bool                             `xml:"trt:ForcePersistence"`
+}
+
+// GetVideoEncoderConfigurations2 is a request to the Media2 GetVideoEncoderConfigurations endpoint.
+type GetVideoEncoderConfigurations2 struct {
+	XMLName            string               `xml:"tr2:GetVideoEncoderConfigurations"`
+	ConfigurationToken onvif.ReferenceToken `xml:"tr2:ConfigurationToken,omitempty"`
+}
+
+// GetVideoEncoderConfigurationOptions2 is a request to the Media2 GetVideoEncoderConfigurationOptions endpoint.
+type GetVideoEncoderConfigurationOptions2 struct {
+	XMLName            string               `xml:"tr2:GetVideoEncoderConfigurationOptions"`
+	ConfigurationToken onvif.ReferenceToken `xml:"tr2:ConfigurationToken,omitempty"`
+}
+
+// SetVideoEncoderConfiguration2 is a request to the Media2 SetVideoEncoderConfiguration endpoint.
+type SetVideoEncoderConfiguration2 struct {
+	XMLName       string                            `xml:"tr2:SetVideoEncoderConfiguration"`
+	Configuration videoEncoder2ConfigurationRequest `xml:"tr2:Configuration"`
+}
+
+type resolutionRequest struct {
+	Width  int `xml:"onvif:Width"`
+	Height int `xml:"onvif:Height"`
+}
+
+type multicastConfiguration struct {
+	Address struct {
+		Type        string `xml:"Type"`
+		IPv4Address string `xml:"IPv4Address"`
+		IPv6Address string `xml:"IPv6Address"`
+	} `xml:"Address"`
+	Port      int  `xml:"Port"`
+	TTL       int  `xml:"TTL"`
+	AutoStart bool `xml:"AutoStart"`
+}
+
+type multicastRequest struct {
+	Type        string `xml:"onvif:Address>onvif:Type"`
+	IPv4Address string `xml:"onvif:Address>onvif:IPv4Address,omitempty"`
+	IPv6Address string `xml:"onvif:Address>onvif:IPv6Address,omitempty"`
+	Port        int    `xml:"onvif:Port"`
+	TTL         int    `xml:"onvif:TTL"`
+	AutoStart   bool   `xml:"onvif:AutoStart"`
+}
+
+func (m multicastConfiguration) request() multicastRequest {
+	req := multicastRequest{
+		Type:        strings.TrimSpace(m.Address.Type),
+		IPv4Address: strings.TrimSpace(m.Address.IPv4Address),
+		IPv6Address: strings.TrimSpace(m.Address.IPv6Address),
+		Port:        m.Port,
+		TTL:         m.TTL,
+		AutoStart:   m.AutoStart,
+	}
+	if req.Type == "" {
+		req.Type = "IPv4"
+		req.IPv4Address = "0.0.0.0"
+	}
+	return req
+}
+
+// videoEncoderConfiguration is a legacy Media video encoder configuration in a response.
+type videoEncoderConfiguration struct {
+	Token      string `xml:"token,attr"`
+	Name       string `xml:"Name"`
+	UseCount   int    `xml:"UseCount"`
+	Encoding   string `xml:"Encoding"`
+	Resolution struct {
+		Width  int `xml:"Width"`
+		Height int `xml:"Height"`
+	} `xml:"Resolution"`
+	Quality     float64 `xml:"Quality"`
+	RateControl struct {
+		FrameRateLimit   float64 `xml:"FrameRateLimit"`
+		EncodingInterval int     `xml:"EncodingInterval"`
+		BitrateLimit     int     `xml:"BitrateLimit"`
+	} `xml:"RateControl"`
+	MPEG4 struct {
+		GovLength    int    `xml:"GovLength"`
+		Mpeg4Profile string `xml:"Mpeg4Profile"`
+	} `xml:"MPEG4"`
+	H264 struct {
+		GovLength   int    `xml:"GovLength"`
+		H264Profile string `xml:"H264Profile"`
+	} `xml:"H264"`
+	Multicast      multicastConfiguration `xml:"Multicast"`
+	SessionTimeout string                 `xml:"SessionTimeout"`
+}
+
+func (c videoEncoderConfiguration) toConfig() VideoEncoderConfig {
+	cfg := VideoEncoderConfig{
+		Token:            strings.TrimSpace(c.Token),
+		Name:             strings.TrimSpace(c.Name),
+		UseCount:         c.UseCount,
+		Encoding:         strings.ToUpper(strings.TrimSpace(c.Encoding)),
+		Width:            c.Resolution.Width,
+		Height:           c.Resolution.Height,
+		Quality:          c.Quality,
+		FrameRateLimit:   c.RateControl.FrameRateLimit,
+		BitrateLimit:     c.RateControl.BitrateLimit,
+		encodingInterval: c.RateControl.EncodingInterval,
+		multicast:        c.Multicast,
+		sessionTimeout:   strings.TrimSpace(c.SessionTimeout),
+	}
+	switch cfg.Encoding {
+	case "H264":
+		cfg.GovLength, cfg.Profile = c.H264.GovLength, strings.TrimSpace(c.H264.H264Profile)
+	case "MPEG4":
+		cfg.GovLength, cfg.Profile = c.MPEG4.GovLength, strings.TrimSpace(c.MPEG4.Mpeg4Profile)
+	}
+	return cfg
+}
+
+type videoEncoderConfigurationRequest struct {
+	Token       string            `xml:"token,attr"`
+	Name        string            `xml:"onvif:Name"`
+	UseCount    int               `xml:"onvif:UseCount"`
+	Encoding    string            `xml:"onvif:Encoding"`
+	Resolution  resolutionRequest `xml:"onvif:Resolution"`
+	Quality     float64           `xml:"onvif:Quality"`
+	RateControl struct {
+		FrameRateLimit   int `xml:"onvif:FrameRateLimit"`
+		EncodingInterval int `xml:"onvif:EncodingInterval"`
+		BitrateLimit     int `xml:"onvif:BitrateLimit"`
+	} `xml:"onvif:RateControl"`
+	MPEG4 *struct {
+		GovLength    int    `xml:"onvif:GovLength"`
+		Mpeg4Profile string `xml:"onvif:Mpeg4Profile"`
+	} `xml:"onvif:MPEG4,omitempty"`
+	H264 *struct {
+		GovLength   int    `xml:"onvif:GovLength"`
+		H264Profile string `xml:"onvif:H264Profile"`
+	} `xml:"onvif:H264,omitempty"`
+	Multicast      multicastRequest `xml:"onvif:Multicast"`
+	SessionTimeout string           `xml:"onvif:SessionTimeout"`
+}
+
+func (cfg VideoEncoderConfig) legacyRequest() (videoEncoderConfigurationRequest, error) {
+	req := videoEncoderConfigurationRequest{
+		Token:          cfg.Token,
+		Name:           cfg.Name,
+		UseCount:       cfg.UseCount,
+		Encoding:       cfg.Encoding,
+		Resolution:     resolutionRequest{Width: cfg.Width, Height: cfg.Height},
+		Quality:        cfg.Quality,
+		Multicast:      cfg.multicast.request(),
+		SessionTimeout: cfg.sessionTimeout,
+	}
+	if req.SessionTimeout == "" {
+		req.SessionTimeout = defaultSessionTimeout
+	}
+	// legacy Media frame rates are whole frames
+	req.RateControl.FrameRateLimit = int(cfg.FrameRateLimit + 0.5) //nolint:mnd
+	req.RateControl.EncodingInterval = max(cfg.encodingInterval, 1)
+	req.RateControl.BitrateLimit = cfg.BitrateLimit
+	switch cfg.Encoding {
+	case "H264":
+		req.H264 = &struct {
+			GovLength   int    `xml:"onvif:GovLength"`
+			H264Profile string `xml:"onvif:H264Profile"`
+		}{cfg.GovLength, cfg.Profile}
+	case "MPEG4":
+		req.MPEG4 = &struct {
+			GovLength    int    `xml:"onvif:GovLength"`
+			Mpeg4Profile string `xml:"onvif:Mpeg4Profile"`
+		}{cfg.GovLength, cfg.Profile}
+	case "JPEG":
+	default:
+		return req, fmt.Errorf("the legacy media service doesn't support %s encoding", cfg.Encoding)
+	}
+	return req, nil
+}
+
+// videoEncoder2Configuration is a Media2 video encoder configuration in a response.
+type videoEncoder2Configuration struct {
+	Token      string `xml:"token,attr"`
+	GovLength  int    `xml:"GovLength,attr"`
+	Profile    string `xml:"Profile,attr"`
+	Name       string `xml:"Name"`
+	UseCount   int    `xml:"UseCount"`
+	Encoding   string `xml:"Encoding"`
+	Resolution struct {
+		Width  int `xml:"Width"`
+		Height int `xml:"Height"`
+	} `xml:"Resolution"`
+	RateControl struct {
+		FrameRateLimit float64 `xml:"FrameRateLimit"`
+		BitrateLimit   int     `xml:"BitrateLimit"`
+	} `xml:"RateControl"`
+	Multicast *multicastConfiguration `xml:"Multicast"`
+	Quality   float64                 `xml:"Quality"`
+}
+
+func (c videoEncoder2Configuration) toConfig() VideoEncoderConfig {
+	cfg := VideoEncoderConfig{
+		Token:          strings.TrimSpace(c.Token),
+		Name:           strings.TrimSpace(c.Name),
+		UseCount:       c.UseCount,
+		Encoding:       string(media2Encoding(strings.TrimSpace(c.Encoding))),
+		Width:          c.Resolution.Width,
+		Height:         c.Resolution.Height,
+		Quality:        c.Quality,
+		FrameRateLimit: c.RateControl.FrameRateLimit,
+		BitrateLimit:   c.RateControl.BitrateLimit,
+		GovLength:      c.GovLength,
+		Profile:        strings.TrimSpace(c.Profile),
+	}
+	if c.Multicast != nil {
+		cfg.multicast = *c.Multicast
+	}
+	return cfg
+}
+
+type videoEncoder2ConfigurationRequest struct {
+	Token       string            `xml:"token,attr"`
+	GovLength   int               `xml:"GovLength,attr,omitempty"`
+	Profile     string            `xml:"Profile,attr,omitempty"`
+	Name        string            `xml:"onvif:Name"`
+	UseCount    int               `xml:"onvif:UseCount"`
+	Encoding    string            `xml:"onvif:Encoding"`
+	Resolution  resolutionRequest `xml:"onvif:Resolution"`
+	RateControl struct {
+		FrameRateLimit float64 `xml:"onvif:FrameRateLimit"`
+		BitrateLimit   int     `xml:"onvif:BitrateLimit"`
+	} `xml:"onvif:RateControl"`
+	Multicast *multicastRequest `xml:"onvif:Multicast,omitempty"`
+	Quality   float64           `xml:"onvif:Quality"`
+}
+
+func (cfg VideoEncoderConfig) media2Request() videoEncoder2ConfigurationRequest {
+	req := videoEncoder2ConfigurationRequest{
+		Token:      cfg.Token,
+		GovLength:  cfg.GovLength,
+		Profile:    cfg.Profile,
+		Name:       cfg.Name,
+		UseCount:   cfg.UseCount,
+		Encoding:   cfg.Encoding,
+		Resolution: resolutionRequest{Width: cfg.Width, Height: cfg.Height},
+		Quality:    cfg.Quality,
+	}
+	// multicast is optional in Media2, so it is only sent back when the device reported it
+	if cfg.multicast.Address.Type != "" {
+		multicast := cfg.multicast.request()
+		req.Multicast = &multicast
+	}
+	req.RateControl.FrameRateLimit = cfg.FrameRateLimit
+	req.RateControl.BitrateLimit = cfg.BitrateLimit
+	return req
+}
+
+type getVideoEncoderConfigurationsResponse struct {
+	Body struct {
+		GetVideoEncoderConfigurationsResponse struct {
+			Configurations []videoEncoderConfiguration `xml:"Configurations"`
+		} `xml:"GetVideoEncoderConfigurationsResponse"`
+		GetVideoEncoderConfigurationResponse struct {
+			Configuration *videoEncoderConfiguration `xml:"Configuration"`
+		} `xml:"GetVideoEncoderConfigurationResponse"`
+	} `xml:"Body"`
+}
+
+type getVideoEncoderConfigurations2Response struct {
+	Body struct {
+		GetVideoEncoderConfigurationsResponse struct {
+			Configurations []videoEncoder2Configuration `xml:"Configurations"`
+		} `xml:"GetVideoEncoderConfigurationsResponse"`
+	} `xml:"Body"`
+}
+
+type intRange struct {
+	Min int `xml:"Min"`
+	Max int `xml:"Max"`
+}
+
+type floatRange struct {
+	Min float64 `xml:"Min"`
+	Max float64 `xml:"Max"`
+}
+
+type resolution struct {
+	Width  int `xml:"Width"`
+	Height int `xml:"Height"`
+}
+
+// legacyEncodingOptions are the options for one encoding in a legacy Media options response.
+type legacyEncodingOptions struct {
+	ResolutionsAvailable   []resolution `xml:"ResolutionsAvailable"`
+	GovLengthRange         intRange     `xml:"GovLengthRange"`
+	FrameRateRange         intRange     `xml:"FrameRateRange"`
+	EncodingIntervalRange  intRange     `xml:"EncodingIntervalRange"`
+	H264ProfilesSupported  []string     `xml:"H264ProfilesSupported"`
+	Mpeg4ProfilesSupported []string     `xml:"Mpeg4ProfilesSupported"`
+	BitrateRange           intRange     `xml:"BitrateRange"`
+}
+
+type getVideoEncoderConfigurationOptionsResponse struct {
+	Body struct {
+		GetVideoEncoderConfigurationOptionsResponse struct {
+			Options struct {
+				QualityRange floatRange             `xml:"QualityRange"`
+				JPEG         *legacyEncodingOptions `xml:"JPEG"`
+				MPEG4        *legacyEncodingOptions `xml:"MPEG4"`
+				H264         *legacyEncodingOptions `xml:"H264"`
+				Extension    struct {
+					JPEG  *legacyEncodingOptions `xml:"JPEG"`
+					MPEG4 *legacyEncodingOptions `xml:"MPEG4"`
+					H264  *legacyEncodingOptions `xml:"H264"`
+				} `xml:"Extension"`
+			} `xml:"Options"`
+		} `xml:"GetVideoEncoderConfigurationOptionsResponse"`
+	} `xml:"Body"`
+}
+
+type getVideoEncoderConfigurationOptions2Response struct {
+	Body struct {
+		GetVideoEncoderConfigurationOptionsResponse struct {
+			Options []struct {
+				GovLengthRange       string       `xml:"GovLengthRange,attr"`
+				FrameRatesSupported  string       `xml:"FrameRatesSupported,attr"`
+				ProfilesSupported    string       `xml:"ProfilesSupported,attr"`
+				Encoding             string       `xml:"Encoding"`
+				QualityRange         floatRange   `xml:"QualityRange"`
+				ResolutionsAvailable []resolution `xml:"ResolutionsAvailable"`
+				BitrateRange         intRange     `xml:"BitrateRange"`
+			} `xml:"Options"`
+		} `xml:"GetVideoEncoderConfigurationOptionsResponse"`
+	} `xml:"Body"`
+}
+
+func resolutions(rs []resolution) []onvif.VideoResolution {
+	ret := make([]onvif.VideoResolution, 0, len(rs))
+	for _, r := range rs {
+		ret = append(ret, onvif.VideoResolution{Width: xsd.Int(r.Width), Height: xsd.Int(r.Height)})
+	}
+	return ret
+}
+
+func legacyOptions(encoding string, quality floatRange, opts, ext *legacyEncodingOptions) VideoEncoderOptions {
+	ret := VideoEncoderOptions{
+		Encoding:     encoding,
+		Resolutions:  resolutions(opts.ResolutionsAvailable),
+		QualityMin:   quality.Min,
+		QualityMax:   quality.Max,
+		FrameRateMin: float64(opts.FrameRateRange.Min),
+		FrameRateMax: float64(opts.FrameRateRange.Max),
+		GovLengthMin: opts.GovLengthRange.Min,
+		GovLengthMax: opts.GovLengthRange.Max,
+	}
+	for _, p := range append(opts.H264ProfilesSupported, opts.Mpeg4ProfilesSupported...) {
+		ret.Profiles = append(ret.Profiles, strings.TrimSpace(p))
+	}
+	// bitrate ranges are only in the options extension
+	if ext != nil {
+		ret.BitrateMin, ret.BitrateMax = ext.BitrateRange.Min, ext.BitrateRange.Max
+	}
+	return ret
+}
+
+// parseNumberList parses a space separated list of numbers, as Media2 options attributes are.
+func parseNumberList(s string) []float64 {
+	var ret []float64
+	for _, field := range strings.Fields(s) {
+		if f, err := strconv.ParseFloat(field, 64); err == nil {
+			ret = append(ret, f)
+		}
+	}
+	return ret
+}
+
+// GetVideoEncoderConfigurations returns the device's video encoder configurations.
+func (dev *Device) GetVideoEncoderConfigurations(ctx context.Context) ([]VideoEncoderConfig, error) {
+	return dev.getVideoEncoderConfigurations(ctx, "")
+}
+
+// GetVideoEncoderConfiguration returns the video encoder configuration with the given token.
+func (dev *Device) GetVideoEncoderConfiguration(ctx context.Context, token string) (VideoEncoderConfig, error) {
+	configs, err := dev.getVideoEncoderConfigurations(ctx, token)
+	if err != nil {
+		return VideoEncoderConfig{}, err
+	}
+	for _, cfg := range configs {
+		if cfg.Token == token {
+			return cfg, nil
+		}
+	}
+	return VideoEncoderConfig{}, fmt.Errorf("no video encoder configuration with token %q", token)
+}
+
+func (dev *Device) getVideoEncoderConfigurations(ctx context.Context, token string) ([]VideoEncoderConfig, error) {
+	var media2Err error
+	if dev.SupportsMedia2(ctx) {
+		configs, err := dev.getVideoEncoderConfigurations2(ctx, token)
+		if err == nil || dev.endpoints["media"] == "" {
+			return configs, err
+		}
+		media2Err = err
+	}
+
+	var method interface{} = GetVideoEncoderConfigurations{}
+	if token != "" {
+		method = GetVideoEncoderConfiguration{ConfigurationToken: onvif.ReferenceToken(token)}
+	}
+	b, err := dev.callMedia(ctx, method)
+	if err != nil {
+		return nil, errors.Join(media2Err, fmt.Errorf("failed to get video encoder configurations: %w", err))
+	}
+	if media2Err != nil {
+		dev.logger.Warnf("using the legacy Media service, which doesn't list H265 encoders, since Media2 failed: %v", media2Err)
+	}
+	dev.logger.Debugf("GetVideoEncoderConfigurations response body: %s", b)
+	var resp getVideoEncoderConfigurationsResponse
+	if err := decodeDeviceResponse(b, &resp, "GetVideoEncoderConfigurations"); err != nil {
+		return nil, err
+	}
+	configs := resp.Body.GetVideoEncoderConfigurationsResponse.Configurations
+	if c := resp.Body.GetVideoEncoderConfigurationResponse.Configuration; c != nil {
+		configs = append(configs, *c)
+	}
+	ret := make([]VideoEncoderConfig, 0, len(configs))
+	for _, c := range configs {
+		ret = append(ret, c.toConfig())
+	}
+	return ret, nil
+}
+
+func (dev *Device) getVideoEncoderConfigurations2(ctx context.Context, token string) ([]VideoEncoderConfig, error) {
+	b, err := dev.callMedia2(ctx, GetVideoEncoderConfigurations2{ConfigurationToken: onvif.ReferenceToken(token)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get media2 video encoder configurations: %w", err)
+	}
+	dev.logger.Debugf("Media2 GetVideoEncoderConfigurations response body: %s", b)
+	var resp getVideoEncoderConfigurations2Response
+	if err := decodeDeviceResponse(b, &resp, "media2 GetVideoEncoderConfigurations"); err != nil {
+		return nil, err
+	}
+	configs := resp.Body.GetVideoEncoderConfigurationsResponse.Configurations
+	ret := make([]VideoEncoderConfig, 0, len(configs))
+	for _, c := range configs {
+		ret = append(ret, c.toConfig())
+	}
+	return ret, nil
+}
+
+// GetVideoEncoderConfigurationOptions returns the settings the video encoder configuration with the
+// given token supports, one entry per encoding.
+func (dev *Device) GetVideoEncoderConfigurationOptions(ctx context.Context, token string) ([]VideoEncoderOptions, error) {
+	var media2Err error
+	if dev.SupportsMedia2(ctx) {
+		opts, err := dev.getVideoEncoderConfigurationOptions2(ctx, token)
+		if err == nil || dev.endpoints["media"] == "" {
+			return opts, err
+		}
+		media2Err = err
+	}
+
+	b, err := dev.callMedia(ctx, GetVideoEncoderConfigurationOptions{ConfigurationToken: onvif.ReferenceToken(token)})
+	if err != nil {
+		return nil, errors.Join(media2Err, fmt.Errorf("failed to get video encoder configuration options: %w", err))
+	}
+	if media2Err != nil {
+		dev.logger.Warnf("using the legacy Media service, which doesn't list H265 options, since Media2 failed: %v", media2Err)
+	}
+	dev.logger.Debugf("GetVideoEncoderConfigurationOptions response body: %s", b)
+	var resp getVideoEncoderConfigurationOptionsResponse
+	if err := decodeDeviceResponse(b, &resp, "GetVideoEncoderConfigurationOptions"); err != nil {
+		return nil, err
+	}
+	opts := resp.Body.GetVideoEncoderConfigurationOptionsResponse.Options
+	var ret []VideoEncoderOptions
+	if opts.H264 != nil {
+		ret = append(ret, legacyOptions("H264", opts.QualityRange, opts.H264, opts.Extension.H264))
+	}
+	if opts.MPEG4 != nil {
+		ret = append(ret, legacyOptions("MPEG4", opts.QualityRange, opts.MPEG4, opts.Extension.MPEG4))
+	}
+	if opts.JPEG != nil {
+		ret = append(ret, legacyOptions("JPEG", opts.QualityRange, opts.JPEG, opts.Extension.JPEG))
+	}
+	return ret, nil
+}
+
+func (dev *Device) getVideoEncoderConfigurationOptions2(ctx context.Context, token string) ([]VideoEncoderOptions, error) {
+	b, err := dev.callMedia2(ctx, GetVideoEncoderConfigurationOptions2{ConfigurationToken: onvif.ReferenceToken(token)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get media2 video encoder configuration options: %w", err)
+	}
+	dev.logger.Debugf("Media2 GetVideoEncoderConfigurationOptions response body: %s", b)
+	var resp getVideoEncoderConfigurationOptions2Response
+	if err := decodeDeviceResponse(b, &resp, "media2 GetVideoEncoderConfigurationOptions"); err != nil {
+		return nil, err
+	}
+	var ret []VideoEncoderOptions
+	for _, o := range resp.Body.GetVideoEncoderConfigurationOptionsResponse.Options {
+		opts := VideoEncoderOptions{
+			Encoding:    string(media2Encoding(strings.TrimSpace(o.Encoding))),
+			Resolutions: resolutions(o.ResolutionsAvailable),
+			QualityMin:  o.QualityRange.Min,
+			QualityMax:  o.QualityRange.Max,
+			FrameRates:  parseNumberList(o.FrameRatesSupported),
+			BitrateMin:  o.BitrateRange.Min,
+			BitrateMax:  o.BitrateRange.Max,
+			Profiles:    strings.Fields(o.ProfilesSupported),
+		}
+		if len(opts.FrameRates) > 0 {
+			opts.FrameRateMin, opts.FrameRateMax = slices.Min(opts.FrameRates), slices.Max(opts.FrameRates)
+		}
+		//nolint:mnd
+		if gov := parseNumberList(o.GovLengthRange); len(gov) == 2 {
+			opts.GovLengthMin, opts.GovLengthMax = int(gov[0]), int(gov[1])
+		}
+		ret = append(ret, opts)
+	}
+	return ret, nil
+}
+
+// SetVideoEncoderConfiguration changes a video encoder configuration. cfg should be a configuration
+// returned by GetVideoEncoderConfiguration with the settings to change updated. Legacy Media devices
+// keep the change across reboots.
+func (dev *Device) SetVideoEncoderConfiguration(ctx context.Context, cfg VideoEncoderConfig) error {
+	if cfg.Token == "" {
+		return errors.New("video encoder configuration has no token")
+	}
+	var media2Err error
+	if dev.SupportsMedia2(ctx) {
+		_, err := dev.callMedia2(ctx, SetVideoEncoderConfiguration2{Configuration: cfg.media2Request()})
+		if err == nil {
+			return nil
+		}
+		media2Err = fmt.Errorf("failed to set media2 video encoder configuration %s: %w", cfg.Token, err)
+		if dev.endpoints["media"] == "" {
+			return media2Err
+		}
+	}
+	req, err := cfg.legacyRequest()
+	if err != nil {
+		// the legacy Media service can't represent the configuration, such as H265, so only Media2's
+		// error says what went wrong
+		if media2Err != nil {
+			return media2Err
+		}
+		return err
+	}
+	if _, err := dev.callMedia(ctx, SetVideoEncoderConfiguration{Configuration: req, ForcePersistence: true}); err != nil {
+		return errors.Join(media2Err, fmt.Errorf("failed to set video encoder configuration %s: %w", cfg.Token, err))
+	}
+	if media2Err != nil {
+		dev.logger.Warnf("set video encoder configuration %s with the legacy Media service since Media2 failed: %v", cfg.Token, media2Err)
+	}
+	return nil
+}
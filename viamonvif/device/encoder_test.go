@@ -0,0 +1,245 @@
+package device
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+const legacyEncoderConfiguration = `<trt:Configuration token="VideoEncoderToken_1">
+	<tt:Name>VideoEncoder_1</tt:Name>
+	<tt:UseCount>1</tt:UseCount>
+	<tt:Encoding>H264</tt:Encoding>
+	<tt:Resolution><tt:Width>1920</tt:Width><tt:Height>1080</tt:Height></tt:Resolution>
+	<tt:Quality>4</tt:Quality>
+	<tt:RateControl>
+		<tt:FrameRateLimit>25</tt:FrameRateLimit>
+		<tt:EncodingInterval>1</tt:EncodingInterval>
+		<tt:BitrateLimit>4096</tt:BitrateLimit>
+	</tt:RateControl>
+	<tt:H264><tt:GovLength>50</tt:GovLength><tt:H264Profile>Main</tt:H264Profile></tt:H264>
+	<tt:Multicast>
+		<tt:Address><tt:Type>IPv4</tt:Type><tt:IPv4Address>239.0.0.1</tt:IPv4Address></tt:Address>
+		<tt:Port>8600</tt:Port><tt:TTL>128</tt:TTL><tt:AutoStart>false</tt:AutoStart>
+	</tt:Multicast>
+	<tt:SessionTimeout>PT5S</tt:SessionTimeout>
+</trt:Configuration>`
+
+const legacyEncoderOptions = `<trt:GetVideoEncoderConfigurationOptionsResponse><trt:Options>
+	<tt:QualityRange><tt:Min>1</tt:Min><tt:Max>6</tt:Max></tt:QualityRange>
+	<tt:JPEG>
+		<tt:ResolutionsAvailable><tt:Width>640</tt:Width><tt:Height>480</tt:Height></tt:ResolutionsAvailable>
+		<tt:FrameRateRange><tt:Min>1</tt:Min><tt:Max>15</tt:Max></tt:FrameRateRange>
+	</tt:JPEG>
+	<tt:H264>
+		<tt:ResolutionsAvailable><tt:Width>1920</tt:Width><tt:Height>1080</tt:Height></tt:ResolutionsAvailable>
+		<tt:ResolutionsAvailable><tt:Width>1280</tt:Width><tt:Height>720</tt:Height></tt:ResolutionsAvailable>
+		<tt:GovLengthRange><tt:Min>1</tt:Min><tt:Max>400</tt:Max></tt:GovLengthRange>
+		<tt:FrameRateRange><tt:Min>1</tt:Min><tt:Max>30</tt:Max></tt:FrameRateRange>
+		<tt:EncodingIntervalRange><tt:Min>1</tt:Min><tt:Max>1</tt:Max></tt:EncodingIntervalRange>
+		<tt:H264ProfilesSupported>Baseline</tt:H264ProfilesSupported>
+		<tt:H264ProfilesSupported>Main</tt:H264ProfilesSupported>
+	</tt:H264>
+	<tt:Extension>
+		<tt:H264><tt:BitrateRange><tt:Min>32</tt:Min><tt:Max>16384</tt:Max></tt:BitrateRange></tt:H264>
+	</tt:Extension>
+</trt:Options></trt:GetVideoEncoderConfigurationOptionsResponse>`
+
+const media2EncoderConfigurations = `<tr2:GetVideoEncoderConfigurationsResponse>
+	<tr2:Configurations token="VideoEncoderToken_1" GovLength="50" Profile="Main">
+		<tt:Name>VideoEncoder_1</tt:Name>
+		<tt:UseCount>1</tt:UseCount>
+		<tt:Encoding>H265</tt:Encoding>
+		<tt:Resolution><tt:Width>3840</tt:Width><tt:Height>2160</tt:Height></tt:Resolution>
+		<tt:RateControl ConstantBitRate="false">
+			<tt:FrameRateLimit>12.5</tt:FrameRateLimit>
+			<tt:BitrateLimit>8192</tt:BitrateLimit>
+		</tt:RateControl>
+		<tt:Quality>3</tt:Quality>
+	</tr2:Configurations>
+</tr2:GetVideoEncoderConfigurationsResponse>`
+
+const media2EncoderOptions = `<tr2:GetVideoEncoderConfigurationOptionsResponse>
+	<tr2:Options GovLengthRange="1 200" FrameRatesSupported="25 12.5 6.25" ProfilesSupported="Main">
+		<tt:Encoding>H265</tt:Encoding>
+		<tt:QualityRange><tt:Min>0</tt:Min><tt:Max>5</tt:Max></tt:QualityRange>
+		<tt:ResolutionsAvailable><tt:Width>3840</tt:Width><tt:Height>2160</tt:Height></tt:ResolutionsAvailable>
+		<tt:BitrateRange><tt:Min>256</tt:Min><tt:Max>16384</tt:Max></tt:BitrateRange>
+	</tr2:Options>
+	<tr2:Options GovLengthRange="1 400" FrameRatesSupported="25" ProfilesSupported="Baseline Main High">
+		<tt:Encoding>H264</tt:Encoding>
+		<tt:QualityRange><tt:Min>0</tt:Min><tt:Max>5</tt:Max></tt:QualityRange>
+	</tr2:Options>
+</tr2:GetVideoEncoderConfigurationOptionsResponse>`
+
+// newEncoderTestServer serves the video encoder endpoints of the legacy Media service, and of Media2
+// when withMedia2 is set.
+func newEncoderTestServer(t *testing.T, withMedia2 bool, requests *[]string) *httptest.Server {
+	var services map[string]string
+	if withMedia2 {
+		services = map[string]string{"http://www.onvif.org/ver20/media/wsdl": "/media2"}
+	}
+	return newFakeDevice(t, requests, services,
+		fakeOperation{"trt:GetVideoEncoderConfigurations", soapResponse(`<trt:GetVideoEncoderConfigurationsResponse>` +
+			strings.ReplaceAll(legacyEncoderConfiguration, "trt:Configuration", "trt:Configurations") +
+			`</trt:GetVideoEncoderConfigurationsResponse>`)},
+		fakeOperation{"trt:GetVideoEncoderConfigurationOptions", soapResponse(legacyEncoderOptions)},
+		fakeOperation{"trt:GetVideoEncoderConfiguration", soapResponse(`<trt:GetVideoEncoderConfigurationResponse>` +
+			legacyEncoderConfiguration + `</trt:GetVideoEncoderConfigurationResponse>`)},
+		fakeOperation{"trt:SetVideoEncoderConfiguration", soapResponse(`<trt:SetVideoEncoderConfigurationResponse/>`)},
+		fakeOperation{"tr2:GetVideoEncoderConfigurationOptions", soapResponse(media2EncoderOptions)},
+		fakeOperation{"tr2:GetVideoEncoderConfigurations", soapResponse(media2EncoderConfigurations)},
+		fakeOperation{"tr2:SetVideoEncoderConfiguration", soapResponse(`<tr2:SetVideoEncoderConfigurationResponse/>`)},
+	)
+}
+
+func TestVideoEncoderConfiguration(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	ctx := context.Background()
+
+	t.Run("legacy media", func(t *testing.T) {
+		var requests []string
+		server := newEncoderTestServer(t, false, &requests)
+		defer server.Close()
+		u, err := url.Parse(server.URL)
+		test.That(t, err, test.ShouldBeNil)
+		dev, err := NewDevice(ctx, Params{Xaddr: u, HTTPClient: &http.Client{}}, logger)
+		test.That(t, err, test.ShouldBeNil)
+
+		configs, err := dev.GetVideoEncoderConfigurations(ctx)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(configs), test.ShouldEqual, 1)
+
+		cfg, err := dev.GetVideoEncoderConfiguration(ctx, "VideoEncoderToken_1")
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, cfg.Name, test.ShouldEqual, "VideoEncoder_1")
+		test.That(t, cfg.Encoding, test.ShouldEqual, "H264")
+		test.That(t, cfg.Width, test.ShouldEqual, 1920)
+		test.That(t, cfg.FrameRateLimit, test.ShouldEqual, 25)
+		test.That(t, cfg.BitrateLimit, test.ShouldEqual, 4096)
+		test.That(t, cfg.GovLength, test.ShouldEqual, 50)
+		test.That(t, cfg.Profile, test.ShouldEqual, "Main")
+
+		opts, err := dev.GetVideoEncoderConfigurationOptions(ctx, cfg.Token)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(opts), test.ShouldEqual, 2)
+		h264 := opts[0]
+		test.That(t, h264.Encoding, test.ShouldEqual, "H264")
+		test.That(t, len(h264.Resolutions), test.ShouldEqual, 2)
+		test.That(t, h264.GovLengthMax, test.ShouldEqual, 400)
+		test.That(t, h264.FrameRateMax, test.ShouldEqual, 30)
+		test.That(t, h264.BitrateMin, test.ShouldEqual, 32)
+		test.That(t, h264.BitrateMax, test.ShouldEqual, 16384)
+		test.That(t, h264.QualityMax, test.ShouldEqual, 6)
+		test.That(t, h264.Profiles, test.ShouldResemble, []string{"Baseline", "Main"})
+		test.That(t, opts[1].Encoding, test.ShouldEqual, "JPEG")
+		test.That(t, opts[1].BitrateMax, test.ShouldEqual, 0)
+
+		requests = nil
+		cfg.GovLength = 25
+		cfg.BitrateLimit = 2048
+		test.That(t, dev.SetVideoEncoderConfiguration(ctx, cfg), test.ShouldBeNil)
+		test.That(t, len(requests), test.ShouldEqual, 1)
+		set := requests[0]
+		test.That(t, set, test.ShouldStartWith, "/media ")
+		test.That(t, set, test.ShouldContainSubstring, `token="VideoEncoderToken_1"`)
+		test.That(t, set, test.ShouldContainSubstring, "<onvif:GovLength>25</onvif:GovLength>")
+		test.That(t, set, test.ShouldContainSubstring, "<onvif:BitrateLimit>2048</onvif:BitrateLimit>")
+		test.That(t, set, test.ShouldContainSubstring, "<onvif:H264Profile>Main</onvif:H264Profile>")
+		test.That(t, set, test.ShouldNotContainSubstring, "onvif:MPEG4")
+		// the settings that weren't changed are sent back as the device reported them
+		test.That(t, set, test.ShouldContainSubstring, "<onvif:IPv4Address>239.0.0.1</onvif:IPv4Address>")
+		test.That(t, set, test.ShouldContainSubstring, "<onvif:SessionTimeout>PT5S</onvif:SessionTimeout>")
+		test.That(t, set, test.ShouldContainSubstring, "<trt:ForcePersistence>true</trt:ForcePersistence>")
+
+		cfg.Encoding = "H265"
+		test.That(t, dev.SetVideoEncoderConfiguration(ctx, cfg), test.ShouldNotBeNil)
+	})
+
+	t.Run("media2", func(t *testing.T) {
+		var requests []string
+		server := newEncoderTestServer(t, true, &requests)
+		defer server.Close()
+		u, err := url.Parse(server.URL)
+		test.That(t, err, test.ShouldBeNil)
+		dev, err := NewDevice(ctx, Params{Xaddr: u, HTTPClient: &http.Client{}}, logger)
+		test.That(t, err, test.ShouldBeNil)
+
+		cfg, err := dev.GetVideoEncoderConfiguration(ctx, "VideoEncoderToken_1")
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, requests[0], test.ShouldContainSubstring, "<tr2:ConfigurationToken>VideoEncoderToken_1</tr2:ConfigurationToken>")
+		test.That(t, cfg.Encoding, test.ShouldEqual, "H265")
+		test.That(t, cfg.FrameRateLimit, test.ShouldEqual, 12.5)
+		test.That(t, cfg.GovLength, test.ShouldEqual, 50)
+		test.That(t, cfg.Profile, test.ShouldEqual, "Main")
+
+		opts, err := dev.GetVideoEncoderConfigurationOptions(ctx, cfg.Token)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(opts), test.ShouldEqual, 2)
+		test.That(t, opts[0].Encoding, test.ShouldEqual, "H265")
+		test.That(t, opts[0].FrameRates, test.ShouldResemble, []float64{25, 12.5, 6.25})
+		test.That(t, opts[0].FrameRateMin, test.ShouldEqual, 6.25)
+		test.That(t, opts[0].GovLengthMax, test.ShouldEqual, 200)
+		test.That(t, opts[0].BitrateMin, test.ShouldEqual, 256)
+		test.That(t, opts[1].Profiles, test.ShouldResemble, []string{"Baseline", "Main", "High"})
+
+		requests = nil
+		cfg.GovLength = 25
+		test.That(t, dev.SetVideoEncoderConfiguration(ctx, cfg), test.ShouldBeNil)
+		test.That(t, len(requests), test.ShouldEqual, 1)
+		test.That(t, requests[0], test.ShouldStartWith, "/media2 ")
+		test.That(t, requests[0], test.ShouldContainSubstring, `GovLength="25"`)
+		test.That(t, requests[0], test.ShouldContainSubstring, "<onvif:FrameRateLimit>12.5</onvif:FrameRateLimit>")
+		// multicast wasn't reported, so it isn't sent
+		test.That(t, requests[0], test.ShouldNotContainSubstring, "Multicast")
+	})
+	t.Run("media2 errors are not hidden by the legacy fallback", func(t *testing.T) {
+		var requests []string
+		server := newEncoderTestServer(t, true, &requests)
+		defer server.Close()
+		failLegacy := false
+		handler := server.Config.Handler
+		server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/media2" || (failLegacy && r.URL.Path == "/media") {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			handler.ServeHTTP(w, r)
+		})
+		u, err := url.Parse(server.URL)
+		test.That(t, err, test.ShouldBeNil)
+		dev, err := NewDevice(ctx, Params{Xaddr: u, HTTPClient: &http.Client{}}, logger)
+		test.That(t, err, test.ShouldBeNil)
+
+		// H265 can't be set with the legacy Media service, so it isn't tried
+		err = dev.SetVideoEncoderConfiguration(ctx, VideoEncoderConfig{Token: "VideoEncoderToken_1", Encoding: "H265"})
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "failed to set media2 video encoder configuration")
+		test.That(t, err.Error(), test.ShouldContainSubstring, "status code: 500")
+		test.That(t, requests, test.ShouldBeEmpty)
+
+		// H264 falls back
+		test.That(t, dev.SetVideoEncoderConfiguration(ctx, VideoEncoderConfig{Token: "VideoEncoderToken_1", Encoding: "H264"}),
+			test.ShouldBeNil)
+		test.That(t, len(requests), test.ShouldEqual, 1)
+		test.That(t, requests[0], test.ShouldStartWith, "/media ")
+
+		// both errors are returned when the fallback fails too
+		failLegacy = true
+		err = dev.SetVideoEncoderConfiguration(ctx, VideoEncoderConfig{Token: "VideoEncoderToken_1", Encoding: "H264"})
+		test.That(t, err.Error(), test.ShouldContainSubstring, "failed to set media2 video encoder configuration")
+		test.That(t, err.Error(), test.ShouldContainSubstring, "failed to set video encoder configuration")
+		_, err = dev.GetVideoEncoderConfigurations(ctx)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "failed to get media2 video encoder configurations")
+		test.That(t, err.Error(), test.ShouldContainSubstring, "failed to get video encoder configurations")
+		_, err = dev.GetVideoEncoderConfigurationOptions(ctx, "VideoEncoderToken_1")
+		test.That(t, err.Error(), test.ShouldContainSubstring, "failed to get media2 video encoder configuration options")
+		test.That(t, err.Error(), test.ShouldContainSubstring, "failed to get video encoder configuration options")
+	})
+}
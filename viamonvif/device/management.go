@@ -0,0 +1,374 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/viam-modules/viamrtsp/viamonvif/xsd"
+	"github.com/viam-modules/viamrtsp/viamonvif/xsd/onvif"
+)
+
+const (
+	// DateTimeTypeManual means the device clock is set with SetSystemDateAndTime.
+	DateTimeTypeManual = "Manual"
+	// DateTimeTypeNTP means the device clock is set from its NTP servers.
+	DateTimeTypeNTP = "NTP"
+
+	// SystemLogTypeSystem is the device's system log.
+	SystemLogTypeSystem = "System"
+	// SystemLogTypeAccess is the device's access log.
+	SystemLogTypeAccess = "Access"
+)
+
+// SystemReboot is a request to the SystemReboot onvif endpoint.
+type SystemReboot struct {
+	XMLName string `xml:"tds:SystemReboot"`
+}
+
+// GetSystemDateAndTime is a request to the GetSystemDateAndTime onvif endpoint.
+type GetSystemDateAndTime struct {
+	XMLName string `xml:"tds:GetSystemDateAndTime"`
+}
+
+// SetSystemDateAndTime is a request to the SetSystemDateAndTime onvif endpoint.
+type SetSystemDateAndTime struct {
+	XMLName         string          `xml:"tds:SetSystemDateAndTime"`
+	DateTimeType    string          `xml:"tds:DateTimeType"`
+	DaylightSavings bool            `xml:"tds:DaylightSavings"`
+	TimeZone        *onvif.TimeZone `xml:"tds:TimeZone,omitempty"`
+	UTCDateTime     *onvif.DateTime `xml:"tds:UTCDateTime,omitempty"`
+}
+
+// GetNTP is a request to the GetNTP onvif endpoint.
+type GetNTP struct {
+	XMLName string `xml:"tds:GetNTP"`
+}
+
+// SetNTP is a request to the SetNTP onvif endpoint.
+type SetNTP struct {
+	XMLName   string      `xml:"tds:SetNTP"`
+	FromDHCP  bool        `xml:"tds:FromDHCP"`
+	NTPManual []NTPServer `xml:"tds:NTPManual,omitempty"`
+}
+
+// NTPServer is the address of an NTP server, as a DNS name or an IP address.
+type NTPServer struct {
+	Type        string `xml:"onvif:Type"`
+	IPv4Address string `xml:"onvif:IPv4Address,omitempty"`
+	IPv6Address string `xml:"onvif:IPv6Address,omitempty"`
+	DNSname     string `xml:"onvif:DNSname,omitempty"`
+}
+
+// GetSystemLog is a request to the GetSystemLog onvif endpoint.
+type GetSystemLog struct {
+	XMLName string `xml:"tds:GetSystemLog"`
+	LogType string `xml:"tds:LogType"`
+}
+
+// GetUsers is a request to the GetUsers onvif endpoint.
+type GetUsers struct {
+	XMLName string `xml:"tds:GetUsers"`
+}
+
+// NewNTPServer returns the NTPServer for an IP address or DNS name.
+func NewNTPServer(server string) NTPServer {
+	ip := net.ParseIP(server)
+	switch {
+	case ip == nil:
+		return NTPServer{Type: "DNS", DNSname: server}
+	case ip.To4() != nil:
+		return NTPServer{Type: "IPv4", IPv4Address: server}
+	default:
+		return NTPServer{Type: "IPv6", IPv6Address: server}
+	}
+}
+
+// dateTime is an onvif DateTime in a response, whose elements aren't prefixed the way requests are.
+type dateTime struct {
+	Time struct {
+		Hour   int `xml:"Hour"`
+		Minute int `xml:"Minute"`
+		Second int `xml:"Second"`
+	} `xml:"Time"`
+	Date struct {
+		Year  int `xml:"Year"`
+		Month int `xml:"Month"`
+		Day   int `xml:"Day"`
+	} `xml:"Date"`
+}
+
+func (dt *dateTime) toTime(loc *time.Location) time.Time {
+	if dt == nil || dt.Date.Year == 0 {
+		return time.Time{}
+	}
+	return time.Date(dt.Date.Year, time.Month(dt.Date.Month), dt.Date.Day,
+		dt.Time.Hour, dt.Time.Minute, dt.Time.Second, 0, loc)
+}
+
+type getSystemDateAndTimeResponse struct {
+	XMLName xml.Name `xml:"Envelope"`
+	Body    struct {
+		GetSystemDateAndTimeResponse struct {
+			SystemDateAndTime struct {
+				DateTimeType    string `xml:"DateTimeType"`
+				DaylightSavings bool   `xml:"DaylightSavings"`
+				TimeZone        struct {
+					TZ string `xml:"TZ"`
+				} `xml:"TimeZone"`
+				UTCDateTime   *dateTime `xml:"UTCDateTime"`
+				LocalDateTime *dateTime `xml:"LocalDateTime"`
+			} `xml:"SystemDateAndTime"`
+		} `xml:"GetSystemDateAndTimeResponse"`
+	} `xml:"Body"`
+}
+
+// SystemDateAndTime is a device's clock and how it is set.
+type SystemDateAndTime struct {
+	DateTimeType    string
+	DaylightSavings bool
+	// TimeZone is a POSIX TZ string, such as CST6CDT,M3.2.0,M11.1.0.
+	TimeZone string
+	// UTC is the device's time, which is zero if the device doesn't report its UTC time.
+	UTC time.Time
+	// Local is the device's local time, with its offset from UTC unknown.
+	Local time.Time
+}
+
+type getNTPResponse struct {
+	XMLName xml.Name `xml:"Envelope"`
+	Body    struct {
+		GetNTPResponse struct {
+			NTPInformation struct {
+				FromDHCP    bool        `xml:"FromDHCP"`
+				NTPFromDHCP []ntpServer `xml:"NTPFromDHCP"`
+				NTPManual   []ntpServer `xml:"NTPManual"`
+			} `xml:"NTPInformation"`
+		} `xml:"GetNTPResponse"`
+	} `xml:"Body"`
+}
+
+// NTPInformation is where a device gets its NTP servers from.
+type NTPInformation struct {
+	FromDHCP bool
+	// Servers are the servers in use, from DHCP if FromDHCP is set and the ones set with SetNTP otherwise.
+	Servers []string
+}
+
+// ntpServer is an NTPServer in a response.
+type ntpServer struct {
+	Type        string `xml:"Type"`
+	IPv4Address string `xml:"IPv4Address"`
+	IPv6Address string `xml:"IPv6Address"`
+	DNSname     string `xml:"DNSname"`
+}
+
+func (s ntpServer) address() string {
+	for _, addr := range []string{s.DNSname, s.IPv4Address, s.IPv6Address} {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			return addr
+		}
+	}
+	return ""
+}
+
+type systemRebootResponse struct {
+	XMLName xml.Name `xml:"Envelope"`
+	Body    struct {
+		SystemRebootResponse struct {
+			Message string `xml:"Message"`
+		} `xml:"SystemRebootResponse"`
+	} `xml:"Body"`
+}
+
+type getSystemLogResponse struct {
+	XMLName xml.Name `xml:"Envelope"`
+	Body    struct {
+		GetSystemLogResponse struct {
+			SystemLog struct {
+				String string `xml:"String"`
+				Binary *struct {
+					ContentType string `xml:"contentType,attr"`
+				} `xml:"Binary"`
+			} `xml:"SystemLog"`
+		} `xml:"GetSystemLogResponse"`
+	} `xml:"Body"`
+}
+
+type getUsersResponse struct {
+	XMLName xml.Name `xml:"Envelope"`
+	Body    struct {
+		GetUsersResponse struct {
+			User []User `xml:"User"`
+		} `xml:"GetUsersResponse"`
+	} `xml:"Body"`
+}
+
+// User is a device user account. Devices never return passwords, so none is read.
+type User struct {
+	Username  string `xml:"Username" json:"username"`
+	UserLevel string `xml:"UserLevel" json:"user_level"`
+}
+
+func decodeDeviceResponse(b []byte, resp interface{}, method string) error {
+	if err := xml.NewDecoder(bytes.NewReader(b)).Decode(resp); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", method, err)
+	}
+	return nil
+}
+
+// SystemReboot reboots the device and returns the message it responds with, which usually says
+// how long the reboot will take.
+func (dev *Device) SystemReboot(ctx context.Context) (string, error) {
+	b, err := dev.callDevice(ctx, SystemReboot{})
+	if err != nil {
+		return "", fmt.Errorf("failed to reboot device: %w", err)
+	}
+	var resp systemRebootResponse
+	if err := decodeDeviceResponse(b, &resp, "SystemReboot"); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(resp.Body.SystemRebootResponse.Message), nil
+}
+
+// GetSystemDateAndTime returns the device's clock. Devices must answer this without credentials,
+// since clients need the device's time to authenticate with WS-Security.
+func (dev *Device) GetSystemDateAndTime(ctx context.Context) (SystemDateAndTime, error) {
+	var zero SystemDateAndTime
+	b, err := dev.callDevice(ctx, GetSystemDateAndTime{})
+	if err != nil {
+		return zero, fmt.Errorf("failed to get system date and time: %w", err)
+	}
+	dev.logger.Debugf("GetSystemDateAndTime response body: %s", string(b))
+	var resp getSystemDateAndTimeResponse
+	if err := decodeDeviceResponse(b, &resp, "GetSystemDateAndTime"); err != nil {
+		return zero, err
+	}
+	sdt := resp.Body.GetSystemDateAndTimeResponse.SystemDateAndTime
+	return SystemDateAndTime{
+		DateTimeType:    strings.TrimSpace(sdt.DateTimeType),
+		DaylightSavings: sdt.DaylightSavings,
+		TimeZone:        strings.TrimSpace(sdt.TimeZone.TZ),
+		UTC:             sdt.UTCDateTime.toTime(time.UTC),
+		Local:           sdt.LocalDateTime.toTime(time.UTC),
+	}, nil
+}
+
+// SetSystemDateAndTime sets the device's clock to t, and its time zone to tz if it isn't empty.
+func (dev *Device) SetSystemDateAndTime(ctx context.Context, t time.Time, tz string, daylightSavings bool) error {
+	t = t.UTC()
+	req := SetSystemDateAndTime{
+		DateTimeType:    DateTimeTypeManual,
+		DaylightSavings: daylightSavings,
+		TimeZone:        timeZone(tz),
+		UTCDateTime: &onvif.DateTime{
+			Time: onvif.Time{Hour: xsd.Int(t.Hour()), Minute: xsd.Int(t.Minute()), Second: xsd.Int(t.Second())},
+			Date: onvif.Date{Year: xsd.Int(t.Year()), Month: xsd.Int(t.Month()), Day: xsd.Int(t.Day())},
+		},
+	}
+	if _, err := dev.callDevice(ctx, req); err != nil {
+		return fmt.Errorf("failed to set system date and time: %w", err)
+	}
+	return nil
+}
+
+// SetSystemDateAndTimeNTP has the device set its clock from its NTP servers, and sets its time zone
+// to tz if it isn't empty.
+func (dev *Device) SetSystemDateAndTimeNTP(ctx context.Context, tz string, daylightSavings bool) error {
+	req := SetSystemDateAndTime{DateTimeType: DateTimeTypeNTP, DaylightSavings: daylightSavings, TimeZone: timeZone(tz)}
+	if _, err := dev.callDevice(ctx, req); err != nil {
+		return fmt.Errorf("failed to set system date and time to ntp: %w", err)
+	}
+	return nil
+}
+
+func timeZone(tz string) *onvif.TimeZone {
+	if tz == "" {
+		return nil
+	}
+	return &onvif.TimeZone{TZ: xsd.Token(tz)}
+}
+
+// GetNTP returns the device's NTP configuration.
+func (dev *Device) GetNTP(ctx context.Context) (NTPInformation, error) {
+	var zero NTPInformation
+	b, err := dev.callDevice(ctx, GetNTP{})
+	if err != nil {
+		return zero, fmt.Errorf("failed to get ntp configuration: %w", err)
+	}
+	dev.logger.Debugf("GetNTP response body: %s", string(b))
+	var resp getNTPResponse
+	if err := decodeDeviceResponse(b, &resp, "GetNTP"); err != nil {
+		return zero, err
+	}
+	info := resp.Body.GetNTPResponse.NTPInformation
+	servers := info.NTPManual
+	if info.FromDHCP {
+		servers = info.NTPFromDHCP
+	}
+	ret := NTPInformation{FromDHCP: info.FromDHCP, Servers: []string{}}
+	for _, s := range servers {
+		if addr := s.address(); addr != "" {
+			ret.Servers = append(ret.Servers, addr)
+		}
+	}
+	return ret, nil
+}
+
+// SetNTP sets the NTP servers the device uses, which are taken from DHCP if fromDHCP is set.
+func (dev *Device) SetNTP(ctx context.Context, servers []string, fromDHCP bool) error {
+	if !fromDHCP && len(servers) == 0 {
+		return errors.New("ntp servers are required unless they are taken from dhcp")
+	}
+	req := SetNTP{FromDHCP: fromDHCP}
+	if !fromDHCP {
+		for _, s := range servers {
+			req.NTPManual = append(req.NTPManual, NewNTPServer(s))
+		}
+	}
+	if _, err := dev.callDevice(ctx, req); err != nil {
+		return fmt.Errorf("failed to set ntp configuration: %w", err)
+	}
+	return nil
+}
+
+// GetSystemLog returns the device's system or access log, logType being SystemLogTypeSystem or
+// SystemLogTypeAccess. Logs the device only returns as an attachment aren't supported.
+func (dev *Device) GetSystemLog(ctx context.Context, logType string) (string, error) {
+	b, err := dev.callDevice(ctx, GetSystemLog{LogType: logType})
+	if err != nil {
+		return "", fmt.Errorf("failed to get %s log: %w", logType, err)
+	}
+	var resp getSystemLogResponse
+	if err := decodeDeviceResponse(b, &resp, "GetSystemLog"); err != nil {
+		return "", err
+	}
+	systemLog := resp.Body.GetSystemLogResponse.SystemLog
+	if systemLog.String == "" && systemLog.Binary != nil {
+		return "", fmt.Errorf("device returned its %s log as an attachment, which is not supported", logType)
+	}
+	return systemLog.String, nil
+}
+
+// GetUsers returns the device's user accounts.
+func (dev *Device) GetUsers(ctx context.Context) ([]User, error) {
+	b, err := dev.callDevice(ctx, GetUsers{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+	var resp getUsersResponse
+	if err := decodeDeviceResponse(b, &resp, "GetUsers"); err != nil {
+		return nil, err
+	}
+	users := resp.Body.GetUsersResponse.User
+	for i := range users {
+		users[i].Username = strings.TrimSpace(users[i].Username)
+		users[i].UserLevel = strings.TrimSpace(users[i].UserLevel)
+	}
+	return users, nil
+}
@@ -0,0 +1,130 @@
+package device
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+// newManagementTestServer serves the device management endpoints.
+func newManagementTestServer(t *testing.T, requests *[]string) *httptest.Server {
+	return newFakeDevice(t, requests, nil,
+		fakeOperation{"tds:GetSystemDateAndTime", soapResponse(`<tds:GetSystemDateAndTimeResponse><tds:SystemDateAndTime>
+			<tt:DateTimeType>NTP</tt:DateTimeType>
+			<tt:DaylightSavings>true</tt:DaylightSavings>
+			<tt:TimeZone><tt:TZ>CST6CDT,M3.2.0,M11.1.0</tt:TZ></tt:TimeZone>
+			<tt:UTCDateTime>
+				<tt:Time><tt:Hour>17</tt:Hour><tt:Minute>4</tt:Minute><tt:Second>5</tt:Second></tt:Time>
+				<tt:Date><tt:Year>2024</tt:Year><tt:Month>3</tt:Month><tt:Day>9</tt:Day></tt:Date>
+			</tt:UTCDateTime>
+			<tt:LocalDateTime>
+				<tt:Time><tt:Hour>11</tt:Hour><tt:Minute>4</tt:Minute><tt:Second>5</tt:Second></tt:Time>
+				<tt:Date><tt:Year>2024</tt:Year><tt:Month>3</tt:Month><tt:Day>9</tt:Day></tt:Date>
+			</tt:LocalDateTime>
+		</tds:SystemDateAndTime></tds:GetSystemDateAndTimeResponse>`)},
+		fakeOperation{"tds:SetSystemDateAndTime", soapResponse(`<tds:SetSystemDateAndTimeResponse/>`)},
+		fakeOperation{"tds:GetNTP", soapResponse(`<tds:GetNTPResponse><tds:NTPInformation>
+			<tt:FromDHCP>false</tt:FromDHCP>
+			<tt:NTPManual><tt:Type>DNS</tt:Type><tt:DNSname>pool.ntp.org</tt:DNSname></tt:NTPManual>
+			<tt:NTPManual><tt:Type>IPv4</tt:Type><tt:IPv4Address>10.0.0.1</tt:IPv4Address></tt:NTPManual>
+		</tds:NTPInformation></tds:GetNTPResponse>`)},
+		fakeOperation{"tds:SetNTP", soapResponse(`<tds:SetNTPResponse/>`)},
+		fakeOperation{"tds:SystemReboot", soapResponse(
+			`<tds:SystemRebootResponse><tds:Message>Rebooting in 30 seconds</tds:Message></tds:SystemRebootResponse>`)},
+		fakeOperation{"tds:GetSystemLog", soapResponse(`<tds:GetSystemLogResponse><tds:SystemLog><tt:String>boot ok
+login admin</tt:String></tds:SystemLog></tds:GetSystemLogResponse>`)},
+		fakeOperation{"tds:GetUsers", soapResponse(`<tds:GetUsersResponse>
+			<tds:User><tt:Username>admin</tt:Username><tt:UserLevel>Administrator</tt:UserLevel></tds:User>
+			<tds:User><tt:Username>viewer</tt:Username><tt:UserLevel>User</tt:UserLevel></tds:User>
+		</tds:GetUsersResponse>`)},
+	)
+}
+
+func TestDeviceManagement(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	ctx := context.Background()
+	var requests []string
+	server := newManagementTestServer(t, &requests)
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	test.That(t, err, test.ShouldBeNil)
+	dev, err := NewDevice(ctx, Params{Xaddr: u, HTTPClient: &http.Client{}}, logger)
+	test.That(t, err, test.ShouldBeNil)
+
+	t.Run("get system date and time", func(t *testing.T) {
+		sdt, err := dev.GetSystemDateAndTime(ctx)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, sdt.DateTimeType, test.ShouldEqual, DateTimeTypeNTP)
+		test.That(t, sdt.DaylightSavings, test.ShouldBeTrue)
+		test.That(t, sdt.TimeZone, test.ShouldEqual, "CST6CDT,M3.2.0,M11.1.0")
+		test.That(t, sdt.UTC, test.ShouldEqual, time.Date(2024, 3, 9, 17, 4, 5, 0, time.UTC))
+		test.That(t, sdt.Local.Hour(), test.ShouldEqual, 11)
+	})
+
+	t.Run("set system date and time", func(t *testing.T) {
+		requests = nil
+		err := dev.SetSystemDateAndTime(ctx, time.Date(2024, 3, 9, 11, 4, 5, 0, time.FixedZone("CST", -6*3600)), "UTC0", false)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(requests), test.ShouldEqual, 1)
+		test.That(t, requests[0], test.ShouldContainSubstring, "<tds:DateTimeType>Manual</tds:DateTimeType>")
+		test.That(t, requests[0], test.ShouldContainSubstring, "<onvif:TZ>UTC0</onvif:TZ>")
+		// the time is sent in UTC
+		test.That(t, requests[0], test.ShouldContainSubstring, "<onvif:Hour>17</onvif:Hour>")
+		test.That(t, requests[0], test.ShouldContainSubstring, "<onvif:Year>2024</onvif:Year>")
+
+		requests = nil
+		err = dev.SetSystemDateAndTimeNTP(ctx, "", true)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, requests[0], test.ShouldContainSubstring, "<tds:DateTimeType>NTP</tds:DateTimeType>")
+		test.That(t, requests[0], test.ShouldNotContainSubstring, "TimeZone")
+		test.That(t, requests[0], test.ShouldNotContainSubstring, "UTCDateTime")
+	})
+
+	t.Run("ntp", func(t *testing.T) {
+		info, err := dev.GetNTP(ctx)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, info.FromDHCP, test.ShouldBeFalse)
+		test.That(t, info.Servers, test.ShouldResemble, []string{"pool.ntp.org", "10.0.0.1"})
+
+		requests = nil
+		err = dev.SetNTP(ctx, []string{"time.example.com", "192.168.1.1", "fe80::1"}, false)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, requests[0], test.ShouldContainSubstring, "<tds:FromDHCP>false</tds:FromDHCP>")
+		test.That(t, requests[0], test.ShouldContainSubstring, "<onvif:DNSname>time.example.com</onvif:DNSname>")
+		test.That(t, requests[0], test.ShouldContainSubstring, "<onvif:IPv4Address>192.168.1.1</onvif:IPv4Address>")
+		test.That(t, requests[0], test.ShouldContainSubstring, "<onvif:IPv6Address>fe80::1</onvif:IPv6Address>")
+
+		requests = nil
+		err = dev.SetNTP(ctx, nil, true)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, requests[0], test.ShouldNotContainSubstring, "NTPManual")
+
+		err = dev.SetNTP(ctx, nil, false)
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+
+	t.Run("reboot, logs and users", func(t *testing.T) {
+		msg, err := dev.SystemReboot(ctx)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, msg, test.ShouldEqual, "Rebooting in 30 seconds")
+
+		requests = nil
+		log, err := dev.GetSystemLog(ctx, SystemLogTypeAccess)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, log, test.ShouldEqual, "boot ok\nlogin admin")
+		test.That(t, requests[0], test.ShouldContainSubstring, "<tds:LogType>Access</tds:LogType>")
+
+		users, err := dev.GetUsers(ctx)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, users, test.ShouldResemble, []User{
+			{Username: "admin", UserLevel: "Administrator"},
+			{Username: "viewer", UserLevel: "User"},
+		})
+	})
+}
@@ -0,0 +1,280 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/viam-modules/viamrtsp/viamonvif/xsd"
+	"github.com/viam-modules/viamrtsp/viamonvif/xsd/onvif"
+)
+
+const (
+	media2Namespace = "http://www.onvif.org/ver20/media/wsdl"
+	// media2Endpoint is the key of the Media2 service in Device.endpoints.
+	media2Endpoint = "media2"
+
+	// StreamProtocolRtspUnicast is RTSP over UDP or TCP as the client negotiates, the Media2 equivalent of
+	// the RTP-Unicast and RTSP stream setup used with the legacy Media service.
+	StreamProtocolRtspUnicast = "RtspUnicast"
+	// StreamProtocolRTSP is the older name for RTSP streaming that some early Media2 implementations
+	// only accept.
+	StreamProtocolRTSP = "RTSP"
+	// StreamProtocolRtspOverHTTP tunnels RTSP over HTTP.
+	StreamProtocolRtspOverHTTP = "RtspOverHttp"
+)
+
+// media2ProfileTypes are the configurations requested with Media2 GetProfiles. Only the video and
+// PTZ configurations are used, and asking for all of them makes responses much larger.
+var media2ProfileTypes = []string{"VideoSource", "VideoEncoder", "PTZ"}
+
+// GetServices is a request to the GetServices onvif endpoint.
+type GetServices struct {
+	XMLName           string `xml:"tds:GetServices"`
+	IncludeCapability bool   `xml:"tds:IncludeCapability"`
+}
+
+type getServicesResponse struct {
+	XMLName xml.Name `xml:"Envelope"`
+	Body    struct {
+		GetServicesResponse struct {
+			Service []struct {
+				Namespace string `xml:"Namespace"`
+				XAddr     string `xml:"XAddr"`
+			} `xml:"Service"`
+		} `xml:"GetServicesResponse"`
+	} `xml:"Body"`
+}
+
+// GetProfiles2 is a request to the Media2 GetProfiles endpoint.
+type GetProfiles2 struct {
+	XMLName string   `xml:"tr2:GetProfiles"`
+	Type    []string `xml:"tr2:Type"`
+}
+
+// GetStreamURI2 is a request to the Media2 GetStreamUri endpoint.
+type GetStreamURI2 struct {
+	XMLName      string               `xml:"tr2:GetStreamUri"`
+	Protocol     string               `xml:"tr2:Protocol"`
+	ProfileToken onvif.ReferenceToken `xml:"tr2:ProfileToken"`
+}
+
+// GetSnapshotURI2 is a request to the Media2 GetSnapshotUri endpoint.
+type GetSnapshotURI2 struct {
+	XMLName      string               `xml:"tr2:GetSnapshotUri"`
+	ProfileToken onvif.ReferenceToken `xml:"tr2:ProfileToken"`
+}
+
+// media2Profile is a Media2 media profile. Media2 profiles hold their configurations in a
+// Configurations element, and encoders name their encoding with its IANA media subtype.
+type media2Profile struct {
+	Token          onvif.ReferenceToken `xml:"token,attr"`
+	Fixed          bool                 `xml:"fixed,attr"`
+	Name           onvif.Name           `xml:"Name"`
+	Configurations struct {
+		VideoSource  onvif.VideoSourceConfiguration `xml:"VideoSource"`
+		VideoEncoder struct {
+			onvif.ConfigurationEntity
+			GovLength   xsd.Int               `xml:"GovLength,attr"`
+			Profile     string                `xml:"Profile,attr"`
+			Encoding    string                `xml:"Encoding"`
+			Resolution  onvif.VideoResolution `xml:"Resolution"`
+			RateControl struct {
+				FrameRateLimit float64 `xml:"FrameRateLimit"`
+				BitrateLimit   xsd.Int `xml:"BitrateLimit"`
+			} `xml:"RateControl"`
+			Quality float64 `xml:"Quality"`
+		} `xml:"VideoEncoder"`
+		PTZ struct {
+			onvif.ConfigurationEntity
+			NodeToken onvif.ReferenceToken `xml:"NodeToken"`
+		} `xml:"PTZ"`
+	} `xml:"Configurations"`
+}
+
+type getProfiles2Response struct {
+	XMLName xml.Name `xml:"Envelope"`
+	Body    struct {
+		GetProfilesResponse struct {
+			Profiles []media2Profile `xml:"Profiles"`
+		} `xml:"GetProfilesResponse"`
+	} `xml:"Body"`
+}
+
+type getURI2Response struct {
+	XMLName xml.Name `xml:"Envelope"`
+	Body    struct {
+		GetStreamURIResponse struct {
+			URI string `xml:"Uri"`
+		} `xml:"GetStreamUriResponse"`
+		GetSnapshotURIResponse struct {
+			URI string `xml:"Uri"`
+		} `xml:"GetSnapshotUriResponse"`
+	} `xml:"Body"`
+}
+
+// media2Encoding converts a Media2 encoding name to the name the legacy Media service uses.
+func media2Encoding(encoding string) onvif.VideoEncoding {
+	switch strings.ToUpper(encoding) {
+	case "MPV4-ES", "MP4V-ES":
+		return "MPEG4"
+	default:
+		return onvif.VideoEncoding(strings.ToUpper(encoding))
+	}
+}
+
+// toProfile converts a Media2 profile to a legacy Media profile, so callers can use either service.
+func (p media2Profile) toProfile() onvif.Profile {
+	var profile onvif.Profile
+	profile.Token = p.Token
+	profile.Fixed = p.Fixed
+	profile.Name = p.Name
+	profile.VideoSourceConfiguration = p.Configurations.VideoSource
+	encoder := p.Configurations.VideoEncoder
+	profile.VideoEncoderConfiguration.ConfigurationEntity = encoder.ConfigurationEntity
+	profile.VideoEncoderConfiguration.Encoding = media2Encoding(encoder.Encoding)
+	profile.VideoEncoderConfiguration.Resolution = encoder.Resolution
+	profile.VideoEncoderConfiguration.Quality = encoder.Quality
+	// Media2 frame rates are floats, legacy Media ones are rounded to whole frames
+	profile.VideoEncoderConfiguration.RateControl.FrameRateLimit = xsd.Int(encoder.RateControl.FrameRateLimit + 0.5) //nolint:mnd
+	profile.VideoEncoderConfiguration.RateControl.BitrateLimit = encoder.RateControl.BitrateLimit
+	profile.PTZConfiguration.ConfigurationEntity = p.Configurations.PTZ.ConfigurationEntity
+	profile.PTZConfiguration.NodeToken = p.Configurations.PTZ.NodeToken
+	return profile
+}
+
+// media2XAddr returns the address of the device's Media2 service, or "" if it doesn't have one.
+// GetCapabilities doesn't report Media2, which Profile T devices advertise in GetServices instead, so
+// GetServices is only called the first time Media2 is needed.
+func (dev *Device) media2XAddr(ctx context.Context) string {
+	if xaddr := dev.endpoints[media2Endpoint]; xaddr != "" {
+		return xaddr
+	}
+	dev.servicesMu.Lock()
+	defer dev.servicesMu.Unlock()
+	if !dev.servicesChecked {
+		dev.media2 = dev.getMedia2XAddr(ctx)
+		// ask again next time if the request was only cut short
+		dev.servicesChecked = ctx.Err() == nil
+	}
+	return dev.media2
+}
+
+func (dev *Device) getMedia2XAddr(ctx context.Context) string {
+	b, err := dev.callDevice(ctx, GetServices{})
+	if err != nil {
+		dev.logger.Debugf("GetServices failed, only using services from GetCapabilities: %v", err)
+		return ""
+	}
+	var resp getServicesResponse
+	if err := xml.NewDecoder(bytes.NewReader(b)).Decode(&resp); err != nil {
+		dev.logger.Debugf("failed to decode GetServices response: %v", err)
+		return ""
+	}
+	for _, service := range resp.Body.GetServicesResponse.Service {
+		if strings.TrimSpace(service.Namespace) == media2Namespace && service.XAddr != "" {
+			dev.logger.Debugf("GetServices media2: %s", service.XAddr)
+			return strings.TrimSpace(service.XAddr)
+		}
+	}
+	return ""
+}
+
+// SupportsMedia2 reports whether the device advertises the Media2 service.
+func (dev *Device) SupportsMedia2(ctx context.Context) bool {
+	return dev.media2XAddr(ctx) != ""
+}
+
+func (dev *Device) callMedia2(ctx context.Context, method interface{}) ([]byte, error) {
+	return dev.callOnvifServiceMethod(ctx, dev.media2XAddr(ctx), method)
+}
+
+// getProfiles2 returns the device's Media2 profiles as legacy Media profiles.
+func (dev *Device) getProfiles2(ctx context.Context) (GetProfilesResponse, error) {
+	var zero GetProfilesResponse
+	b, err := dev.callMedia2(ctx, GetProfiles2{Type: media2ProfileTypes})
+	if err != nil {
+		return zero, fmt.Errorf("failed to get media2 profiles: %w", err)
+	}
+	dev.logger.Debugf("Media2 GetProfiles response body: %s", b)
+	var resp getProfiles2Response
+	if err := xml.NewDecoder(bytes.NewReader(b)).Decode(&resp); err != nil {
+		return zero, fmt.Errorf("failed to decode media2 profiles response: %w", err)
+	}
+	if len(resp.Body.GetProfilesResponse.Profiles) == 0 {
+		return zero, errors.New("no media2 profiles found")
+	}
+	var ret GetProfilesResponse
+	for _, p := range resp.Body.GetProfilesResponse.Profiles {
+		ret.Profiles = append(ret.Profiles, p.toProfile())
+	}
+	return ret, nil
+}
+
+// GetStreamURIWithProtocol returns a device's Media2 stream URI for a given profile token and
+// streaming protocol, such as StreamProtocolRtspUnicast.
+func (dev *Device) GetStreamURIWithProtocol(
+	ctx context.Context,
+	token onvif.ReferenceToken,
+	protocol string,
+	creds Credentials,
+) (*url.URL, error) {
+	if !dev.SupportsMedia2(ctx) {
+		return nil, errors.New("device does not support media2")
+	}
+	b, err := dev.callMedia2(ctx, GetStreamURI2{Protocol: protocol, ProfileToken: token})
+	if err != nil {
+		return nil, err
+	}
+	dev.logger.Debugf("Media2 GetStreamUri response: %s", string(b))
+	var resp getURI2Response
+	if err := xml.NewDecoder(bytes.NewReader(b)).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to get RTSP URL for token %s: %w", token, err)
+	}
+	return parseMediaURI(resp.Body.GetStreamURIResponse.URI, "stream", token, creds)
+}
+
+// getStreamURI2 returns the RTSP URI of a profile, trying the older protocol name for devices
+// that don't accept RtspUnicast.
+func (dev *Device) getStreamURI2(ctx context.Context, token onvif.ReferenceToken, creds Credentials) (*url.URL, error) {
+	uri, err := dev.GetStreamURIWithProtocol(ctx, token, StreamProtocolRtspUnicast, creds)
+	if err == nil {
+		return uri, nil
+	}
+	dev.logger.Debugf("Media2 GetStreamUri with %s failed for token %s, trying %s: %v",
+		StreamProtocolRtspUnicast, token, StreamProtocolRTSP, err)
+	return dev.GetStreamURIWithProtocol(ctx, token, StreamProtocolRTSP, creds)
+}
+
+func (dev *Device) getSnapshotURI2(ctx context.Context, token onvif.ReferenceToken, creds Credentials) (*url.URL, error) {
+	b, err := dev.callMedia2(ctx, GetSnapshotURI2{ProfileToken: token})
+	if err != nil {
+		return nil, err
+	}
+	dev.logger.Debugf("Media2 GetSnapshotUri response: %s", string(b))
+	var resp getURI2Response
+	if err := xml.NewDecoder(bytes.NewReader(b)).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to get snapshot URL for token %s: %w", token, err)
+	}
+	return parseMediaURI(resp.Body.GetSnapshotURIResponse.URI, "snapshot", token, creds)
+}
+
+// parseMediaURI parses a stream or snapshot URI and adds creds to it.
+func parseMediaURI(uriStr, kind string, token onvif.ReferenceToken, creds Credentials) (*url.URL, error) {
+	uriStr = strings.TrimSpace(uriStr)
+	if uriStr == "" {
+		return nil, fmt.Errorf("got empty %s uri for token %s", kind, token)
+	}
+	uri, err := url.Parse(uriStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse URI %s: %w", uriStr, err)
+	}
+	if creds.User != "" || creds.Pass != "" {
+		uri.User = url.UserPassword(creds.User, creds.Pass)
+	}
+	return uri, nil
+}
@@ -3,6 +3,7 @@ package viamonvif
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"maps"
 	"net"
@@ -41,34 +42,40 @@ func DiscoverCameras(
 	manualXAddrs []*url.URL,
 	logger logging.Logger,
 ) (*CameraInfoList, error) {
-	var ret []CameraInfo
-	discoveredXAddrs, err := discoverOnAllInterfaces(ctx, manualXAddrs, logger)
+	discovered, err := discoverOnAllInterfaces(ctx, manualXAddrs, logger)
 	if err != nil {
 		return nil, err
 	}
 
-	var wg sync.WaitGroup
-	ch := make(chan CameraInfo, len(discoveredXAddrs))
-	wg.Add(len(discoveredXAddrs))
-	for _, xaddr := range discoveredXAddrs {
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	ret := &CameraInfoList{}
+	wg.Add(len(discovered))
+	for _, match := range discovered {
 		utils.ManagedGo(func() {
-			cameraInfo, err := DiscoverCameraInfo(ctx, xaddr, creds, logger)
+			cameraInfo, err := discoverCameraInfo(ctx, match.xaddr, match.scopes, creds, logger)
+			mu.Lock()
+			defer mu.Unlock()
+			var authErr *authError
+			if errors.As(err, &authErr) {
+				logger.Debugf("failed to authenticate to ONVIF device %s", err)
+				ret.AuthFailures = append(ret.AuthFailures, authErr.failure)
+				return
+			}
 			if err != nil {
 				logger.Debugf("failed to connect to ONVIF device %s", err)
 				return
 			}
-			ch <- cameraInfo
+			ret.Cameras = append(ret.Cameras, cameraInfo)
 		}, wg.Done)
 	}
 	wg.Wait()
-	close(ch)
-	for cameraInfo := range ch {
-		ret = append(ret, cameraInfo)
-	}
-	return &CameraInfoList{Cameras: ret}, nil
+	return ret, nil
 }
 
-func discoverOnAllInterfaces(ctx context.Context, manualXAddrs []*url.URL, logger logging.Logger) ([]*url.URL, error) {
+func discoverOnAllInterfaces(ctx context.Context, manualXAddrs []*url.URL, logger logging.Logger) ([]wsDiscoveryMatch, error) {
 	logger.Debug("WS-Discovery start")
 	ifaces, err := net.Interfaces()
 	if err != nil {
@@ -76,16 +83,16 @@ func discoverOnAllInterfaces(ctx context.Context, manualXAddrs []*url.URL, logge
 	}
 
 	var wg sync.WaitGroup
-	ch := make(chan []*url.URL, len(ifaces))
+	ch := make(chan []wsDiscoveryMatch, len(ifaces))
 	wg.Add(len(ifaces))
 	for _, iface := range ifaces {
 		utils.ManagedGo(func() {
-			xaddrs, err := WSDiscovery(ctx, logger, iface)
+			matches, err := wsDiscovery(ctx, logger, iface)
 			if err != nil {
 				logger.Debugf("WS-Discovery skipping interface %s: due to error from SendProbe: %w", iface.Name, err)
 				return
 			}
-			ch <- xaddrs
+			ch <- matches
 		}, wg.Done)
 	}
 	logger.Debug("WS-Discovery waiting for all interfaces to return results")
@@ -94,19 +101,29 @@ func discoverOnAllInterfaces(ctx context.Context, manualXAddrs []*url.URL, logge
 	close(ch)
 	logger.Debug("WS-Discovery all interfaces have returned results")
 
-	discovered := map[string]*url.URL{}
+	discovered := map[string]wsDiscoveryMatch{}
 	for _, xaddr := range manualXAddrs {
-		discovered[xaddr.Host] = xaddr
+		discovered[xaddrKey(xaddr)] = wsDiscoveryMatch{xaddr: xaddr}
 	}
-	for xaddrs := range ch {
-		for _, xaddr := range xaddrs {
-			discovered[xaddr.Host] = xaddr
+	for matches := range ch {
+		for _, match := range matches {
+			discovered[xaddrKey(match.xaddr)] = match
 		}
 	}
 	logger.Debug("WS-Discovery complete")
 	return slices.Collect(maps.Values(discovered)), nil
 }
 
+// xaddrKey identifies the device behind xaddr, so a device found by WS-Discovery as
+// http://host/onvif/device_service and by a subnet scan as http://host:80/onvif/device_service is only queried once.
+func xaddrKey(xaddr *url.URL) string {
+	p := xaddr.Port()
+	if p == "" || (xaddr.Scheme == "http" && p == "80") || (xaddr.Scheme == "https" && p == "443") {
+		return xaddr.Hostname()
+	}
+	return xaddr.Host
+}
+
 // MediaInfo holds detailed information about a camera's media capabilities, including
 // the stream URI, snapshot URI, frame rate, resolution, and codec.
 type MediaInfo struct {
@@ -139,6 +156,8 @@ type CameraInfo struct {
 	FirmwareVersion string      `json:"firmware_version"`
 	HardwareID      string      `json:"hardware_id"`
 	MACAddress      string      `json:"mac_address"`
+	// CredentialUser is the username of the credentials that worked, empty if none were needed.
+	CredentialUser string `json:"credential_user"`
 
 	deviceIP net.IP
 	mdnsName string
@@ -247,6 +266,29 @@ func (cam *CameraInfo) urlDependsOnMDNS(idx int) bool {
 // CameraInfoList is a struct containing a list of CameraInfo structs.
 type CameraInfoList struct {
 	Cameras []CameraInfo `json:"cameras"`
+	// AuthFailures are devices that were found but that none of the credentials worked on.
+	AuthFailures []CameraAuthFailure `json:"auth_failures,omitempty"`
+}
+
+// CameraAuthFailure is a device that was found but rejected every credential in scope for it.
+type CameraAuthFailure struct {
+	Host string `json:"host"`
+	// Manufacturer and SerialNumber are only known for devices that report them without credentials.
+	Manufacturer string `json:"manufacturer,omitempty"`
+	SerialNumber string `json:"serial_number,omitempty"`
+	// TriedUsers are the usernames of the credentials that were tried, in order. The attempt without
+	// credentials isn't listed.
+	TriedUsers []string `json:"tried_users"`
+	Error      string   `json:"error"`
+}
+
+// authError is returned by discoverCameraInfo for devices that rejected every credential in scope.
+type authError struct {
+	failure CameraAuthFailure
+}
+
+func (e *authError) Error() string {
+	return fmt.Sprintf("no credentials matched %s: %s", e.failure.Host, e.failure.Error)
 }
 
 // DiscoverCameraInfo discovers camera information on a given uri
@@ -257,10 +299,31 @@ func DiscoverCameraInfo(
 	xaddr *url.URL,
 	creds []device.Credentials,
 	logger logging.Logger,
+) (CameraInfo, error) {
+	return discoverCameraInfo(ctx, xaddr, nil, creds, logger)
+}
+
+// discoverCameraInfo is DiscoverCameraInfo for a device that answered WS-Discovery with scopes.
+// Only the credentials in scope for the device are tried. If the device rejects all of them an *authError
+// is returned, any other failure is returned as is.
+func discoverCameraInfo(
+	ctx context.Context,
+	xaddr *url.URL,
+	scopes []string,
+	creds []device.Credentials,
+	logger logging.Logger,
 ) (CameraInfo, error) {
 	logger.Debugf("Connecting to ONVIF device with URL: %s", xaddr)
 	var zero CameraInfo
-	for _, cred := range creds {
+	matched, id := credentialsFor(ctx, xaddr, scopes, creds, logger)
+	failure := CameraAuthFailure{
+		Host:         xaddr.Host,
+		Manufacturer: id.manufacturer,
+		SerialNumber: id.serialNumber,
+		TriedUsers:   []string{},
+		Error:        "no credentials are in scope for the device",
+	}
+	for _, cred := range matched {
 		if ctx.Err() != nil {
 			return zero, fmt.Errorf("context canceled while connecting to ONVIF device: %s", xaddr)
 		}
@@ -271,20 +334,26 @@ func DiscoverCameraInfo(
 			Password:                 cred.Pass,
 			SkipLocalTLSVerification: true,
 		}, logger)
-		if err != nil {
-			logger.Debugf("Failed to get camera capabilities from %s: %v", xaddr, err)
-			continue
+		if cred.User != "" || cred.Pass != "" {
+			failure.TriedUsers = append(failure.TriedUsers, cred.User)
 		}
-
-		cameraInfo, err := GetCameraInfo(ctx, dev, xaddr, cred, logger)
-		if err != nil {
-			logger.Debugf("Failed to get camera info from %s: %v", xaddr, err)
-			continue
+		if err == nil {
+			var cameraInfo CameraInfo
+			cameraInfo, err = GetCameraInfo(ctx, dev, xaddr, cred, logger)
+			if err == nil {
+				cameraInfo.CredentialUser = cred.User
+				// once we have added a camera info break
+				return cameraInfo, nil
+			}
+		}
+		// anything but the device rejecting the credentials won't go differently with other credentials
+		if !device.IsAuthError(err) {
+			return zero, fmt.Errorf("failed to get camera info from %s: %w", xaddr, err)
 		}
-		// once we have added a camera info break
-		return cameraInfo, nil
+		logger.Debugf("Credentials rejected by %s: %v", xaddr, err)
+		failure.Error = err.Error()
 	}
-	return zero, fmt.Errorf("no credentials matched IP %s", xaddr)
+	return zero, &authError{failure: failure}
 }
 
 // macToHostName converts a MAC address to a DNS-compatible hostname by stripping separators.
@@ -441,6 +441,27 @@ func TestExtractXAddrsFromProbeMatch(t *testing.T) {
 	})
 }
 
+func TestExtractProbeMatchScopes(t *testing.T) {
+	response := `
+		<Envelope>
+			<Body>
+				<ProbeMatches>
+					<ProbeMatch>
+						<Scopes>onvif://www.onvif.org/name/AXIS onvif://www.onvif.org/hardware/P3245-LV</Scopes>
+						<XAddrs>http://192.168.1.100/onvif/device_service</XAddrs>
+					</ProbeMatch>
+				</ProbeMatches>
+			</Body>
+		</Envelope>`
+	matches := extractProbeMatches(response, logging.NewTestLogger(t))
+	test.That(t, len(matches), test.ShouldEqual, 1)
+	test.That(t, matches[0].xaddr.Host, test.ShouldEqual, "192.168.1.100")
+	test.That(t, matches[0].scopes, test.ShouldResemble, []string{
+		"onvif://www.onvif.org/name/AXIS",
+		"onvif://www.onvif.org/hardware/P3245-LV",
+	})
+}
+
 func TestIsLocalIP(t *testing.T) {
 	test.That(t, isLocalIP(net.ParseIP("192.168.1.1")), test.ShouldBeTrue)
 	test.That(t, isLocalIP(net.ParseIP("10.0.0.1")), test.ShouldBeTrue)
@@ -101,10 +101,16 @@ func (msg *SoapMessage) AddRootNamespace(key, value string) error {
 
 // AddWSSecurity Header for soapMessage.
 func (msg *SoapMessage) AddWSSecurity(username, password string) error {
+	return msg.AddWSSecurityAt(username, password, time.Now())
+}
+
+// AddWSSecurityAt adds a WS-Security header created at the given time, which lets a client
+// authenticate to a device whose clock differs from its own.
+func (msg *SoapMessage) AddWSSecurityAt(username, password string, created time.Time) error {
 	/*
 		Getting an WS-Security struct representation
 	*/
-	auth := newSecurity(username, password)
+	auth := newSecurity(username, password, created)
 
 	/*
 		Adding WS-Security namespaces to root element of SOAP message
@@ -188,13 +194,13 @@ type wsAuth struct {
 */
 
 // newSecurity get a new security.
-func newSecurity(username, passwd string) Security {
+func newSecurity(username, passwd string, at time.Time) Security {
 	/** Generating Nonce sequence **/
 	charsToGenerate := 32
 	charSet := gostrgen.Lower | gostrgen.Digit
 
 	nonceSeq, _ := gostrgen.RandGen(charsToGenerate, charSet, "", "")
-	created := time.Now().UTC().Format(time.RFC3339Nano)
+	created := at.UTC().Format(time.RFC3339Nano)
 	auth := Security{
 		Auth: wsAuth{
 			Username: username,
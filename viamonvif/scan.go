@@ -0,0 +1,243 @@
+package viamonvif
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/utils"
+)
+
+const (
+	deviceServicePath = "/onvif/device_service"
+	// how long a port may take to accept a connection before it's taken to be closed
+	connectTimeout = 500 * time.Millisecond
+	// how long a single host and port may take to answer a probe
+	probeTimeout = 2 * time.Second
+	// how many hosts are probed at the same time
+	probeConcurrency = 64
+	// subnets are limited to /20, 4094 hosts, so a typo can't start a scan of a whole /8
+	minSubnetPrefixLen = 20
+	// only enough of a response to recognize a SOAP envelope is read
+	maxProbeResponseBytes = 64 * 1024
+)
+
+// defaultScanPorts are the ports ONVIF device services commonly listen on, in the order they are tried.
+var defaultScanPorts = []int{80, 8080, 8000, 8899, 2020, 443}
+
+// probeMessage is an unauthenticated GetSystemDateAndTime request, which ONVIF devices must answer
+// without credentials so clients can sync their clocks for WS-Security.
+const probeMessage = `<?xml version="1.0" encoding="UTF-8"?>
+<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:tds="http://www.onvif.org/ver10/device/wsdl">
+ <s:Body>
+  <tds:GetSystemDateAndTime/>
+ </s:Body>
+</s:Envelope>`
+
+// ParseXAddr parses a device service address. A bare host or host:port is taken to be the
+// default device service path over http, or https on port 443.
+func ParseXAddr(xaddr string) (*url.URL, error) {
+	if !strings.Contains(xaddr, "://") {
+		scheme := "http"
+		if _, p, err := net.SplitHostPort(xaddr); err == nil && p == "443" {
+			scheme = "https"
+		}
+		xaddr = scheme + "://" + xaddr
+	}
+	u, err := url.Parse(xaddr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("xaddr %s must be an http or https url", xaddr)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("xaddr %s has no host", xaddr)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = deviceServicePath
+	}
+	return u, nil
+}
+
+// parseSubnet parses an IPv4 CIDR range to scan.
+func parseSubnet(subnet string) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(subnet)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if !prefix.Addr().Is4() {
+		return netip.Prefix{}, fmt.Errorf("subnet %s must be an IPv4 range", subnet)
+	}
+	if prefix.Bits() < minSubnetPrefixLen {
+		return netip.Prefix{}, fmt.Errorf("subnet %s is too large, the largest range that can be scanned is a /%d", subnet, minSubnetPrefixLen)
+	}
+	return prefix.Masked(), nil
+}
+
+// subnetHosts returns the host addresses of prefix, leaving out the network and broadcast addresses
+// of ranges that have them.
+func subnetHosts(prefix netip.Prefix) []netip.Addr {
+	var hosts []netip.Addr
+	for addr := prefix.Addr(); prefix.Contains(addr); addr = addr.Next() {
+		hosts = append(hosts, addr)
+	}
+	//nolint:mnd
+	if prefix.Bits() < 31 && len(hosts) > 2 {
+		hosts = hosts[1 : len(hosts)-1]
+	}
+	return hosts
+}
+
+// SubnetHosts returns the host addresses of every IPv4 CIDR range in subnets. Ranges larger than a
+// /20 are rejected.
+func SubnetHosts(subnets []string) ([]netip.Addr, error) {
+	var hosts []netip.Addr
+	for _, subnet := range subnets {
+		prefix, err := parseSubnet(subnet)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, subnetHosts(prefix)...)
+	}
+	return hosts, nil
+}
+
+// scanXAddr returns the device service address of host on port.
+func scanXAddr(host netip.Addr, p int) *url.URL {
+	scheme := "http"
+	//nolint:mnd
+	if p == 443 {
+		scheme = "https"
+	}
+	return &url.URL{Scheme: scheme, Host: net.JoinHostPort(host.String(), strconv.Itoa(p)), Path: deviceServicePath}
+}
+
+// probeDeviceService reports whether xaddr answers like an ONVIF device service. Any SOAP response
+// counts, since some devices answer with a fault rather than the time.
+func probeDeviceService(ctx context.Context, client *http.Client, xaddr *url.URL) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, xaddr.String(), strings.NewReader(probeMessage))
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Content-Type", "application/soap+xml; charset=utf-8")
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxProbeResponseBytes))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false
+	}
+	return bytes.Contains(body, []byte("Envelope"))
+}
+
+// openPorts returns the ports of host that accept a TCP connection, in the order of ports. The
+// ports are tried at the same time, so a host that doesn't listen on any of them takes at most
+// connectTimeout.
+func openPorts(ctx context.Context, host netip.Addr, ports []int) []int {
+	open := make([]bool, len(ports))
+	var wg sync.WaitGroup
+	for i, p := range ports {
+		wg.Add(1)
+		utils.ManagedGo(func() {
+			dialCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+			defer cancel()
+			conn, err := (&net.Dialer{}).DialContext(dialCtx, "tcp", net.JoinHostPort(host.String(), strconv.Itoa(p)))
+			if err != nil {
+				return
+			}
+			utils.UncheckedError(conn.Close())
+			open[i] = true
+		}, wg.Done)
+	}
+	wg.Wait()
+	var result []int
+	for i, p := range ports {
+		if open[i] {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// ScanSubnets probes every host in subnets for an ONVIF device service on ports, which is how
+// cameras on networks that multicast WS-Discovery can't reach are found. Each host is reported
+// at most once, at the first port in ports that answers.
+//
+// Each host's ports are first checked for a TCP connection at the same time, and only the open
+// ones are probed. A host with no open ports takes at most connectTimeout, and each open port that
+// doesn't answer adds up to probeTimeout. With probeConcurrency hosts scanned at a time, the worst
+// case is about ceil(hosts/probeConcurrency) * (connectTimeout + len(ports)*probeTimeout), though
+// a range with no devices listening takes ceil(hosts/probeConcurrency) * connectTimeout, 2s for a /24.
+func ScanSubnets(ctx context.Context, subnets []string, ports []int, logger logging.Logger) ([]*url.URL, error) {
+	if len(ports) == 0 {
+		ports = defaultScanPorts
+	}
+	hosts, err := SubnetHosts(subnets)
+	if err != nil {
+		return nil, err
+	}
+	if len(hosts) == 0 {
+		return nil, nil
+	}
+	logger.Debugf("scanning %d hosts on ports %v for ONVIF devices", len(hosts), ports)
+
+	client := &http.Client{
+		Timeout: probeTimeout,
+		Transport: &http.Transport{
+			// cameras commonly use self signed certificates
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, //nolint:gosec
+			DisableKeepAlives: true,
+		},
+	}
+	defer client.CloseIdleConnections()
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		found  []*url.URL
+		tokens = make(chan struct{}, probeConcurrency)
+	)
+	for _, host := range hosts {
+		select {
+		case tokens <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return found, ctx.Err()
+		}
+		wg.Add(1)
+		utils.ManagedGo(func() {
+			defer func() { <-tokens }()
+			for _, p := range openPorts(ctx, host, ports) {
+				xaddr := scanXAddr(host, p)
+				if ctx.Err() != nil {
+					return
+				}
+				if probeDeviceService(ctx, client, xaddr) {
+					logger.Debugf("found ONVIF device service at %s", xaddr)
+					mu.Lock()
+					found = append(found, xaddr)
+					mu.Unlock()
+					return
+				}
+			}
+		}, wg.Done)
+	}
+	wg.Wait()
+	logger.Debugf("subnet scan found %d ONVIF devices", len(found))
+	return found, nil
+}
@@ -0,0 +1,119 @@
+package viamonvif
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestParseXAddr(t *testing.T) {
+	for _, tc := range []struct {
+		in, out string
+	}{
+		{"192.168.1.10", "http://192.168.1.10/onvif/device_service"},
+		{"192.168.1.10:8080", "http://192.168.1.10:8080/onvif/device_service"},
+		{"192.168.1.10:443", "https://192.168.1.10:443/onvif/device_service"},
+		{"http://cam.local/onvif/device_service", "http://cam.local/onvif/device_service"},
+		{"https://10.0.0.2:8443/onvif/other_path", "https://10.0.0.2:8443/onvif/other_path"},
+	} {
+		u, err := ParseXAddr(tc.in)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, u.String(), test.ShouldEqual, tc.out)
+	}
+
+	_, err := ParseXAddr("rtsp://192.168.1.10/stream")
+	test.That(t, err.Error(), test.ShouldContainSubstring, "must be an http or https url")
+	_, err = ParseXAddr("http:///onvif/device_service")
+	test.That(t, err.Error(), test.ShouldContainSubstring, "has no host")
+}
+
+func TestParseSubnet(t *testing.T) {
+	prefix, err := parseSubnet("192.168.1.77/24")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, prefix.String(), test.ShouldEqual, "192.168.1.0/24")
+
+	hosts := subnetHosts(prefix)
+	test.That(t, len(hosts), test.ShouldEqual, 254)
+	test.That(t, hosts[0].String(), test.ShouldEqual, "192.168.1.1")
+	test.That(t, hosts[253].String(), test.ShouldEqual, "192.168.1.254")
+
+	test.That(t, len(subnetHosts(netip.MustParsePrefix("10.0.0.5/32"))), test.ShouldEqual, 1)
+	test.That(t, len(subnetHosts(netip.MustParsePrefix("10.0.0.4/31"))), test.ShouldEqual, 2)
+
+	_, err = parseSubnet("10.0.0.0/8")
+	test.That(t, err.Error(), test.ShouldContainSubstring, "too large")
+	_, err = parseSubnet("fd00::/120")
+	test.That(t, err.Error(), test.ShouldContainSubstring, "must be an IPv4 range")
+	_, err = parseSubnet("192.168.1.0")
+	test.That(t, err, test.ShouldNotBeNil)
+}
+
+func TestXAddrKey(t *testing.T) {
+	key := func(s string) string {
+		u, err := url.Parse(s)
+		test.That(t, err, test.ShouldBeNil)
+		return xaddrKey(u)
+	}
+	test.That(t, key("http://10.0.0.2/onvif/device_service"), test.ShouldEqual, key("http://10.0.0.2:80/onvif/device_service"))
+	test.That(t, key("https://10.0.0.2:443/onvif/device_service"), test.ShouldEqual, "10.0.0.2")
+	test.That(t, key("http://10.0.0.2:8080/onvif/device_service"), test.ShouldEqual, "10.0.0.2:8080")
+}
+
+func TestScanSubnets(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	onvif := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != deviceServicePath {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(`<s:Envelope><s:Body><tds:GetSystemDateAndTimeResponse/></s:Body></s:Envelope>`))
+	}))
+	defer onvif.Close()
+	notOnvif := startTestHTTPServer(t, "/", http.StatusOK, "text/html", "<html>router login</html>", false)
+	defer notOnvif.Close()
+
+	port := func(s *httptest.Server) int {
+		u, err := url.Parse(s.URL)
+		test.That(t, err, test.ShouldBeNil)
+		p, err := strconv.Atoi(u.Port())
+		test.That(t, err, test.ShouldBeNil)
+		return p
+	}
+
+	client := &http.Client{}
+	onvifURL, err := url.Parse(onvif.URL + deviceServicePath)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, probeDeviceService(context.Background(), client, onvifURL), test.ShouldBeTrue)
+	otherURL, err := url.Parse(notOnvif.URL + deviceServicePath)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, probeDeviceService(context.Background(), client, otherURL), test.ShouldBeFalse)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	test.That(t, err, test.ShouldBeNil)
+	closed := l.Addr().(*net.TCPAddr).Port
+	test.That(t, l.Close(), test.ShouldBeNil)
+	localhost := netip.MustParseAddr("127.0.0.1")
+	test.That(t, openPorts(context.Background(), localhost, []int{port(onvif), closed, port(notOnvif)}),
+		test.ShouldResemble, []int{port(onvif), port(notOnvif)})
+
+	found, err := ScanSubnets(context.Background(), []string{"127.0.0.1/32"}, []int{closed, port(notOnvif), port(onvif)}, logger)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, len(found), test.ShouldEqual, 1)
+	test.That(t, found[0].String(), test.ShouldEqual, onvifURL.String())
+
+	found, err = ScanSubnets(context.Background(), []string{"127.0.0.1/32"}, []int{port(notOnvif)}, logger)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, found, test.ShouldBeEmpty)
+
+	_, err = ScanSubnets(context.Background(), []string{"not a subnet"}, nil, logger)
+	test.That(t, err, test.ShouldNotBeNil)
+}
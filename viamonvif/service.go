@@ -56,6 +56,13 @@ func init() {
 // Config is the config for the discovery service.
 type Config struct {
 	Credentials []device.Credentials `json:"credentials"`
+	// XAddrs are device service addresses queried as if WS-Discovery had returned them,
+	// for cameras that don't answer multicast.
+	XAddrs []string `json:"xaddrs,omitempty"`
+	// Subnets are IPv4 CIDR ranges whose hosts are probed for an ONVIF device service.
+	Subnets []string `json:"subnets,omitempty"`
+	// ScanPorts are the ports probed on each subnet host, defaultScanPorts when empty.
+	ScanPorts []int `json:"scan_ports,omitempty"`
 }
 
 // Validate validates the discovery service.
@@ -65,6 +72,24 @@ func (cfg *Config) Validate(_ string) ([]string, []string, error) {
 		if cred.Pass != "" && cred.User == "" {
 			return nil, nil, fmt.Errorf("credential missing username, has password %v", cred.Pass)
 		}
+		if err := validateCredentialScope(cred); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, xaddr := range cfg.XAddrs {
+		if _, err := ParseXAddr(xaddr); err != nil {
+			return nil, nil, fmt.Errorf("invalid xaddr %q: %w", xaddr, err)
+		}
+	}
+	for _, subnet := range cfg.Subnets {
+		if _, err := parseSubnet(subnet); err != nil {
+			return nil, nil, fmt.Errorf("invalid subnet %q: %w", subnet, err)
+		}
+	}
+	for _, p := range cfg.ScanPorts {
+		if p <= 0 || p > 65535 {
+			return nil, nil, fmt.Errorf("invalid scan port %d", p)
+		}
 	}
 	return []string{}, nil, nil
 }
@@ -77,6 +102,9 @@ type rtspDiscovery struct {
 	rtspToSnapshotURIs   map[string]string
 
 	Credentials []device.Credentials
+	xaddrs      []*url.URL
+	subnets     []string
+	scanPorts   []int
 	mdnsServer  *mdnsServer
 	logger      logging.Logger
 
@@ -84,6 +112,11 @@ type rtspDiscovery struct {
 
 	discoveredResourcesMu sync.Mutex
 	discoveredResources   []resource.Config
+
+	// statusMu guards the result of the last discovery, reported by the get-discovery-status DoCommand.
+	statusMu     sync.Mutex
+	lastCameras  []CameraInfo
+	authFailures []CameraAuthFailure
 }
 
 func newDiscovery(_ context.Context, _ resource.Dependencies,
@@ -98,9 +131,18 @@ func newDiscovery(_ context.Context, _ resource.Dependencies,
 	dis := &rtspDiscovery{
 		Named:       conf.ResourceName().AsNamed(),
 		Credentials: append([]device.Credentials{emptyCred}, cfg.Credentials...),
+		subnets:     cfg.Subnets,
+		scanPorts:   cfg.ScanPorts,
 		logger:      logger,
 		workers:     utils.NewBackgroundStoppableWorkers(),
 	}
+	for _, xaddr := range cfg.XAddrs {
+		u, err := ParseXAddr(xaddr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid xaddr %q: %w", xaddr, err)
+		}
+		dis.xaddrs = append(dis.xaddrs, u)
+	}
 
 	// viam-server sets this environment variable. The contents of this directory is expected to
 	// persist across process restarts and module upgrades.
@@ -165,10 +207,11 @@ func (dis *rtspDiscovery) runDiscoveryLookup(ctx context.Context, extra map[stri
 	if ok {
 		discoverCreds = append(discoverCreds, extraCred)
 	}
-	list, err := DiscoverCameras(ctx, discoverCreds, nil, dis.logger)
+	list, err := DiscoverCameras(ctx, discoverCreds, dis.manualXAddrs(ctx), dis.logger)
 	if err != nil {
 		return nil, err
 	}
+	dis.updateStatus(list)
 	if len(list.Cameras) == 0 {
 		return nil, errNoCamerasFound
 	}
@@ -221,6 +264,69 @@ func (dis *rtspDiscovery) runDiscoveryLookup(ctx context.Context, extra map[stri
 	return cams, nil
 }
 
+// updateStatus records the cameras and auth failures of a discovery run. Failures are logged as warnings
+// when a device first fails, since they usually need an installer to add or fix credentials.
+func (dis *rtspDiscovery) updateStatus(list *CameraInfoList) {
+	dis.statusMu.Lock()
+	defer dis.statusMu.Unlock()
+	previous := map[string]bool{}
+	for _, f := range dis.authFailures {
+		previous[f.Host] = true
+	}
+	for _, f := range list.AuthFailures {
+		if !previous[f.Host] {
+			dis.logger.Warnf("found ONVIF device %s but could not authenticate with any credentials in scope for it (tried users %q): %s",
+				f.Host, f.TriedUsers, f.Error)
+		}
+	}
+	dis.lastCameras = list.Cameras
+	dis.authFailures = list.AuthFailures
+}
+
+// discoveryStatus is the response of the get-discovery-status DoCommand. Passwords are never included.
+func (dis *rtspDiscovery) discoveryStatus() map[string]interface{} {
+	dis.statusMu.Lock()
+	defer dis.statusMu.Unlock()
+	cameras := make([]interface{}, 0, len(dis.lastCameras))
+	for _, cam := range dis.lastCameras {
+		cameras = append(cameras, map[string]interface{}{
+			"host":            cam.Host,
+			"manufacturer":    cam.Manufacturer,
+			"model":           cam.Model,
+			"serial_number":   cam.SerialNumber,
+			"credential_user": cam.CredentialUser,
+		})
+	}
+	failures := make([]interface{}, 0, len(dis.authFailures))
+	for _, f := range dis.authFailures {
+		triedUsers := make([]interface{}, 0, len(f.TriedUsers))
+		for _, u := range f.TriedUsers {
+			triedUsers = append(triedUsers, u)
+		}
+		failures = append(failures, map[string]interface{}{
+			"host":          f.Host,
+			"manufacturer":  f.Manufacturer,
+			"serial_number": f.SerialNumber,
+			"tried_users":   triedUsers,
+			"error":         f.Error,
+		})
+	}
+	return map[string]interface{}{"cameras": cameras, "auth_failures": failures}
+}
+
+// manualXAddrs returns the configured xaddrs and those found by scanning the configured subnets.
+// Configured xaddrs come last so they take precedence over a scanned address for the same device.
+func (dis *rtspDiscovery) manualXAddrs(ctx context.Context) []*url.URL {
+	if len(dis.subnets) == 0 {
+		return dis.xaddrs
+	}
+	scanned, err := ScanSubnets(ctx, dis.subnets, dis.scanPorts, dis.logger)
+	if err != nil {
+		dis.logger.Warnf("subnet scan failed: %v", err)
+	}
+	return append(scanned, dis.xaddrs...)
+}
+
 func (dis *rtspDiscovery) DoCommand(ctx context.Context, command map[string]interface{}) (map[string]interface{}, error) {
 	cmd, ok := command["command"].(string)
 	if !ok {
@@ -243,6 +349,8 @@ func (dis *rtspDiscovery) DoCommand(ctx context.Context, command map[string]inte
 		return map[string]interface{}{
 			"preview": dataURL,
 		}, nil
+	case "get-discovery-status":
+		return dis.discoveryStatus(), nil
 	default:
 		return nil, fmt.Errorf("unknown command: %s", cmd)
 	}
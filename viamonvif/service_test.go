@@ -101,6 +101,55 @@ func TestDiscoveryConfig(t *testing.T) {
 		test.That(t, err.Error(), test.ShouldContainSubstring, "credential missing username, has password pass1")
 		test.That(t, deps, test.ShouldBeEmpty)
 	})
+	t.Run("Test scoped credentials", func(t *testing.T) {
+		cfg := Config{Credentials: []device.Credentials{
+			{User: "axis", Pass: "pass1", Manufacturers: []string{"axis"}},
+			{User: "lab", Pass: "pass2", Hosts: []string{"10.1.0.0/16"}, SerialNumbers: []string{"ACCC8E000000"}},
+		}}
+		_, _, err := cfg.Validate("")
+		test.That(t, err, test.ShouldBeNil)
+
+		cfg = Config{Credentials: []device.Credentials{{User: "lab", Pass: "pass2", Hosts: []string{"10.1.0.0/99"}}}}
+		_, _, err = cfg.Validate("")
+		test.That(t, err.Error(), test.ShouldContainSubstring, "invalid host range")
+	})
+	t.Run("Test xaddrs and subnets", func(t *testing.T) {
+		cfg := Config{XAddrs: []string{"192.168.2.10", "http://192.168.3.10:8080/onvif/device_service"}, Subnets: []string{"192.168.4.0/24"}}
+		_, _, err := cfg.Validate("")
+		test.That(t, err, test.ShouldBeNil)
+
+		cfg = Config{XAddrs: []string{"rtsp://192.168.2.10/stream"}}
+		_, _, err = cfg.Validate("")
+		test.That(t, err.Error(), test.ShouldContainSubstring, "invalid xaddr")
+
+		cfg = Config{Subnets: []string{"10.0.0.0/8"}}
+		_, _, err = cfg.Validate("")
+		test.That(t, err.Error(), test.ShouldContainSubstring, "invalid subnet")
+
+		cfg = Config{ScanPorts: []int{0}}
+		_, _, err = cfg.Validate("")
+		test.That(t, err.Error(), test.ShouldContainSubstring, "invalid scan port 0")
+	})
+}
+
+func TestDiscoveryStatus(t *testing.T) {
+	dis := &rtspDiscovery{logger: logging.NewTestLogger(t)}
+	dis.updateStatus(&CameraInfoList{
+		Cameras: []CameraInfo{{Host: "10.0.0.2", Manufacturer: "Axis", CredentialUser: "axis"}},
+		AuthFailures: []CameraAuthFailure{
+			{Host: "10.0.0.3", TriedUsers: []string{"axis"}, Error: "SOAP request failed with status code: 401"},
+		},
+	})
+	resp, err := dis.DoCommand(context.Background(), map[string]interface{}{"command": "get-discovery-status"})
+	test.That(t, err, test.ShouldBeNil)
+	cameras := resp["cameras"].([]interface{})
+	test.That(t, len(cameras), test.ShouldEqual, 1)
+	test.That(t, cameras[0].(map[string]interface{})["credential_user"], test.ShouldEqual, "axis")
+	failures := resp["auth_failures"].([]interface{})
+	test.That(t, len(failures), test.ShouldEqual, 1)
+	failure := failures[0].(map[string]interface{})
+	test.That(t, failure["host"], test.ShouldEqual, "10.0.0.3")
+	test.That(t, failure["tried_users"], test.ShouldResemble, []interface{}{"axis"})
 }
 
 func TestGetCredFromExtra(t *testing.T) {
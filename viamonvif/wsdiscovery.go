@@ -26,8 +26,28 @@ func isLocalIP(ip net.IP) bool {
 	return ip.IsPrivate() || ip.IsLoopback() || rfc2544BenchmarkNet.Contains(ip)
 }
 
+// wsDiscoveryMatch is a device that answered WS-Discovery.
+type wsDiscoveryMatch struct {
+	xaddr *url.URL
+	// scopes are the device's WS-Discovery scopes, such as onvif://www.onvif.org/name/<name>,
+	// which often name the manufacturer and model before any credentials are used.
+	scopes []string
+}
+
 // WSDiscovery runs WS-Discovery on the network interface.
 func WSDiscovery(ctx context.Context, logger logging.Logger, iface net.Interface) ([]*url.URL, error) {
+	matches, err := wsDiscovery(ctx, logger, iface)
+	if err != nil {
+		return nil, err
+	}
+	xaddrs := make([]*url.URL, 0, len(matches))
+	for _, m := range matches {
+		xaddrs = append(xaddrs, m.xaddr)
+	}
+	return xaddrs, nil
+}
+
+func wsDiscovery(ctx context.Context, logger logging.Logger, iface net.Interface) ([]wsDiscoveryMatch, error) {
 	logger.Debugf("WS-Discovery starting on interface: %s\n", iface.Name)
 	defer logger.Debugf("WS-Discovery stopping on interface: %s\n", iface.Name)
 	if !validWSDiscoveryInterface(iface) {
@@ -62,26 +82,37 @@ func WSDiscovery(ctx context.Context, logger logging.Logger, iface net.Interface
 		return nil, fmt.Errorf("no unique discovery responses received on interface %s after multiple attempts", iface.Name)
 	}
 
-	xaddrsSet := make(map[string]*url.URL)
+	matchesSet := make(map[string]wsDiscoveryMatch)
 	for _, response := range discoveryResps {
-		for _, xaddr := range extractXAddrsFromProbeMatch(response, logger) {
-			xaddrsSet[xaddr.Host] = xaddr
+		for _, m := range extractProbeMatches(response, logger) {
+			matchesSet[m.xaddr.Host] = m
 		}
 	}
 
-	return slices.Collect(maps.Values(xaddrsSet)), nil
+	return slices.Collect(maps.Values(matchesSet)), nil
 }
 
 // extractXAddrsFromProbeMatch extracts XAddrs from the WS-Discovery ProbeMatch response.
 // It filters out any XAddrs that point to non-local IP addresses to prevent
 // reaching out to external/public IPs that may be maliciously advertised by cameras.
 func extractXAddrsFromProbeMatch(response string, logger logging.Logger) []*url.URL {
+	xaddrs := []*url.URL{}
+	for _, m := range extractProbeMatches(response, logger) {
+		xaddrs = append(xaddrs, m.xaddr)
+	}
+	return xaddrs
+}
+
+// extractProbeMatches extracts the XAddrs and scopes from the WS-Discovery ProbeMatch response,
+// with the same filtering as extractXAddrsFromProbeMatch.
+func extractProbeMatches(response string, logger logging.Logger) []wsDiscoveryMatch {
 	type ProbeMatch struct {
 		XMLName xml.Name `xml:"Envelope"`
 		Body    struct {
 			ProbeMatches struct {
 				ProbeMatch []struct {
 					XAddrs string `xml:"XAddrs"`
+					Scopes string `xml:"Scopes"`
 				} `xml:"ProbeMatch"`
 			} `xml:"ProbeMatches"`
 		} `xml:"Body"`
@@ -93,8 +124,9 @@ func extractXAddrsFromProbeMatch(response string, logger logging.Logger) []*url.
 		logger.Warnf("error unmarshalling ONVIF discovery xml response: %w\nFull xml resp: %s", err, response)
 	}
 
-	xaddrs := []*url.URL{}
+	matches := []wsDiscoveryMatch{}
 	for _, match := range probeMatch.Body.ProbeMatches.ProbeMatch {
+		scopes := strings.Fields(match.Scopes)
 		for _, xaddr := range strings.Split(match.XAddrs, " ") {
 			parsedURL, err := url.Parse(xaddr)
 			if err != nil {
@@ -115,11 +147,11 @@ func extractXAddrsFromProbeMatch(response string, logger logging.Logger) []*url.
 				continue
 			}
 
-			xaddrs = append(xaddrs, parsedURL)
+			matches = append(matches, wsDiscoveryMatch{xaddr: parsedURL, scopes: scopes})
 		}
 	}
 
-	return xaddrs
+	return matches
 }
 
 // TODO(Nick S): What happens if we don't do this?
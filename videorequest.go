@@ -24,6 +24,13 @@ func (vr *videoRequest) active() bool {
 	return vr.mux != nil
 }
 
+// pending returns whether a mux has requested video that it hasn't been sent yet.
+func (vr *videoRequest) pending() bool {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+	return vr.mux != nil && !vr.started
+}
+
 func (vr *videoRequest) newRequest(mux registry.Mux) (context.Context, error) {
 	vr.mu.Lock()
 	defer vr.mu.Unlock()
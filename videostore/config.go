@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"path/filepath"
+	"time"
 
 	"github.com/viam-modules/video-store/videostore"
 	rutils "go.viam.com/rdk/utils"
@@ -18,18 +19,130 @@ type Video struct {
 
 // Config is the config for videostore.
 type Config struct {
-	Camera     *string `json:"camera,omitempty"`
-	SourceName string  `json:"source_name,omitempty"`
-	Storage    Storage `json:"storage"`
-	Video      Video   `json:"video,omitempty"`
-	Framerate  int     `json:"framerate,omitempty"`
+	Camera     *string     `json:"camera,omitempty"`
+	SourceName string      `json:"source_name,omitempty"`
+	Storage    Storage     `json:"storage"`
+	Video      Video       `json:"video,omitempty"`
+	Framerate  int         `json:"framerate,omitempty"`
+	HLS        *HLS        `json:"hls,omitempty"`
+	Thumbnails *Thumbnails `json:"thumbnails,omitempty"`
+	Schedule   *Schedule   `json:"schedule,omitempty"`
+}
+
+// Schedule is the optional recording schedule subconfig for videostore. Video is only
+// recorded inside one of its windows.
+type Schedule struct {
+	Timezone string           `json:"timezone,omitempty"`
+	Windows  []ScheduleWindow `json:"windows"`
+}
+
+// ScheduleWindow starts recording when Start fires and stops when Stop fires.
+// Both are standard 5 field cron specs.
+type ScheduleWindow struct {
+	Start string `json:"start"`
+	Stop  string `json:"stop"`
+}
+
+// Thumbnails is the optional thumbnail index subconfig for videostore.
+type Thumbnails struct {
+	IntervalSec int `json:"interval_sec,omitempty"`
+	Width       int `json:"width,omitempty"`
+}
+
+func (c *Thumbnails) interval() time.Duration {
+	if c.IntervalSec == 0 {
+		return defaultThumbnailInterval
+	}
+	return time.Duration(c.IntervalSec) * time.Second
+}
+
+func (c *Thumbnails) width() int {
+	if c.Width == 0 {
+		return defaultThumbnailWidth
+	}
+	return c.Width
+}
+
+func (c *Thumbnails) validate() error {
+	if c.IntervalSec < 0 || c.Width < 0 {
+		return errors.New("thumbnails interval_sec and width can't be negative")
+	}
+	return nil
+}
+
+// HLS is the optional HLS output subconfig for videostore.
+type HLS struct {
+	Address            string `json:"address"`
+	SegmentDurationSec int    `json:"segment_duration_sec,omitempty"`
+	PartDurationMS     int    `json:"part_duration_ms,omitempty"`
+	SegmentCount       int    `json:"segment_count,omitempty"`
+}
+
+func (c *HLS) segmentDuration() time.Duration {
+	if c.SegmentDurationSec == 0 {
+		return defaultHLSSegmentDuration
+	}
+	return time.Duration(c.SegmentDurationSec) * time.Second
+}
+
+func (c *HLS) partDuration() time.Duration {
+	if c.PartDurationMS == 0 {
+		return defaultHLSPartDuration
+	}
+	return time.Duration(c.PartDurationMS) * time.Millisecond
+}
+
+func (c *HLS) segmentCount() int {
+	if c.SegmentCount == 0 {
+		return defaultHLSSegmentCount
+	}
+	return c.SegmentCount
+}
+
+func (c *HLS) validate(path string) error {
+	if c.Address == "" {
+		return utils.NewConfigValidationFieldRequiredError(path, "hls.address")
+	}
+	if c.SegmentDurationSec < 0 || c.PartDurationMS < 0 || c.SegmentCount < 0 {
+		return errors.New("hls segment_duration_sec, part_duration_ms and segment_count can't be negative")
+	}
+	if c.partDuration() >= c.segmentDuration() {
+		return errors.New("hls part_duration_ms must be shorter than segment_duration_sec")
+	}
+	return nil
 }
 
 // Storage is the storage subconfig for videostore.
 type Storage struct {
-	SizeGB      int    `json:"size_gb"`
-	UploadPath  string `json:"upload_path,omitempty"`
-	StoragePath string `json:"storage_path,omitempty"`
+	SizeGB      int         `json:"size_gb"`
+	UploadPath  string      `json:"upload_path,omitempty"`
+	StoragePath string      `json:"storage_path,omitempty"`
+	Encryption  *Encryption `json:"encryption,omitempty"`
+}
+
+// Encryption is the optional encryption at rest subconfig for storage. Video is
+// encrypted with Key, PreviousKeys are only used to read video stored before a rotation.
+type Encryption struct {
+	Key          KeySource   `json:"key"`
+	PreviousKeys []KeySource `json:"previous_keys,omitempty"`
+}
+
+// KeySource reads a hex encoded 32 byte key from a file or an environment variable.
+type KeySource struct {
+	File string `json:"file,omitempty"`
+	Env  string `json:"env,omitempty"`
+}
+
+func (c *Encryption) validate() error {
+	if !encryptionSupported {
+		return errors.New("storage encryption is only supported on linux")
+	}
+	for _, k := range append([]KeySource{c.Key}, c.PreviousKeys...) {
+		if (k.File == "") == (k.Env == "") {
+			return errors.New("encryption keys must set exactly one of file or env")
+		}
+	}
+	return nil
 }
 
 func applyDefaults(cfg *Config, name string) (videostore.Config, error) {
@@ -42,6 +155,9 @@ func applyDefaults(cfg *Config, name string) (videostore.Config, error) {
 	}
 
 	sc := applyStorageDefaults(cfg.Storage, name)
+	if cfg.Thumbnails != nil {
+		sc.SizeGB -= thumbnailStorageGB
+	}
 
 	ec := applyVideoEncoderDefaults(cfg.Video)
 	return videostore.Config{
@@ -64,10 +180,40 @@ func (cfg *Config) Validate(path string) ([]string, []string, error) {
 		return nil, nil, utils.NewConfigValidationFieldRequiredError(path, "size_gb")
 	}
 
+	if cfg.Storage.Encryption != nil {
+		if err := cfg.Storage.Encryption.validate(); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	if cfg.Framerate < 0 {
 		return nil, nil, fmt.Errorf("invalid framerate %d, must be greater than 0", cfg.Framerate)
 	}
 
+	if cfg.HLS != nil {
+		if err := cfg.HLS.validate(path); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if cfg.Thumbnails != nil {
+		if err := cfg.Thumbnails.validate(); err != nil {
+			return nil, nil, err
+		}
+		if cfg.Storage.SizeGB <= thumbnailStorageGB {
+			return nil, nil, fmt.Errorf("size_gb must be greater than %d when thumbnails are enabled", thumbnailStorageGB)
+		}
+	}
+
+	if cfg.Schedule != nil {
+		if cfg.Camera == nil {
+			return nil, nil, errors.New("schedule requires a camera")
+		}
+		if err := cfg.Schedule.validate(); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	sConfig := applyStorageDefaults(cfg.Storage, "someprefix")
 	if err := sConfig.Validate(); err != nil {
 		return nil, nil, err
@@ -0,0 +1,911 @@
+package videostore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/viam-modules/video-store/videostore"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/services/video"
+	"go.viam.com/utils"
+	"golang.org/x/sync/semaphore"
+)
+
+const (
+	encryptionKeySize = 32
+	// files are sealed a chunk at a time so they never have to be held in memory whole.
+	encryptionChunkSize = 1024 * 1024 // bytes
+	encryptionInterval  = 10 * time.Second
+	// the newest segment is encrypted once nothing is being recorded, or once it hasn't been
+	// written to for this long when that isn't known.
+	segmentIdleTime  = 5 * time.Minute
+	encryptedDirName = "encrypted"
+	// decryptDirName only ever holds links to video decrypted into memory.
+	decryptDirName = ".decrypted"
+	// how much decrypted video all requests may hold in memory at once.
+	maxDecryptedBytes = 1024 * 1024 * 1024 // bytes
+
+	// encrypted files start with encryptedMagic, the id of the key they are encrypted
+	// with and a random nonce prefix. mp4 files start with a box size so never match it.
+	encryptedMagic      = "VSENC1"
+	keyIDSize           = 8
+	noncePrefixSize     = 8
+	encryptedHeaderSize = len(encryptedMagic) + keyIDSize + noncePrefixSize
+)
+
+type encryptionKey struct {
+	id   [keyIDSize]byte
+	aead cipher.AEAD
+}
+
+func newEncryptionKey(raw []byte) (*encryptionKey, error) {
+	if len(raw) != encryptionKeySize {
+		return nil, fmt.Errorf("encryption keys must be %d bytes, got %d", encryptionKeySize, len(raw))
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	k := &encryptionKey{aead: aead}
+	sum := sha256.Sum256(raw)
+	copy(k.id[:], sum[:])
+	return k, nil
+}
+
+// keyring encrypts with the current key and decrypts with any key it holds.
+type keyring struct {
+	current *encryptionKey
+	byID    map[[keyIDSize]byte]*encryptionKey
+}
+
+func newKeyring(current *encryptionKey, previous ...*encryptionKey) *keyring {
+	k := &keyring{current: current, byID: map[[keyIDSize]byte]*encryptionKey{}}
+	for _, key := range append([]*encryptionKey{current}, previous...) {
+		k.byID[key.id] = key
+	}
+	return k
+}
+
+func loadKeyring(cfg *Encryption) (*keyring, error) {
+	current, err := readKey(cfg.Key)
+	if err != nil {
+		return nil, err
+	}
+	previous := make([]*encryptionKey, 0, len(cfg.PreviousKeys))
+	for _, src := range cfg.PreviousKeys {
+		key, err := readKey(src)
+		if err != nil {
+			return nil, err
+		}
+		previous = append(previous, key)
+	}
+	return newKeyring(current, previous...), nil
+}
+
+// readKey reads a hex encoded key, errors never include the key itself.
+func readKey(src KeySource) (*encryptionKey, error) {
+	var text string
+	switch {
+	case src.File != "":
+		//nolint:gosec // the key file is configured by the user
+		data, err := os.ReadFile(src.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption key: %w", err)
+		}
+		text = string(data)
+	case src.Env != "":
+		v, ok := os.LookupEnv(src.Env)
+		if !ok {
+			return nil, fmt.Errorf("encryption key environment variable %s is not set", src.Env)
+		}
+		text = v
+	default:
+		return nil, errors.New("encryption keys must set exactly one of file or env")
+	}
+	raw, err := hex.DecodeString(strings.TrimSpace(text))
+	if err != nil {
+		return nil, errors.New("encryption keys must be hex encoded")
+	}
+	return newEncryptionKey(raw)
+}
+
+func isEncrypted(header []byte) bool {
+	return len(header) >= encryptedHeaderSize && string(header[:len(encryptedMagic)]) == encryptedMagic
+}
+
+func (k *keyring) key(header []byte) (*encryptionKey, error) {
+	var id [keyIDSize]byte
+	copy(id[:], header[len(encryptedMagic):])
+	key, ok := k.byID[id]
+	if !ok {
+		return nil, fmt.Errorf("video is encrypted with unknown key %s, add it to previous_keys", hex.EncodeToString(id[:]))
+	}
+	return key, nil
+}
+
+// chunkNonce is the file's random nonce prefix followed by the chunk index.
+func chunkNonce(prefix []byte, index uint32) []byte {
+	nonce := make([]byte, noncePrefixSize+4)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], index)
+	return nonce
+}
+
+// chunkAAD binds every chunk to the file header and marks the last one, so chunks
+// can't be moved between files and a file can't be truncated at a chunk boundary.
+func chunkAAD(header []byte, last bool) []byte {
+	aad := append(make([]byte, 0, len(header)+1), header...)
+	if last {
+		return append(aad, 1)
+	}
+	return append(aad, 0)
+}
+
+// readChunk fills buf from r and reports whether it was the last chunk.
+func readChunk(r *bufio.Reader, buf []byte) (int, bool, error) {
+	n, err := io.ReadFull(r, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return n, true, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if _, err := r.Peek(1); errors.Is(err, io.EOF) {
+		return n, true, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return n, false, nil
+}
+
+// encrypt writes r to w encrypted with key using AES-GCM.
+func encrypt(w io.Writer, r io.Reader, key *encryptionKey) error {
+	header := make([]byte, 0, encryptedHeaderSize)
+	header = append(header, encryptedMagic...)
+	header = append(header, key.id[:]...)
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return err
+	}
+	header = append(header, prefix...)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(r)
+	buf := make([]byte, encryptionChunkSize)
+	var sealed []byte
+	for index := uint32(0); ; index++ {
+		n, last, err := readChunk(br, buf)
+		if err != nil {
+			return err
+		}
+		sealed = key.aead.Seal(sealed[:0], chunkNonce(prefix, index), buf[:n], chunkAAD(header, last))
+		if _, err := w.Write(sealed); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// decrypt writes the plaintext of r to w. Plaintext written before an error must be
+// discarded, the error means the rest of it could not be authenticated.
+func (k *keyring) decrypt(w io.Writer, r io.Reader) error {
+	header := make([]byte, encryptedHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return fmt.Errorf("failed to read encryption header: %w", err)
+	}
+	if !isEncrypted(header) {
+		return errors.New("video is not encrypted")
+	}
+	key, err := k.key(header)
+	if err != nil {
+		return err
+	}
+	prefix := header[len(encryptedMagic)+keyIDSize:]
+
+	br := bufio.NewReader(r)
+	buf := make([]byte, encryptionChunkSize+key.aead.Overhead())
+	var plain []byte
+	for index := uint32(0); ; index++ {
+		n, last, err := readChunk(br, buf)
+		if err != nil {
+			return err
+		}
+		plain, err = key.aead.Open(plain[:0], chunkNonce(prefix, index), buf[:n], chunkAAD(header, last))
+		if err != nil {
+			return fmt.Errorf("failed to decrypt video: %w", err)
+		}
+		if _, err := w.Write(plain); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// open returns the plaintext of the file at path, which may or may not be encrypted.
+func (k *keyring) open(path string) (io.ReadCloser, error) {
+	//nolint:gosec // paths come from listing the storage directories
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(f)
+	header, err := br.Peek(encryptedHeaderSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		utils.UncheckedError(f.Close())
+		return nil, err
+	}
+	if !isEncrypted(header) {
+		return struct {
+			io.Reader
+			io.Closer
+		}{br, f}, nil
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(k.decrypt(pw, br))
+	}()
+	return struct {
+		io.Reader
+		io.Closer
+	}{pr, closerFunc(func() error {
+		utils.UncheckedError(pr.Close())
+		return f.Close()
+	})}, nil
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+// readFile returns the plaintext of the file at path.
+func (k *keyring) readFile(path string) ([]byte, error) {
+	r, err := k.open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.UncheckedErrorFunc(r.Close)
+	return io.ReadAll(r)
+}
+
+// writeFile encrypts r with the current key into dst. It is written to a hidden temporary
+// file and renamed into place, so readers and uploads never see a partial file.
+func (k *keyring) writeFile(dst string, r io.Reader, perm os.FileMode) error {
+	tmp, err := k.writeTemp(dst, r, perm)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+func (k *keyring) writeTemp(dst string, r io.Reader, perm os.FileMode) (string, error) {
+	tmp := filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp")
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return "", err
+	}
+	if err := encrypt(out, r, k.current); err != nil {
+		utils.UncheckedError(out.Close())
+		utils.UncheckedError(os.Remove(tmp))
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		utils.UncheckedError(os.Remove(tmp))
+		return "", err
+	}
+	return tmp, nil
+}
+
+// sealFile encrypts the file at src with the current key into dst, which may be src,
+// decrypting it first if it is encrypted with another key. It returns an error wrapping
+// os.ErrNotExist if src is deleted before dst is renamed into place.
+func (k *keyring) sealFile(dst, src string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	r, err := k.open(src)
+	if err != nil {
+		return err
+	}
+	defer utils.UncheckedErrorFunc(r.Close)
+	tmp, err := k.writeTemp(dst, r, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(src); err != nil {
+		// video-store deleted it while it was encrypted, don't bring it back
+		utils.UncheckedError(os.Remove(tmp))
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// keyID returns the id of the key the file at path is encrypted with, or false if it
+// isn't encrypted.
+func keyID(path string) ([keyIDSize]byte, bool, error) {
+	var id [keyIDSize]byte
+	//nolint:gosec // paths come from listing the storage directories
+	f, err := os.Open(path)
+	if err != nil {
+		return id, false, err
+	}
+	defer utils.UncheckedErrorFunc(f.Close)
+	header := make([]byte, encryptedHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil || !isEncrypted(header) {
+		return id, false, nil
+	}
+	copy(id[:], header[len(encryptedMagic):])
+	return id, true, nil
+}
+
+// encryptedStore moves the segments another video store records out of its storage path
+// once they are finished, encrypting them into a directory video-store does not index, so
+// video-store only ever holds the segment it is recording. Requests decrypt the segments
+// they need into memory and are served by a read-only video store over a directory of
+// links to them, so decrypted video is never written to disk.
+type encryptedStore struct {
+	inner   videostore.VideoStore
+	keys    *keyring
+	name    string
+	storage videostore.StorageConfig
+	// dir holds the encrypted segments, maxBytes is how much of it is kept.
+	dir      string
+	maxBytes int64
+	// recording reports whether inner is writing a segment, nil means always.
+	recording func() bool
+	logger    logging.Logger
+	// openReadOnly opens a video store over decrypted segments.
+	openReadOnly func(ctx context.Context, cfg videostore.Config) (videostore.VideoStore, error)
+	// mu serializes moving segments, so Close can move the last one once inner is closed.
+	mu      sync.Mutex
+	workers *utils.StoppableWorkers
+}
+
+func newEncryptedStore(
+	inner videostore.VideoStore,
+	keys *keyring,
+	cfg videostore.Config,
+	recording func() bool,
+	logger logging.Logger,
+) (*encryptedStore, error) {
+	// fail on startup rather than on the first request where video can't be decrypted into memory
+	f, err := memFile("probe")
+	if err != nil {
+		return nil, fmt.Errorf("encryption at rest is not supported on this platform: %w", err)
+	}
+	utils.UncheckedError(f.Close())
+
+	e := &encryptedStore{
+		inner:     inner,
+		keys:      keys,
+		name:      cfg.Name,
+		storage:   cfg.Storage,
+		dir:       encryptedSegmentDir(cfg.Storage.StoragePath),
+		maxBytes:  int64(cfg.Storage.SizeGB) * gibibyte,
+		recording: recording,
+		logger:    logger,
+		openReadOnly: func(ctx context.Context, cfg videostore.Config) (videostore.VideoStore, error) {
+			return videostore.NewReadOnlyVideoStore(ctx, cfg, logger)
+		},
+	}
+	// links left behind by a crash, the video they pointed to went with the process
+	if err := os.RemoveAll(e.decryptDir()); err != nil {
+		return nil, fmt.Errorf("failed to clean up decrypted video: %w", err)
+	}
+	if err := os.MkdirAll(e.decryptDir(), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create decrypted video directory: %w", err)
+	}
+	if err := os.MkdirAll(e.dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create encrypted video directory: %w", err)
+	}
+	e.workers = utils.NewBackgroundStoppableWorkers(e.run)
+	return e, nil
+}
+
+func encryptedSegmentDir(storagePath string) string {
+	return filepath.Join(storagePath, encryptedDirName)
+}
+
+func (e *encryptedStore) decryptDir() string {
+	return filepath.Join(e.storage.StoragePath, decryptDirName)
+}
+
+func (e *encryptedStore) run(ctx context.Context) {
+	ticker := time.NewTicker(encryptionInterval)
+	defer ticker.Stop()
+	for {
+		if err := e.moveSegments(ctx, time.Now(), false); err != nil && ctx.Err() == nil {
+			e.logger.Errorf("failed to encrypt stored video: %s", err.Error())
+		}
+		if err := e.rotateSegments(ctx); err != nil && ctx.Err() == nil {
+			e.logger.Errorf("failed to re-encrypt stored video: %s", err.Error())
+		}
+		if err := e.prune(); err != nil {
+			e.logger.Errorf("failed to delete old encrypted video: %s", err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// moveSegments encrypts the finished segments in the storage path into dir and deletes
+// them from the storage path. The newest segment is only moved once it is finished, or
+// regardless when all is set.
+func (e *encryptedStore) moveSegments(ctx context.Context, now time.Time, all bool) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	segments, err := listSegments(e.storage.StoragePath)
+	if err != nil {
+		return err
+	}
+	for i, seg := range segments {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if i == len(segments)-1 && !all {
+			finished, err := e.finished(seg.path, now)
+			if err != nil {
+				return err
+			}
+			if !finished {
+				continue
+			}
+		}
+		dst := filepath.Join(e.dir, filepath.Base(seg.path))
+		if err := e.keys.sealFile(dst, seg.path); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// deleted by video-store since it was listed
+				continue
+			}
+			return fmt.Errorf("failed to encrypt %s: %w", filepath.Base(seg.path), err)
+		}
+		if err := os.Remove(seg.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// finished reports whether the newest segment is done being written. It is once nothing
+// is being recorded, or once it hasn't been written to for segmentIdleTime.
+func (e *encryptedStore) finished(path string, now time.Time) (bool, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	idle := now.Sub(info.ModTime())
+	if idle >= segmentIdleTime {
+		return true, nil
+	}
+	// a segment video-store only just created may not have been written to yet
+	return e.recording != nil && !e.recording() && idle >= encryptionInterval, nil
+}
+
+// rotateSegments re-encrypts the segments in dir that aren't encrypted with the current key.
+func (e *encryptedStore) rotateSegments(ctx context.Context) error {
+	segments, err := listSegments(e.dir)
+	if err != nil {
+		return err
+	}
+	for _, seg := range segments {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		id, encrypted, err := keyID(seg.path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if encrypted && id == e.keys.current.id {
+			continue
+		}
+		if err := e.keys.sealFile(seg.path, seg.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to encrypt %s: %w", filepath.Base(seg.path), err)
+		}
+	}
+	return nil
+}
+
+// prune deletes the oldest encrypted segments once they use more than maxBytes.
+func (e *encryptedStore) prune() error {
+	segments, err := listSegments(e.dir)
+	if err != nil {
+		return err
+	}
+	sizes := make([]int64, len(segments))
+	var total int64
+	for i, seg := range segments {
+		info, err := os.Stat(seg.path)
+		if err != nil {
+			continue
+		}
+		sizes[i] = info.Size()
+		total += sizes[i]
+	}
+	for i, seg := range segments {
+		if total <= e.maxBytes {
+			break
+		}
+		if err := os.Remove(seg.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		total -= sizes[i]
+	}
+	return nil
+}
+
+// sizeBytes returns how many bytes the encrypted segments use.
+func (e *encryptedStore) sizeBytes() (int64, error) {
+	segments, err := listSegments(e.dir)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, seg := range segments {
+		info, err := os.Stat(seg.path)
+		if err != nil {
+			continue
+		}
+		total += info.Size()
+	}
+	return total, nil
+}
+
+// decryptBudget is shared by every request in the process, so concurrent requests can't
+// hold more than maxDecryptedBytes of decrypted video between them.
+var decryptBudget = semaphore.NewWeighted(maxDecryptedBytes)
+
+// decryptedView is a read-only video store over a directory of links to the plaintext of
+// segments held open in memory.
+type decryptedView struct {
+	vs       videostore.VideoStore
+	dir      string
+	files    []*os.File
+	names    map[string]bool
+	reserved int64
+}
+
+func (v *decryptedView) close() error {
+	if v.vs != nil {
+		v.vs.Close()
+	}
+	err := os.RemoveAll(v.dir)
+	for _, f := range v.files {
+		utils.UncheckedError(f.Close())
+	}
+	decryptBudget.Release(v.reserved)
+	return err
+}
+
+func (e *encryptedStore) closeView(v *decryptedView) {
+	if err := v.close(); err != nil {
+		e.logger.Errorf("failed to remove decrypted video links: %s", err.Error())
+	}
+}
+
+// segmentsBetween returns the segments overlapping from and to, whether they are still in
+// the storage path or already encrypted.
+func (e *encryptedStore) segmentsBetween(from, to time.Time) ([]segment, error) {
+	recorded, err := listSegments(e.storage.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := listSegments(e.dir)
+	if err != nil {
+		return nil, err
+	}
+	// a segment being moved can briefly be in both
+	byName := map[string]segment{}
+	for _, seg := range append(encrypted, recorded...) {
+		byName[filepath.Base(seg.path)] = seg
+	}
+	all := make([]segment, 0, len(byName))
+	for _, seg := range byName {
+		all = append(all, seg)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].start.Before(all[j].start) })
+
+	var segments []segment
+	for i, seg := range all {
+		// a segment runs until the next one starts
+		if !seg.start.Before(to) {
+			break
+		}
+		if i+1 < len(all) && !all[i+1].start.After(from) {
+			continue
+		}
+		segments = append(segments, seg)
+	}
+	return segments, nil
+}
+
+// openView links segments into a new directory and opens a read-only video store over it.
+// Segments still in the storage path are linked as they are, encrypted ones are decrypted
+// into memory once decryptBudget has room for them.
+func (e *encryptedStore) openView(ctx context.Context, segments []segment) (*decryptedView, error) {
+	// a segment's plaintext is never larger than the file listed, encrypted or not
+	var size int64
+	for _, seg := range segments {
+		if info, err := os.Stat(seg.path); err == nil {
+			size += info.Size()
+		}
+	}
+	if size > maxDecryptedBytes {
+		return nil, fmt.Errorf("requests can decrypt at most %d bytes of video, request a shorter range", maxDecryptedBytes)
+	}
+	if err := decryptBudget.Acquire(ctx, size); err != nil {
+		return nil, fmt.Errorf("failed waiting for other requests to finish decrypting video: %w", err)
+	}
+	dir, err := os.MkdirTemp(e.decryptDir(), "fetch")
+	if err != nil {
+		decryptBudget.Release(size)
+		return nil, err
+	}
+	v := &decryptedView{dir: dir, names: map[string]bool{}, reserved: size}
+	for _, seg := range segments {
+		name := filepath.Base(seg.path)
+		f, err := e.openSegment(name)
+		if errors.Is(err, os.ErrNotExist) {
+			// deleted by video-store since it was listed
+			continue
+		}
+		if err == nil {
+			v.files = append(v.files, f)
+			err = os.Symlink(fdPath(f), filepath.Join(v.dir, name))
+		}
+		if err != nil {
+			e.closeView(v)
+			return nil, fmt.Errorf("failed to decrypt %s: %w", name, err)
+		}
+		v.names[name] = true
+	}
+	storage := e.storage
+	storage.StoragePath = v.dir
+	v.vs, err = e.openReadOnly(ctx, videostore.Config{Name: e.name, Type: videostore.SourceTypeReadOnly, Storage: storage})
+	if err != nil {
+		e.closeView(v)
+		return nil, err
+	}
+	return v, nil
+}
+
+// openSegment opens the plaintext of the named segment, preferring the storage path
+// since a segment is only deleted from it once it has been encrypted.
+func (e *encryptedStore) openSegment(name string) (*os.File, error) {
+	//nolint:gosec // names come from listing the storage directories
+	f, err := os.Open(filepath.Join(e.storage.StoragePath, name))
+	if errors.Is(err, os.ErrNotExist) {
+		//nolint:gosec // names come from listing the storage directories
+		f, err = os.Open(filepath.Join(e.dir, name))
+	}
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, encryptedHeaderSize)
+	n, err := io.ReadFull(f, header)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		utils.UncheckedError(f.Close())
+		return nil, err
+	}
+	if !isEncrypted(header[:n]) {
+		// the segment being recorded
+		return f, nil
+	}
+	defer utils.UncheckedErrorFunc(f.Close)
+	mem, err := memFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.keys.decrypt(mem, io.MultiReader(bytes.NewReader(header), f)); err != nil {
+		utils.UncheckedError(mem.Close())
+		return nil, err
+	}
+	return mem, nil
+}
+
+// withDecrypted runs fn against a read-only video store over the decrypted segments
+// between from and to.
+func (e *encryptedStore) withDecrypted(
+	ctx context.Context,
+	from, to time.Time,
+	fn func(videostore.VideoStore) error,
+) error {
+	segments, err := e.segmentsBetween(from, to)
+	if err != nil {
+		return err
+	}
+	v, err := e.openView(ctx, segments)
+	if err != nil {
+		return err
+	}
+	defer e.closeView(v)
+	return fn(v.vs)
+}
+
+// viewCache serves fetches from the view opened for an earlier one while they only need
+// segments it already decrypted, so fetching many short ranges in order, like the samples
+// of a timelapse, decrypts each segment once.
+type viewCache struct {
+	e    *encryptedStore
+	view *decryptedView
+}
+
+func (e *encryptedStore) newViewCache() *viewCache {
+	return &viewCache{e: e}
+}
+
+func (c *viewCache) Fetch(ctx context.Context, r *videostore.FetchRequest) (*videostore.FetchResponse, error) {
+	segments, err := c.e.segmentsBetween(r.From, r.To)
+	if err != nil {
+		return nil, err
+	}
+	if !c.holds(segments) {
+		c.close()
+		if c.view, err = c.e.openView(ctx, segments); err != nil {
+			return nil, err
+		}
+	}
+	return c.view.vs.Fetch(ctx, r)
+}
+
+// holds reports whether the cached view has all of segments. The segment being recorded is
+// never reused, since the read-only video store may not see what was written to it since.
+func (c *viewCache) holds(segments []segment) bool {
+	if c.view == nil {
+		return false
+	}
+	for _, seg := range segments {
+		if filepath.Dir(seg.path) != c.e.dir || !c.view.names[filepath.Base(seg.path)] {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *viewCache) close() {
+	if c.view != nil {
+		c.e.closeView(c.view)
+		c.view = nil
+	}
+}
+
+func (e *encryptedStore) Fetch(ctx context.Context, r *videostore.FetchRequest) (*videostore.FetchResponse, error) {
+	var res *videostore.FetchResponse
+	err := e.withDecrypted(ctx, r.From, r.To, func(vs videostore.VideoStore) error {
+		var err error
+		res, err = vs.Fetch(ctx, r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (e *encryptedStore) FetchStream(ctx context.Context, r *videostore.FetchRequest, emit func(video.Chunk) error) error {
+	return e.withDecrypted(ctx, r.From, r.To, func(vs videostore.VideoStore) error {
+		return vs.FetchStream(ctx, r, emit)
+	})
+}
+
+// Save writes a clip to the upload path. Clips are written unencrypted since they are
+// uploaded by data manager to be played back, encryption only protects the video kept on
+// the device. They are named <name>_<from>_<metadata>.mp4 with from in UTC, so async saves
+// can return the filename before the clip is written.
+func (e *encryptedStore) Save(ctx context.Context, r *videostore.SaveRequest) (*videostore.SaveResponse, error) {
+	if !r.To.After(r.From) {
+		return nil, errors.New("to timestamp must be after from timestamp")
+	}
+	filename := fmt.Sprintf("%s_%s", e.name, formatUTCDatetime(r.From))
+	if r.Metadata != "" {
+		filename += "_" + r.Metadata
+	}
+	filename += segmentExt
+	if !r.Async {
+		if err := e.save(ctx, r, filename); err != nil {
+			return nil, err
+		}
+		return &videostore.SaveResponse{Filename: filename}, nil
+	}
+	e.workers.Add(func(ctx context.Context) {
+		if err := e.save(ctx, r, filename); err != nil {
+			e.logger.Errorf("failed to save %s: %s", filename, err.Error())
+		}
+	})
+	return &videostore.SaveResponse{Filename: filename}, nil
+}
+
+// save fetches the clip rather than having the read-only video store save it, so it gets
+// the filename Save already returned.
+func (e *encryptedStore) save(ctx context.Context, r *videostore.SaveRequest, filename string) error {
+	var clip []byte
+	err := e.withDecrypted(ctx, r.From, r.To, func(vs videostore.VideoStore) error {
+		res, err := vs.Fetch(ctx, &videostore.FetchRequest{From: r.From, To: r.To, Container: videostore.ContainerMP4})
+		if err != nil {
+			return err
+		}
+		clip = res.Video
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(e.storage.UploadPath, 0o755); err != nil {
+		return fmt.Errorf("failed to create upload path: %w", err)
+	}
+	// written to a hidden file and renamed, so data manager never uploads a partial clip
+	dst := filepath.Join(e.storage.UploadPath, filename)
+	tmp := filepath.Join(e.storage.UploadPath, "."+filename+".tmp")
+	//nolint:gosec // clips are uploaded by data manager which needs to read them
+	if err := os.WriteFile(tmp, clip, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// GetStorageState returns the state of the storage path, which only holds the segments
+// that haven't been encrypted yet.
+func (e *encryptedStore) GetStorageState(ctx context.Context) (*videostore.StorageState, error) {
+	return e.inner.GetStorageState(ctx)
+}
+
+// Close closes inner, which finishes the segment being recorded, and then encrypts it.
+func (e *encryptedStore) Close() {
+	e.workers.Stop()
+	e.inner.Close()
+	if err := e.moveSegments(context.Background(), time.Now(), true); err != nil {
+		e.logger.Errorf("failed to encrypt stored video: %s", err.Error())
+	}
+}
+
+// writeFile writes data to path, encrypted when keys is set.
+func writeFile(keys *keyring, path string, data []byte, perm os.FileMode) error {
+	if keys == nil {
+		//nolint:gosec // stored video is not encrypted unless configured
+		return os.WriteFile(path, data, perm)
+	}
+	return keys.writeFile(path, bytes.NewReader(data), perm)
+}
+
+// readFile reads path, decrypting it when keys is set.
+func readFile(keys *keyring, path string) ([]byte, error) {
+	if keys == nil {
+		//nolint:gosec // paths come from listing the storage directories
+		return os.ReadFile(path)
+	}
+	return keys.readFile(path)
+}
@@ -0,0 +1,26 @@
+package videostore
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// encryptionSupported reports whether video can be decrypted into memory with memFile.
+const encryptionSupported = true
+
+// memFile returns an anonymous file that lives in memory and is freed once it is closed
+// or the process exits.
+func memFile(name string) (*os.File, error) {
+	fd, err := unix.MemfdCreate(name, unix.MFD_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), name), nil
+}
+
+// fdPath returns a path other code in this process can open f by.
+func fdPath(f *os.File) string {
+	return fmt.Sprintf("/proc/self/fd/%d", f.Fd())
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package videostore
+
+import (
+	"errors"
+	"os"
+)
+
+const encryptionSupported = false
+
+// memFile is only supported on linux, which android builds count as.
+func memFile(string) (*os.File, error) {
+	return nil, errors.New("video can only be decrypted into memory on linux")
+}
+
+func fdPath(*os.File) string {
+	return ""
+}
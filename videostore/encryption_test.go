@@ -0,0 +1,405 @@
+package videostore
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/viam-modules/video-store/videostore"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/services/video"
+	"go.viam.com/test"
+	"go.viam.com/utils"
+	"golang.org/x/sync/semaphore"
+)
+
+func newTestKey(t *testing.T) (*encryptionKey, string) {
+	t.Helper()
+	raw := make([]byte, encryptionKeySize)
+	_, err := rand.Read(raw)
+	test.That(t, err, test.ShouldBeNil)
+	key, err := newEncryptionKey(raw)
+	test.That(t, err, test.ShouldBeNil)
+	return key, hex.EncodeToString(raw)
+}
+
+func encryptBytes(t *testing.T, key *encryptionKey, plain []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	test.That(t, encrypt(&buf, bytes.NewReader(plain), key), test.ShouldBeNil)
+	return buf.Bytes()
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key, _ := newTestKey(t)
+	keys := newKeyring(key)
+
+	for _, size := range []int{0, 1, encryptionChunkSize - 1, encryptionChunkSize, 2*encryptionChunkSize + 7} {
+		plain := make([]byte, size)
+		_, err := rand.Read(plain)
+		test.That(t, err, test.ShouldBeNil)
+		sealed := encryptBytes(t, key, plain)
+		test.That(t, isEncrypted(sealed), test.ShouldBeTrue)
+		// short plaintexts can turn up in random ciphertext by chance
+		if size > 16 {
+			test.That(t, bytes.Contains(sealed, plain), test.ShouldBeFalse)
+		}
+
+		var out bytes.Buffer
+		test.That(t, keys.decrypt(&out, bytes.NewReader(sealed)), test.ShouldBeNil)
+		test.That(t, bytes.Equal(out.Bytes(), plain), test.ShouldBeTrue)
+	}
+
+	plain := make([]byte, 2*encryptionChunkSize)
+	sealed := encryptBytes(t, key, plain)
+
+	t.Run("wrong key", func(t *testing.T) {
+		other, _ := newTestKey(t)
+		err := newKeyring(other).decrypt(&bytes.Buffer{}, bytes.NewReader(sealed))
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "unknown key")
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		tampered := append([]byte{}, sealed...)
+		tampered[len(tampered)-100] ^= 1
+		test.That(t, keys.decrypt(&bytes.Buffer{}, bytes.NewReader(tampered)), test.ShouldNotBeNil)
+	})
+
+	t.Run("truncated at a chunk boundary", func(t *testing.T) {
+		chunk := encryptionChunkSize + key.aead.Overhead()
+		truncated := sealed[:encryptedHeaderSize+chunk]
+		test.That(t, keys.decrypt(&bytes.Buffer{}, bytes.NewReader(truncated)), test.ShouldNotBeNil)
+		test.That(t, keys.decrypt(&bytes.Buffer{}, bytes.NewReader(sealed[:encryptedHeaderSize])), test.ShouldNotBeNil)
+	})
+
+	t.Run("previous keys decrypt", func(t *testing.T) {
+		current, _ := newTestKey(t)
+		var out bytes.Buffer
+		test.That(t, newKeyring(current, key).decrypt(&out, bytes.NewReader(sealed)), test.ShouldBeNil)
+		test.That(t, out.Bytes(), test.ShouldResemble, plain)
+	})
+}
+
+func TestLoadKeyring(t *testing.T) {
+	key, keyHex := newTestKey(t)
+	old, oldHex := newTestKey(t)
+	keyFile := filepath.Join(t.TempDir(), "video.key")
+	test.That(t, os.WriteFile(keyFile, []byte(keyHex+"\n"), 0o600), test.ShouldBeNil)
+	t.Setenv("VIDEO_STORE_OLD_KEY", oldHex)
+
+	keys, err := loadKeyring(&Encryption{
+		Key:          KeySource{File: keyFile},
+		PreviousKeys: []KeySource{{Env: "VIDEO_STORE_OLD_KEY"}},
+	})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, keys.current.id, test.ShouldEqual, key.id)
+	test.That(t, len(keys.byID), test.ShouldEqual, 2)
+	_, ok := keys.byID[old.id]
+	test.That(t, ok, test.ShouldBeTrue)
+
+	t.Setenv("VIDEO_STORE_BAD_KEY", "not hex")
+	for _, src := range []KeySource{
+		{},
+		{File: filepath.Join(t.TempDir(), "missing")},
+		{Env: "VIDEO_STORE_UNSET_KEY"},
+		{Env: "VIDEO_STORE_BAD_KEY"},
+	} {
+		_, err := loadKeyring(&Encryption{Key: src})
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldNotContainSubstring, keyHex)
+	}
+
+	test.That(t, (&Encryption{Key: KeySource{File: keyFile}}).validate(), test.ShouldBeNil)
+	test.That(t, (&Encryption{Key: KeySource{File: keyFile, Env: "X"}}).validate(), test.ShouldNotBeNil)
+	test.That(t, (&Encryption{Key: KeySource{Env: "X"}, PreviousKeys: []KeySource{{}}}).validate(), test.ShouldNotBeNil)
+}
+
+// newTestEncryptedStore returns an encrypted store whose read-only stores fetch the
+// concatenated decrypted segments.
+func newTestEncryptedStore(t *testing.T, keys *keyring) *encryptedStore {
+	t.Helper()
+	f, err := memFile("probe")
+	if err != nil {
+		t.Skipf("video can't be decrypted into memory: %s", err.Error())
+	}
+	test.That(t, f.Close(), test.ShouldBeNil)
+
+	storagePath := t.TempDir()
+	test.That(t, os.MkdirAll(filepath.Join(storagePath, decryptDirName), 0o700), test.ShouldBeNil)
+	test.That(t, os.MkdirAll(encryptedSegmentDir(storagePath), 0o700), test.ShouldBeNil)
+	e := &encryptedStore{
+		inner:    &mockVideoStore{},
+		keys:     keys,
+		name:     "cam",
+		storage:  videostore.StorageConfig{StoragePath: storagePath, UploadPath: t.TempDir()},
+		dir:      encryptedSegmentDir(storagePath),
+		maxBytes: gibibyte,
+		logger:   logging.NewTestLogger(t),
+		workers:  utils.NewBackgroundStoppableWorkers(),
+	}
+	e.openReadOnly = func(_ context.Context, cfg videostore.Config) (videostore.VideoStore, error) {
+		concat := func() []byte {
+			entries, err := os.ReadDir(cfg.Storage.StoragePath)
+			test.That(t, err, test.ShouldBeNil)
+			for _, entry := range entries {
+				// decrypted video never touches the disk
+				test.That(t, entry.Type()&os.ModeSymlink, test.ShouldNotEqual, 0)
+			}
+			segments, err := listSegments(cfg.Storage.StoragePath)
+			test.That(t, err, test.ShouldBeNil)
+			var out []byte
+			for _, seg := range segments {
+				data, err := os.ReadFile(seg.path)
+				test.That(t, err, test.ShouldBeNil)
+				out = append(out, data...)
+			}
+			return out
+		}
+		return &mockVideoStore{
+			fetchFunc: func(_ context.Context, req *videostore.FetchRequest) (*videostore.FetchResponse, error) {
+				return &videostore.FetchResponse{Video: concat()}, nil
+			},
+			fetchStreamFunc: func(_ context.Context, _ *videostore.FetchRequest, emit func(video.Chunk) error) error {
+				return emit(video.Chunk{Data: concat()})
+			},
+		}, nil
+	}
+	t.Cleanup(e.workers.Stop)
+	return e
+}
+
+func writeSegment(t *testing.T, dir string, start time.Time, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, start.Local().Format(datetimeFormat)+segmentExt)
+	test.That(t, os.WriteFile(path, data, 0o600), test.ShouldBeNil)
+	return path
+}
+
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	test.That(t, err, test.ShouldBeNil)
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestMoveSegments(t *testing.T) {
+	key, _ := newTestKey(t)
+	old, _ := newTestKey(t)
+	e := newTestEncryptedStore(t, newKeyring(key, old))
+	start := time.Unix(1700000000, 0)
+	now := start.Add(time.Minute)
+	recording := true
+	e.recording = func() bool { return recording }
+
+	plain := writeSegment(t, e.storage.StoragePath, start, []byte("first"))
+	// encrypted in place by an earlier version
+	inPlace := writeSegment(t, e.storage.StoragePath, start.Add(20*time.Second), encryptBytes(t, old, []byte("second")))
+	newest := writeSegment(t, e.storage.StoragePath, start.Add(40*time.Second), []byte("third"))
+	test.That(t, os.Chtimes(newest, now, now), test.ShouldBeNil)
+
+	test.That(t, e.moveSegments(context.Background(), now, false), test.ShouldBeNil)
+	for path, want := range map[string]string{plain: "first", inPlace: "second"} {
+		moved := filepath.Join(e.dir, filepath.Base(path))
+		id, encrypted, err := keyID(moved)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, encrypted, test.ShouldBeTrue)
+		test.That(t, id, test.ShouldEqual, key.id)
+		data, err := e.keys.readFile(moved)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, string(data), test.ShouldEqual, want)
+	}
+
+	// the segment being recorded stays with video-store
+	test.That(t, dirNames(t, e.storage.StoragePath), test.ShouldResemble,
+		[]string{decryptDirName, filepath.Base(newest), encryptedDirName})
+
+	t.Run("the newest segment is moved once recording stops", func(t *testing.T) {
+		test.That(t, e.moveSegments(context.Background(), now.Add(encryptionInterval), false), test.ShouldBeNil)
+		test.That(t, len(dirNames(t, e.dir)), test.ShouldEqual, 2)
+
+		recording = false
+		test.That(t, e.moveSegments(context.Background(), now.Add(encryptionInterval/2), false), test.ShouldBeNil)
+		test.That(t, len(dirNames(t, e.dir)), test.ShouldEqual, 2)
+		test.That(t, e.moveSegments(context.Background(), now.Add(encryptionInterval), false), test.ShouldBeNil)
+		test.That(t, len(dirNames(t, e.dir)), test.ShouldEqual, 3)
+		test.That(t, dirNames(t, e.storage.StoragePath), test.ShouldResemble, []string{decryptDirName, encryptedDirName})
+	})
+
+	t.Run("or once it is idle", func(t *testing.T) {
+		recording = true
+		idle := writeSegment(t, e.storage.StoragePath, start.Add(60*time.Second), []byte("fourth"))
+		test.That(t, os.Chtimes(idle, now, now), test.ShouldBeNil)
+		test.That(t, e.moveSegments(context.Background(), now.Add(segmentIdleTime), false), test.ShouldBeNil)
+		test.That(t, len(dirNames(t, e.dir)), test.ShouldEqual, 4)
+	})
+
+	t.Run("rotation", func(t *testing.T) {
+		rotated := writeSegment(t, e.dir, start.Add(-20*time.Second), encryptBytes(t, old, []byte("zeroth")))
+		test.That(t, e.rotateSegments(context.Background()), test.ShouldBeNil)
+		id, encrypted, err := keyID(rotated)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, encrypted, test.ShouldBeTrue)
+		test.That(t, id, test.ShouldEqual, key.id)
+	})
+
+	t.Run("prune deletes the oldest", func(t *testing.T) {
+		names := dirNames(t, e.dir)
+		info, err := os.Stat(filepath.Join(e.dir, names[len(names)-1]))
+		test.That(t, err, test.ShouldBeNil)
+		e.maxBytes = info.Size()
+		test.That(t, e.prune(), test.ShouldBeNil)
+		test.That(t, dirNames(t, e.dir), test.ShouldResemble, names[len(names)-1:])
+	})
+
+	t.Run("deleted segments are not recreated", func(t *testing.T) {
+		test.That(t, os.Remove(plain), test.ShouldNotBeNil)
+		err := e.keys.sealFile(filepath.Join(e.dir, filepath.Base(plain)), plain)
+		test.That(t, os.IsNotExist(err), test.ShouldBeTrue)
+	})
+}
+
+func TestEncryptedStore(t *testing.T) {
+	key, _ := newTestKey(t)
+	e := newTestEncryptedStore(t, newKeyring(key))
+	start := time.Unix(1700000000, 0)
+	for i, data := range []string{"a", "b", "c", "d"} {
+		writeSegment(t, e.dir, start.Add(time.Duration(i)*20*time.Second), encryptBytes(t, key, []byte(data)))
+	}
+	// being recorded
+	writeSegment(t, e.storage.StoragePath, start.Add(80*time.Second), []byte("e"))
+
+	decryptDirEmpty := func() {
+		test.That(t, dirNames(t, e.decryptDir()), test.ShouldBeEmpty)
+	}
+
+	t.Run("fetch decrypts the overlapping segments", func(t *testing.T) {
+		res, err := e.Fetch(context.Background(), &videostore.FetchRequest{
+			From: start.Add(30 * time.Second), To: start.Add(61 * time.Second),
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, string(res.Video), test.ShouldEqual, "bcd")
+		decryptDirEmpty()
+
+		res, err = e.Fetch(context.Background(), &videostore.FetchRequest{From: start.Add(70 * time.Second), To: start.Add(90 * time.Second)})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, string(res.Video), test.ShouldEqual, "de")
+	})
+
+	t.Run("fetch stream", func(t *testing.T) {
+		var got []byte
+		err := e.FetchStream(context.Background(), &videostore.FetchRequest{From: start, To: start.Add(10 * time.Second)},
+			func(c video.Chunk) error {
+				got = append(got, c.Data...)
+				return nil
+			})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, string(got), test.ShouldEqual, "a")
+		decryptDirEmpty()
+	})
+
+	t.Run("save writes a plaintext clip", func(t *testing.T) {
+		from := start.Add(20 * time.Second)
+		res, err := e.Save(context.Background(), &videostore.SaveRequest{From: from, To: start.Add(50 * time.Second), Metadata: "front"})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, res.Filename, test.ShouldEqual, "cam_"+formatUTCDatetime(from)+"_front.mp4")
+
+		data, err := os.ReadFile(filepath.Join(e.storage.UploadPath, res.Filename))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, string(data), test.ShouldEqual, "bc")
+
+		test.That(t, dirNames(t, e.storage.UploadPath), test.ShouldResemble, []string{res.Filename})
+		decryptDirEmpty()
+	})
+
+	t.Run("async save", func(t *testing.T) {
+		res, err := e.Save(context.Background(), &videostore.SaveRequest{From: start, To: start.Add(10 * time.Second), Async: true})
+		test.That(t, err, test.ShouldBeNil)
+		path := filepath.Join(e.storage.UploadPath, res.Filename)
+		var data []byte
+		for range 100 {
+			if data, err = os.ReadFile(path); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, string(data), test.ShouldEqual, "a")
+	})
+
+	t.Run("view cache reuses decrypted segments", func(t *testing.T) {
+		openReadOnly := e.openReadOnly
+		defer func() { e.openReadOnly = openReadOnly }()
+		opened := 0
+		e.openReadOnly = func(ctx context.Context, cfg videostore.Config) (videostore.VideoStore, error) {
+			opened++
+			return openReadOnly(ctx, cfg)
+		}
+		cache := e.newViewCache()
+		fetch := func(from time.Duration) string {
+			t.Helper()
+			res, err := cache.Fetch(context.Background(), &videostore.FetchRequest{
+				From: start.Add(from), To: start.Add(from + time.Second),
+			})
+			test.That(t, err, test.ShouldBeNil)
+			return string(res.Video)
+		}
+		test.That(t, fetch(0), test.ShouldEqual, "a")
+		test.That(t, fetch(10*time.Second), test.ShouldEqual, "a")
+		test.That(t, opened, test.ShouldEqual, 1)
+		test.That(t, fetch(20*time.Second), test.ShouldEqual, "b")
+		test.That(t, opened, test.ShouldEqual, 2)
+		// the segment being recorded is reopened every time
+		test.That(t, fetch(80*time.Second), test.ShouldEqual, "e")
+		test.That(t, fetch(85*time.Second), test.ShouldEqual, "e")
+		test.That(t, opened, test.ShouldEqual, 4)
+		cache.close()
+		decryptDirEmpty()
+	})
+
+	t.Run("requests share the decrypted video budget", func(t *testing.T) {
+		budget := decryptBudget
+		defer func() { decryptBudget = budget }()
+		// each segment is one byte of video
+		decryptBudget = semaphore.NewWeighted(int64(2 * (encryptedHeaderSize + key.aead.Overhead() + 1)))
+
+		// holds the budget for two segments
+		segments, err := e.segmentsBetween(start, start.Add(30*time.Second))
+		test.That(t, err, test.ShouldBeNil)
+		v, err := e.openView(context.Background(), segments)
+		test.That(t, err, test.ShouldBeNil)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err = e.Fetch(ctx, &videostore.FetchRequest{From: start.Add(40 * time.Second), To: start.Add(50 * time.Second)})
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, errors.Is(err, context.DeadlineExceeded), test.ShouldBeTrue)
+
+		e.closeView(v)
+		res, err := e.Fetch(context.Background(), &videostore.FetchRequest{From: start.Add(40 * time.Second), To: start.Add(50 * time.Second)})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, string(res.Video), test.ShouldEqual, "c")
+		decryptDirEmpty()
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		other, _ := newTestKey(t)
+		writeSegment(t, e.dir, start.Add(-20*time.Second), encryptBytes(t, other, []byte("z")))
+		_, err := e.Fetch(context.Background(), &videostore.FetchRequest{From: start.Add(-10 * time.Second), To: start})
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "unknown key")
+		decryptDirEmpty()
+	})
+}
@@ -0,0 +1,156 @@
+package videostore
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+const (
+	// chunks are base64 encoded, so keep them well under maxGRPCSize.
+	maxFetchChunkSize = 16 * 1024 * 1024 // bytes
+	// how long a chunked fetch is kept after its last chunk was read.
+	fetchCacheTTL = 5 * time.Minute
+	// how many bytes of chunked fetches are kept in memory at once, the least recently
+	// read is dropped first. A single fetch larger than this is rejected.
+	maxFetchCacheBytes = 512 * 1024 * 1024
+)
+
+// chunkRequest is the paging part of a fetch DoCommand.
+type chunkRequest struct {
+	fetchID   string
+	offset    int
+	chunkSize int
+}
+
+// toChunkRequest returns nil when the fetch is not chunked.
+func toChunkRequest(command map[string]interface{}) (*chunkRequest, error) {
+	fetchID, hasID := command["fetch_id"].(string)
+	chunkSize, hasSize := command["chunk_size"].(float64)
+	if !hasID && !hasSize {
+		return nil, nil
+	}
+	if !hasSize {
+		chunkSize = maxFetchChunkSize
+	}
+	if chunkSize < 1 || chunkSize > maxFetchChunkSize {
+		return nil, fmt.Errorf("chunk_size must be between 1 and %d", maxFetchChunkSize)
+	}
+	req := &chunkRequest{fetchID: fetchID, chunkSize: int(chunkSize)}
+	if offset, ok := command["offset"].(float64); ok {
+		if offset < 0 {
+			return nil, errors.New("offset can't be negative")
+		}
+		req.offset = int(offset)
+	}
+	return req, nil
+}
+
+type fetchCacheEntry struct {
+	video    []byte
+	checksum string
+	lastRead time.Time
+}
+
+// fetchCache keeps fetched videos in memory so they can be read back a chunk at a time
+// without fetching them from storage again.
+type fetchCache struct {
+	mu       sync.Mutex
+	entries  map[string]*fetchCacheEntry
+	size     int
+	maxBytes int
+	now      func() time.Time
+}
+
+func newFetchCache() *fetchCache {
+	return &fetchCache{entries: map[string]*fetchCacheEntry{}, maxBytes: maxFetchCacheBytes, now: time.Now}
+}
+
+// add stores video and returns its fetch id and sha256 checksum.
+func (c *fetchCache) add(video []byte) (string, string, error) {
+	if len(video) > c.maxBytes {
+		return "", "", fmt.Errorf("video is %d bytes, chunked fetches can be at most %d bytes, fetch a shorter range",
+			len(video), c.maxBytes)
+	}
+	sum := sha256.Sum256(video)
+	id := uuid.Must(uuid.NewV4()).String()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expireLocked()
+	for c.size+len(video) > c.maxBytes {
+		var oldestID string
+		var oldest time.Time
+		for id, e := range c.entries {
+			if oldestID == "" || e.lastRead.Before(oldest) {
+				oldestID, oldest = id, e.lastRead
+			}
+		}
+		c.deleteLocked(oldestID)
+	}
+	checksum := hex.EncodeToString(sum[:])
+	c.entries[id] = &fetchCacheEntry{video: video, checksum: checksum, lastRead: c.now()}
+	c.size += len(video)
+	return id, checksum, nil
+}
+
+// get returns a chunk of the cached video, the full video size and its checksum.
+func (c *fetchCache) get(id string, offset, chunkSize int) ([]byte, int, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expireLocked()
+	e, ok := c.entries[id]
+	if !ok {
+		return nil, 0, "", fmt.Errorf("fetch_id %s not found, it may have expired", id)
+	}
+	if offset > len(e.video) {
+		return nil, 0, "", fmt.Errorf("offset %d is past the end of the video (%d bytes)", offset, len(e.video))
+	}
+	end := min(offset+chunkSize, len(e.video))
+	e.lastRead = c.now()
+	return e.video[offset:end], len(e.video), e.checksum, nil
+}
+
+func (c *fetchCache) expireLocked() {
+	now := c.now()
+	for id, e := range c.entries {
+		if now.Sub(e.lastRead) > fetchCacheTTL {
+			c.deleteLocked(id)
+		}
+	}
+}
+
+func (c *fetchCache) deleteLocked(id string) {
+	if e, ok := c.entries[id]; ok {
+		c.size -= len(e.video)
+		delete(c.entries, id)
+	}
+}
+
+// fetchChunk reads the next chunk of a cached fetch and builds the fetch DoCommand response.
+func (s *service) fetchChunk(req *chunkRequest) (map[string]interface{}, error) {
+	chunk, size, checksum, err := s.fetches.get(req.fetchID, req.offset, req.chunkSize)
+	if err != nil {
+		return nil, err
+	}
+	next := req.offset + len(chunk)
+	ret := map[string]interface{}{
+		"command":  "fetch",
+		"video":    base64.StdEncoding.EncodeToString(chunk),
+		"fetch_id": req.fetchID,
+		"offset":   req.offset,
+		"size":     size,
+		"sha256":   checksum,
+		"done":     next == size,
+	}
+	if next < size {
+		ret["next_offset"] = next
+	}
+	return ret, nil
+}
@@ -0,0 +1,155 @@
+package videostore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/viam-modules/video-store/videostore"
+	"go.viam.com/test"
+)
+
+func TestChunkedFetch(t *testing.T) {
+	video := make([]byte, 1000)
+	for i := range video {
+		video[i] = byte(i)
+	}
+	var fetches int
+	s := createTestService(t, &mockVideoStore{
+		fetchFunc: func(_ context.Context, _ *videostore.FetchRequest) (*videostore.FetchResponse, error) {
+			fetches++
+			return &videostore.FetchResponse{Video: video}, nil
+		},
+	})
+
+	t.Run("reassembles the video from chunks", func(t *testing.T) {
+		fetches = 0
+		res, err := s.DoCommand(context.Background(), map[string]interface{}{
+			"command":    "fetch",
+			"from":       "2024-01-01_00-00-00",
+			"to":         "2024-01-01_00-01-00",
+			"chunk_size": 300.0,
+		})
+		test.That(t, err, test.ShouldBeNil)
+		fetchID := res["fetch_id"].(string)
+		test.That(t, fetchID, test.ShouldNotBeEmpty)
+		test.That(t, res["size"], test.ShouldEqual, len(video))
+
+		var got []byte
+		for {
+			chunk, err := base64.StdEncoding.DecodeString(res["video"].(string))
+			test.That(t, err, test.ShouldBeNil)
+			test.That(t, res["offset"], test.ShouldEqual, len(got))
+			got = append(got, chunk...)
+			if res["done"].(bool) {
+				break
+			}
+			res, err = s.DoCommand(context.Background(), map[string]interface{}{
+				"command":    "fetch",
+				"fetch_id":   fetchID,
+				"offset":     float64(res["next_offset"].(int)),
+				"chunk_size": 300.0,
+			})
+			test.That(t, err, test.ShouldBeNil)
+		}
+		test.That(t, bytes.Equal(got, video), test.ShouldBeTrue)
+		sum := sha256.Sum256(got)
+		test.That(t, res["sha256"], test.ShouldEqual, hex.EncodeToString(sum[:]))
+		// storage is only read for the first chunk
+		test.That(t, fetches, test.ShouldEqual, 1)
+
+		// the last chunk can be read again
+		_, err = s.DoCommand(context.Background(), map[string]interface{}{
+			"command":  "fetch",
+			"fetch_id": fetchID,
+			"offset":   900.0,
+		})
+		test.That(t, err, test.ShouldBeNil)
+	})
+
+	t.Run("unknown fetch id", func(t *testing.T) {
+		_, err := s.DoCommand(context.Background(), map[string]interface{}{
+			"command":  "fetch",
+			"fetch_id": "bogus",
+		})
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+
+	t.Run("offset past the end", func(t *testing.T) {
+		_, err := s.DoCommand(context.Background(), map[string]interface{}{
+			"command":    "fetch",
+			"from":       "2024-01-01_00-00-00",
+			"to":         "2024-01-01_00-01-00",
+			"chunk_size": 300.0,
+			"offset":     2000.0,
+		})
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+
+	t.Run("invalid chunk size", func(t *testing.T) {
+		_, err := s.DoCommand(context.Background(), map[string]interface{}{
+			"command":    "fetch",
+			"from":       "2024-01-01_00-00-00",
+			"to":         "2024-01-01_00-01-00",
+			"chunk_size": float64(maxFetchChunkSize + 1),
+		})
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+}
+
+func TestFetchCache(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := newFetchCache()
+	c.now = func() time.Time { return now }
+
+	t.Run("expires idle entries", func(t *testing.T) {
+		id, _, err := c.add([]byte("video"))
+		test.That(t, err, test.ShouldBeNil)
+		_, _, _, err = c.get(id, 0, 2)
+		test.That(t, err, test.ShouldBeNil)
+		now = now.Add(fetchCacheTTL + time.Second)
+		_, _, _, err = c.get(id, 0, 2)
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, c.size, test.ShouldEqual, 0)
+	})
+
+	t.Run("drops the least recently read entry when full", func(t *testing.T) {
+		c.maxBytes = 4 * len("video")
+		var ids []string
+		for range 4 {
+			now = now.Add(time.Second)
+			id, _, err := c.add([]byte("video"))
+			test.That(t, err, test.ShouldBeNil)
+			ids = append(ids, id)
+		}
+		now = now.Add(time.Second)
+		_, _, _, err := c.get(ids[0], 0, 1)
+		test.That(t, err, test.ShouldBeNil)
+
+		now = now.Add(time.Second)
+		_, _, err = c.add([]byte("video"))
+		test.That(t, err, test.ShouldBeNil)
+		_, _, _, err = c.get(ids[0], 0, 1)
+		test.That(t, err, test.ShouldBeNil)
+		_, _, _, err = c.get(ids[1], 0, 1)
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, c.size, test.ShouldEqual, c.maxBytes)
+
+		// a larger video drops as many entries as it needs
+		now = now.Add(time.Second)
+		_, _, err = c.add([]byte("videovideo"))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(c.entries), test.ShouldEqual, 3)
+		test.That(t, c.size, test.ShouldEqual, c.maxBytes)
+	})
+
+	t.Run("rejects videos larger than the cache", func(t *testing.T) {
+		_, _, err := c.add(make([]byte, c.maxBytes+1))
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, c.size, test.ShouldEqual, c.maxBytes)
+	})
+}
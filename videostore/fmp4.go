@@ -0,0 +1,668 @@
+//nolint:mnd // box layouts are full of fixed field sizes and values from ISO/IEC 14496-12.
+package videostore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h265"
+	"github.com/viam-modules/video-store/videostore"
+)
+
+// Minimal fragmented MP4 (ISO BMFF) writer used by the HLS endpoint. It only
+// knows how to describe a single H264 or H265 video track, which is all the
+// rtsp cameras hand to video-store.
+
+const (
+	fmp4TrackID   = 1
+	fmp4Timescale = 90000 // rtsp pts are in 90kHz units
+
+	// trun flags: data-offset, sample-duration, sample-size, sample-flags, sample-composition-time-offset.
+	trunFlags = 0x000001 | 0x000100 | 0x000200 | 0x000400 | 0x000800
+	// tfhd flags: default-base-is-moof.
+	tfhdFlags = 0x020000
+
+	syncSampleFlags    = 0x02000000 // sample_depends_on=2 (does not depend on others)
+	nonSyncSampleFlags = 0x01010000 // sample_depends_on=1, sample_is_non_sync_sample=1
+
+	// minimum RBSP length needed to read the H265 profile_tier_level out of a SPS.
+	h265SPSProfileLen = 15
+)
+
+// fmp4Sample is a single access unit in AVCC form.
+type fmp4Sample struct {
+	dts       int64
+	ptsOffset int32
+	duration  uint32
+	sync      bool
+	payload   []byte
+}
+
+// fmp4Params holds the parameter sets needed to build an init segment.
+type fmp4Params struct {
+	codec videostore.CodecType
+	vps   []byte
+	sps   []byte
+	pps   []byte
+}
+
+func writeBox(w *bytes.Buffer, typ string, fn func(w *bytes.Buffer)) {
+	start := w.Len()
+	w.Write([]byte{0, 0, 0, 0})
+	w.WriteString(typ)
+	fn(w)
+	binary.BigEndian.PutUint32(w.Bytes()[start:], uint32(w.Len()-start))
+}
+
+func writeFullBox(w *bytes.Buffer, typ string, version byte, flags uint32, fn func(w *bytes.Buffer)) {
+	writeBox(w, typ, func(w *bytes.Buffer) {
+		w.WriteByte(version)
+		w.Write([]byte{byte(flags >> 16), byte(flags >> 8), byte(flags)})
+		fn(w)
+	})
+}
+
+func writeU16(w *bytes.Buffer, v uint16) {
+	_ = binary.Write(w, binary.BigEndian, v)
+}
+
+func writeU32(w *bytes.Buffer, v uint32) {
+	_ = binary.Write(w, binary.BigEndian, v)
+}
+
+func writeU64(w *bytes.Buffer, v uint64) {
+	_ = binary.Write(w, binary.BigEndian, v)
+}
+
+func writeZeros(w *bytes.Buffer, n int) {
+	w.Write(make([]byte, n))
+}
+
+var unityMatrix = []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+func writeMatrix(w *bytes.Buffer) {
+	for _, v := range unityMatrix {
+		writeU32(w, v)
+	}
+}
+
+// marshalFMP4Init builds the ftyp and moov boxes describing the video track.
+func marshalFMP4Init(p fmp4Params) ([]byte, error) {
+	width, height, sampleEntry, err := sampleEntryForParams(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var w bytes.Buffer
+	writeBox(&w, "ftyp", func(w *bytes.Buffer) {
+		w.WriteString("iso5")
+		writeU32(w, 512)
+		w.WriteString("iso5iso6mp41")
+	})
+	writeBox(&w, "moov", func(w *bytes.Buffer) {
+		writeFullBox(w, "mvhd", 0, 0, func(w *bytes.Buffer) {
+			writeU32(w, 0)    // creation_time
+			writeU32(w, 0)    // modification_time
+			writeU32(w, 1000) // timescale
+			writeU32(w, 0)    // duration
+			writeU32(w, 0x00010000)
+			writeU16(w, 0x0100)
+			writeZeros(w, 10)
+			writeMatrix(w)
+			writeZeros(w, 24)
+			writeU32(w, fmp4TrackID+1) // next_track_ID
+		})
+		writeBox(w, "trak", func(w *bytes.Buffer) {
+			writeFullBox(w, "tkhd", 0, 3, func(w *bytes.Buffer) {
+				writeU32(w, 0)
+				writeU32(w, 0)
+				writeU32(w, fmp4TrackID)
+				writeU32(w, 0)
+				writeU32(w, 0) // duration
+				writeZeros(w, 8)
+				writeU16(w, 0) // layer
+				writeU16(w, 0) // alternate_group
+				writeU16(w, 0) // volume
+				writeU16(w, 0)
+				writeMatrix(w)
+				writeU32(w, uint32(width)<<16)
+				writeU32(w, uint32(height)<<16)
+			})
+			writeBox(w, "mdia", func(w *bytes.Buffer) {
+				writeFullBox(w, "mdhd", 0, 0, func(w *bytes.Buffer) {
+					writeU32(w, 0)
+					writeU32(w, 0)
+					writeU32(w, fmp4Timescale)
+					writeU32(w, 0)
+					writeU16(w, 0x55c4) // 'und'
+					writeU16(w, 0)
+				})
+				writeFullBox(w, "hdlr", 0, 0, func(w *bytes.Buffer) {
+					writeU32(w, 0)
+					w.WriteString("vide")
+					writeZeros(w, 12)
+					w.WriteString("VideoHandler\x00")
+				})
+				writeBox(w, "minf", func(w *bytes.Buffer) {
+					writeFullBox(w, "vmhd", 0, 1, func(w *bytes.Buffer) {
+						writeZeros(w, 8)
+					})
+					writeBox(w, "dinf", func(w *bytes.Buffer) {
+						writeFullBox(w, "dref", 0, 0, func(w *bytes.Buffer) {
+							writeU32(w, 1)
+							writeFullBox(w, "url ", 0, 1, func(*bytes.Buffer) {})
+						})
+					})
+					writeBox(w, "stbl", func(w *bytes.Buffer) {
+						writeFullBox(w, "stsd", 0, 0, func(w *bytes.Buffer) {
+							writeU32(w, 1)
+							w.Write(sampleEntry)
+						})
+						writeFullBox(w, "stts", 0, 0, func(w *bytes.Buffer) { writeU32(w, 0) })
+						writeFullBox(w, "stsc", 0, 0, func(w *bytes.Buffer) { writeU32(w, 0) })
+						writeFullBox(w, "stsz", 0, 0, func(w *bytes.Buffer) {
+							writeU32(w, 0)
+							writeU32(w, 0)
+						})
+						writeFullBox(w, "stco", 0, 0, func(w *bytes.Buffer) { writeU32(w, 0) })
+					})
+				})
+			})
+		})
+		writeBox(w, "mvex", func(w *bytes.Buffer) {
+			writeFullBox(w, "trex", 0, 0, func(w *bytes.Buffer) {
+				writeU32(w, fmp4TrackID)
+				writeU32(w, 1) // default_sample_description_index
+				writeU32(w, 0)
+				writeU32(w, 0)
+				writeU32(w, 0)
+			})
+		})
+	})
+	return w.Bytes(), nil
+}
+
+// sampleEntryForParams returns the frame size and the avc1/hvc1 sample entry for the params.
+func sampleEntryForParams(p fmp4Params) (int, int, []byte, error) {
+	if len(p.sps) == 0 || len(p.pps) == 0 {
+		return 0, 0, nil, errors.New("sps and pps are required to build an fmp4 init segment")
+	}
+	var (
+		width, height int
+		entryType     string
+		config        []byte
+	)
+	switch p.codec {
+	case videostore.CodecTypeH264:
+		var sps h264.SPS
+		if err := sps.Unmarshal(p.sps); err != nil {
+			return 0, 0, nil, err
+		}
+		width, height = sps.Width(), sps.Height()
+		entryType = "avc1"
+		config = avcCBox(p.sps, p.pps)
+	case videostore.CodecTypeH265:
+		if len(p.vps) == 0 {
+			return 0, 0, nil, errors.New("vps is required to build an h265 fmp4 init segment")
+		}
+		var sps h265.SPS
+		if err := sps.Unmarshal(p.sps); err != nil {
+			return 0, 0, nil, err
+		}
+		width, height = sps.Width(), sps.Height()
+		entryType = "hvc1"
+		var err error
+		config, err = hvcCBox(p.vps, p.sps, p.pps, &sps)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+	case videostore.CodecTypeUnknown:
+		fallthrough
+	default:
+		return 0, 0, nil, errors.New("invalid codec")
+	}
+
+	var w bytes.Buffer
+	writeBox(&w, entryType, func(w *bytes.Buffer) {
+		writeZeros(w, 6)
+		writeU16(w, 1) // data_reference_index
+		writeZeros(w, 16)
+		writeU16(w, uint16(width))
+		writeU16(w, uint16(height))
+		writeU32(w, 0x00480000) // 72 dpi
+		writeU32(w, 0x00480000)
+		writeU32(w, 0)
+		writeU16(w, 1) // frame_count
+		writeZeros(w, 32)
+		writeU16(w, 0x0018) // depth
+		writeU16(w, 0xffff) // pre_defined = -1
+		w.Write(config)
+	})
+	return width, height, w.Bytes(), nil
+}
+
+func avcCBox(sps, pps []byte) []byte {
+	var w bytes.Buffer
+	writeBox(&w, "avcC", func(w *bytes.Buffer) {
+		w.WriteByte(1)
+		w.Write(sps[1:4]) // profile, compatibility, level
+		w.WriteByte(0xff) // lengthSizeMinusOne = 3
+		w.WriteByte(0xe1) // one SPS
+		writeU16(w, uint16(len(sps)))
+		w.Write(sps)
+		w.WriteByte(1)
+		writeU16(w, uint16(len(pps)))
+		w.Write(pps)
+	})
+	return w.Bytes()
+}
+
+func hvcCBox(vps, sps, pps []byte, parsed *h265.SPS) ([]byte, error) {
+	// profile_tier_level is byte aligned right after the two byte NALU header and
+	// the vps id / sub layer byte, so copy it straight out of the RBSP.
+	rbsp := h264.EmulationPreventionRemove(sps)
+	if len(rbsp) < h265SPSProfileLen {
+		return nil, errors.New("h265 sps too short")
+	}
+	var w bytes.Buffer
+	writeBox(&w, "hvcC", func(w *bytes.Buffer) {
+		w.WriteByte(1)
+		w.Write(rbsp[3:15]) // profile space/tier/idc, compatibility, constraint flags, level
+		writeU16(w, 0xf000)
+		w.WriteByte(0xfc)
+		w.WriteByte(0xfc | byte(parsed.ChromaFormatIdc))
+		w.WriteByte(0xf8 | byte(parsed.BitDepthLumaMinus8))
+		w.WriteByte(0xf8 | byte(parsed.BitDepthChromaMinus8))
+		writeU16(w, 0) // avgFrameRate
+		nesting := byte(0)
+		if parsed.TemporalIDNestingFlag {
+			nesting = 1
+		}
+		w.WriteByte((parsed.MaxSubLayersMinus1+1)<<3 | nesting<<2 | 0x03)
+		w.WriteByte(3)
+		for _, nalu := range []struct {
+			typ  h265.NALUType
+			data []byte
+		}{
+			{h265.NALUType_VPS_NUT, vps},
+			{h265.NALUType_SPS_NUT, sps},
+			{h265.NALUType_PPS_NUT, pps},
+		} {
+			w.WriteByte(0x80 | byte(nalu.typ))
+			writeU16(w, 1)
+			writeU16(w, uint16(len(nalu.data)))
+			w.Write(nalu.data)
+		}
+	})
+	return w.Bytes(), nil
+}
+
+// marshalFMP4Fragment builds a moof and mdat pair for the given samples.
+func marshalFMP4Fragment(seq uint32, samples []*fmp4Sample) []byte {
+	if len(samples) == 0 {
+		return nil
+	}
+	var w bytes.Buffer
+	dataOffsetPos := 0
+	writeBox(&w, "moof", func(w *bytes.Buffer) {
+		writeFullBox(w, "mfhd", 0, 0, func(w *bytes.Buffer) {
+			writeU32(w, seq)
+		})
+		writeBox(w, "traf", func(w *bytes.Buffer) {
+			writeFullBox(w, "tfhd", 0, tfhdFlags, func(w *bytes.Buffer) {
+				writeU32(w, fmp4TrackID)
+			})
+			writeFullBox(w, "tfdt", 1, 0, func(w *bytes.Buffer) {
+				writeU64(w, uint64(samples[0].dts))
+			})
+			writeFullBox(w, "trun", 1, trunFlags, func(w *bytes.Buffer) {
+				writeU32(w, uint32(len(samples)))
+				dataOffsetPos = w.Len()
+				writeU32(w, 0) // patched once the moof size is known
+				for _, s := range samples {
+					writeU32(w, s.duration)
+					writeU32(w, uint32(len(s.payload)))
+					if s.sync {
+						writeU32(w, syncSampleFlags)
+					} else {
+						writeU32(w, nonSyncSampleFlags)
+					}
+					writeU32(w, uint32(s.ptsOffset))
+				}
+			})
+		})
+	})
+	// data_offset is relative to the start of the moof and points past the mdat header.
+	binary.BigEndian.PutUint32(w.Bytes()[dataOffsetPos:], uint32(w.Len()+8))
+	writeBox(&w, "mdat", func(w *bytes.Buffer) {
+		for _, s := range samples {
+			w.Write(s.payload)
+		}
+	})
+	return w.Bytes()
+}
+
+// splitFMP4 splits a fragmented MP4 file into its init section (everything
+// before the first moof) and its media section.
+func splitFMP4(data []byte) ([]byte, []byte, error) {
+	pos := 0
+	for pos+8 <= len(data) {
+		size := int(binary.BigEndian.Uint32(data[pos:]))
+		typ := string(data[pos+4 : pos+8])
+		if typ == "moof" {
+			return data[:pos], data[pos:], nil
+		}
+		if size < 8 || pos+size > len(data) {
+			return nil, nil, errors.New("malformed mp4 box")
+		}
+		pos += size
+	}
+	return nil, nil, errors.New("no fragments found in video")
+}
+
+// mp4Box is a box read back out of an mp4 file.
+type mp4Box struct {
+	typ     string
+	offset  int // offset of the box header within the parsed buffer
+	payload []byte
+}
+
+// readBoxes parses the boxes laid out back to back in data.
+func readBoxes(data []byte) ([]mp4Box, error) {
+	var boxes []mp4Box
+	for pos := 0; pos < len(data); {
+		if pos+8 > len(data) {
+			return nil, errors.New("truncated mp4 box header")
+		}
+		size := uint64(binary.BigEndian.Uint32(data[pos:]))
+		typ := string(data[pos+4 : pos+8])
+		header := 8
+		switch size {
+		case 0:
+			size = uint64(len(data) - pos)
+		case 1:
+			if pos+16 > len(data) {
+				return nil, errors.New("truncated mp4 box header")
+			}
+			size = binary.BigEndian.Uint64(data[pos+8:])
+			header = 16
+		}
+		if size < uint64(header) || uint64(pos)+size > uint64(len(data)) {
+			return nil, errors.New("malformed mp4 box")
+		}
+		boxes = append(boxes, mp4Box{typ: typ, offset: pos, payload: data[pos+header : pos+int(size)]})
+		pos += int(size)
+	}
+	return boxes, nil
+}
+
+// findBox walks the path of box types and returns the first match.
+func findBox(data []byte, path ...string) (mp4Box, bool) {
+	boxes, err := readBoxes(data)
+	if err != nil {
+		return mp4Box{}, false
+	}
+	for _, b := range boxes {
+		if b.typ != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return b, true
+		}
+		return findBox(b.payload, path[1:]...)
+	}
+	return mp4Box{}, false
+}
+
+// fmp4Track describes the single video track of a fragmented mp4 init section.
+type fmp4Track struct {
+	id        uint32
+	timescale uint32
+}
+
+func parseFMP4Track(init []byte) (fmp4Track, error) {
+	tkhd, ok := findBox(init, "moov", "trak", "tkhd")
+	if !ok || len(tkhd.payload) < 24 {
+		return fmp4Track{}, errors.New("no track header found in video")
+	}
+	mdhd, ok := findBox(init, "moov", "trak", "mdia", "mdhd")
+	if !ok || len(mdhd.payload) < 24 {
+		return fmp4Track{}, errors.New("no media header found in video")
+	}
+	var track fmp4Track
+	// version 1 boxes use 64 bit creation and modification times
+	if tkhd.payload[0] == 1 {
+		track.id = binary.BigEndian.Uint32(tkhd.payload[20:])
+	} else {
+		track.id = binary.BigEndian.Uint32(tkhd.payload[12:])
+	}
+	if mdhd.payload[0] == 1 {
+		track.timescale = binary.BigEndian.Uint32(mdhd.payload[20:])
+	} else {
+		track.timescale = binary.BigEndian.Uint32(mdhd.payload[12:])
+	}
+	if track.timescale == 0 {
+		return fmp4Track{}, errors.New("invalid media timescale")
+	}
+	return track, nil
+}
+
+// fmp4Config is the codec and parameter sets of the video track of a fragmented mp4 init section.
+type fmp4Config struct {
+	codec videostore.CodecType
+	// params holds the VPS, SPS and PPS NALUs the track was configured with.
+	params [][]byte
+}
+
+func parseFMP4Config(init []byte) (fmp4Config, error) {
+	stsd, ok := findBox(init, "moov", "trak", "mdia", "minf", "stbl", "stsd")
+	if !ok || len(stsd.payload) < 8 {
+		return fmp4Config{}, errors.New("no sample description found in video")
+	}
+	entries, err := readBoxes(stsd.payload[8:])
+	if err != nil || len(entries) == 0 {
+		return fmp4Config{}, errors.New("no sample entry found in video")
+	}
+	entry := entries[0]
+	// child boxes follow the fixed visual sample entry fields
+	if len(entry.payload) < 78 {
+		return fmp4Config{}, errors.New("truncated sample entry")
+	}
+	var (
+		config     fmp4Config
+		configType string
+	)
+	switch entry.typ {
+	case "avc1", "avc3":
+		config.codec, configType = videostore.CodecTypeH264, "avcC"
+	case "hvc1", "hev1":
+		config.codec, configType = videostore.CodecTypeH265, "hvcC"
+	default:
+		return fmp4Config{}, fmt.Errorf("unsupported sample entry %q", entry.typ)
+	}
+	box, ok := findBox(entry.payload[78:], configType)
+	if !ok {
+		return fmp4Config{}, fmt.Errorf("no %s box found in video", configType)
+	}
+	if config.codec == videostore.CodecTypeH264 {
+		config.params, err = avcCParams(box.payload)
+	} else {
+		config.params, err = hvcCParams(box.payload)
+	}
+	if err != nil {
+		return fmp4Config{}, err
+	}
+	return config, nil
+}
+
+// avcCParams returns the SPS and PPS NALUs of an avcC box.
+func avcCParams(avcC []byte) ([][]byte, error) {
+	if len(avcC) < 6 {
+		return nil, errors.New("truncated avcC box")
+	}
+	// samples are split with h264.AVCCUnmarshal, which only reads 4 byte lengths
+	if avcC[4]&0x03 != 3 {
+		return nil, errors.New("unsupported NALU length size")
+	}
+	r := paramSetReader{buf: avcC, pos: 6}
+	params := r.nalus(int(avcC[5] & 0x1f))
+	if r.pos < len(avcC) {
+		count := int(avcC[r.pos])
+		r.pos++
+		params = append(params, r.nalus(count)...)
+	}
+	return params, r.err
+}
+
+// hvcCParams returns the VPS, SPS and PPS NALUs of an hvcC box.
+func hvcCParams(hvcC []byte) ([][]byte, error) {
+	if len(hvcC) < 23 {
+		return nil, errors.New("truncated hvcC box")
+	}
+	if hvcC[21]&0x03 != 3 {
+		return nil, errors.New("unsupported NALU length size")
+	}
+	r := paramSetReader{buf: hvcC, pos: 23}
+	var params [][]byte
+	for range int(hvcC[22]) {
+		if r.pos+3 > len(hvcC) {
+			return nil, errors.New("truncated hvcC box")
+		}
+		count := int(binary.BigEndian.Uint16(hvcC[r.pos+1:]))
+		r.pos += 3
+		params = append(params, r.nalus(count)...)
+	}
+	return params, r.err
+}
+
+// paramSetReader reads 16 bit length prefixed NALUs, remembering the first short read.
+type paramSetReader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (r *paramSetReader) nalus(count int) [][]byte {
+	var nalus [][]byte
+	for range count {
+		if r.pos+2 > len(r.buf) {
+			r.err = errors.New("truncated parameter set")
+			return nalus
+		}
+		size := int(binary.BigEndian.Uint16(r.buf[r.pos:]))
+		r.pos += 2
+		if r.pos+size > len(r.buf) {
+			r.err = errors.New("truncated parameter set")
+			return nalus
+		}
+		nalus = append(nalus, r.buf[r.pos:r.pos+size])
+		r.pos += size
+	}
+	return nalus
+}
+
+// firstFMP4Sample returns the payload of the first sample in the first fragment
+// of media and whether it is a sync sample.
+func firstFMP4Sample(media []byte) ([]byte, bool, error) {
+	boxes, err := readBoxes(media)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(boxes) == 0 || boxes[0].typ != "moof" {
+		return nil, false, errors.New("video does not start with a fragment")
+	}
+	moof := boxes[0]
+	tfhd, ok := findBox(moof.payload, "traf", "tfhd")
+	if !ok || len(tfhd.payload) < 8 {
+		return nil, false, errors.New("no track fragment header found")
+	}
+	trun, ok := findBox(moof.payload, "traf", "trun")
+	if !ok || len(trun.payload) < 8 {
+		return nil, false, errors.New("no track run found")
+	}
+
+	r := fieldReader{buf: tfhd.payload}
+	tfFlags := r.u32() & 0xffffff
+	r.u32() // track_ID
+	baseOffset := uint64(moof.offset)
+	if tfFlags&0x01 != 0 {
+		baseOffset = r.u64()
+	}
+	if tfFlags&0x02 != 0 {
+		r.u32() // sample_description_index
+	}
+	var defaultSize, defaultFlags uint32
+	if tfFlags&0x08 != 0 {
+		r.u32() // default_sample_duration
+	}
+	if tfFlags&0x10 != 0 {
+		defaultSize = r.u32()
+	}
+	if tfFlags&0x20 != 0 {
+		defaultFlags = r.u32()
+	}
+
+	r = fieldReader{buf: trun.payload}
+	trFlags := r.u32() & 0xffffff
+	if r.u32() == 0 {
+		return nil, false, errors.New("empty track run")
+	}
+	var dataOffset int64
+	if trFlags&0x01 != 0 {
+		dataOffset = int64(int32(r.u32()))
+	}
+	flags := defaultFlags
+	if trFlags&0x04 != 0 {
+		flags = r.u32() // first_sample_flags
+	}
+	if trFlags&0x100 != 0 {
+		r.u32() // sample_duration
+	}
+	size := defaultSize
+	if trFlags&0x200 != 0 {
+		size = r.u32()
+	}
+	if trFlags&0x400 != 0 && trFlags&0x04 == 0 {
+		flags = r.u32()
+	}
+	if r.err != nil {
+		return nil, false, r.err
+	}
+
+	start := int64(baseOffset) + dataOffset
+	if start < 0 || start+int64(size) > int64(len(media)) || size == 0 {
+		return nil, false, errors.New("sample data out of range")
+	}
+	sync := flags&0x10000 == 0 // sample_is_non_sync_sample
+	return media[start : start+int64(size)], sync, nil
+}
+
+// fieldReader reads big endian fields, remembering the first short read.
+type fieldReader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (r *fieldReader) u32() uint32 {
+	if r.pos+4 > len(r.buf) {
+		r.err = errors.New("truncated mp4 box")
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.buf[r.pos:])
+	r.pos += 4
+	return v
+}
+
+func (r *fieldReader) u64() uint64 {
+	if r.pos+8 > len(r.buf) {
+		r.err = errors.New("truncated mp4 box")
+		return 0
+	}
+	v := binary.BigEndian.Uint64(r.buf[r.pos:])
+	r.pos += 8
+	return v
+}
@@ -0,0 +1,622 @@
+package videostore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/viam-modules/video-store/videostore"
+	vsutils "github.com/viam-modules/video-store/videostore/utils"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/utils"
+)
+
+const (
+	defaultHLSSegmentDuration = 2 * time.Second
+	defaultHLSPartDuration    = 500 * time.Millisecond
+	defaultHLSSegmentCount    = 7
+	// number of trailing segments whose parts are advertised in the live playlist.
+	hlsPartWindow = 3
+	// how long a blocking playlist reload or preload hinted part request may wait.
+	hlsBlockTimeout = 10 * time.Second
+	// stored video is served as fixed size windows, each fetched from video-store on demand.
+	vodSegmentDuration = 10 * time.Second
+	// the longest range a VOD playlist may cover, which bounds the size of the playlist.
+	maxVODDuration  = 24 * time.Hour
+	hlsReadTimeout  = 5 * time.Second
+	mpegURLMimeType = "application/vnd.apple.mpegurl"
+	mp4MimeType     = "video/mp4"
+	liveNotReadyMsg = "live stream is not ready yet"
+)
+
+// hlsPart is a LL-HLS partial segment, a single moof and mdat pair.
+type hlsPart struct {
+	duration    time.Duration
+	independent bool
+	data        []byte
+}
+
+type hlsSegment struct {
+	msn      int
+	start    time.Time
+	parts    []*hlsPart
+	ticks    int64
+	complete bool
+}
+
+func (s *hlsSegment) duration() time.Duration {
+	return ticksToDuration(s.ticks)
+}
+
+func (s *hlsSegment) bytes() []byte {
+	var b bytes.Buffer
+	for _, p := range s.parts {
+		b.Write(p.data)
+	}
+	return b.Bytes()
+}
+
+// hlsLive turns the access units the camera hands to rawSegmenterMux into
+// in-memory LL-HLS segments and parts.
+type hlsLive struct {
+	logger          logging.Logger
+	segmentDuration time.Duration
+	partDuration    time.Duration
+	segmentCount    int
+
+	mu          sync.Mutex
+	params      fmp4Params
+	init        []byte
+	segments    []*hlsSegment
+	nextMSN     int
+	fragmentSeq uint32
+	pending     *fmp4Sample
+	partSamples []*fmp4Sample
+	partTicks   int64
+	// updated is closed and replaced every time a part is published or the stream resets.
+	updated chan struct{}
+}
+
+func newHLSLive(cfg *HLS, logger logging.Logger) *hlsLive {
+	return &hlsLive{
+		logger:          logger,
+		segmentDuration: cfg.segmentDuration(),
+		partDuration:    cfg.partDuration(),
+		segmentCount:    cfg.segmentCount(),
+		updated:         make(chan struct{}),
+	}
+}
+
+func paramsEqual(a, b fmp4Params) bool {
+	return a.codec == b.codec && bytes.Equal(a.vps, b.vps) && bytes.Equal(a.sps, b.sps) && bytes.Equal(a.pps, b.pps)
+}
+
+// writeAU adds an access unit, without parameter sets, to the live stream.
+// Timestamps are in 90kHz units.
+func (h *hlsLive) writeAU(params fmp4Params, au [][]byte, pts, dts int64, randomAccess bool) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.init == nil || !paramsEqual(params, h.params) {
+		// parameter sets can only take effect on a random access point
+		if !randomAccess {
+			return nil
+		}
+		init, err := marshalFMP4Init(params)
+		if err != nil {
+			return err
+		}
+		if h.init != nil {
+			h.logger.Debug("hls parameter sets changed, restarting live stream")
+		}
+		h.resetLocked()
+		h.params = params
+		h.init = init
+	}
+
+	payload, err := h264.AVCCMarshal(au)
+	if err != nil {
+		return err
+	}
+	sample := &fmp4Sample{
+		dts:       dts,
+		ptsOffset: int32(pts - dts),
+		sync:      randomAccess,
+		payload:   payload,
+	}
+	if h.pending != nil {
+		// a sample's duration is only known once the next one arrives
+		duration := dts - h.pending.dts
+		if duration <= 0 {
+			duration = 1
+		}
+		h.pending.duration = uint32(duration)
+		h.addSampleLocked(h.pending)
+	}
+	h.pending = sample
+	return nil
+}
+
+// durations are tracked in 90kHz ticks to avoid rounding errors adding up.
+func ticksToDuration(ticks int64) time.Duration {
+	return time.Duration(ticks * int64(time.Second) / fmp4Timescale)
+}
+
+func durationToTicks(d time.Duration) int64 {
+	return int64(d) * fmp4Timescale / int64(time.Second)
+}
+
+func (h *hlsLive) addSampleLocked(s *fmp4Sample) {
+	ticks := int64(s.duration)
+	cur := h.currentSegmentLocked()
+	if s.sync && cur.ticks+h.partTicks >= durationToTicks(h.segmentDuration) {
+		h.flushPartLocked(cur)
+		h.completeSegmentLocked(cur)
+		cur = h.currentSegmentLocked()
+	} else if len(h.partSamples) > 0 && h.partTicks+ticks > durationToTicks(h.partDuration) {
+		h.flushPartLocked(cur)
+	}
+	h.partSamples = append(h.partSamples, s)
+	h.partTicks += ticks
+}
+
+func (h *hlsLive) currentSegmentLocked() *hlsSegment {
+	if n := len(h.segments); n > 0 && !h.segments[n-1].complete {
+		return h.segments[n-1]
+	}
+	seg := &hlsSegment{msn: h.nextMSN, start: time.Now()}
+	h.nextMSN++
+	h.segments = append(h.segments, seg)
+	return seg
+}
+
+func (h *hlsLive) flushPartLocked(seg *hlsSegment) {
+	if len(h.partSamples) == 0 {
+		return
+	}
+	h.fragmentSeq++
+	seg.parts = append(seg.parts, &hlsPart{
+		duration:    ticksToDuration(h.partTicks),
+		independent: h.partSamples[0].sync,
+		data:        marshalFMP4Fragment(h.fragmentSeq, h.partSamples),
+	})
+	seg.ticks += h.partTicks
+	h.partSamples = nil
+	h.partTicks = 0
+	h.notifyLocked()
+}
+
+func (h *hlsLive) completeSegmentLocked(seg *hlsSegment) {
+	if len(seg.parts) == 0 {
+		return
+	}
+	seg.complete = true
+	// drop the oldest segments once more than segmentCount are complete
+	complete := 0
+	for _, s := range h.segments {
+		if s.complete {
+			complete++
+		}
+	}
+	if drop := complete - h.segmentCount; drop > 0 {
+		h.segments = h.segments[drop:]
+	}
+}
+
+func (h *hlsLive) notifyLocked() {
+	close(h.updated)
+	h.updated = make(chan struct{})
+}
+
+// reset drops all buffered video, called when the camera stops sending video.
+func (h *hlsLive) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.resetLocked()
+	h.params = fmp4Params{}
+	h.init = nil
+}
+
+func (h *hlsLive) resetLocked() {
+	// media sequence numbers keep increasing across resets so players never see them go backwards
+	h.segments = nil
+	h.pending = nil
+	h.partSamples = nil
+	h.partTicks = 0
+	h.notifyLocked()
+}
+
+// hasPartLocked reports whether the playlist already contains the given part.
+// A negative part means the whole segment.
+func (h *hlsLive) hasPartLocked(msn, part int) bool {
+	for _, seg := range h.segments {
+		if seg.msn > msn {
+			return true
+		}
+		if seg.msn == msn {
+			if seg.complete {
+				return true
+			}
+			return part >= 0 && len(seg.parts) > part
+		}
+	}
+	return false
+}
+
+// waitFor blocks until cond holds, the context is done or hlsBlockTimeout elapses.
+func (h *hlsLive) waitFor(ctx context.Context, cond func() bool) bool {
+	timeout := time.NewTimer(hlsBlockTimeout)
+	defer timeout.Stop()
+	for {
+		h.mu.Lock()
+		ok := cond()
+		updated := h.updated
+		h.mu.Unlock()
+		if ok {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timeout.C:
+			return false
+		case <-updated:
+		}
+	}
+}
+
+func formatHLSSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.5f", d.Seconds())
+}
+
+// readyLocked reports whether the live stream has a part to advertise, assumes mu is held.
+func (h *hlsLive) readyLocked() bool {
+	return len(h.segments) > 0 && len(h.segments[0].parts) > 0
+}
+
+// playlistLocked renders the live media playlist, assumes mu is held.
+// It returns false when the stream has nothing to advertise yet.
+func (h *hlsLive) playlistLocked() (string, bool) {
+	if !h.readyLocked() {
+		return "", false
+	}
+	target := h.segmentDuration
+	for _, seg := range h.segments {
+		if seg.complete && seg.duration() > target {
+			target = seg.duration()
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:9\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(target.Seconds())))
+	//nolint:mnd
+	fmt.Fprintf(&b, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%s\n", formatHLSSeconds(3*h.partDuration))
+	fmt.Fprintf(&b, "#EXT-X-PART-INF:PART-TARGET=%s\n", formatHLSSeconds(h.partDuration))
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", h.segments[0].msn)
+	b.WriteString("#EXT-X-MAP:URI=\"init.mp4\"\n")
+
+	for i, seg := range h.segments {
+		fmt.Fprintf(&b, "#EXT-X-PROGRAM-DATE-TIME:%s\n", seg.start.UTC().Format(time.RFC3339Nano))
+		if i >= len(h.segments)-hlsPartWindow {
+			for j, part := range seg.parts {
+				fmt.Fprintf(&b, "#EXT-X-PART:DURATION=%s,URI=\"part-%d-%d.mp4\"", formatHLSSeconds(part.duration), seg.msn, j)
+				if part.independent {
+					b.WriteString(",INDEPENDENT=YES")
+				}
+				b.WriteString("\n")
+			}
+		}
+		if seg.complete {
+			fmt.Fprintf(&b, "#EXTINF:%s,\n", formatHLSSeconds(seg.duration()))
+			fmt.Fprintf(&b, "seg-%d.mp4\n", seg.msn)
+		}
+	}
+
+	last := h.segments[len(h.segments)-1]
+	if last.complete {
+		fmt.Fprintf(&b, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"part-%d-0.mp4\"\n", h.nextMSN)
+	} else {
+		fmt.Fprintf(&b, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"part-%d-%d.mp4\"\n", last.msn, len(last.parts))
+	}
+	return b.String(), true
+}
+
+// hlsServer serves live and stored video as HLS over a local HTTP endpoint.
+type hlsServer struct {
+	logger     logging.Logger
+	vs         videostore.VideoStore
+	live       *hlsLive
+	httpServer *http.Server
+	workers    *utils.StoppableWorkers
+
+	vodMu    sync.Mutex
+	vodKey   string
+	vodInit  []byte
+	vodMedia []byte
+}
+
+// newHLSServer starts listening on the configured address. live may be nil, in
+// which case only stored video is served.
+func newHLSServer(cfg *HLS, vs videostore.VideoStore, live *hlsLive, logger logging.Logger) (*hlsServer, error) {
+	ln, err := net.Listen("tcp", cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on hls address %s: %w", cfg.Address, err)
+	}
+	s := &hlsServer{
+		logger: logger,
+		vs:     vs,
+		live:   live,
+	}
+	s.httpServer = &http.Server{
+		Handler:           s.handler(),
+		ReadHeaderTimeout: hlsReadTimeout,
+	}
+	logger.Infof("serving hls on http://%s", ln.Addr().String())
+	s.workers = utils.NewBackgroundStoppableWorkers(func(context.Context) {
+		if err := s.httpServer.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("hls server stopped: %s", err.Error())
+		}
+	})
+	return s, nil
+}
+
+func (s *hlsServer) close() error {
+	if s == nil {
+		return nil
+	}
+	err := s.httpServer.Close()
+	s.workers.Stop()
+	return err
+}
+
+func (s *hlsServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /live/index.m3u8", s.handleLivePlaylist)
+	mux.HandleFunc("GET /live/init.mp4", s.handleLiveInit)
+	mux.HandleFunc("GET /live/{file}", s.handleLiveMedia)
+	mux.HandleFunc("GET /vod/index.m3u8", s.handleVODPlaylist)
+	mux.HandleFunc("GET /vod/init.mp4", s.handleVODInit)
+	mux.HandleFunc("GET /vod/segment.mp4", s.handleVODSegment)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// allow players embedded in other origins to read the stream
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func writeHLSResponse(w http.ResponseWriter, contentType string, body []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	//nolint:errcheck
+	w.Write(body)
+}
+
+func (s *hlsServer) handleLivePlaylist(w http.ResponseWriter, r *http.Request) {
+	if s.live == nil {
+		http.Error(w, "live hls requires an h264 or h265 viamrtsp camera", http.StatusNotFound)
+		return
+	}
+	msn, part := -1, -1
+	if v := r.URL.Query().Get("_HLS_msn"); v != "" {
+		if _, err := fmt.Sscanf(v, "%d", &msn); err != nil {
+			http.Error(w, "invalid _HLS_msn", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("_HLS_part"); v != "" {
+		if msn < 0 {
+			http.Error(w, "_HLS_part requires _HLS_msn", http.StatusBadRequest)
+			return
+		}
+		if _, err := fmt.Sscanf(v, "%d", &part); err != nil {
+			http.Error(w, "invalid _HLS_part", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// the playlist is rendered under the same lock as the readiness check so a
+	// reset in between can't leave it with no segments
+	var playlist string
+	ready := s.live.waitFor(r.Context(), func() bool {
+		if msn >= 0 && !s.live.hasPartLocked(msn, part) {
+			return false
+		}
+		var ok bool
+		playlist, ok = s.live.playlistLocked()
+		return ok
+	})
+	if !ready {
+		http.Error(w, liveNotReadyMsg, http.StatusServiceUnavailable)
+		return
+	}
+	writeHLSResponse(w, mpegURLMimeType, []byte(playlist))
+}
+
+func (s *hlsServer) handleLiveInit(w http.ResponseWriter, r *http.Request) {
+	if s.live == nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.live.mu.Lock()
+	init := s.live.init
+	s.live.mu.Unlock()
+	if init == nil {
+		http.Error(w, liveNotReadyMsg, http.StatusServiceUnavailable)
+		return
+	}
+	writeHLSResponse(w, mp4MimeType, init)
+}
+
+func (s *hlsServer) handleLiveMedia(w http.ResponseWriter, r *http.Request) {
+	if s.live == nil {
+		http.NotFound(w, r)
+		return
+	}
+	file := r.PathValue("file")
+	var msn, partIdx int
+	if _, err := fmt.Sscanf(file, "part-%d-%d.mp4", &msn, &partIdx); err == nil {
+		s.serveLivePart(w, r, msn, partIdx)
+		return
+	}
+	if _, err := fmt.Sscanf(file, "seg-%d.mp4", &msn); err == nil {
+		s.serveLiveSegment(w, r, msn)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func (s *hlsServer) serveLivePart(w http.ResponseWriter, r *http.Request, msn, partIdx int) {
+	var data []byte
+	// parts advertised through a preload hint are requested before they exist
+	s.live.waitFor(r.Context(), func() bool {
+		for _, seg := range s.live.segments {
+			if seg.msn == msn && partIdx < len(seg.parts) {
+				data = seg.parts[partIdx].data
+				return true
+			}
+		}
+		return s.live.hasPartLocked(msn, partIdx) || (len(s.live.segments) > 0 && msn < s.live.segments[0].msn)
+	})
+	if data == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeHLSResponse(w, mp4MimeType, data)
+}
+
+func (s *hlsServer) serveLiveSegment(w http.ResponseWriter, r *http.Request, msn int) {
+	s.live.mu.Lock()
+	var data []byte
+	for _, seg := range s.live.segments {
+		if seg.msn == msn && seg.complete {
+			data = seg.bytes()
+		}
+	}
+	s.live.mu.Unlock()
+	if data == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeHLSResponse(w, mp4MimeType, data)
+}
+
+// parseHLSRange reads the from and to query parameters, rejecting ranges longer than maxDuration.
+func parseHLSRange(r *http.Request, maxDuration time.Duration) (time.Time, time.Time, error) {
+	from, err := vsutils.ParseDateTimeString(r.URL.Query().Get("from"))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid from: %w", err)
+	}
+	to, err := vsutils.ParseDateTimeString(r.URL.Query().Get("to"))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid to: %w", err)
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, errors.New("from must be before to")
+	}
+	if to.Sub(from) > maxDuration {
+		return time.Time{}, time.Time{}, fmt.Errorf("range must be at most %s", maxDuration)
+	}
+	return from, to, nil
+}
+
+// hlsRangeQuery formats the range as the query parseHLSRange reads. Times are formatted in UTC
+// so they're read back as the same instants whatever the host's time zone.
+func hlsRangeQuery(from, to time.Time) string {
+	return fmt.Sprintf("from=%s&to=%s", formatUTCDatetime(from), formatUTCDatetime(to))
+}
+
+// vodPlaylist renders a VOD playlist covering [from, to) in vodSegmentDuration windows.
+// Every window is fetched independently, so each starts a new discontinuity with its own init section.
+func vodPlaylist(from, to time.Time) string {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:7\n")
+	b.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(vodSegmentDuration.Seconds()))
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	for start := from; start.Before(to); start = start.Add(vodSegmentDuration) {
+		end := start.Add(vodSegmentDuration)
+		if end.After(to) {
+			end = to
+		}
+		query := hlsRangeQuery(start, end)
+		if !start.Equal(from) {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		fmt.Fprintf(&b, "#EXT-X-MAP:URI=\"init.mp4?%s\"\n", query)
+		fmt.Fprintf(&b, "#EXT-X-PROGRAM-DATE-TIME:%s\n", start.UTC().Format(time.RFC3339))
+		fmt.Fprintf(&b, "#EXTINF:%s,\n", formatHLSSeconds(end.Sub(start)))
+		fmt.Fprintf(&b, "segment.mp4?%s\n", query)
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return b.String()
+}
+
+func (s *hlsServer) handleVODPlaylist(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseHLSRange(r, maxVODDuration)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeHLSResponse(w, mpegURLMimeType, []byte(vodPlaylist(from, to)))
+}
+
+// fetchVODWindow fetches a window as fragmented mp4 and splits it into its init
+// and media sections. The last window is cached since players request the init
+// and the segment back to back.
+func (s *hlsServer) fetchVODWindow(ctx context.Context, from, to time.Time) ([]byte, []byte, error) {
+	key := hlsRangeQuery(from, to)
+	s.vodMu.Lock()
+	defer s.vodMu.Unlock()
+	if s.vodKey == key {
+		return s.vodInit, s.vodMedia, nil
+	}
+	res, err := s.vs.Fetch(ctx, &videostore.FetchRequest{From: from, To: to, Container: videostore.ContainerFMP4})
+	if err != nil {
+		return nil, nil, err
+	}
+	init, media, err := splitFMP4(res.Video)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.vodKey, s.vodInit, s.vodMedia = key, init, media
+	return init, media, nil
+}
+
+func (s *hlsServer) serveVOD(w http.ResponseWriter, r *http.Request, wantInit bool) {
+	// playlists only link to single windows, so nothing longer is fetched
+	from, to, err := parseHLSRange(r, vodSegmentDuration)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	init, media, err := s.fetchVODWindow(r.Context(), from, to)
+	if err != nil {
+		s.logger.Debugf("hls failed to fetch %s: %s", hlsRangeQuery(from, to), err.Error())
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if wantInit {
+		writeHLSResponse(w, mp4MimeType, init)
+		return
+	}
+	writeHLSResponse(w, mp4MimeType, media)
+}
+
+func (s *hlsServer) handleVODInit(w http.ResponseWriter, r *http.Request) {
+	s.serveVOD(w, r, true)
+}
+
+func (s *hlsServer) handleVODSegment(w http.ResponseWriter, r *http.Request) {
+	s.serveVOD(w, r, false)
+}
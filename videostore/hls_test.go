@@ -0,0 +1,383 @@
+package videostore
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/viam-modules/video-store/videostore"
+	vsutils "github.com/viam-modules/video-store/videostore/utils"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+var (
+	testH264SPS, _ = base64.StdEncoding.DecodeString("Z2QAFayyA8Ef1gLcCAgWlAAAAwAEAAADAPA8WLkg")
+	testH264PPS, _ = base64.StdEncoding.DecodeString("aOvDyyLA")
+	testH265VPS    = []byte{0x40, 0x01, 0x0c, 0x01, 0xff, 0xff, 0x01, 0x60, 0x00, 0x00, 0x03, 0x00, 0x90}
+	testH265SPS    = []byte{
+		0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03,
+		0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03,
+		0x00, 0x78, 0xa0, 0x03, 0xc0, 0x80, 0x10, 0xe5,
+		0x96, 0x66, 0x69, 0x24, 0xca, 0xe0, 0x10, 0x00,
+		0x00, 0x03, 0x00, 0x10, 0x00, 0x00, 0x03, 0x01,
+		0xe0, 0x80,
+	}
+	testH265PPS = []byte{0x44, 0x01, 0xc1, 0x72, 0xb4, 0x62, 0x40}
+
+	testIDR    = []byte{0x65, 0x88, 0x84, 0x00}
+	testNonIDR = []byte{0x41, 0x9a, 0x02, 0x00}
+)
+
+// topLevelBoxes returns the types of the top level boxes in an mp4 byte slice.
+func topLevelBoxes(t *testing.T, data []byte) []string {
+	t.Helper()
+	var types []string
+	for pos := 0; pos < len(data); {
+		test.That(t, pos+8, test.ShouldBeLessThanOrEqualTo, len(data))
+		size := int(binary.BigEndian.Uint32(data[pos:]))
+		types = append(types, string(data[pos+4:pos+8]))
+		test.That(t, size, test.ShouldBeGreaterThanOrEqualTo, 8)
+		pos += size
+	}
+	return types
+}
+
+func TestMarshalFMP4Init(t *testing.T) {
+	t.Run("h264", func(t *testing.T) {
+		init, err := marshalFMP4Init(fmp4Params{codec: videostore.CodecTypeH264, sps: testH264SPS, pps: testH264PPS})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, topLevelBoxes(t, init), test.ShouldResemble, []string{"ftyp", "moov"})
+		test.That(t, bytes.Contains(init, []byte("avc1")), test.ShouldBeTrue)
+		test.That(t, bytes.Contains(init, []byte("avcC")), test.ShouldBeTrue)
+		test.That(t, bytes.Contains(init, testH264SPS), test.ShouldBeTrue)
+	})
+
+	t.Run("h265", func(t *testing.T) {
+		init, err := marshalFMP4Init(fmp4Params{
+			codec: videostore.CodecTypeH265,
+			vps:   testH265VPS,
+			sps:   testH265SPS,
+			pps:   testH265PPS,
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, topLevelBoxes(t, init), test.ShouldResemble, []string{"ftyp", "moov"})
+		test.That(t, bytes.Contains(init, []byte("hvc1")), test.ShouldBeTrue)
+		test.That(t, bytes.Contains(init, []byte("hvcC")), test.ShouldBeTrue)
+	})
+
+	t.Run("h265 without vps", func(t *testing.T) {
+		_, err := marshalFMP4Init(fmp4Params{codec: videostore.CodecTypeH265, sps: testH265SPS, pps: testH265PPS})
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+
+	t.Run("missing parameter sets", func(t *testing.T) {
+		_, err := marshalFMP4Init(fmp4Params{codec: videostore.CodecTypeH264})
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+}
+
+func TestMarshalFMP4Fragment(t *testing.T) {
+	samples := []*fmp4Sample{
+		{dts: 0, duration: 3000, sync: true, payload: []byte{0, 0, 0, 2, 0x65, 0x01}},
+		{dts: 3000, duration: 3000, payload: []byte{0, 0, 0, 2, 0x41, 0x02}},
+	}
+	frag := marshalFMP4Fragment(7, samples)
+	test.That(t, topLevelBoxes(t, frag), test.ShouldResemble, []string{"moof", "mdat"})
+
+	// the trun data offset must point at the first sample in the mdat
+	trun := bytes.Index(frag, []byte("trun"))
+	test.That(t, trun, test.ShouldBeGreaterThan, 0)
+	// type(4) + version/flags(4) + sample_count(4)
+	dataOffset := int(binary.BigEndian.Uint32(frag[trun+12:]))
+	test.That(t, frag[dataOffset:dataOffset+6], test.ShouldResemble, samples[0].payload)
+
+	test.That(t, marshalFMP4Fragment(1, nil), test.ShouldBeNil)
+}
+
+func TestSplitFMP4(t *testing.T) {
+	init, err := marshalFMP4Init(fmp4Params{codec: videostore.CodecTypeH264, sps: testH264SPS, pps: testH264PPS})
+	test.That(t, err, test.ShouldBeNil)
+	frag := marshalFMP4Fragment(1, []*fmp4Sample{{duration: 3000, sync: true, payload: []byte{0, 0, 0, 1, 0x65}}})
+
+	gotInit, gotMedia, err := splitFMP4(append(append([]byte{}, init...), frag...))
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, gotInit, test.ShouldResemble, init)
+	test.That(t, gotMedia, test.ShouldResemble, frag)
+
+	_, _, err = splitFMP4(init)
+	test.That(t, err, test.ShouldNotBeNil)
+}
+
+// feedLive writes seconds worth of 30fps h264 video with an IDR every gop frames.
+func feedLive(t *testing.T, live *hlsLive, start int64, frames, gop int) int64 {
+	t.Helper()
+	params := fmp4Params{codec: videostore.CodecTypeH264, sps: testH264SPS, pps: testH264PPS}
+	const frameTicks = fmp4Timescale / 30
+	ts := start
+	for i := range frames {
+		au := [][]byte{testNonIDR}
+		if i%gop == 0 {
+			au = [][]byte{testIDR}
+		}
+		test.That(t, live.writeAU(params, au, ts, ts, i%gop == 0), test.ShouldBeNil)
+		ts += frameTicks
+	}
+	return ts
+}
+
+func TestHLSLive(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+
+	t.Run("waits for a random access point", func(t *testing.T) {
+		live := newHLSLive(&HLS{}, logger)
+		params := fmp4Params{codec: videostore.CodecTypeH264, sps: testH264SPS, pps: testH264PPS}
+		test.That(t, live.writeAU(params, [][]byte{testNonIDR}, 0, 0, false), test.ShouldBeNil)
+		test.That(t, live.init, test.ShouldBeNil)
+		test.That(t, live.segments, test.ShouldBeEmpty)
+	})
+
+	t.Run("builds segments and parts", func(t *testing.T) {
+		live := newHLSLive(&HLS{SegmentCount: 3}, logger)
+		// 10 seconds of video with a one second gop
+		feedLive(t, live, 0, 300, 30)
+
+		live.mu.Lock()
+		defer live.mu.Unlock()
+		test.That(t, live.init, test.ShouldNotBeNil)
+
+		var complete []*hlsSegment
+		for _, seg := range live.segments {
+			if seg.complete {
+				complete = append(complete, seg)
+			}
+		}
+		test.That(t, len(complete), test.ShouldEqual, 3)
+		for _, seg := range complete {
+			test.That(t, seg.duration(), test.ShouldEqual, 2*time.Second)
+			test.That(t, seg.parts[0].independent, test.ShouldBeTrue)
+			for _, part := range seg.parts {
+				test.That(t, part.duration, test.ShouldBeLessThanOrEqualTo, defaultHLSPartDuration)
+			}
+		}
+		// media sequence numbers are contiguous after old segments are dropped
+		for i := 1; i < len(live.segments); i++ {
+			test.That(t, live.segments[i].msn, test.ShouldEqual, live.segments[i-1].msn+1)
+		}
+
+		playlist, ok := live.playlistLocked()
+		test.That(t, ok, test.ShouldBeTrue)
+		test.That(t, playlist, test.ShouldStartWith, "#EXTM3U\n")
+		test.That(t, playlist, test.ShouldContainSubstring, "#EXT-X-TARGETDURATION:2\n")
+		test.That(t, playlist, test.ShouldContainSubstring, "#EXT-X-PART-INF:PART-TARGET=0.50000\n")
+		test.That(t, playlist, test.ShouldContainSubstring, "#EXT-X-MAP:URI=\"init.mp4\"\n")
+		test.That(t, playlist, test.ShouldContainSubstring, ",INDEPENDENT=YES")
+		test.That(t, playlist, test.ShouldContainSubstring, "#EXTINF:2.00000,\n")
+		test.That(t, playlist, test.ShouldContainSubstring, "#EXT-X-PRELOAD-HINT:TYPE=PART")
+	})
+
+	t.Run("parameter set change restarts the stream", func(t *testing.T) {
+		live := newHLSLive(&HLS{}, logger)
+		feedLive(t, live, 0, 90, 30)
+		live.mu.Lock()
+		msn := live.nextMSN
+		live.mu.Unlock()
+
+		other := fmp4Params{codec: videostore.CodecTypeH264, sps: testH264SPS, pps: []byte{0x68, 0xce, 0x3c, 0x80}}
+		test.That(t, live.writeAU(other, [][]byte{testIDR}, 0, 0, true), test.ShouldBeNil)
+
+		live.mu.Lock()
+		defer live.mu.Unlock()
+		test.That(t, live.segments, test.ShouldBeEmpty)
+		test.That(t, live.params.pps, test.ShouldResemble, other.pps)
+		test.That(t, live.nextMSN, test.ShouldEqual, msn)
+	})
+
+	t.Run("reset clears the stream", func(t *testing.T) {
+		live := newHLSLive(&HLS{}, logger)
+		feedLive(t, live, 0, 90, 30)
+		live.reset()
+		test.That(t, live.init, test.ShouldBeNil)
+		test.That(t, live.segments, test.ShouldBeEmpty)
+		_, ok := live.playlistLocked()
+		test.That(t, ok, test.ShouldBeFalse)
+	})
+}
+
+func newTestHLSServer(t *testing.T, vs videostore.VideoStore, live *hlsLive) *httptest.Server {
+	t.Helper()
+	s := &hlsServer{logger: logging.NewTestLogger(t), vs: vs, live: live}
+	server := httptest.NewServer(s.handler())
+	t.Cleanup(server.Close)
+	return server
+}
+
+func httpGet(t *testing.T, url string) (int, string) {
+	t.Helper()
+	//nolint:noctx
+	resp, err := http.Get(url)
+	test.That(t, err, test.ShouldBeNil)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	test.That(t, err, test.ShouldBeNil)
+	return resp.StatusCode, string(body)
+}
+
+func TestHLSServerLive(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+
+	t.Run("no live source", func(t *testing.T) {
+		server := newTestHLSServer(t, &mockVideoStore{}, nil)
+		code, _ := httpGet(t, server.URL+"/live/index.m3u8")
+		test.That(t, code, test.ShouldEqual, http.StatusNotFound)
+	})
+
+	t.Run("serves playlist, init, segments and parts", func(t *testing.T) {
+		live := newHLSLive(&HLS{}, logger)
+		feedLive(t, live, 0, 150, 30)
+		server := newTestHLSServer(t, &mockVideoStore{}, live)
+
+		code, playlist := httpGet(t, server.URL+"/live/index.m3u8")
+		test.That(t, code, test.ShouldEqual, http.StatusOK)
+		test.That(t, playlist, test.ShouldContainSubstring, "seg-0.mp4")
+
+		code, body := httpGet(t, server.URL+"/live/init.mp4")
+		test.That(t, code, test.ShouldEqual, http.StatusOK)
+		test.That(t, topLevelBoxes(t, []byte(body)), test.ShouldResemble, []string{"ftyp", "moov"})
+
+		code, body = httpGet(t, server.URL+"/live/seg-0.mp4")
+		test.That(t, code, test.ShouldEqual, http.StatusOK)
+		test.That(t, topLevelBoxes(t, []byte(body))[0], test.ShouldEqual, "moof")
+
+		code, body = httpGet(t, server.URL+"/live/part-0-0.mp4")
+		test.That(t, code, test.ShouldEqual, http.StatusOK)
+		test.That(t, topLevelBoxes(t, []byte(body)), test.ShouldResemble, []string{"moof", "mdat"})
+
+		code, _ = httpGet(t, server.URL+"/live/bogus.ts")
+		test.That(t, code, test.ShouldEqual, http.StatusNotFound)
+	})
+
+	t.Run("blocking playlist reload waits for the requested part", func(t *testing.T) {
+		live := newHLSLive(&HLS{}, logger)
+		ts := feedLive(t, live, 0, 30, 30)
+		server := newTestHLSServer(t, &mockVideoStore{}, live)
+
+		live.mu.Lock()
+		msn := live.nextMSN - 1
+		live.mu.Unlock()
+
+		done := make(chan string, 1)
+		go func() {
+			_, playlist := httpGet(t, server.URL+"/live/index.m3u8?_HLS_msn="+strconv.Itoa(msn+1)+"&_HLS_part=0")
+			done <- playlist
+		}()
+
+		select {
+		case <-done:
+			t.Fatal("blocking reload returned before the part existed")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		feedLive(t, live, ts, 90, 30)
+		select {
+		case playlist := <-done:
+			test.That(t, playlist, test.ShouldContainSubstring, "part-"+strconv.Itoa(msn+1)+"-0.mp4")
+		case <-time.After(2 * time.Second):
+			t.Fatal("blocking reload did not return once the part existed")
+		}
+	})
+}
+
+func TestHLSServerVOD(t *testing.T) {
+	init, err := marshalFMP4Init(fmp4Params{codec: videostore.CodecTypeH264, sps: testH264SPS, pps: testH264PPS})
+	test.That(t, err, test.ShouldBeNil)
+	frag := marshalFMP4Fragment(1, []*fmp4Sample{{duration: 3000, sync: true, payload: []byte{0, 0, 0, 1, 0x65}}})
+
+	var fetches []*videostore.FetchRequest
+	mockVS := &mockVideoStore{
+		fetchFunc: func(_ context.Context, req *videostore.FetchRequest) (*videostore.FetchResponse, error) {
+			fetches = append(fetches, req)
+			return &videostore.FetchResponse{Video: append(append([]byte{}, init...), frag...)}, nil
+		},
+	}
+	server := newTestHLSServer(t, mockVS, nil)
+
+	t.Run("playlist splits the range into windows", func(t *testing.T) {
+		code, playlist := httpGet(t, server.URL+"/vod/index.m3u8?from=2024-09-06_15-00-00&to=2024-09-06_15-00-25")
+		test.That(t, code, test.ShouldEqual, http.StatusOK)
+		test.That(t, playlist, test.ShouldContainSubstring, "#EXT-X-PLAYLIST-TYPE:VOD\n")
+		test.That(t, strings.Count(playlist, "#EXTINF:"), test.ShouldEqual, 3)
+		test.That(t, strings.Count(playlist, "#EXT-X-DISCONTINUITY"), test.ShouldEqual, 2)
+		test.That(t, playlist, test.ShouldContainSubstring, "#EXTINF:5.00000,\n")
+		from, err := vsutils.ParseDateTimeString("2024-09-06_15-00-20")
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, playlist, test.ShouldContainSubstring, "segment.mp4?"+hlsRangeQuery(from, from.Add(5*time.Second))+"\n")
+		test.That(t, playlist, test.ShouldEndWith, "#EXT-X-ENDLIST\n")
+	})
+
+	t.Run("invalid range", func(t *testing.T) {
+		code, _ := httpGet(t, server.URL+"/vod/index.m3u8?from=2024-09-06_15-00-25&to=2024-09-06_15-00-00")
+		test.That(t, code, test.ShouldEqual, http.StatusBadRequest)
+		code, _ = httpGet(t, server.URL+"/vod/index.m3u8")
+		test.That(t, code, test.ShouldEqual, http.StatusBadRequest)
+	})
+
+	t.Run("ranges are capped", func(t *testing.T) {
+		code, _ := httpGet(t, server.URL+"/vod/index.m3u8?from=2024-09-06_15-00-00&to=2024-09-07_15-00-00")
+		test.That(t, code, test.ShouldEqual, http.StatusOK)
+		code, _ = httpGet(t, server.URL+"/vod/index.m3u8?from=2024-09-06_15-00-00&to=2024-09-07_15-00-01")
+		test.That(t, code, test.ShouldEqual, http.StatusBadRequest)
+
+		fetches = nil
+		code, _ = httpGet(t, server.URL+"/vod/segment.mp4?from=2024-09-06_15-00-00&to=2024-09-06_15-00-11")
+		test.That(t, code, test.ShouldEqual, http.StatusBadRequest)
+		test.That(t, fetches, test.ShouldBeEmpty)
+	})
+
+	t.Run("init and segment share one fetch", func(t *testing.T) {
+		fetches = nil
+		query := "?from=2024-09-06_15-00-00&to=2024-09-06_15-00-10"
+		code, body := httpGet(t, server.URL+"/vod/init.mp4"+query)
+		test.That(t, code, test.ShouldEqual, http.StatusOK)
+		test.That(t, []byte(body), test.ShouldResemble, init)
+
+		code, body = httpGet(t, server.URL+"/vod/segment.mp4"+query)
+		test.That(t, code, test.ShouldEqual, http.StatusOK)
+		test.That(t, []byte(body), test.ShouldResemble, frag)
+
+		test.That(t, len(fetches), test.ShouldEqual, 1)
+		test.That(t, fetches[0].Container, test.ShouldEqual, videostore.ContainerFMP4)
+		test.That(t, fetches[0].To.Sub(fetches[0].From), test.ShouldEqual, 10*time.Second)
+	})
+}
+
+func TestHLSRangeQuery(t *testing.T) {
+	// a host in any time zone must read the range back as the same instants
+	loc := time.FixedZone("UTC-7", -7*60*60)
+	from := time.Date(2024, 9, 6, 8, 0, 0, 0, loc)
+	to := from.Add(5 * time.Second)
+
+	query := hlsRangeQuery(from, to)
+	test.That(t, query, test.ShouldEqual, "from=2024-09-06_15-00-00Z&to=2024-09-06_15-00-05Z")
+
+	req := httptest.NewRequest(http.MethodGet, "/vod/segment.mp4?"+query, nil)
+	gotFrom, gotTo, err := parseHLSRange(req, vodSegmentDuration)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, gotFrom.Equal(from), test.ShouldBeTrue)
+	test.That(t, gotTo.Equal(to), test.ShouldBeTrue)
+}
+
+func TestHLSConfigValidate(t *testing.T) {
+	test.That(t, (&HLS{Address: "localhost:0"}).validate("path"), test.ShouldBeNil)
+	test.That(t, (&HLS{}).validate("path"), test.ShouldNotBeNil)
+	test.That(t, (&HLS{Address: "localhost:0", SegmentCount: -1}).validate("path"), test.ShouldNotBeNil)
+	test.That(t, (&HLS{Address: "localhost:0", SegmentDurationSec: 1, PartDurationMS: 1000}).validate("path"), test.ShouldNotBeNil)
+}
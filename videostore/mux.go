@@ -1,6 +1,7 @@
 package videostore
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -29,6 +30,7 @@ type metadata struct {
 		Extract(au [][]byte, pts int64) (int64, error)
 	}
 	spsUnChanged       bool
+	spsInvalid         bool
 	firstTimeStampsSet bool
 	firstPTS           int64
 	firstDTS           int64
@@ -42,6 +44,10 @@ type rawSegmenterMux struct {
 	worker  *utils.StoppableWorkers
 	regDone <-chan struct{}
 	cam     registry.ModuleCamera
+	// live is optional and receives every access unit for hls playback
+	live *hlsLive
+	// schedule is optional, video is only requested from the camera while it is active
+	schedule *recordingSchedule
 
 	mu       sync.Mutex
 	rawSeg   *videostore.RawSegmenter
@@ -72,18 +78,25 @@ func (m *rawSegmenterMux) init() error {
 	if err != nil {
 		return fmt.Errorf("resource %s unable to be found in viamrtsp registry err: %s", m.camName.String(), err.Error())
 	}
+	m.cam = cam
+	// video is requested even outside of the schedule so an unsupported camera
+	// returns an error here and videostore can fall back to polling frames
 	regCtx, err := cam.RequestVideo(m, codecs)
 	if err != nil {
 		return err
 	}
 	m.regDone = regCtx.Done()
-	m.cam = cam
+	if !m.schedule.active(time.Now()) {
+		m.logger.Info("outside of recording schedule, waiting to record")
+		m.cleanup()
+		m.regDone = nil
+	}
 	m.worker.Add(m.registrationMonitor)
 	return nil
 }
 
 func (m *rawSegmenterMux) registrationMonitor(ctx context.Context) {
-	registered := true
+	registered := m.regDone != nil
 	timer := time.NewTimer(monitorInterval)
 	defer timer.Stop()
 	defer m.cleanup()
@@ -101,6 +114,19 @@ func (m *rawSegmenterMux) registrationMonitor(ctx context.Context) {
 			m.regDone = nil
 
 		case <-timer.C:
+			recording := m.schedule.active(time.Now())
+			if registered && !recording {
+				m.logger.Info("recording schedule ended, stopping recording")
+				m.cleanup()
+				registered = false
+				m.regDone = nil
+				timer.Reset(monitorInterval)
+				continue
+			}
+			if !registered && !recording {
+				timer.Reset(monitorInterval)
+				continue
+			}
 			if !registered {
 				cam, err := registry.Global.Get(m.camName.String())
 				if err != nil {
@@ -134,6 +160,10 @@ func (m *rawSegmenterMux) cleanup() {
 	if err := m.Stop(); err != nil {
 		m.logger.Warnf("failed to stop raw segmenter %s", err.Error())
 	}
+	// the request has already ended or was never made
+	if m.regDone == nil {
+		return
+	}
 	if err := m.cam.CancelRequest(m); err != nil {
 		m.logger.Warnf("DeRegister video-store from viamrtsp camera %s", err.Error())
 	}
@@ -292,9 +322,36 @@ func (m *rawSegmenterMux) Stop() error {
 	}
 	m.codec.Store(int64(videostore.CodecTypeUnknown))
 	m.metadata = metadata{}
+	if m.live != nil {
+		m.live.reset()
+	}
 	return nil
 }
 
+// writing returns whether packets are being written to the segmenter, which starts once
+// a random access point has been received.
+func (m *rawSegmenterMux) writing() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.metadata.dtsExtractor != nil
+}
+
+// writeLive forwards an access unit to the hls live stream, assumes mu is held.
+func (m *rawSegmenterMux) writeLive(au [][]byte, pts, dts int64, randomAccess bool) {
+	if m.live == nil {
+		return
+	}
+	params := fmp4Params{
+		codec: videostore.CodecType(m.codec.Load()),
+		vps:   m.metadata.vps,
+		sps:   m.metadata.sps,
+		pps:   m.metadata.pps,
+	}
+	if err := m.live.writeAU(params, au, pts, dts, randomAccess); err != nil {
+		m.logger.Debugf("failed to write hls live packet: %s", err.Error())
+	}
+}
+
 // enforceMonotonicTimestamps ensures DTS is strictly increasing and PTS >= DTS.
 // If DTS is non-monotonic, it is replaced with lastDTS + 1.
 func (m *rawSegmenterMux) enforceMonotonicTimestamps(pts, dts int64) (int64, int64) {
@@ -323,18 +380,29 @@ func (m *rawSegmenterMux) writeH265(au [][]byte, pts int64) error {
 	var filteredAU [][]byte
 
 	isRandomAccess := false
+	vclPresent := false
 
 	for _, nalu := range au {
+		if len(nalu) == 0 {
+			continue
+		}
 		//nolint:mnd
 		typ := h265.NALUType((nalu[0] >> 1) & 0b111111)
+		// nalu types below 32 hold slice data
+		//nolint:mnd
+		if typ < 32 {
+			vclPresent = true
+		}
 		switch typ {
 		case h265.NALUType_VPS_NUT:
 			m.metadata.vps = nalu
 			continue
 
 		case h265.NALUType_SPS_NUT:
+			if !bytes.Equal(nalu, m.metadata.sps) {
+				m.metadata.spsUnChanged = false
+			}
 			m.metadata.sps = nalu
-			m.metadata.spsUnChanged = false
 			continue
 
 		case h265.NALUType_PPS_NUT:
@@ -384,16 +452,28 @@ func (m *rawSegmenterMux) writeH265(au [][]byte, pts int64) error {
 
 	au = filteredAU
 
-	if au == nil {
+	if au == nil || !vclPresent {
 		return nil
 	}
 
+	if len(m.metadata.sps) == 0 {
+		m.logger.Debug("skipping h265 access unit until SPS is received")
+		return nil
+	}
 	if err := m.maybeReInitVideoStore(); err != nil {
-		return err
+		return fmt.Errorf("unable to init video store: %w", err)
+	}
+	if m.metadata.spsInvalid {
+		return nil
 	}
 
+	liveAU := au
 	// add VPS, SPS and PPS before random access au
 	if isRandomAccess {
+		if len(m.metadata.vps) == 0 || len(m.metadata.pps) == 0 {
+			m.logger.Debug("skipping random access h265 access unit until VPS and PPS are received")
+			return nil
+		}
 		au = append([][]byte{m.metadata.vps, m.metadata.sps, m.metadata.pps}, au...)
 	}
 
@@ -424,6 +504,7 @@ func (m *rawSegmenterMux) writeH265(au [][]byte, pts int64) error {
 	}
 
 	normPTS, normDTS := m.enforceMonotonicTimestamps(pts, dts)
+	m.writeLive(liveAU, normPTS, normDTS, isRandomAccess)
 	err = m.rawSeg.WritePacket(nalu, normPTS, normDTS, isRandomAccess)
 	if err != nil {
 		m.logger.Errorf("error writing packet to segmenter: %s", err)
@@ -437,12 +518,17 @@ func (m *rawSegmenterMux) writeH264(au [][]byte, pts int64) error {
 	idrPresent := false
 
 	for _, nalu := range au {
+		if len(nalu) == 0 {
+			continue
+		}
 		//nolint:mnd
 		typ := h264.NALUType(nalu[0] & 0x1F)
 		switch typ {
 		case h264.NALUTypeSPS:
+			if !bytes.Equal(nalu, m.metadata.sps) {
+				m.metadata.spsUnChanged = false
+			}
 			m.metadata.sps = nalu
-			m.metadata.spsUnChanged = false
 			continue
 
 		case h264.NALUTypePPS:
@@ -494,13 +580,24 @@ func (m *rawSegmenterMux) writeH264(au [][]byte, pts int64) error {
 		return nil
 	}
 
+	if len(m.metadata.sps) == 0 {
+		m.logger.Debug("skipping h264 access unit until SPS is received")
+		return nil
+	}
 	if err := m.maybeReInitVideoStore(); err != nil {
-		m.logger.Debugf("unable to init video store: %s", err.Error())
+		return fmt.Errorf("unable to init video store: %w", err)
+	}
+	if m.metadata.spsInvalid {
 		return nil
 	}
 
+	liveAU := au
 	// add SPS and PPS before access unit that contains an IDR
 	if idrPresent {
+		if len(m.metadata.pps) == 0 {
+			m.logger.Debug("skipping h264 IDR access unit until PPS is received")
+			return nil
+		}
 		au = append([][]byte{m.metadata.sps, m.metadata.pps}, au...)
 	}
 
@@ -531,6 +628,7 @@ func (m *rawSegmenterMux) writeH264(au [][]byte, pts int64) error {
 	}
 
 	normPTS, normDTS := m.enforceMonotonicTimestamps(pts, dts)
+	m.writeLive(liveAU, normPTS, normDTS, idrPresent)
 	err = m.rawSeg.WritePacket(packed, normPTS, normDTS, idrPresent)
 	if err != nil {
 		m.logger.Errorf("error writing packet to segmenter: %s", err)
@@ -538,39 +636,43 @@ func (m *rawSegmenterMux) writeH264(au [][]byte, pts int64) error {
 	return nil
 }
 
-// // maybeReInitVideoStore assumes mu is held by caller.
+// // maybeReInitVideoStore assumes mu is held by caller. An sps that can't be recorded is
+// logged once and sets spsInvalid, so access units are skipped rather than failing until
+// the sps changes.
 func (m *rawSegmenterMux) maybeReInitVideoStore() error {
 	if m.metadata.spsUnChanged {
 		return nil
 	}
 	var width, height int
+	var spsErr error
 	codec := videostore.CodecType(m.codec.Load())
 	switch codec {
 	case videostore.CodecTypeH265:
 		var hsps h265.SPS
-		if err := hsps.Unmarshal(m.metadata.sps); err != nil {
-			m.logger.Debugf("unable to init video store: %s", err.Error())
-			return nil
+		if spsErr = hsps.Unmarshal(m.metadata.sps); spsErr == nil {
+			width, height = hsps.Width(), hsps.Height()
 		}
-		width, height = hsps.Width(), hsps.Height()
 	case videostore.CodecTypeH264:
 		var hsps h264.SPS
-		if err := hsps.Unmarshal(m.metadata.sps); err != nil {
-			m.logger.Debugf("unable to init video store: %s", err.Error())
-			return nil
+		if spsErr = hsps.Unmarshal(m.metadata.sps); spsErr == nil {
+			width, height = hsps.Width(), hsps.Height()
 		}
-		width, height = hsps.Width(), hsps.Height()
 	case videostore.CodecTypeUnknown:
 		fallthrough
 	default:
 		return errors.New("invalid videostore.CodecType")
 	}
 
-	if width <= 0 || height <= 0 {
-		err := errors.New("width and height must both be greater than 0")
-		m.logger.Infof("unable to init video store: %s", err.Error())
+	if spsErr == nil && (width <= 0 || height <= 0) {
+		spsErr = errors.New("width and height must both be greater than 0")
+	}
+	if spsErr != nil {
+		m.logger.Errorf("skipping video until the camera sends a valid sps: %s", spsErr.Error())
+		m.metadata.spsInvalid = true
+		m.metadata.spsUnChanged = true
 		return nil
 	}
+	m.metadata.spsInvalid = false
 	// if vs is initialized and the height & width have not changed,
 	// record the sps as unchanged and return
 	if m.metadata.width == width && m.metadata.height == height {
@@ -588,6 +690,9 @@ func (m *rawSegmenterMux) maybeReInitVideoStore() error {
 		return err
 	}
 
+	// the new segment must start on a random access point, so wait for one
+	// before extracting timestamps again
+	m.metadata.dtsExtractor = nil
 	m.metadata.width = width
 	m.metadata.height = height
 	m.metadata.spsUnChanged = true
@@ -1,12 +1,240 @@
 package videostore
 
 import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h265"
+	"github.com/viam-modules/viamrtsp"
+	"github.com/viam-modules/viamrtsp/registry"
+	"github.com/viam-modules/video-store/videostore"
+	"go.viam.com/rdk/components/camera"
 	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/resource"
 	"go.viam.com/test"
 )
 
+var (
+	testH265IDR   = []byte{0x26, 0x01, 0xaf, 0x08, 0x40}
+	testH265Trail = []byte{0x02, 0x01, 0xd0, 0x10, 0x80}
+)
+
+func newTestRawSegmenterMux(t *testing.T) *rawSegmenterMux {
+	t.Helper()
+	logger := logging.NewTestLogger(t)
+	dir := t.TempDir()
+	rtpVs, err := videostore.NewRTPVideoStore(context.Background(), videostore.Config{
+		Name: "test",
+		Type: videostore.SourceTypeRTP,
+		Storage: videostore.StorageConfig{
+			SizeGB:               1,
+			OutputFileNamePrefix: "test",
+			StoragePath:          filepath.Join(dir, "storage"),
+			UploadPath:           filepath.Join(dir, "upload"),
+		},
+	}, logger)
+	test.That(t, err, test.ShouldBeNil)
+	t.Cleanup(rtpVs.Close)
+	return newRawSegmenterMux(rtpVs.Segmenter(), camera.Named("cam"), logger)
+}
+
+func TestRawSegmenterMuxH265(t *testing.T) {
+	var sps h265.SPS
+	test.That(t, sps.Unmarshal(testH265SPS), test.ShouldBeNil)
+
+	t.Run("waits for a complete set of parameter sets", func(t *testing.T) {
+		m := newTestRawSegmenterMux(t)
+		// cameras are not required to put the VPS in the SDP
+		test.That(t, m.Start(videostore.CodecTypeH265, [][]byte{nil, testH265SPS, testH265PPS}), test.ShouldBeNil)
+		test.That(t, m.WritePacket(videostore.CodecTypeH265, [][]byte{testH265IDR}, 0), test.ShouldBeNil)
+		test.That(t, m.metadata.dtsExtractor, test.ShouldBeNil)
+		test.That(t, m.writing(), test.ShouldBeFalse)
+
+		// the VPS arrives in band with the next random access point
+		test.That(t, m.WritePacket(videostore.CodecTypeH265, [][]byte{testH265VPS, testH265IDR}, 3000), test.ShouldBeNil)
+		test.That(t, m.metadata.dtsExtractor, test.ShouldNotBeNil)
+		test.That(t, m.metadata.width, test.ShouldEqual, sps.Width())
+		test.That(t, m.metadata.height, test.ShouldEqual, sps.Height())
+		test.That(t, m.writing(), test.ShouldBeTrue)
+		test.That(t, m.Stop(), test.ShouldBeNil)
+		test.That(t, m.writing(), test.ShouldBeFalse)
+	})
+
+	t.Run("skips empty nalus and access units without slices", func(t *testing.T) {
+		m := newTestRawSegmenterMux(t)
+		test.That(t, m.Start(videostore.CodecTypeH265, [][]byte{testH265VPS, testH265SPS, testH265PPS}), test.ShouldBeNil)
+		test.That(t, m.WritePacket(videostore.CodecTypeH265, [][]byte{{}, testH265IDR}, 0), test.ShouldBeNil)
+		test.That(t, m.WritePacket(videostore.CodecTypeH265, [][]byte{testH265PPS}, 3000), test.ShouldBeNil)
+		test.That(t, m.WritePacket(videostore.CodecTypeH265, [][]byte{testH265Trail, {}}, 6000), test.ShouldBeNil)
+		test.That(t, m.Stop(), test.ShouldBeNil)
+	})
+
+	t.Run("parameter sets can change mid stream", func(t *testing.T) {
+		m := newTestRawSegmenterMux(t)
+		test.That(t, m.Start(videostore.CodecTypeH265, [][]byte{testH265VPS, testH265SPS, testH265PPS}), test.ShouldBeNil)
+		test.That(t, m.WritePacket(videostore.CodecTypeH265, [][]byte{testH265IDR}, 0), test.ShouldBeNil)
+		test.That(t, m.metadata.spsUnChanged, test.ShouldBeTrue)
+
+		newPPS := []byte{0x44, 0x01, 0xc1, 0x73, 0xd0, 0x89}
+		test.That(t, m.WritePacket(videostore.CodecTypeH265, [][]byte{testH265SPS, newPPS, testH265IDR}, 3000), test.ShouldBeNil)
+		test.That(t, m.metadata.pps, test.ShouldResemble, newPPS)
+		// same resolution, so the segmenter keeps writing to the same segment
+		test.That(t, m.metadata.spsUnChanged, test.ShouldBeTrue)
+		test.That(t, m.metadata.dtsExtractor, test.ShouldNotBeNil)
+		test.That(t, m.metadata.width, test.ShouldEqual, sps.Width())
+		test.That(t, m.Stop(), test.ShouldBeNil)
+	})
+
+	t.Run("resolution change restarts at the next random access point", func(t *testing.T) {
+		m := newTestRawSegmenterMux(t)
+		test.That(t, m.Start(videostore.CodecTypeH265, [][]byte{testH265VPS, testH265SPS, testH265PPS}), test.ShouldBeNil)
+		test.That(t, m.WritePacket(videostore.CodecTypeH265, [][]byte{testH265IDR}, 0), test.ShouldBeNil)
+		test.That(t, m.metadata.dtsExtractor, test.ShouldNotBeNil)
+
+		// pretend the previous segment was a different size
+		m.metadata.width, m.metadata.height = 320, 240
+		m.metadata.spsUnChanged = false
+		test.That(t, m.WritePacket(videostore.CodecTypeH265, [][]byte{testH265Trail}, 3000), test.ShouldBeNil)
+		test.That(t, m.metadata.dtsExtractor, test.ShouldBeNil)
+		test.That(t, m.metadata.width, test.ShouldEqual, sps.Width())
+
+		test.That(t, m.WritePacket(videostore.CodecTypeH265, [][]byte{testH265IDR}, 6000), test.ShouldBeNil)
+		test.That(t, m.metadata.dtsExtractor, test.ShouldNotBeNil)
+		test.That(t, m.Stop(), test.ShouldBeNil)
+	})
+
+	t.Run("invalid sps is skipped until it changes", func(t *testing.T) {
+		m := newTestRawSegmenterMux(t)
+		test.That(t, m.Start(videostore.CodecTypeH265, [][]byte{testH265VPS, nil, testH265PPS}), test.ShouldBeNil)
+		// waiting for the sps is not an error
+		test.That(t, m.WritePacket(videostore.CodecTypeH265, [][]byte{testH265Trail}, 0), test.ShouldBeNil)
+
+		// an invalid sps is logged once rather than failing every packet
+		badSPS := []byte{0x42, 0x01, 0xff}
+		test.That(t, m.WritePacket(videostore.CodecTypeH265, [][]byte{badSPS, testH265IDR}, 3000), test.ShouldBeNil)
+		test.That(t, m.metadata.spsInvalid, test.ShouldBeTrue)
+		test.That(t, m.WritePacket(videostore.CodecTypeH265, [][]byte{badSPS, testH265IDR}, 6000), test.ShouldBeNil)
+		test.That(t, m.writing(), test.ShouldBeFalse)
+
+		test.That(t, m.WritePacket(videostore.CodecTypeH265, [][]byte{testH265SPS, testH265IDR}, 9000), test.ShouldBeNil)
+		test.That(t, m.metadata.spsInvalid, test.ShouldBeFalse)
+		test.That(t, m.writing(), test.ShouldBeTrue)
+		test.That(t, m.Stop(), test.ShouldBeNil)
+	})
+
+	t.Run("rejects packets for a different codec", func(t *testing.T) {
+		m := newTestRawSegmenterMux(t)
+		test.That(t, m.Start(videostore.CodecTypeH265, [][]byte{testH265VPS, testH265SPS, testH265PPS}), test.ShouldBeNil)
+		test.That(t, m.WritePacket(videostore.CodecTypeH264, [][]byte{testIDR}, 0), test.ShouldNotBeNil)
+		test.That(t, m.Stop(), test.ShouldBeNil)
+	})
+}
+
+func TestRawSegmenterMuxRTSPH265(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	bURL, err := base.ParseURL("rtsp://127.0.0.1:32513")
+	test.That(t, err, test.ShouldBeNil)
+	forma := &format.H265{
+		PayloadTyp: 96,
+		VPS:        []byte{0x40, 0x01, 0x0c, 0x01, 0xff, 0xff, 0x01, 0x60, 0x00, 0x00, 0x03, 0x00, 0x90},
+		// 1920x1080
+		SPS: []byte{
+			0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03,
+			0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03,
+			0x00, 0x78, 0xa0, 0x03, 0xc0, 0x80, 0x10, 0xe5,
+			0x96, 0x66, 0x69, 0x24, 0xca, 0xe0, 0x10, 0x00,
+			0x00, 0x03, 0x00, 0x10, 0x00, 0x00, 0x03, 0x01,
+			0xe0, 0x80,
+		},
+		PPS: []byte{0x44, 0x01, 0xc1, 0x72, 0xb4, 0x62, 0x40},
+	}
+	// the same stream at 640x360
+	smallSPS := []byte{
+		0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03,
+		0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03,
+		0x00, 0x78, 0xa0, 0x05, 0x02, 0x01, 0x69, 0x65,
+		0x99, 0x9a, 0x49, 0x32, 0xb8, 0x04, 0x00, 0x00,
+		0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0x78,
+		0x20,
+	}
+	// the camera switches resolution in band at every other keyframe
+	aus := [][][]byte{
+		{forma.VPS, forma.SPS, forma.PPS, testH265IDR},
+		{testH265Trail},
+		{forma.VPS, smallSPS, forma.PPS, testH265IDR},
+		{testH265Trail},
+	}
+	h, closeFunc := viamrtsp.NewMockH265ServerHandler(t, forma, bURL, aus, logger)
+	defer closeFunc()
+	test.That(t, h.S.Start(), test.ShouldBeNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	config := resource.NewEmptyConfig(camera.Named("foo"), viamrtsp.ModelAgnostic)
+	config.ConvertedAttributes = &viamrtsp.Config{Address: "rtsp://" + h.S.RTSPAddress + "/stream1"}
+	cam, err := viamrtsp.NewRTSPCamera(ctx, nil, config, logger)
+	test.That(t, err, test.ShouldBeNil)
+	defer func() { test.That(t, cam.Close(context.Background()), test.ShouldBeNil) }()
+	moduleCam, ok := cam.(registry.ModuleCamera)
+	test.That(t, ok, test.ShouldBeTrue)
+
+	storagePath := filepath.Join(t.TempDir(), "storage")
+	rtpVs, err := videostore.NewRTPVideoStore(ctx, videostore.Config{
+		Name: "test",
+		Type: videostore.SourceTypeRTP,
+		Storage: videostore.StorageConfig{
+			SizeGB:               1,
+			OutputFileNamePrefix: "test",
+			StoragePath:          storagePath,
+			UploadPath:           filepath.Join(t.TempDir(), "upload"),
+		},
+	}, logger)
+	test.That(t, err, test.ShouldBeNil)
+	defer rtpVs.Close()
+	m := newRawSegmenterMux(rtpVs.Segmenter(), camera.Named("foo"), logger)
+
+	_, err = moduleCam.RequestVideo(m, []videostore.CodecType{videostore.CodecTypeH265})
+	test.That(t, err, test.ShouldBeNil)
+
+	// wait for the mux to follow the stream from one resolution to the other and back
+	var widths []int
+	for ctx.Err() == nil && len(widths) < 3 {
+		m.mu.Lock()
+		width := m.metadata.width
+		m.mu.Unlock()
+		if width != 0 && (len(widths) == 0 || widths[len(widths)-1] != width) {
+			widths = append(widths, width)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	test.That(t, ctx.Err(), test.ShouldBeNil)
+	test.That(t, moduleCam.CancelRequest(m), test.ShouldBeNil)
+	test.That(t, m.Stop(), test.ShouldBeNil)
+	// the stream may have been joined at either resolution
+	switched := slices.Clone(widths[:2])
+	slices.Sort(switched)
+	test.That(t, switched, test.ShouldResemble, []int{640, 1920})
+
+	entries, err := os.ReadDir(storagePath)
+	test.That(t, err, test.ShouldBeNil)
+	var written int
+	for _, entry := range entries {
+		info, err := entry.Info()
+		test.That(t, err, test.ShouldBeNil)
+		if strings.HasSuffix(entry.Name(), segmentExt) && info.Size() > 0 {
+			written++
+		}
+	}
+	test.That(t, written, test.ShouldBeGreaterThan, 0)
+}
+
 func TestEnforceMonotonicTimestamps(t *testing.T) {
 	t.Run("monotonic DTS passes through unchanged", func(t *testing.T) {
 		logger := logging.NewTestLogger(t)
@@ -160,3 +388,56 @@ func TestEnforceMonotonicTimestamps(t *testing.T) {
 		test.That(t, dts, test.ShouldEqual, 492)
 	})
 }
+
+// fakeModuleCamera records the video requests made by a rawSegmenterMux.
+type fakeModuleCamera struct {
+	requestErr error
+	requests   int
+	cancels    int
+	cancel     context.CancelFunc
+}
+
+func (c *fakeModuleCamera) RequestVideo(_ registry.Mux, _ []videostore.CodecType) (context.Context, error) {
+	if c.requestErr != nil {
+		return nil, c.requestErr
+	}
+	c.requests++
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	return ctx, nil
+}
+
+func (c *fakeModuleCamera) CancelRequest(_ registry.Mux) error {
+	c.cancels++
+	c.cancel()
+	return nil
+}
+
+func TestRawSegmenterMuxInit(t *testing.T) {
+	// a schedule that has been evaluated with no next change never records
+	neverRecording := &recordingSchedule{evaluated: true}
+
+	t.Run("unsupported cameras fail outside of the schedule", func(t *testing.T) {
+		cam := &fakeModuleCamera{requestErr: registry.ErrUnsupported}
+		m := newTestRawSegmenterMux(t)
+		test.That(t, registry.Global.Add(m.camName.String(), cam), test.ShouldBeNil)
+		defer func() { test.That(t, registry.Global.Remove(m.camName.String()), test.ShouldBeNil) }()
+		m.schedule = neverRecording
+		test.That(t, m.init(), test.ShouldBeError, registry.ErrUnsupported)
+		test.That(t, m.close(), test.ShouldBeNil)
+	})
+
+	t.Run("supported cameras are released until the schedule starts", func(t *testing.T) {
+		cam := &fakeModuleCamera{}
+		m := newTestRawSegmenterMux(t)
+		test.That(t, registry.Global.Add(m.camName.String(), cam), test.ShouldBeNil)
+		defer func() { test.That(t, registry.Global.Remove(m.camName.String()), test.ShouldBeNil) }()
+		m.schedule = neverRecording
+		test.That(t, m.init(), test.ShouldBeNil)
+		test.That(t, cam.requests, test.ShouldEqual, 1)
+		test.That(t, cam.cancels, test.ShouldEqual, 1)
+		test.That(t, m.close(), test.ShouldBeNil)
+		// nothing is requested, so closing doesn't cancel another mux's request
+		test.That(t, cam.cancels, test.ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,136 @@
+package videostore
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	// how far back to look for the last time a window started or stopped,
+	// the search starts at an hour and doubles from there.
+	minScheduleLookback = time.Hour
+	maxScheduleLookback = 400 * 24 * time.Hour
+)
+
+type scheduleWindow struct {
+	start cron.Schedule
+	stop  cron.Schedule
+}
+
+// recordingSchedule decides when video-store records. A nil schedule always records.
+type recordingSchedule struct {
+	loc     *time.Location
+	windows []scheduleWindow
+
+	mu         sync.Mutex
+	recording  bool
+	nextChange time.Time
+	evaluated  bool
+}
+
+func newRecordingSchedule(cfg *Schedule) (*recordingSchedule, error) {
+	loc := time.Local
+	if cfg.Timezone != "" {
+		var err error
+		loc, err = time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule timezone %q: %w", cfg.Timezone, err)
+		}
+	}
+	s := &recordingSchedule{loc: loc}
+	for i, w := range cfg.Windows {
+		start, err := parseScheduleSpec(w.Start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule window %d start: %w", i, err)
+		}
+		stop, err := parseScheduleSpec(w.Stop)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule window %d stop: %w", i, err)
+		}
+		s.windows = append(s.windows, scheduleWindow{start: start, stop: stop})
+	}
+	return s, nil
+}
+
+// parseScheduleSpec parses a standard 5 field cron spec. Timezones come from the
+// schedule config and @every has no fixed boundaries, so neither is allowed here.
+func parseScheduleSpec(spec string) (cron.Schedule, error) {
+	if strings.HasPrefix(spec, "@every") || strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		return nil, fmt.Errorf("unsupported cron spec %q", spec)
+	}
+	return cron.ParseStandard(spec)
+}
+
+// active returns whether video should be recorded at now.
+func (s *recordingSchedule) active(now time.Time) bool {
+	if s == nil {
+		return true
+	}
+	recording, _ := s.state(now)
+	return recording
+}
+
+// state returns whether video should be recorded at now and when that next changes.
+// Results are cached until the next window boundary.
+func (s *recordingSchedule) state(now time.Time) (bool, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.evaluated && (s.nextChange.IsZero() || now.Before(s.nextChange)) {
+		return s.recording, s.nextChange
+	}
+	now = now.In(s.loc)
+	s.recording = false
+	s.nextChange = time.Time{}
+	for _, w := range s.windows {
+		lastStart, lastStop := lastFire(w.start, now), lastFire(w.stop, now)
+		if !lastStart.IsZero() && lastStart.After(lastStop) {
+			s.recording = true
+		}
+		for _, next := range []time.Time{w.start.Next(now), w.stop.Next(now)} {
+			if !next.IsZero() && (s.nextChange.IsZero() || next.Before(s.nextChange)) {
+				s.nextChange = next
+			}
+		}
+	}
+	s.evaluated = true
+	return s.recording, s.nextChange
+}
+
+// lastFire returns the last time at or before now that sched fired, or the zero time.
+func lastFire(sched cron.Schedule, now time.Time) time.Time {
+	for lookback := minScheduleLookback; ; lookback *= 2 {
+		var last time.Time
+		for t := sched.Next(now.Add(-lookback)); !t.IsZero() && !t.After(now); t = sched.Next(t) {
+			last = t
+		}
+		if !last.IsZero() || lookback >= maxScheduleLookback {
+			return last
+		}
+	}
+}
+
+// doCommandState is reported by get-storage-state.
+func (s *recordingSchedule) doCommandState(now time.Time) map[string]interface{} {
+	recording, next := s.state(now)
+	state := map[string]interface{}{
+		"recording": recording,
+		"timezone":  s.loc.String(),
+	}
+	if !next.IsZero() {
+		state["next_change"] = formatUTCDatetime(next)
+	}
+	return state
+}
+
+func (c *Schedule) validate() error {
+	if len(c.Windows) == 0 {
+		return errors.New("schedule must have at least one window")
+	}
+	_, err := newRecordingSchedule(c)
+	return err
+}
@@ -0,0 +1,100 @@
+package videostore
+
+import (
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+)
+
+func TestRecordingSchedule(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	test.That(t, err, test.ShouldBeNil)
+	s, err := newRecordingSchedule(&Schedule{
+		Timezone: "America/New_York",
+		Windows: []ScheduleWindow{
+			// weekdays 9am to 5pm
+			{Start: "0 9 * * 1-5", Stop: "0 17 * * 1-5"},
+			// saturday mornings
+			{Start: "0 10 * * 6", Stop: "0 12 * * 6"},
+		},
+	})
+	test.That(t, err, test.ShouldBeNil)
+
+	for _, tc := range []struct {
+		name      string
+		now       time.Time
+		recording bool
+		next      time.Time
+	}{
+		{
+			name:      "weekday during hours",
+			now:       time.Date(2024, 1, 3, 12, 0, 0, 0, loc), // wednesday
+			recording: true,
+			next:      time.Date(2024, 1, 3, 17, 0, 0, 0, loc),
+		},
+		{
+			name:      "weekday at the start boundary",
+			now:       time.Date(2024, 1, 3, 9, 0, 0, 0, loc),
+			recording: true,
+			next:      time.Date(2024, 1, 3, 17, 0, 0, 0, loc),
+		},
+		{
+			name:      "weekday after hours",
+			now:       time.Date(2024, 1, 3, 20, 0, 0, 0, loc),
+			recording: false,
+			next:      time.Date(2024, 1, 4, 9, 0, 0, 0, loc),
+		},
+		{
+			name:      "saturday window",
+			now:       time.Date(2024, 1, 6, 11, 0, 0, 0, loc),
+			recording: true,
+			next:      time.Date(2024, 1, 6, 12, 0, 0, 0, loc),
+		},
+		{
+			name:      "sunday",
+			now:       time.Date(2024, 1, 7, 11, 0, 0, 0, loc),
+			recording: false,
+			next:      time.Date(2024, 1, 8, 9, 0, 0, 0, loc),
+		},
+		{
+			name:      "timezone is applied to utc times",
+			now:       time.Date(2024, 1, 3, 15, 0, 0, 0, time.UTC), // 10am in new york
+			recording: true,
+			next:      time.Date(2024, 1, 3, 17, 0, 0, 0, loc),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// force a fresh evaluation since the cases are not in time order
+			s.evaluated = false
+			recording, next := s.state(tc.now)
+			test.That(t, recording, test.ShouldEqual, tc.recording)
+			test.That(t, next.Equal(tc.next), test.ShouldBeTrue)
+		})
+	}
+
+	t.Run("cached until the next change", func(t *testing.T) {
+		s.evaluated = false
+		monday := time.Date(2024, 1, 8, 8, 0, 0, 0, loc)
+		test.That(t, s.active(monday), test.ShouldBeFalse)
+		test.That(t, s.active(monday.Add(59*time.Minute)), test.ShouldBeFalse)
+		test.That(t, s.active(monday.Add(time.Hour)), test.ShouldBeTrue)
+		test.That(t, s.active(monday.Add(9*time.Hour)), test.ShouldBeFalse)
+	})
+
+	t.Run("nil schedule always records", func(t *testing.T) {
+		var nilSchedule *recordingSchedule
+		test.That(t, nilSchedule.active(time.Now()), test.ShouldBeTrue)
+	})
+}
+
+func TestScheduleValidate(t *testing.T) {
+	test.That(t, (&Schedule{Windows: []ScheduleWindow{{Start: "0 9 * * *", Stop: "0 17 * * *"}}}).validate(), test.ShouldBeNil)
+	test.That(t, (&Schedule{}).validate(), test.ShouldNotBeNil)
+	test.That(t, (&Schedule{Windows: []ScheduleWindow{{Start: "bogus", Stop: "0 17 * * *"}}}).validate(), test.ShouldNotBeNil)
+	test.That(t, (&Schedule{Windows: []ScheduleWindow{{Start: "@every 1h", Stop: "0 17 * * *"}}}).validate(), test.ShouldNotBeNil)
+	test.That(t, (&Schedule{
+		Timezone: "Not/AZone",
+		Windows:  []ScheduleWindow{{Start: "0 9 * * *", Stop: "0 17 * * *"}},
+	}).validate(), test.ShouldNotBeNil)
+}
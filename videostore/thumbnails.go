@@ -0,0 +1,445 @@
+package videostore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/viam-modules/viamrtsp/registry"
+	"github.com/viam-modules/video-store/videostore"
+	vsutils "github.com/viam-modules/video-store/videostore/utils"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/utils"
+	"golang.org/x/image/draw"
+)
+
+const (
+	defaultThumbnailInterval = 10 * time.Second
+	defaultThumbnailWidth    = 160
+	defaultThumbnailCount    = 10
+	maxThumbnailCount        = 50
+	thumbnailJPEGQuality     = 70
+	thumbnailDirName         = "thumbnails"
+	thumbnailExt             = ".jpg"
+	segmentExt               = ".mp4"
+	// caps how many thumbnails are taken per tick while catching up on stored video.
+	maxThumbnailsPerTick = 30
+	// thumbnailStorageGB is taken out of size_gb for thumbnails, so video-store and the
+	// thumbnail directory together never use more than size_gb.
+	thumbnailStorageGB = 1
+	gibibyte           = 1 << 30
+)
+
+// thumbnailer periodically stores a small jpeg of the stored video next to the segments.
+// Thumbnails are decoded from the first keyframe at or after every interval of the finished
+// segments, so they only show video that was recorded. They are deleted once they are
+// older than the oldest stored segment, or once they use more than maxBytes.
+type thumbnailer struct {
+	logger      logging.Logger
+	vs          videostore.VideoStore
+	decoder     registry.KeyframeDecoder
+	dir         string
+	storagePath string
+	interval    time.Duration
+	width       int
+	maxBytes    int64
+	// keys encrypts thumbnails when storage encryption is configured.
+	keys *keyring
+	// next is when the next thumbnail is taken from, zero until the first tick.
+	next    time.Time
+	workers *utils.StoppableWorkers
+}
+
+func thumbnailDir(storagePath string) string {
+	return filepath.Join(storagePath, thumbnailDirName)
+}
+
+func newThumbnailer(
+	cfg *Thumbnails,
+	vs videostore.VideoStore,
+	storagePath string,
+	keys *keyring,
+	logger logging.Logger,
+) (*thumbnailer, error) {
+	if registry.NewKeyframeDecoder == nil {
+		return nil, errors.New("thumbnails need the viamrtsp decoders, which aren't available")
+	}
+	dir := thumbnailDir(storagePath)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create thumbnail directory: %w", err)
+	}
+	t := &thumbnailer{
+		logger:      logger,
+		vs:          vs,
+		decoder:     registry.NewKeyframeDecoder(logger),
+		dir:         dir,
+		storagePath: storagePath,
+		interval:    cfg.interval(),
+		width:       cfg.width(),
+		maxBytes:    thumbnailStorageGB * gibibyte,
+		keys:        keys,
+	}
+	t.workers = utils.NewBackgroundStoppableWorkers(t.run)
+	return t, nil
+}
+
+func (t *thumbnailer) close() {
+	if t == nil {
+		return
+	}
+	t.workers.Stop()
+	t.decoder.Close()
+}
+
+func (t *thumbnailer) run(ctx context.Context) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		t.tick(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t *thumbnailer) tick(ctx context.Context) {
+	if err := t.captureFinished(ctx); err != nil && ctx.Err() == nil {
+		t.logger.Debugf("failed to capture thumbnails: %s", err.Error())
+	}
+	if err := t.prune(); err != nil {
+		t.logger.Debugf("failed to prune thumbnails: %s", err.Error())
+	}
+}
+
+// segmentDirs returns the directories stored segments are in.
+func (t *thumbnailer) segmentDirs() []string {
+	dirs := []string{t.storagePath}
+	if t.keys != nil {
+		// video-store only holds the segment being recorded, older ones are encrypted
+		dirs = append(dirs, encryptedSegmentDir(t.storagePath))
+	}
+	return dirs
+}
+
+// captureFinished takes a thumbnail every interval of the finished segments, picking up
+// after the newest thumbnail. The newest segment is still being written, so its video is
+// only used once the segment after it starts. At most maxThumbnailsPerTick are taken at
+// a time, so catching up after a restart doesn't hold up pruning.
+func (t *thumbnailer) captureFinished(ctx context.Context) error {
+	segments, err := listSegments(t.segmentDirs()...)
+	if err != nil {
+		return err
+	}
+	if len(segments) < 2 {
+		return nil
+	}
+	if t.next.IsZero() {
+		thumbs, err := listThumbnails(t.dir)
+		if err != nil {
+			return err
+		}
+		if len(thumbs) > 0 {
+			t.next = thumbs[len(thumbs)-1].time.Add(t.interval)
+		} else {
+			// don't go back over video recorded before thumbnails were configured
+			t.next = segments[len(segments)-2].start
+		}
+	}
+	// video-store deletes the oldest segments once storage is full
+	if t.next.Before(segments[0].start) {
+		t.next = segments[0].start
+	}
+	end := segments[len(segments)-1].start
+	for range maxThumbnailsPerTick {
+		if !t.next.Before(end) {
+			return nil
+		}
+		if err := t.capture(ctx, t.next); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// e.g. a gap in the recording, there is no video to take a thumbnail of
+			t.logger.Debugf("failed to capture thumbnail at %s: %s", formatUTCDatetime(t.next), err.Error())
+		}
+		t.next = t.next.Add(t.interval)
+	}
+	return nil
+}
+
+// capture stores a thumbnail of the first keyframe of the stored video at or after at.
+func (t *thumbnailer) capture(ctx context.Context, at time.Time) error {
+	fetched, err := t.vs.Fetch(ctx, &videostore.FetchRequest{
+		From:      at,
+		To:        at.Add(min(t.interval, timelapseSampleWindow)),
+		Container: videostore.ContainerFMP4,
+	})
+	if err != nil {
+		return err
+	}
+	init, media, err := splitFMP4(fetched.Video)
+	if err != nil {
+		return err
+	}
+	config, err := parseFMP4Config(init)
+	if err != nil {
+		return err
+	}
+	payload, sync, err := firstFMP4Sample(media)
+	if err != nil {
+		return err
+	}
+	if !sync {
+		return errors.New("stored video does not start with a keyframe")
+	}
+	// h265 samples use the same 4 byte length prefixes
+	keyframe, err := h264.AVCCUnmarshal(payload)
+	if err != nil {
+		return err
+	}
+	img, err := t.decoder.Decode(config.codec, append(config.params, keyframe...))
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleToWidth(img, t.width), &jpeg.Options{Quality: thumbnailJPEGQuality}); err != nil {
+		return err
+	}
+	// write then rename so get-thumbnails never reads a partial file
+	path := filepath.Join(t.dir, thumbnailFilename(at))
+	tmp := path + ".tmp"
+	if err := writeFile(t.keys, tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// prune deletes the thumbnails taken before the oldest stored segment, video-store
+// deletes segments from the front once storage is full so those thumbnails have no video left.
+// If the remaining thumbnails still use more than maxBytes the oldest are deleted as well.
+func (t *thumbnailer) prune() error {
+	thumbs, err := listThumbnails(t.dir)
+	if err != nil {
+		return err
+	}
+	segments, err := listSegments(t.segmentDirs()...)
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, th := range thumbs {
+		size += th.size
+	}
+	for _, th := range thumbs {
+		if (len(segments) == 0 || !th.time.Before(segments[0].start)) && size <= t.maxBytes {
+			break
+		}
+		if err := os.Remove(th.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		size -= th.size
+	}
+	return nil
+}
+
+// thumbnailsSize returns how many bytes the thumbnails in dir use.
+func thumbnailsSize(dir string) (int64, error) {
+	thumbs, err := listThumbnails(dir)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, th := range thumbs {
+		size += th.size
+	}
+	return size, nil
+}
+
+type segment struct {
+	start time.Time
+	path  string
+}
+
+// listSegments returns the segments in dirs, oldest first. Segments are named by the
+// local time they start at, e.g. 2024-09-06_15-00-03.mp4. Dirs that don't exist yet are skipped.
+func listSegments(dirs ...string) ([]segment, error) {
+	var segments []segment
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			name := e.Name()
+			if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+				continue
+			}
+			start, err := vsutils.ParseDateTimeString(strings.TrimSuffix(name, segmentExt))
+			if err != nil {
+				continue
+			}
+			segments = append(segments, segment{start: start, path: filepath.Join(dir, name)})
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].start.Before(segments[j].start) })
+	return segments, nil
+}
+
+func scaleToWidth(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	if b.Dx() <= width {
+		return img
+	}
+	height := max(1, b.Dy()*width/b.Dx())
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// thumbnails are named by unix time so they sort and parse without timezone ambiguity.
+func thumbnailFilename(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10) + thumbnailExt
+}
+
+type thumbnail struct {
+	time time.Time
+	path string
+	size int64
+}
+
+// listThumbnails returns the thumbnails in dir, oldest first.
+func listThumbnails(dir string) ([]thumbnail, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var thumbs []thumbnail
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, thumbnailExt) {
+			continue
+		}
+		sec, err := strconv.ParseInt(strings.TrimSuffix(name, thumbnailExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			// pruned since the directory was read
+			continue
+		}
+		thumbs = append(thumbs, thumbnail{time: time.Unix(sec, 0), path: filepath.Join(dir, name), size: info.Size()})
+	}
+	sort.Slice(thumbs, func(i, j int) bool { return thumbs[i].time.Before(thumbs[j].time) })
+	return thumbs, nil
+}
+
+// thumbnailStrip is a horizontal strip of equally sized thumbnails.
+type thumbnailStrip struct {
+	jpeg       []byte
+	times      []time.Time
+	tileWidth  int
+	tileHeight int
+}
+
+// buildThumbnailStrip picks up to count thumbnails spread evenly between from and to
+// and lays them out left to right.
+func buildThumbnailStrip(dir string, keys *keyring, from, to time.Time, count int) (*thumbnailStrip, error) {
+	thumbs, err := listThumbnails(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	var inRange []thumbnail
+	for _, th := range thumbs {
+		if !th.time.Before(from) && !th.time.After(to) {
+			inRange = append(inRange, th)
+		}
+	}
+	if len(inRange) == 0 {
+		return nil, errors.New("no thumbnails found between from and to")
+	}
+	picked := inRange
+	if len(inRange) > count {
+		picked = make([]thumbnail, 0, count)
+		for i := range count {
+			picked = append(picked, inRange[i*len(inRange)/count])
+		}
+	}
+
+	var tiles []image.Image
+	strip := &thumbnailStrip{}
+	for _, th := range picked {
+		data, err := readFile(keys, th.path)
+		if err != nil {
+			// pruned since it was listed
+			continue
+		}
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			continue
+		}
+		if len(tiles) == 0 {
+			strip.tileWidth, strip.tileHeight = img.Bounds().Dx(), img.Bounds().Dy()
+		}
+		tiles = append(tiles, img)
+		strip.times = append(strip.times, th.time)
+	}
+	if len(tiles) == 0 {
+		return nil, errors.New("no thumbnails found between from and to")
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, strip.tileWidth*len(tiles), strip.tileHeight))
+	for i, img := range tiles {
+		r := image.Rect(i*strip.tileWidth, 0, (i+1)*strip.tileWidth, strip.tileHeight)
+		// the camera resolution can change, so tiles are scaled to match the first one
+		draw.ApproxBiLinear.Scale(dst, r, img, img.Bounds(), draw.Src, nil)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: thumbnailJPEGQuality}); err != nil {
+		return nil, err
+	}
+	strip.jpeg = buf.Bytes()
+	return strip, nil
+}
+
+// toThumbnailsCommand parses the get-thumbnails DoCommand.
+func toThumbnailsCommand(command map[string]interface{}) (time.Time, time.Time, int, error) {
+	fromStr, ok := command["from"].(string)
+	if !ok {
+		return time.Time{}, time.Time{}, 0, errors.New("from timestamp not found")
+	}
+	from, err := vsutils.ParseDateTimeString(fromStr)
+	if err != nil {
+		return time.Time{}, time.Time{}, 0, err
+	}
+	toStr, ok := command["to"].(string)
+	if !ok {
+		return time.Time{}, time.Time{}, 0, errors.New("to timestamp not found")
+	}
+	to, err := vsutils.ParseDateTimeString(toStr)
+	if err != nil {
+		return time.Time{}, time.Time{}, 0, err
+	}
+	count := defaultThumbnailCount
+	if c, ok := command["count"].(float64); ok {
+		if c < 1 || c > maxThumbnailCount {
+			return time.Time{}, time.Time{}, 0, fmt.Errorf("count must be between 1 and %d", maxThumbnailCount)
+		}
+		count = int(c)
+	}
+	return from, to, count, nil
+}
@@ -0,0 +1,294 @@
+package videostore
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/viam-modules/video-store/videostore"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+// fakeKeyframeDecoder decodes every keyframe to a gray frame of the given size.
+type fakeKeyframeDecoder struct {
+	width, height int
+	codec         videostore.CodecType
+	nalus         [][]byte
+}
+
+func (d *fakeKeyframeDecoder) Decode(codec videostore.CodecType, nalus [][]byte) (image.Image, error) {
+	d.codec, d.nalus = codec, nalus
+	img := image.NewRGBA(image.Rect(0, 0, d.width, d.height))
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+	return img, nil
+}
+
+func (d *fakeKeyframeDecoder) Close() {}
+
+var testKeyframe = []byte{0x65, 0x88, 0x84}
+
+// newThumbnailVideoStore returns a mock whose stored video starts on a keyframe at every
+// time not in gaps.
+func newThumbnailVideoStore(t *testing.T, gaps ...time.Time) *mockVideoStore {
+	t.Helper()
+	init, err := marshalFMP4Init(fmp4Params{codec: videostore.CodecTypeH264, sps: testH264SPS, pps: testH264PPS})
+	test.That(t, err, test.ShouldBeNil)
+	return &mockVideoStore{
+		fetchFunc: func(_ context.Context, req *videostore.FetchRequest) (*videostore.FetchResponse, error) {
+			test.That(t, req.Container, test.ShouldEqual, videostore.ContainerFMP4)
+			for _, gap := range gaps {
+				if req.From.Equal(gap) {
+					return nil, errors.New("no video data found")
+				}
+			}
+			payload, err := h264.AVCCMarshal([][]byte{testKeyframe})
+			test.That(t, err, test.ShouldBeNil)
+			frag := marshalFMP4Fragment(1, []*fmp4Sample{{dts: 0, duration: 3000, sync: true, payload: payload}})
+			return &videostore.FetchResponse{Video: append(append([]byte{}, init...), frag...)}, nil
+		},
+	}
+}
+
+func newTestThumbnailer(t *testing.T, width, height int) *thumbnailer {
+	t.Helper()
+	return &thumbnailer{
+		logger:      logging.NewTestLogger(t),
+		vs:          newThumbnailVideoStore(t),
+		decoder:     &fakeKeyframeDecoder{width: width, height: height},
+		dir:         t.TempDir(),
+		storagePath: t.TempDir(),
+		interval:    defaultThumbnailInterval,
+		width:       defaultThumbnailWidth,
+		maxBytes:    thumbnailStorageGB * gibibyte,
+	}
+}
+
+// writeTestSegment creates an empty segment named the way video-store names them.
+func writeTestSegment(t *testing.T, storagePath string, start time.Time) {
+	t.Helper()
+	name := filepath.Join(storagePath, start.Local().Format(datetimeFormat)+segmentExt)
+	test.That(t, os.WriteFile(name, nil, 0o600), test.ShouldBeNil)
+}
+
+func TestThumbnailCapture(t *testing.T) {
+	th := newTestThumbnailer(t, 640, 480)
+	start := time.Unix(1700000000, 0)
+	for i := range 5 {
+		test.That(t, th.capture(context.Background(), start.Add(time.Duration(i)*10*time.Second)), test.ShouldBeNil)
+	}
+	// only the stored keyframe is decoded, along with the parameter sets from the init section
+	decoder := th.decoder.(*fakeKeyframeDecoder)
+	test.That(t, decoder.codec, test.ShouldEqual, videostore.CodecTypeH264)
+	test.That(t, decoder.nalus, test.ShouldResemble, [][]byte{testH264SPS, testH264PPS, testKeyframe})
+
+	thumbs, err := listThumbnails(th.dir)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, len(thumbs), test.ShouldEqual, 5)
+	test.That(t, thumbs[0].time.Equal(start), test.ShouldBeTrue)
+
+	data, err := os.ReadFile(thumbs[0].path)
+	test.That(t, err, test.ShouldBeNil)
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, img.Bounds().Dx(), test.ShouldEqual, defaultThumbnailWidth)
+	test.That(t, img.Bounds().Dy(), test.ShouldEqual, 120)
+
+	t.Run("prune keeps thumbnails without segments until one is stored", func(t *testing.T) {
+		test.That(t, th.prune(), test.ShouldBeNil)
+		left, err := listThumbnails(th.dir)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(left), test.ShouldEqual, 5)
+	})
+
+	t.Run("prune deletes thumbnails older than the oldest segment", func(t *testing.T) {
+		writeTestSegment(t, th.storagePath, thumbs[4].time)
+		writeTestSegment(t, th.storagePath, thumbs[3].time)
+		test.That(t, th.prune(), test.ShouldBeNil)
+		left, err := listThumbnails(th.dir)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(left), test.ShouldEqual, 2)
+		test.That(t, left[0].time.Equal(thumbs[3].time), test.ShouldBeTrue)
+	})
+
+	t.Run("prune deletes the oldest thumbnails over maxBytes", func(t *testing.T) {
+		size, err := thumbnailsSize(th.dir)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, size, test.ShouldEqual, thumbs[3].size+thumbs[4].size)
+		th.maxBytes = size - 1
+		test.That(t, th.prune(), test.ShouldBeNil)
+		left, err := listThumbnails(th.dir)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(left), test.ShouldEqual, 1)
+		test.That(t, left[0].time.Equal(thumbs[4].time), test.ShouldBeTrue)
+	})
+}
+
+func TestThumbnailStorage(t *testing.T) {
+	cfg := &Config{Storage: Storage{SizeGB: 10}, Thumbnails: &Thumbnails{}}
+	_, _, err := cfg.Validate("path")
+	test.That(t, err, test.ShouldBeNil)
+	vsConfig, err := applyDefaults(cfg, "cam")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, vsConfig.Storage.SizeGB, test.ShouldEqual, 10-thumbnailStorageGB)
+
+	cfg.Storage.SizeGB = thumbnailStorageGB
+	_, _, err = cfg.Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+}
+
+func TestThumbnailTick(t *testing.T) {
+	th := newTestThumbnailer(t, 640, 480)
+	start := time.Unix(1700000000, 0)
+	times := func() []time.Time {
+		thumbs, err := listThumbnails(th.dir)
+		test.That(t, err, test.ShouldBeNil)
+		var times []time.Time
+		for _, thumb := range thumbs {
+			times = append(times, thumb.time)
+		}
+		return times
+	}
+
+	// the only segment is still being written
+	writeTestSegment(t, th.storagePath, start)
+	th.tick(context.Background())
+	test.That(t, times(), test.ShouldBeEmpty)
+
+	writeTestSegment(t, th.storagePath, start.Add(30*time.Second))
+	th.tick(context.Background())
+	test.That(t, times(), test.ShouldResemble, []time.Time{start, start.Add(10 * time.Second), start.Add(20 * time.Second)})
+
+	t.Run("gaps in the recording are skipped", func(t *testing.T) {
+		th.vs = newThumbnailVideoStore(t, start.Add(40*time.Second))
+		writeTestSegment(t, th.storagePath, start.Add(60*time.Second))
+		th.tick(context.Background())
+		test.That(t, times()[3:], test.ShouldResemble, []time.Time{start.Add(30 * time.Second), start.Add(50 * time.Second)})
+	})
+
+	t.Run("a restart picks up after the newest thumbnail", func(t *testing.T) {
+		restarted := newTestThumbnailer(t, 640, 480)
+		restarted.dir, restarted.storagePath = th.dir, th.storagePath
+		writeTestSegment(t, th.storagePath, start.Add(90*time.Second))
+		restarted.tick(context.Background())
+		test.That(t, times()[5:], test.ShouldResemble, []time.Time{
+			start.Add(60 * time.Second), start.Add(70 * time.Second), start.Add(80 * time.Second),
+		})
+	})
+
+	t.Run("catching up is spread over ticks", func(t *testing.T) {
+		behind := newTestThumbnailer(t, 640, 480)
+		writeTestSegment(t, behind.storagePath, start)
+		writeTestSegment(t, behind.storagePath, start.Add(time.Hour))
+		writeTestSegment(t, behind.storagePath, start.Add(2*time.Hour))
+		behind.next = start
+		behind.tick(context.Background())
+		thumbs, err := listThumbnails(behind.dir)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(thumbs), test.ShouldEqual, maxThumbnailsPerTick)
+		test.That(t, behind.next, test.ShouldEqual, start.Add(maxThumbnailsPerTick*behind.interval))
+	})
+}
+
+func TestScaleToWidth(t *testing.T) {
+	small := image.NewRGBA(image.Rect(0, 0, 100, 50))
+	test.That(t, scaleToWidth(small, 160), test.ShouldEqual, small)
+	test.That(t, scaleToWidth(image.NewRGBA(image.Rect(0, 0, 1920, 1080)), 160).Bounds(), test.ShouldResemble, image.Rect(0, 0, 160, 90))
+}
+
+func TestGetThumbnailsDoCommand(t *testing.T) {
+	th := newTestThumbnailer(t, 320, 240)
+	start := time.Unix(1700000000, 0)
+	for i := range 20 {
+		test.That(t, th.capture(context.Background(), start.Add(time.Duration(i)*10*time.Second)), test.ShouldBeNil)
+	}
+	s := createTestService(t, &mockVideoStore{})
+	s.thumbDir = th.dir
+
+	t.Run("returns an evenly spaced strip", func(t *testing.T) {
+		res, err := s.DoCommand(context.Background(), map[string]interface{}{
+			"command": "get-thumbnails",
+			"from":    formatUTCDatetime(start),
+			"to":      formatUTCDatetime(start.Add(time.Hour)),
+			"count":   4.0,
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, res["tile_width"], test.ShouldEqual, defaultThumbnailWidth)
+		test.That(t, res["tile_height"], test.ShouldEqual, 120)
+		test.That(t, res["times"], test.ShouldResemble, []interface{}{
+			formatUTCDatetime(start),
+			formatUTCDatetime(start.Add(50 * time.Second)),
+			formatUTCDatetime(start.Add(100 * time.Second)),
+			formatUTCDatetime(start.Add(150 * time.Second)),
+		})
+
+		strip, err := base64.StdEncoding.DecodeString(res["strip"].(string))
+		test.That(t, err, test.ShouldBeNil)
+		img, err := jpeg.Decode(bytes.NewReader(strip))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, img.Bounds().Dx(), test.ShouldEqual, 4*defaultThumbnailWidth)
+	})
+
+	t.Run("no thumbnails in range", func(t *testing.T) {
+		_, err := s.DoCommand(context.Background(), map[string]interface{}{
+			"command": "get-thumbnails",
+			"from":    formatUTCDatetime(start.Add(-time.Hour)),
+			"to":      formatUTCDatetime(start.Add(-time.Minute)),
+		})
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+
+	t.Run("invalid count", func(t *testing.T) {
+		_, err := s.DoCommand(context.Background(), map[string]interface{}{
+			"command": "get-thumbnails",
+			"from":    formatUTCDatetime(start),
+			"to":      formatUTCDatetime(start.Add(time.Hour)),
+			"count":   0.0,
+		})
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+}
+
+func TestEncryptedThumbnails(t *testing.T) {
+	key, _ := newTestKey(t)
+	th := newTestThumbnailer(t, 640, 480)
+	th.keys = newKeyring(key)
+	start := time.Unix(1700000000, 0)
+	test.That(t, th.capture(context.Background(), start), test.ShouldBeNil)
+
+	thumbs, err := listThumbnails(th.dir)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, len(thumbs), test.ShouldEqual, 1)
+	_, encrypted, err := keyID(thumbs[0].path)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, encrypted, test.ShouldBeTrue)
+
+	_, err = buildThumbnailStrip(th.dir, nil, start, start, 1)
+	test.That(t, err, test.ShouldNotBeNil)
+	strip, err := buildThumbnailStrip(th.dir, th.keys, start, start, 1)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, strip.tileWidth, test.ShouldEqual, defaultThumbnailWidth)
+
+	t.Run("prune counts encrypted segments", func(t *testing.T) {
+		test.That(t, th.capture(context.Background(), start.Add(10*time.Second)), test.ShouldBeNil)
+		encryptedDir := encryptedSegmentDir(th.storagePath)
+		test.That(t, os.MkdirAll(encryptedDir, 0o700), test.ShouldBeNil)
+		writeTestSegment(t, encryptedDir, start.Add(10*time.Second))
+		writeTestSegment(t, th.storagePath, start.Add(20*time.Second))
+		test.That(t, th.prune(), test.ShouldBeNil)
+		left, err := listThumbnails(th.dir)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(left), test.ShouldEqual, 1)
+		test.That(t, left[0].time.Equal(start.Add(10*time.Second)), test.ShouldBeTrue)
+	})
+}
@@ -0,0 +1,225 @@
+package videostore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/viam-modules/video-store/videostore"
+	vsutils "github.com/viam-modules/video-store/videostore/utils"
+)
+
+const (
+	defaultTimelapseFPS = 30
+	maxTimelapseFPS     = 120
+	// caps how many frames a single timelapse may pull out of storage.
+	maxTimelapseFrames = 3600
+	// each sample fetches at most this much stored video to find a keyframe.
+	timelapseSampleWindow = 5 * time.Second
+)
+
+// timelapseRequest describes a timelapse built from keyframes of stored video.
+type timelapseRequest struct {
+	from     time.Time
+	to       time.Time
+	interval time.Duration
+	fps      int
+	metadata string
+	async    bool
+	fetch    bool
+}
+
+// timelapseResult is a built timelapse along with how many sample times were
+// skipped because they had no usable stored video.
+type timelapseResult struct {
+	video   []byte
+	frames  int
+	skipped int
+}
+
+// toTimelapseCommand parses the timelapse DoCommand. Exactly one of interval_sec,
+// the gap between sampled frames, or speed, how many times faster than real time
+// the output plays back, must be set.
+func toTimelapseCommand(command map[string]interface{}) (*timelapseRequest, error) {
+	fromStr, ok := command["from"].(string)
+	if !ok {
+		return nil, errors.New("from timestamp not found")
+	}
+	from, err := vsutils.ParseDateTimeString(fromStr)
+	if err != nil {
+		return nil, err
+	}
+	toStr, ok := command["to"].(string)
+	if !ok {
+		return nil, errors.New("to timestamp not found")
+	}
+	to, err := vsutils.ParseDateTimeString(toStr)
+	if err != nil {
+		return nil, err
+	}
+	if !to.After(from) {
+		return nil, errors.New("to timestamp must be after from timestamp")
+	}
+
+	req := &timelapseRequest{from: from, to: to, fps: defaultTimelapseFPS}
+	if fps, ok := command["fps"].(float64); ok {
+		if fps < 1 || fps > maxTimelapseFPS || fps != math.Trunc(fps) {
+			return nil, fmt.Errorf("fps must be a whole number between 1 and %d", maxTimelapseFPS)
+		}
+		req.fps = int(fps)
+	}
+
+	intervalSec, hasInterval := command["interval_sec"].(float64)
+	speed, hasSpeed := command["speed"].(float64)
+	switch {
+	case hasInterval && hasSpeed:
+		return nil, errors.New("only one of interval_sec or speed may be set")
+	case hasInterval:
+		if intervalSec <= 0 {
+			return nil, errors.New("interval_sec must be greater than 0")
+		}
+		req.interval = time.Duration(intervalSec * float64(time.Second))
+	case hasSpeed:
+		// frames are sampled at least a second apart, so each second of output covers at least fps seconds
+		if speed < float64(req.fps) {
+			return nil, fmt.Errorf("speed must be at least fps (%d)", req.fps)
+		}
+		req.interval = time.Duration(speed / float64(req.fps) * float64(time.Second))
+	default:
+		return nil, errors.New("one of interval_sec or speed must be set")
+	}
+	if req.interval < time.Second {
+		return nil, errors.New("frames must be sampled at least one second apart")
+	}
+	if frames := to.Sub(from) / req.interval; frames > maxTimelapseFrames {
+		return nil, fmt.Errorf("timelapse would contain %d frames, max is %d", frames, maxTimelapseFrames)
+	}
+
+	if metadata, ok := command["metadata"].(string); ok {
+		req.metadata = metadata
+	}
+	if async, ok := command["async"].(bool); ok {
+		req.async = async
+	}
+	if fetch, ok := command["fetch"].(bool); ok {
+		req.fetch = fetch
+	}
+	if req.async && req.fetch {
+		return nil, errors.New("async timelapses can not be fetched")
+	}
+	return req, nil
+}
+
+// timelapseFilename follows the save command's <name>_<timestamp>_<metadata>.mp4 layout.
+func timelapseFilename(name string, req *timelapseRequest) string {
+	filename := fmt.Sprintf("%s_timelapse_%s", name, formatUTCDatetime(req.from))
+	if req.metadata != "" {
+		filename += "_" + req.metadata
+	}
+	return filename + ".mp4"
+}
+
+// fetcher is the part of a video store timelapses are built from.
+type fetcher interface {
+	Fetch(ctx context.Context, r *videostore.FetchRequest) (*videostore.FetchResponse, error)
+}
+
+// buildTimelapse samples a keyframe every interval between from and to and
+// muxes them into a fragmented mp4 that plays back at fps. Frames are copied
+// as is, so no decoding or re-encoding is needed. Sample times with no stored
+// video, or whose video doesn't start on a keyframe, are skipped and counted in the result.
+func buildTimelapse(ctx context.Context, vs fetcher, req *timelapseRequest) (*timelapseResult, error) {
+	window := min(req.interval, timelapseSampleWindow)
+	var init []byte
+	var track fmp4Track
+	var out bytes.Buffer
+	res := &timelapseResult{}
+	var lastErr error
+	for t := req.from; t.Before(req.to); t = t.Add(req.interval) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		fetched, err := vs.Fetch(ctx, &videostore.FetchRequest{From: t, To: t.Add(window), Container: videostore.ContainerFMP4})
+		if err != nil {
+			lastErr = err
+			res.skipped++
+			continue
+		}
+		sampleInit, media, err := splitFMP4(fetched.Video)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stored video at %s: %w", formatUTCDatetime(t), err)
+		}
+		if init == nil {
+			track, err = parseFMP4Track(sampleInit)
+			if err != nil {
+				return nil, err
+			}
+			if track.id != fmp4TrackID {
+				return nil, fmt.Errorf("unsupported track id %d", track.id)
+			}
+			init = sampleInit
+			out.Write(init)
+		} else if !bytes.Equal(sampleInit, init) {
+			// the output can only have a single init section, so it can't span a change of stream parameters
+			return nil, fmt.Errorf("stream parameters changed at %s, build separate timelapses before and after it",
+				formatUTCDatetime(t))
+		}
+		payload, sync, err := firstFMP4Sample(media)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stored video at %s: %w", formatUTCDatetime(t), err)
+		}
+		if !sync {
+			res.skipped++
+			continue
+		}
+		frameTicks := track.timescale / uint32(req.fps)
+		out.Write(marshalFMP4Fragment(uint32(res.frames+1), []*fmp4Sample{{
+			dts:      int64(res.frames) * int64(frameTicks),
+			duration: frameTicks,
+			sync:     true,
+			payload:  payload,
+		}}))
+		res.frames++
+	}
+	if res.frames == 0 {
+		if lastErr != nil {
+			return nil, fmt.Errorf("no stored video found between from and to: %w", lastErr)
+		}
+		return nil, errors.New("no stored video found between from and to")
+	}
+	res.video = out.Bytes()
+	return res, nil
+}
+
+// timelapse builds a timelapse from the stored video. Encrypted segments are kept decrypted
+// for the samples after the one that needed them.
+func (s *service) timelapse(ctx context.Context, req *timelapseRequest) (*timelapseResult, error) {
+	if s.encrypted == nil {
+		return buildTimelapse(ctx, s.vs, req)
+	}
+	cache := s.encrypted.newViewCache()
+	defer cache.close()
+	return buildTimelapse(ctx, cache, req)
+}
+
+// saveTimelapse builds the timelapse and writes it unencrypted to the upload path, like
+// saved clips.
+func (s *service) saveTimelapse(ctx context.Context, req *timelapseRequest, filename string) (*timelapseResult, error) {
+	res, err := s.timelapse(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(s.uploadPath, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create upload path: %w", err)
+	}
+	//nolint:gosec // timelapses are uploaded by data manager which needs to read them
+	if err := os.WriteFile(filepath.Join(s.uploadPath, filename), res.video, 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write timelapse: %w", err)
+	}
+	return res, nil
+}
@@ -0,0 +1,298 @@
+package videostore
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/viam-modules/video-store/videostore"
+	vsutils "github.com/viam-modules/video-store/videostore/utils"
+	"go.viam.com/test"
+)
+
+// newTimelapseVideoStore returns a mock that has stored video for the minute after start.
+// Every fetch starts on a keyframe whose payload records the second it was fetched from.
+func newTimelapseVideoStore(t *testing.T, start time.Time) *mockVideoStore {
+	t.Helper()
+	init, err := marshalFMP4Init(fmp4Params{codec: videostore.CodecTypeH264, sps: testH264SPS, pps: testH264PPS})
+	test.That(t, err, test.ShouldBeNil)
+	return &mockVideoStore{
+		fetchFunc: func(_ context.Context, req *videostore.FetchRequest) (*videostore.FetchResponse, error) {
+			test.That(t, req.Container, test.ShouldEqual, videostore.ContainerFMP4)
+			offset := req.From.Sub(start)
+			if offset < 0 || offset >= time.Minute {
+				return nil, errors.New("no video data found")
+			}
+			frag := marshalFMP4Fragment(1, []*fmp4Sample{
+				{dts: 0, duration: 3000, sync: true, payload: []byte{0, 0, 0, 2, 0x65, byte(offset / time.Second)}},
+				{dts: 3000, duration: 3000, payload: []byte{0, 0, 0, 2, 0x41, 0x00}},
+			})
+			return &videostore.FetchResponse{Video: append(append([]byte{}, init...), frag...)}, nil
+		},
+	}
+}
+
+func TestFirstFMP4Sample(t *testing.T) {
+	init, err := marshalFMP4Init(fmp4Params{codec: videostore.CodecTypeH264, sps: testH264SPS, pps: testH264PPS})
+	test.That(t, err, test.ShouldBeNil)
+	track, err := parseFMP4Track(init)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, track, test.ShouldResemble, fmp4Track{id: fmp4TrackID, timescale: fmp4Timescale})
+
+	payload := []byte{0, 0, 0, 2, 0x65, 0x01}
+	frag := marshalFMP4Fragment(1, []*fmp4Sample{
+		{dts: 0, duration: 3000, sync: true, payload: payload},
+		{dts: 3000, duration: 3000, payload: []byte{0, 0, 0, 2, 0x41, 0x02}},
+	})
+	got, sync, err := firstFMP4Sample(frag)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, sync, test.ShouldBeTrue)
+	test.That(t, got, test.ShouldResemble, payload)
+
+	frag = marshalFMP4Fragment(1, []*fmp4Sample{{dts: 0, duration: 3000, payload: payload}})
+	_, sync, err = firstFMP4Sample(frag)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, sync, test.ShouldBeFalse)
+
+	_, _, err = firstFMP4Sample(init)
+	test.That(t, err, test.ShouldNotBeNil)
+}
+
+func TestParseFMP4Config(t *testing.T) {
+	init, err := marshalFMP4Init(fmp4Params{codec: videostore.CodecTypeH264, sps: testH264SPS, pps: testH264PPS})
+	test.That(t, err, test.ShouldBeNil)
+	config, err := parseFMP4Config(init)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, config, test.ShouldResemble, fmp4Config{codec: videostore.CodecTypeH264, params: [][]byte{testH264SPS, testH264PPS}})
+
+	init, err = marshalFMP4Init(fmp4Params{codec: videostore.CodecTypeH265, vps: testH265VPS, sps: testH265SPS, pps: testH265PPS})
+	test.That(t, err, test.ShouldBeNil)
+	config, err = parseFMP4Config(init)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, config.codec, test.ShouldEqual, videostore.CodecTypeH265)
+	test.That(t, config.params, test.ShouldResemble, [][]byte{testH265VPS, testH265SPS, testH265PPS})
+
+	_, err = parseFMP4Config(init[:len(init)/2])
+	test.That(t, err, test.ShouldNotBeNil)
+}
+
+func TestToTimelapseCommand(t *testing.T) {
+	base := map[string]interface{}{
+		"command": "timelapse",
+		"from":    "2024-01-01_00-00-00",
+		"to":      "2024-01-01_01-00-00",
+	}
+	with := func(kv map[string]interface{}) map[string]interface{} {
+		cmd := map[string]interface{}{}
+		for k, v := range base {
+			cmd[k] = v
+		}
+		for k, v := range kv {
+			cmd[k] = v
+		}
+		return cmd
+	}
+
+	t.Run("interval", func(t *testing.T) {
+		req, err := toTimelapseCommand(with(map[string]interface{}{"interval_sec": 10.0}))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, req.interval, test.ShouldEqual, 10*time.Second)
+		test.That(t, req.fps, test.ShouldEqual, defaultTimelapseFPS)
+	})
+
+	t.Run("speed", func(t *testing.T) {
+		req, err := toTimelapseCommand(with(map[string]interface{}{"speed": 300.0, "fps": 10.0}))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, req.interval, test.ShouldEqual, 30*time.Second)
+		test.That(t, req.fps, test.ShouldEqual, 10)
+	})
+
+	for _, tc := range []struct {
+		name string
+		cmd  map[string]interface{}
+	}{
+		{"neither interval nor speed", with(nil)},
+		{"both interval and speed", with(map[string]interface{}{"interval_sec": 10.0, "speed": 300.0})},
+		{"interval under a second", with(map[string]interface{}{"interval_sec": 0.5})},
+		{"speed under fps", with(map[string]interface{}{"speed": 10.0})},
+		{"invalid fps", with(map[string]interface{}{"interval_sec": 10.0, "fps": 0.0})},
+		{"too many frames", with(map[string]interface{}{"interval_sec": 1.0, "to": "2024-01-02_00-00-00"})},
+		{"to before from", with(map[string]interface{}{"interval_sec": 10.0, "to": "2023-12-31_00-00-00"})},
+		{"async fetch", with(map[string]interface{}{"interval_sec": 10.0, "async": true, "fetch": true})},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := toTimelapseCommand(tc.cmd)
+			test.That(t, err, test.ShouldNotBeNil)
+		})
+	}
+}
+
+func TestTimelapseDoCommand(t *testing.T) {
+	start, err := vsutils.ParseDateTimeString("2024-01-01_00-00-00")
+	test.That(t, err, test.ShouldBeNil)
+
+	t.Run("fetch returns the sampled keyframes", func(t *testing.T) {
+		s := createTestService(t, newTimelapseVideoStore(t, start))
+		res, err := s.DoCommand(context.Background(), map[string]interface{}{
+			"command":      "timelapse",
+			"from":         "2023-12-31_23-59-50",
+			"to":           "2024-01-01_00-01-10",
+			"interval_sec": 10.0,
+			"fetch":        true,
+		})
+		test.That(t, err, test.ShouldBeNil)
+		video, err := base64.StdEncoding.DecodeString(res["video"].(string))
+		test.That(t, err, test.ShouldBeNil)
+
+		init, media, err := splitFMP4(video)
+		test.That(t, err, test.ShouldBeNil)
+		track, err := parseFMP4Track(init)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, track.timescale, test.ShouldEqual, fmp4Timescale)
+
+		// sample times before and after the stored minute are skipped
+		boxes, err := readBoxes(media)
+		test.That(t, err, test.ShouldBeNil)
+		var seconds []byte
+		for i := 0; i < len(boxes); i += 2 {
+			payload, sync, err := firstFMP4Sample(media[boxes[i].offset:])
+			test.That(t, err, test.ShouldBeNil)
+			test.That(t, sync, test.ShouldBeTrue)
+			seconds = append(seconds, payload[5])
+		}
+		test.That(t, seconds, test.ShouldResemble, []byte{0, 10, 20, 30, 40, 50})
+		test.That(t, res["frames"], test.ShouldEqual, 6)
+		test.That(t, res["skipped"], test.ShouldEqual, 2)
+	})
+
+	t.Run("fetch in chunks", func(t *testing.T) {
+		s := createTestService(t, newTimelapseVideoStore(t, start))
+		cmd := map[string]interface{}{
+			"command":      "timelapse",
+			"from":         "2024-01-01_00-00-00",
+			"to":           "2024-01-01_00-01-00",
+			"interval_sec": 10.0,
+			"fetch":        true,
+			"chunk_size":   100.0,
+		}
+		res, err := s.DoCommand(context.Background(), cmd)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, res["command"], test.ShouldEqual, "timelapse")
+		test.That(t, res["frames"], test.ShouldEqual, 6)
+		test.That(t, res["done"], test.ShouldBeFalse)
+
+		video, err := base64.StdEncoding.DecodeString(res["video"].(string))
+		test.That(t, err, test.ShouldBeNil)
+		for !res["done"].(bool) {
+			res, err = s.DoCommand(context.Background(), map[string]interface{}{
+				"command":    "fetch",
+				"fetch_id":   res["fetch_id"],
+				"offset":     float64(res["next_offset"].(int)),
+				"chunk_size": 100.0,
+			})
+			test.That(t, err, test.ShouldBeNil)
+			chunk, err := base64.StdEncoding.DecodeString(res["video"].(string))
+			test.That(t, err, test.ShouldBeNil)
+			video = append(video, chunk...)
+		}
+		test.That(t, len(video), test.ShouldEqual, res["size"])
+		test.That(t, topLevelBoxes(t, video)[:2], test.ShouldResemble, []string{"ftyp", "moov"})
+
+		// remaining chunks are read with the fetch command
+		cmd["fetch_id"] = res["fetch_id"]
+		_, err = s.DoCommand(context.Background(), cmd)
+		test.That(t, err, test.ShouldNotBeNil)
+		delete(cmd, "fetch_id")
+		delete(cmd, "fetch")
+		_, err = s.DoCommand(context.Background(), cmd)
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+
+	t.Run("unreadable stored video is an error", func(t *testing.T) {
+		s := createTestService(t, &mockVideoStore{
+			fetchFunc: func(context.Context, *videostore.FetchRequest) (*videostore.FetchResponse, error) {
+				return &videostore.FetchResponse{Video: []byte{0, 0, 0, 1}}, nil
+			},
+		})
+		_, err := s.DoCommand(context.Background(), map[string]interface{}{
+			"command":      "timelapse",
+			"from":         "2024-01-01_00-00-00",
+			"to":           "2024-01-01_00-01-00",
+			"interval_sec": 10.0,
+			"fetch":        true,
+		})
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "failed to read stored video")
+	})
+
+	t.Run("stream parameter change is an error", func(t *testing.T) {
+		vs := newTimelapseVideoStore(t, start)
+		h264 := vs.fetchFunc
+		h265, err := marshalFMP4Init(fmp4Params{
+			codec: videostore.CodecTypeH265, vps: testH265VPS, sps: testH265SPS, pps: testH265PPS,
+		})
+		test.That(t, err, test.ShouldBeNil)
+		vs.fetchFunc = func(ctx context.Context, req *videostore.FetchRequest) (*videostore.FetchResponse, error) {
+			res, err := h264(ctx, req)
+			if err != nil || req.From.Before(start.Add(30*time.Second)) {
+				return res, err
+			}
+			_, media, err := splitFMP4(res.Video)
+			test.That(t, err, test.ShouldBeNil)
+			return &videostore.FetchResponse{Video: append(append([]byte{}, h265...), media...)}, nil
+		}
+		s := createTestService(t, vs)
+		_, err = s.DoCommand(context.Background(), map[string]interface{}{
+			"command":      "timelapse",
+			"from":         "2024-01-01_00-00-00",
+			"to":           "2024-01-01_00-01-00",
+			"interval_sec": 10.0,
+			"fetch":        true,
+		})
+		test.That(t, err, test.ShouldNotBeNil)
+		changed := formatUTCDatetime(start.Add(30 * time.Second))
+		test.That(t, err.Error(), test.ShouldContainSubstring, "stream parameters changed at "+changed)
+	})
+
+	t.Run("writes to the upload path", func(t *testing.T) {
+		utcStart, err := vsutils.ParseDateTimeString("2024-01-01_00-00-00Z")
+		test.That(t, err, test.ShouldBeNil)
+		s := createTestService(t, newTimelapseVideoStore(t, utcStart))
+		s.uploadPath = t.TempDir()
+		res, err := s.DoCommand(context.Background(), map[string]interface{}{
+			"command":  "timelapse",
+			"from":     "2024-01-01_00-00-00Z",
+			"to":       "2024-01-01_00-01-00Z",
+			"speed":    300.0,
+			"fps":      10.0,
+			"metadata": "front",
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, res["filename"], test.ShouldEqual, "test-video-service_timelapse_2024-01-01_00-00-00Z_front.mp4")
+		test.That(t, res["frames"], test.ShouldEqual, 2)
+		test.That(t, res["skipped"], test.ShouldEqual, 0)
+		_, hasStatus := res["status"]
+		test.That(t, hasStatus, test.ShouldBeFalse)
+
+		video, err := os.ReadFile(filepath.Join(s.uploadPath, res["filename"].(string)))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, topLevelBoxes(t, video), test.ShouldResemble, []string{"ftyp", "moov", "moof", "mdat", "moof", "mdat"})
+	})
+
+	t.Run("no stored video", func(t *testing.T) {
+		s := createTestService(t, newTimelapseVideoStore(t, start))
+		_, err := s.DoCommand(context.Background(), map[string]interface{}{
+			"command":      "timelapse",
+			"from":         "2024-02-01_00-00-00",
+			"to":           "2024-02-01_00-01-00",
+			"interval_sec": 10.0,
+			"fetch":        true,
+		})
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "no video data found")
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"path/filepath"
 	"time"
 
@@ -24,6 +25,8 @@ const (
 	defaultFramerate    = 20               // frames per second
 	defaultVideoBitrate = 1000000
 	defaultVideoPreset  = "ultrafast"
+	// datetimeFormat matches the datetime format accepted by the save and fetch commands.
+	datetimeFormat = "2006-01-02_15-04-05"
 )
 
 var (
@@ -49,10 +52,18 @@ func init() {
 type service struct {
 	resource.Named
 	resource.AlwaysRebuild
-	logger  logging.Logger
-	vs      videostore.VideoStore
-	rsMux   *rawSegmenterMux
-	workers *utils.StoppableWorkers
+	logger     logging.Logger
+	vs         videostore.VideoStore
+	rsMux      *rawSegmenterMux
+	hls        *hlsServer
+	thumbs     *thumbnailer
+	uploadPath string
+	thumbDir   string
+	keys       *keyring
+	encrypted  *encryptedStore
+	fetches    *fetchCache
+	schedule   *recordingSchedule
+	workers    *utils.StoppableWorkers
 }
 
 // Ensure service implements video.Service at compile time.
@@ -69,10 +80,22 @@ func New(ctx context.Context, deps resource.Dependencies, conf resource.Config,
 	if err != nil {
 		return nil, err
 	}
+	var keys *keyring
+	if newConf.Storage.Encryption != nil {
+		keys, err = loadKeyring(newConf.Storage.Encryption)
+		if err != nil {
+			return nil, err
+		}
+	}
 	var vs videostore.VideoStore
 	var mux *rawSegmenterMux
+	var live *hlsLive
+	var schedule *recordingSchedule
+	// recording is nil for the frame poller, which stores video for as long as it runs
+	var recording func() bool
+	var c camera.Camera
 	if newConf.Camera != nil {
-		c, err := camera.FromProvider(deps, *newConf.Camera)
+		c, err = camera.FromProvider(deps, *newConf.Camera)
 		if err != nil {
 			return nil, err
 		}
@@ -86,10 +109,29 @@ func New(ctx context.Context, deps resource.Dependencies, conf resource.Config,
 		}
 
 		mux = newRawSegmenterMux(rtpVs.Segmenter(), c.Name(), logger)
+		if newConf.Schedule != nil {
+			schedule, err = newRecordingSchedule(newConf.Schedule)
+			if err != nil {
+				rtpVs.Close()
+				return nil, err
+			}
+			mux.schedule = schedule
+		}
+		if newConf.HLS != nil {
+			live = newHLSLive(newConf.HLS, logger)
+			mux.live = live
+		}
 		if err := mux.init(); err == nil {
 			vs = rtpVs
+			recording = func() bool { return schedule.active(time.Now()) && mux.writing() }
 		} else {
 			rtpVs.Close()
+			if schedule != nil {
+				// the frame poller records for as long as it runs, so it can't follow a schedule
+				return nil, fmt.Errorf("recording schedules are only supported for viamrtsp cameras: %w", err)
+			}
+			// live hls is only available for cameras that hand video-store their rtp stream
+			live = nil
 			vsConfig.FramePoller.Camera = c
 			fVs, err := videostore.NewFramePollingVideoStore(ctx, videostore.Config{
 				Name:        vsConfig.Name,
@@ -114,17 +156,67 @@ func New(ctx context.Context, deps resource.Dependencies, conf resource.Config,
 		}
 	}
 
+	var encrypted *encryptedStore
+	if keys != nil {
+		encrypted, err = newEncryptedStore(vs, keys, vsConfig, recording, logger)
+		if err != nil {
+			if closeErr := mux.close(); closeErr != nil {
+				logger.Warnf("failed to close mux: %s", closeErr.Error())
+			}
+			vs.Close()
+			return nil, err
+		}
+		vs = encrypted
+	}
+
+	var thumbs *thumbnailer
+	if c != nil && newConf.Thumbnails != nil {
+		thumbs, err = newThumbnailer(newConf.Thumbnails, vs, vsConfig.Storage.StoragePath, keys, logger)
+		if err != nil {
+			if closeErr := mux.close(); closeErr != nil {
+				logger.Warnf("failed to close mux: %s", closeErr.Error())
+			}
+			vs.Close()
+			return nil, err
+		}
+	}
+
+	var hls *hlsServer
+	if newConf.HLS != nil {
+		hls, err = newHLSServer(newConf.HLS, vs, live, logger)
+		if err != nil {
+			thumbs.close()
+			if closeErr := mux.close(); closeErr != nil {
+				logger.Warnf("failed to close mux: %s", closeErr.Error())
+			}
+			vs.Close()
+			return nil, err
+		}
+	}
+
 	s := &service{
-		Named:   conf.ResourceName().AsNamed(),
-		logger:  logger,
-		vs:      vs,
-		rsMux:   mux,
-		workers: utils.NewBackgroundStoppableWorkers(),
+		Named:      conf.ResourceName().AsNamed(),
+		logger:     logger,
+		vs:         vs,
+		rsMux:      mux,
+		hls:        hls,
+		thumbs:     thumbs,
+		uploadPath: vsConfig.Storage.UploadPath,
+		thumbDir:   thumbnailDir(vsConfig.Storage.StoragePath),
+		keys:       keys,
+		encrypted:  encrypted,
+		fetches:    newFetchCache(),
+		schedule:   schedule,
+		workers:    utils.NewBackgroundStoppableWorkers(),
 	}
 	return s, nil
 }
 
 func (s *service) Close(_ context.Context) error {
+	if err := s.hls.close(); err != nil {
+		s.logger.Warnf("failed to close hls server: %s", err.Error())
+	}
+	s.thumbs.close()
 	if err := s.rsMux.close(); err != nil {
 		return err
 	}
@@ -229,8 +321,16 @@ func (s *service) DoCommand(ctx context.Context, command map[string]interface{})
 			ret["status"] = "async"
 		}
 		return ret, nil
+	// Passing chunk_size or fetch_id pages through the video instead of returning it whole.
 	case "fetch":
 		s.logger.Debug("fetch command received")
+		chunk, err := toChunkRequest(command)
+		if err != nil {
+			return nil, err
+		}
+		if chunk != nil && chunk.fetchID != "" {
+			return s.fetchChunk(chunk)
+		}
 		req, err := toFetchCommand(command)
 		if err != nil {
 			return nil, err
@@ -239,8 +339,14 @@ func (s *service) DoCommand(ctx context.Context, command map[string]interface{})
 		if err != nil {
 			return nil, err
 		}
+		if chunk != nil {
+			if chunk.fetchID, _, err = s.fetches.add(res.Video); err != nil {
+				return nil, err
+			}
+			return s.fetchChunk(chunk)
+		}
 		if len(res.Video) > maxGRPCSize {
-			return nil, errors.New("video file size exceeds max grpc size")
+			return nil, errors.New("video file size exceeds max grpc size, use chunk_size to fetch it in chunks")
 		}
 		// TODO(seanp): Do we need to encode the video bytes to base64?
 		videoBytesBase64 := base64.StdEncoding.EncodeToString(res.Video)
@@ -248,13 +354,125 @@ func (s *service) DoCommand(ctx context.Context, command map[string]interface{})
 			"command": "fetch",
 			"video":   videoBytesBase64,
 		}, nil
+	// Timelapse command samples keyframes between the given timestamps into a sped up video.
+	// The video is either returned directly or written to the upload path like save.
+	case "timelapse":
+		s.logger.Debug("timelapse command received")
+		req, err := toTimelapseCommand(command)
+		if err != nil {
+			return nil, err
+		}
+		chunk, err := toChunkRequest(command)
+		if err != nil {
+			return nil, err
+		}
+		if chunk != nil && (!req.fetch || chunk.fetchID != "") {
+			return nil, errors.New("chunk_size requires fetch, read the remaining chunks with the fetch command")
+		}
+		if req.fetch {
+			res, err := s.timelapse(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+			if chunk != nil {
+				if chunk.fetchID, _, err = s.fetches.add(res.video); err != nil {
+					return nil, err
+				}
+				ret, err := s.fetchChunk(chunk)
+				if err != nil {
+					return nil, err
+				}
+				ret["command"] = "timelapse"
+				ret["frames"] = res.frames
+				ret["skipped"] = res.skipped
+				return ret, nil
+			}
+			if len(res.video) > maxGRPCSize {
+				return nil, errors.New("video file size exceeds max grpc size, use chunk_size to fetch it in chunks")
+			}
+			return map[string]interface{}{
+				"command": "timelapse",
+				"video":   base64.StdEncoding.EncodeToString(res.video),
+				"frames":  res.frames,
+				"skipped": res.skipped,
+			}, nil
+		}
+		filename := timelapseFilename(s.Name().Name, req)
+		if req.async {
+			s.workers.Add(func(ctx context.Context) {
+				res, err := s.saveTimelapse(ctx, req, filename)
+				if err != nil {
+					s.logger.Errorf("failed to save timelapse %s: %s", filename, err.Error())
+					return
+				}
+				if res.skipped > 0 {
+					s.logger.Warnf("timelapse %s skipped %d of %d sample times with no usable stored video",
+						filename, res.skipped, res.frames+res.skipped)
+				}
+			})
+			return map[string]interface{}{
+				"command":  "timelapse",
+				"filename": filename,
+				"status":   "async",
+			}, nil
+		}
+		res, err := s.saveTimelapse(ctx, req, filename)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"command":  "timelapse",
+			"filename": filename,
+			"frames":   res.frames,
+			"skipped":  res.skipped,
+		}, nil
+	// Get-thumbnails command returns a jpeg strip of stored thumbnails between the given timestamps.
+	case "get-thumbnails":
+		s.logger.Debug("get-thumbnails command received")
+		from, to, count, err := toThumbnailsCommand(command)
+		if err != nil {
+			return nil, err
+		}
+		strip, err := buildThumbnailStrip(s.thumbDir, s.keys, from, to, count)
+		if err != nil {
+			return nil, err
+		}
+		times := make([]interface{}, 0, len(strip.times))
+		for _, t := range strip.times {
+			times = append(times, formatUTCDatetime(t))
+		}
+		return map[string]interface{}{
+			"command":     "get-thumbnails",
+			"strip":       base64.StdEncoding.EncodeToString(strip.jpeg),
+			"times":       times,
+			"tile_width":  strip.tileWidth,
+			"tile_height": strip.tileHeight,
+		}, nil
 	case "get-storage-state":
 		s.logger.Debug("get-storage-state command received")
 		state, err := s.vs.GetStorageState(ctx)
 		if err != nil {
 			return nil, err
 		}
-		return vscamera.GetStorageStateDoCommandResponse(state), nil
+		ret := vscamera.GetStorageStateDoCommandResponse(state)
+		if s.schedule != nil {
+			ret["recording_schedule"] = s.schedule.doCommandState(time.Now())
+		}
+		if s.thumbs != nil {
+			size, err := thumbnailsSize(s.thumbDir)
+			if err != nil {
+				return nil, err
+			}
+			ret["thumbnails_size_bytes"] = size
+		}
+		if s.encrypted != nil {
+			size, err := s.encrypted.sizeBytes()
+			if err != nil {
+				return nil, err
+			}
+			ret["encrypted_size_bytes"] = size
+		}
+		return ret, nil
 	default:
 		return nil, errors.New("invalid command")
 	}
@@ -329,6 +547,11 @@ func toFetchCommand(command map[string]interface{}) (*videostore.FetchRequest, e
 	return &videostore.FetchRequest{From: from, To: to, Container: container}, nil
 }
 
+// formatUTCDatetime formats t in UTC using the datetime format accepted by the commands.
+func formatUTCDatetime(t time.Time) string {
+	return t.UTC().Format(datetimeFormat) + "Z"
+}
+
 // parseContainerFormat parses a container format string and returns the corresponding ContainerFormat.
 func parseContainerFormat(s string) (videostore.ContainerFormat, error) {
 	switch s {
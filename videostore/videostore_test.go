@@ -68,6 +68,7 @@ func createTestService(t *testing.T, mockVS *mockVideoStore) *service {
 		logger:  logger,
 		vs:      mockVS,
 		rsMux:   nil,
+		fetches: newFetchCache(),
 		workers: utils.NewBackgroundStoppableWorkers(),
 	}
 